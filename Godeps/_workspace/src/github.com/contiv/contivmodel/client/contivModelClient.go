@@ -175,16 +175,62 @@ type Bgp struct {
 	// every object has a key
 	Key string `json:"key,omitempty"`
 
-	As         string `json:"as,omitempty"`          // AS id
-	Hostname   string `json:"hostname,omitempty"`    // host name
-	Neighbor   string `json:"neighbor,omitempty"`    // Bgp  neighbor
-	NeighborAs string `json:"neighbor-as,omitempty"` // AS id
-	Routerip   string `json:"routerip,omitempty"`    // Bgp router intf ip
+	As             string        `json:"as,omitempty"`              // AS id
+	ExportPrefixes []string      `json:"export-prefixes,omitempty"` // Prefixes advertised to the neighbors
+	ExportTenants  []string      `json:"export-tenants,omitempty"`  // Tenants whose endpoints are advertised
+	HoldTime       int           `json:"hold-time,omitempty"`       // Hold time in seconds
+	Hostname       string        `json:"hostname,omitempty"`        // host name
+	ImportPrefixes []string      `json:"import-prefixes,omitempty"` // Prefixes accepted from the neighbors
+	Keepalive      int           `json:"keepalive,omitempty"`       // Keepalive interval in seconds
+	Neighbor       string        `json:"neighbor,omitempty"`        // Bgp  neighbor
+	NeighborAs     string        `json:"neighbor-as,omitempty"`     // AS id
+	Neighbors      []BgpNeighbor `json:"neighbors,omitempty"`       // Additional neighbors
+	Routerip       string        `json:"routerip,omitempty"`        // Bgp router intf ip
+
+}
+
+type BgpNeighbor struct {
+	Address       string `json:"address,omitempty"`        // Bgp  neighbor
+	As            string `json:"as,omitempty"`             // AS id
+	ClearPassword bool   `json:"clear-password,omitempty"` // Remove the MD5 password
+	Password      string `json:"password,omitempty"`       // MD5 password, write only
+
+}
+
+type BgpOper struct {
+	As          string            `json:"as,omitempty"`          // AS id
+	LastUpdated string            `json:"lastUpdated,omitempty"` // Time the state was collected on the host
+	Neighbors   []BgpNeighborOper `json:"neighbors,omitempty"`   // Neighbors and their session state
+	RibSize     int               `json:"ribSize,omitempty"`     // Number of routes in the RIB
+	RouterIP    string            `json:"routerIp,omitempty"`    // Bgp router intf ip
+	Routes      []BgpRoute        `json:"routes,omitempty"`      // Host routes of the RIB
+
+}
+
+type BgpNeighborOper struct {
+	Accepted   int    `json:"accepted,omitempty"`   // Prefixes accepted
+	AdminState string `json:"adminState,omitempty"` // Admin state
+	Address    string `json:"address,omitempty"`    // Bgp  neighbor
+	Advertised int    `json:"advertised,omitempty"` // Prefixes advertised
+	As         string `json:"as,omitempty"`         // AS id
+	Received   int    `json:"received,omitempty"`   // Prefixes received
+	State      string `json:"state,omitempty"`      // Session state
+	Uptime     int    `json:"uptime,omitempty"`     // Seconds the session is established
+
+}
+
+type BgpRoute struct {
+	AsPath  string `json:"asPath,omitempty"`  // AS path
+	Best    bool   `json:"best,omitempty"`    // Best path to the prefix
+	NextHop string `json:"nextHop,omitempty"` // Next hop
+	Prefix  string `json:"prefix,omitempty"`  // Destination prefix
 
 }
 
 type BgpInspect struct {
 	Config Bgp
+
+	Oper BgpOper
 }
 
 type EndpointOper struct {
@@ -192,8 +238,9 @@ type EndpointOper struct {
 	ContainerID      string   `json:"containerID,omitempty"`      //
 	EndpointGroupID  int      `json:"endpointGroupId,omitempty"`  //
 	EndpointGroupKey string   `json:"endpointGroupKey,omitempty"` //
-	HomingHost       string   `json:"homingHost,omitempty"`       //
-	IntfName         string   `json:"intfName,omitempty"`         //
+	Endpoints        []string `json:"endpoints,omitempty"`
+	HomingHost       string   `json:"homingHost,omitempty"` //
+	IntfName         string   `json:"intfName,omitempty"`   //
 	IpAddress        []string `json:"ipAddress,omitempty"`
 	Labels           string   `json:"labels,omitempty"`      //
 	MacAddress       string   `json:"macAddress,omitempty"`  //
@@ -268,6 +315,7 @@ type Global struct {
 
 	Name             string `json:"name,omitempty"`             // name of this block(must be 'global')
 	NetworkInfraType string `json:"networkInfraType,omitempty"` // Network infrastructure type
+	ServiceVIPPool   string `json:"serviceVIPPool,omitempty"`   // Pool of external service VIPs
 	Vlans            string `json:"vlans,omitempty"`            // Allowed vlan range
 	Vxlans           string `json:"vxlans,omitempty"`           // Allwed vxlan range
 
@@ -318,15 +366,19 @@ type Network struct {
 	// every object has a key
 	Key string `json:"key,omitempty"`
 
-	Encap       string `json:"encap,omitempty"`       // Encapsulation
-	Gateway     string `json:"gateway,omitempty"`     // Gateway
-	Ipv6Gateway string `json:"ipv6Gateway,omitempty"` // IPv6Gateway
-	Ipv6Subnet  string `json:"ipv6Subnet,omitempty"`  // IPv6Subnet
-	NetworkName string `json:"networkName,omitempty"` // Network name
-	NwType      string `json:"nwType,omitempty"`      // Network Type
-	PktTag      int    `json:"pktTag,omitempty"`      // Vlan/Vxlan Tag
-	Subnet      string `json:"subnet,omitempty"`      // Subnet
-	TenantName  string `json:"tenantName,omitempty"`  // Tenant Name
+	DnsOptions   []string `json:"dnsOptions,omitempty"`
+	DnsSearch    []string `json:"dnsSearch,omitempty"`
+	DnsServers   []string `json:"dnsServers,omitempty"`
+	Encap        string   `json:"encap,omitempty"`       // Encapsulation
+	Gateway      string   `json:"gateway,omitempty"`     // Gateway
+	Ipv6Gateway  string   `json:"ipv6Gateway,omitempty"` // IPv6Gateway
+	Ipv6Subnet   string   `json:"ipv6Subnet,omitempty"`  // IPv6Subnet
+	NetworkName  string   `json:"networkName,omitempty"` // Network name
+	NwType       string   `json:"nwType,omitempty"`      // Network Type
+	PktTag       int      `json:"pktTag,omitempty"`      // Vlan/Vxlan Tag
+	StaticRoutes []string `json:"staticRoutes,omitempty"`
+	Subnet       string   `json:"subnet,omitempty"`     // Subnet
+	TenantName   string   `json:"tenantName,omitempty"` // Tenant Name
 
 	// add link-sets and links
 	LinkSets NetworkLinkSets `json:"link-sets,omitempty"`
@@ -344,12 +396,16 @@ type NetworkLinks struct {
 }
 
 type NetworkOper struct {
-	AllocatedAddressesCount int    `json:"allocatedAddressesCount,omitempty"` // Vlan/Vxlan Tag
-	AllocatedIPAddresses    string `json:"allocatedIPAddresses,omitempty"`    // allocated IP addresses
-	DnsServerIP             string `json:"dnsServerIP,omitempty"`             // dns IP for the network
-	ExternalPktTag          int    `json:"externalPktTag,omitempty"`          // external packet tag
-	NumEndpoints            int    `json:"numEndpoints,omitempty"`            // external packet tag
-	PktTag                  int    `json:"pktTag,omitempty"`                  // internal packet tag
+	AllocatedAddressesCount     int      `json:"allocatedAddressesCount,omitempty"`     // Vlan/Vxlan Tag
+	AllocatedIPAddresses        string   `json:"allocatedIPAddresses,omitempty"`        // allocated IP addresses
+	AllocatedIPv6Addresses      string   `json:"allocatedIPv6Addresses,omitempty"`      // allocated IPv6 addresses
+	AllocatedIPv6AddressesCount int      `json:"allocatedIPv6AddressesCount,omitempty"` // number of allocated IPv6 addresses
+	DnsServerIP                 string   `json:"dnsServerIP,omitempty"`                 // dns IP for the network
+	ExternalPktTag              int      `json:"externalPktTag,omitempty"`              // external packet tag
+	NumEndpoints                int      `json:"numEndpoints,omitempty"`                // external packet tag
+	PktTag                      int      `json:"pktTag,omitempty"`                      // internal packet tag
+	Reservations                []string `json:"reservations,omitempty"`
+	SubnetPools                 []string `json:"subnetPools,omitempty"`
 
 }
 
@@ -419,12 +475,24 @@ type ServiceLB struct {
 	// every object has a key
 	Key string `json:"key,omitempty"`
 
-	IpAddress   string   `json:"ipAddress,omitempty"`   // Service ip
-	NetworkName string   `json:"networkName,omitempty"` // Service network name
-	Ports       []string `json:"ports,omitempty"`
-	Selectors   []string `json:"selectors,omitempty"`
-	ServiceName string   `json:"serviceName,omitempty"` // service name
-	TenantName  string   `json:"tenantName,omitempty"`  // Tenant Name
+	AffinityTimeout     int      `json:"affinityTimeout,omitempty"`     // session affinity timeout in seconds
+	External            bool     `json:"external,omitempty"`            // advertise the service ip from the external pool
+	HealthCheck         string   `json:"healthCheck,omitempty"`         // provider health check, tcp or http
+	HealthCheckInterval int      `json:"healthCheckInterval,omitempty"` // seconds between health checks
+	HealthCheckPath     string   `json:"healthCheckPath,omitempty"`     // path of the http health check
+	HealthCheckPort     int      `json:"healthCheckPort,omitempty"`     // provider port of the health check
+	HealthCheckTimeout  int      `json:"healthCheckTimeout,omitempty"`  // health check timeout in seconds
+	HealthyThreshold    int      `json:"healthyThreshold,omitempty"`    // passed checks to become healthy
+	IpAddress           string   `json:"ipAddress,omitempty"`           // Service ip
+	LbMode              string   `json:"lbMode,omitempty"`              // load balancing mode, roundrobin or leastconn
+	NetworkName         string   `json:"networkName,omitempty"`         // Service network name
+	Ports               []string `json:"ports,omitempty"`
+	Selectors           []string `json:"selectors,omitempty"`
+	ServiceName         string   `json:"serviceName,omitempty"`        // service name
+	SessionAffinity     string   `json:"sessionAffinity,omitempty"`    // session affinity, none or clientip
+	TenantName          string   `json:"tenantName,omitempty"`         // Tenant Name
+	UnhealthyThreshold  int      `json:"unhealthyThreshold,omitempty"` // failed checks to become unhealthy
+	Weights             []string `json:"weights,omitempty"`
 
 	Links ServiceLBLinks `json:"links,omitempty"`
 }
@@ -434,8 +502,15 @@ type ServiceLBLinks struct {
 	Tenant  Link `json:"Tenant,omitempty"`
 }
 
+type ServiceLBOper struct {
+	Providers []string `json:"providers,omitempty"`
+
+}
+
 type ServiceLBInspect struct {
 	Config ServiceLB
+
+	Oper ServiceLBOper
 }
 
 type Tenant struct {
@@ -45,16 +45,62 @@ type Bgp struct {
 	// every object has a key
 	Key string `json:"key,omitempty"`
 
-	As         string `json:"as,omitempty"`          // AS id
-	Hostname   string `json:"hostname,omitempty"`    // host name
-	Neighbor   string `json:"neighbor,omitempty"`    // Bgp  neighbor
-	NeighborAs string `json:"neighbor-as,omitempty"` // AS id
-	Routerip   string `json:"routerip,omitempty"`    // Bgp router intf ip
+	As             string        `json:"as,omitempty"`              // AS id
+	ExportPrefixes []string      `json:"export-prefixes,omitempty"` // Prefixes advertised to the neighbors
+	ExportTenants  []string      `json:"export-tenants,omitempty"`  // Tenants whose endpoints are advertised
+	HoldTime       int           `json:"hold-time,omitempty"`       // Hold time in seconds
+	Hostname       string        `json:"hostname,omitempty"`        // host name
+	ImportPrefixes []string      `json:"import-prefixes,omitempty"` // Prefixes accepted from the neighbors
+	Keepalive      int           `json:"keepalive,omitempty"`       // Keepalive interval in seconds
+	Neighbor       string        `json:"neighbor,omitempty"`        // Bgp  neighbor
+	NeighborAs     string        `json:"neighbor-as,omitempty"`     // AS id
+	Neighbors      []BgpNeighbor `json:"neighbors,omitempty"`       // Additional neighbors
+	Routerip       string        `json:"routerip,omitempty"`        // Bgp router intf ip
+
+}
+
+type BgpNeighbor struct {
+	Address       string `json:"address,omitempty"`        // Bgp  neighbor
+	As            string `json:"as,omitempty"`             // AS id
+	ClearPassword bool   `json:"clear-password,omitempty"` // Remove the MD5 password
+	Password      string `json:"password,omitempty"`       // MD5 password, write only
+
+}
+
+type BgpOper struct {
+	As          string            `json:"as,omitempty"`          // AS id
+	LastUpdated string            `json:"lastUpdated,omitempty"` // Time the state was collected on the host
+	Neighbors   []BgpNeighborOper `json:"neighbors,omitempty"`   // Neighbors and their session state
+	RibSize     int               `json:"ribSize,omitempty"`     // Number of routes in the RIB
+	RouterIP    string            `json:"routerIp,omitempty"`    // Bgp router intf ip
+	Routes      []BgpRoute        `json:"routes,omitempty"`      // Host routes of the RIB
+
+}
+
+type BgpNeighborOper struct {
+	Accepted   int    `json:"accepted,omitempty"`   // Prefixes accepted
+	AdminState string `json:"adminState,omitempty"` // Admin state
+	Address    string `json:"address,omitempty"`    // Bgp  neighbor
+	Advertised int    `json:"advertised,omitempty"` // Prefixes advertised
+	As         string `json:"as,omitempty"`         // AS id
+	Received   int    `json:"received,omitempty"`   // Prefixes received
+	State      string `json:"state,omitempty"`      // Session state
+	Uptime     int    `json:"uptime,omitempty"`     // Seconds the session is established
+
+}
+
+type BgpRoute struct {
+	AsPath  string `json:"asPath,omitempty"`  // AS path
+	Best    bool   `json:"best,omitempty"`    // Best path to the prefix
+	NextHop string `json:"nextHop,omitempty"` // Next hop
+	Prefix  string `json:"prefix,omitempty"`  // Destination prefix
 
 }
 
 type BgpInspect struct {
 	Config Bgp
+
+	Oper BgpOper
 }
 
 type EndpointOper struct {
@@ -66,8 +112,9 @@ type EndpointOper struct {
 	ContainerID      string   `json:"containerID,omitempty"`      //
 	EndpointGroupID  int      `json:"endpointGroupId,omitempty"`  //
 	EndpointGroupKey string   `json:"endpointGroupKey,omitempty"` //
-	HomingHost       string   `json:"homingHost,omitempty"`       //
-	IntfName         string   `json:"intfName,omitempty"`         //
+	Endpoints        []string `json:"endpoints,omitempty"`
+	HomingHost       string   `json:"homingHost,omitempty"` //
+	IntfName         string   `json:"intfName,omitempty"`   //
 	IpAddress        []string `json:"ipAddress,omitempty"`
 	Labels           string   `json:"labels,omitempty"`      //
 	MacAddress       string   `json:"macAddress,omitempty"`  //
@@ -142,6 +189,7 @@ type Global struct {
 
 	Name             string `json:"name,omitempty"`             // name of this block(must be 'global')
 	NetworkInfraType string `json:"networkInfraType,omitempty"` // Network infrastructure type
+	ServiceVIPPool   string `json:"serviceVIPPool,omitempty"`   // Pool of external service VIPs
 	Vlans            string `json:"vlans,omitempty"`            // Allowed vlan range
 	Vxlans           string `json:"vxlans,omitempty"`           // Allwed vxlan range
 
@@ -192,15 +240,19 @@ type Network struct {
 	// every object has a key
 	Key string `json:"key,omitempty"`
 
-	Encap       string `json:"encap,omitempty"`       // Encapsulation
-	Gateway     string `json:"gateway,omitempty"`     // Gateway
-	Ipv6Gateway string `json:"ipv6Gateway,omitempty"` // IPv6Gateway
-	Ipv6Subnet  string `json:"ipv6Subnet,omitempty"`  // IPv6Subnet
-	NetworkName string `json:"networkName,omitempty"` // Network name
-	NwType      string `json:"nwType,omitempty"`      // Network Type
-	PktTag      int    `json:"pktTag,omitempty"`      // Vlan/Vxlan Tag
-	Subnet      string `json:"subnet,omitempty"`      // Subnet
-	TenantName  string `json:"tenantName,omitempty"`  // Tenant Name
+	DnsOptions   []string `json:"dnsOptions,omitempty"`
+	DnsSearch    []string `json:"dnsSearch,omitempty"`
+	DnsServers   []string `json:"dnsServers,omitempty"`
+	Encap        string   `json:"encap,omitempty"`       // Encapsulation
+	Gateway      string   `json:"gateway,omitempty"`     // Gateway
+	Ipv6Gateway  string   `json:"ipv6Gateway,omitempty"` // IPv6Gateway
+	Ipv6Subnet   string   `json:"ipv6Subnet,omitempty"`  // IPv6Subnet
+	NetworkName  string   `json:"networkName,omitempty"` // Network name
+	NwType       string   `json:"nwType,omitempty"`      // Network Type
+	PktTag       int      `json:"pktTag,omitempty"`      // Vlan/Vxlan Tag
+	StaticRoutes []string `json:"staticRoutes,omitempty"`
+	Subnet       string   `json:"subnet,omitempty"`     // Subnet
+	TenantName   string   `json:"tenantName,omitempty"` // Tenant Name
 
 	// add link-sets and links
 	LinkSets NetworkLinkSets `json:"link-sets,omitempty"`
@@ -218,12 +270,16 @@ type NetworkLinks struct {
 }
 
 type NetworkOper struct {
-	AllocatedAddressesCount int    `json:"allocatedAddressesCount,omitempty"` // Vlan/Vxlan Tag
-	AllocatedIPAddresses    string `json:"allocatedIPAddresses,omitempty"`    // allocated IP addresses
-	DnsServerIP             string `json:"dnsServerIP,omitempty"`             // dns IP for the network
-	ExternalPktTag          int    `json:"externalPktTag,omitempty"`          // external packet tag
-	NumEndpoints            int    `json:"numEndpoints,omitempty"`            // external packet tag
-	PktTag                  int    `json:"pktTag,omitempty"`                  // internal packet tag
+	AllocatedAddressesCount     int      `json:"allocatedAddressesCount,omitempty"`     // Vlan/Vxlan Tag
+	AllocatedIPAddresses        string   `json:"allocatedIPAddresses,omitempty"`        // allocated IP addresses
+	AllocatedIPv6Addresses      string   `json:"allocatedIPv6Addresses,omitempty"`      // allocated IPv6 addresses
+	AllocatedIPv6AddressesCount int      `json:"allocatedIPv6AddressesCount,omitempty"` // number of allocated IPv6 addresses
+	DnsServerIP                 string   `json:"dnsServerIP,omitempty"`                 // dns IP for the network
+	ExternalPktTag              int      `json:"externalPktTag,omitempty"`              // external packet tag
+	NumEndpoints                int      `json:"numEndpoints,omitempty"`                // external packet tag
+	PktTag                      int      `json:"pktTag,omitempty"`                      // internal packet tag
+	Reservations                []string `json:"reservations,omitempty"`
+	SubnetPools                 []string `json:"subnetPools,omitempty"`
 
 }
 
@@ -293,12 +349,24 @@ type ServiceLB struct {
 	// every object has a key
 	Key string `json:"key,omitempty"`
 
-	IpAddress   string   `json:"ipAddress,omitempty"`   // Service ip
-	NetworkName string   `json:"networkName,omitempty"` // Service network name
-	Ports       []string `json:"ports,omitempty"`
-	Selectors   []string `json:"selectors,omitempty"`
-	ServiceName string   `json:"serviceName,omitempty"` // service name
-	TenantName  string   `json:"tenantName,omitempty"`  // Tenant Name
+	AffinityTimeout     int      `json:"affinityTimeout,omitempty"`     // session affinity timeout in seconds
+	External            bool     `json:"external,omitempty"`            // advertise the service ip from the external pool
+	HealthCheck         string   `json:"healthCheck,omitempty"`         // provider health check, tcp or http
+	HealthCheckInterval int      `json:"healthCheckInterval,omitempty"` // seconds between health checks
+	HealthCheckPath     string   `json:"healthCheckPath,omitempty"`     // path of the http health check
+	HealthCheckPort     int      `json:"healthCheckPort,omitempty"`     // provider port of the health check
+	HealthCheckTimeout  int      `json:"healthCheckTimeout,omitempty"`  // health check timeout in seconds
+	HealthyThreshold    int      `json:"healthyThreshold,omitempty"`    // passed checks to become healthy
+	IpAddress           string   `json:"ipAddress,omitempty"`           // Service ip
+	LbMode              string   `json:"lbMode,omitempty"`              // load balancing mode, roundrobin or leastconn
+	NetworkName         string   `json:"networkName,omitempty"`         // Service network name
+	Ports               []string `json:"ports,omitempty"`
+	Selectors           []string `json:"selectors,omitempty"`
+	ServiceName         string   `json:"serviceName,omitempty"`        // service name
+	SessionAffinity     string   `json:"sessionAffinity,omitempty"`    // session affinity, none or clientip
+	TenantName          string   `json:"tenantName,omitempty"`         // Tenant Name
+	UnhealthyThreshold  int      `json:"unhealthyThreshold,omitempty"` // failed checks to become unhealthy
+	Weights             []string `json:"weights,omitempty"`
 
 	Links ServiceLBLinks `json:"links,omitempty"`
 }
@@ -308,8 +376,15 @@ type ServiceLBLinks struct {
 	Tenant  modeldb.Link `json:"Tenant,omitempty"`
 }
 
+type ServiceLBOper struct {
+	Providers []string `json:"providers,omitempty"`
+
+}
+
 type ServiceLBInspect struct {
 	Config ServiceLB
+
+	Oper ServiceLBOper
 }
 
 type Tenant struct {
@@ -419,6 +494,8 @@ type AppProfileCallbacks interface {
 }
 
 type BgpCallbacks interface {
+	BgpGetOper(Bgp *BgpInspect) error
+
 	BgpCreate(Bgp *Bgp) error
 	BgpUpdate(Bgp, params *Bgp) error
 	BgpDelete(Bgp *Bgp) error
@@ -475,6 +552,8 @@ type RuleCallbacks interface {
 }
 
 type ServiceLBCallbacks interface {
+	ServiceLBGetOper(serviceLB *ServiceLBInspect) error
+
 	ServiceLBCreate(serviceLB *ServiceLB) error
 	ServiceLBUpdate(serviceLB, params *ServiceLB) error
 	ServiceLBDelete(serviceLB *ServiceLB) error
@@ -1108,10 +1187,33 @@ func httpInspectBgp(w http.ResponseWriter, r *http.Request, vars map[string]stri
 	}
 	obj.Config = *objConfig
 
+	if err := GetOperBgp(&obj); err != nil {
+		log.Errorf("GetBgp error for: %+v. Err: %v", obj, err)
+		return nil, err
+	}
+
 	// Return the obj
 	return &obj, nil
 }
 
+// Get a BgpOper object
+func GetOperBgp(obj *BgpInspect) error {
+	// Check if we handle this object
+	if objCallbackHandler.BgpCb == nil {
+		log.Errorf("No callback registered for Bgp object")
+		return errors.New("Invalid object type")
+	}
+
+	// Perform callback
+	err := objCallbackHandler.BgpCb.BgpGetOper(obj)
+	if err != nil {
+		log.Errorf("BgpDelete retruned error for: %+v. Err: %v", obj, err)
+		return err
+	}
+
+	return nil
+}
+
 // LIST REST call
 func httpListBgps(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
 	log.Debugf("Received httpListBgps: %+v", vars)
@@ -1352,6 +1454,10 @@ func ValidateBgp(obj *Bgp) error {
 		return errors.New("as string too long")
 	}
 
+	if obj.HoldTime > 65535 {
+		return errors.New("hold-time Value Out of bound")
+	}
+
 	if len(obj.Hostname) > 256 {
 		return errors.New("hostname string too long")
 	}
@@ -1361,6 +1467,10 @@ func ValidateBgp(obj *Bgp) error {
 		return errors.New("hostname string invalid format")
 	}
 
+	if obj.Keepalive > 21845 {
+		return errors.New("keepalive Value Out of bound")
+	}
+
 	if len(obj.Neighbor) > 15 {
 		return errors.New("neighbor string too long")
 	}
@@ -2278,6 +2388,11 @@ func ValidateGlobal(obj *Global) error {
 		return errors.New("networkInfraType string invalid format")
 	}
 
+	serviceVIPPoolMatch := regexp.MustCompile("^(((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])(\\.(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])){3})/(3[0-2]|2[0-9]|1[0-9]|[1-9]))?$")
+	if serviceVIPPoolMatch.MatchString(obj.ServiceVIPPool) == false {
+		return errors.New("serviceVIPPool string invalid format")
+	}
+
 	vlansMatch := regexp.MustCompile("^([0-9]{1,4}?-[0-9]{1,4}?)$")
 	if vlansMatch.MatchString(obj.Vlans) == false {
 		return errors.New("vlans string invalid format")
@@ -3555,10 +3670,33 @@ func httpInspectServiceLB(w http.ResponseWriter, r *http.Request, vars map[strin
 	}
 	obj.Config = *objConfig
 
+	if err := GetOperServiceLB(&obj); err != nil {
+		log.Errorf("GetServiceLB error for: %+v. Err: %v", obj, err)
+		return nil, err
+	}
+
 	// Return the obj
 	return &obj, nil
 }
 
+// Get a serviceLBOper object
+func GetOperServiceLB(obj *ServiceLBInspect) error {
+	// Check if we handle this object
+	if objCallbackHandler.ServiceLBCb == nil {
+		log.Errorf("No callback registered for serviceLB object")
+		return errors.New("Invalid object type")
+	}
+
+	// Perform callback
+	err := objCallbackHandler.ServiceLBCb.ServiceLBGetOper(obj)
+	if err != nil {
+		log.Errorf("ServiceLBDelete retruned error for: %+v. Err: %v", obj, err)
+		return err
+	}
+
+	return nil
+}
+
 // LIST REST call
 func httpListServiceLBs(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
 	log.Debugf("Received httpListServiceLBs: %+v", vars)
@@ -3795,6 +3933,27 @@ func ValidateServiceLB(obj *ServiceLB) error {
 
 	// Validate each field
 
+	if obj.AffinityTimeout > 86400 {
+		return errors.New("affinityTimeout Value Out of bound")
+	}
+
+	if len(obj.HealthCheck) > 4 {
+		return errors.New("healthCheck string too long")
+	}
+
+	healthCheckMatch := regexp.MustCompile("^(tcp|http)?$")
+	if healthCheckMatch.MatchString(obj.HealthCheck) == false {
+		return errors.New("healthCheck string invalid format")
+	}
+
+	if len(obj.HealthCheckPath) > 256 {
+		return errors.New("healthCheckPath string too long")
+	}
+
+	if obj.HealthCheckPort > 65535 {
+		return errors.New("healthCheckPort Value Out of bound")
+	}
+
 	if len(obj.IpAddress) > 15 {
 		return errors.New("ipAddress string too long")
 	}
@@ -3804,6 +3963,15 @@ func ValidateServiceLB(obj *ServiceLB) error {
 		return errors.New("ipAddress string invalid format")
 	}
 
+	if len(obj.LbMode) > 16 {
+		return errors.New("lbMode string too long")
+	}
+
+	lbModeMatch := regexp.MustCompile("^(roundrobin|leastconn)?$")
+	if lbModeMatch.MatchString(obj.LbMode) == false {
+		return errors.New("lbMode string invalid format")
+	}
+
 	if len(obj.NetworkName) > 64 {
 		return errors.New("networkName string too long")
 	}
@@ -3822,6 +3990,15 @@ func ValidateServiceLB(obj *ServiceLB) error {
 		return errors.New("serviceName string invalid format")
 	}
 
+	if len(obj.SessionAffinity) > 8 {
+		return errors.New("sessionAffinity string too long")
+	}
+
+	sessionAffinityMatch := regexp.MustCompile("^(none|clientip)?$")
+	if sessionAffinityMatch.MatchString(obj.SessionAffinity) == false {
+		return errors.New("sessionAffinity string invalid format")
+	}
+
 	if len(obj.TenantName) > 64 {
 		return errors.New("tenantName string too long")
 	}
@@ -76,6 +76,7 @@ type Flow struct {
 	isInstalled bool          // Is the flow installed in the switch
 	flowId      uint64        // Unique ID for the flow
 	flowActions []*FlowAction // List of flow actions
+	IdleTimeout uint16        // Seconds the flow may go unused, 0 for no timeout
 }
 
 const IP_PROTO_TCP = 6
@@ -419,6 +420,12 @@ func (self *Flow) install() error {
 	flowMod.Priority = self.Match.Priority
 	flowMod.Cookie = self.flowId
 
+	// the switch tells when it removes an idle flow
+	if self.IdleTimeout != 0 {
+		flowMod.IdleTimeout = self.IdleTimeout
+		flowMod.Flags |= openflow13.FF_SEND_FLOW_REM
+	}
+
 	// Add or modify
 	if !self.isInstalled {
 		flowMod.Command = openflow13.FC_ADD
@@ -650,6 +657,11 @@ func (self *Flow) SetTunnelId(tunnelId uint64) error {
 	return nil
 }
 
+// Cookie returns the cookie the flow is installed with
+func (self *Flow) Cookie() uint64 {
+	return self.flowId
+}
+
 // Delete the flow
 func (self *Flow) Delete() error {
 	// Delete from ofswitch
@@ -191,6 +191,8 @@ func (self *OFSwitch) handleMessages(dpid net.HardwareAddr, msg util.Message) {
 		self.app.PacketRcvd(self, (*PacketIn)(t))
 
 	case *openflow13.FlowRemoved:
+		log.Debugf("Received flow removed(ofctrl): %+v", t)
+		self.app.FlowRemoved(self, (*FlowRemoved)(t))
 
 	case *openflow13.PortStatus:
 		// FIXME: This needs to propagated to the app.
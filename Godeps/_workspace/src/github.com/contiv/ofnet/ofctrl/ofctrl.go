@@ -30,6 +30,9 @@ import (
 
 type PacketIn openflow13.PacketIn
 
+// FlowRemoved is the notification of a flow removed by the switch
+type FlowRemoved openflow13.FlowRemoved
+
 // Note: Command to make ovs connect to controller:
 // ovs-vsctl set-controller <bridge-name> tcp:<ip-addr>:<port>
 // E.g.    sudo ovs-vsctl set-controller ovsbr0 tcp:127.0.0.1:6633
@@ -47,6 +50,9 @@ type AppInterface interface {
 
 	// Controller received a packet from the switch
 	PacketRcvd(sw *OFSwitch, pkt *PacketIn)
+
+	// Switch removed a flow that asked to be notified, e.g. on its idle timeout
+	FlowRemoved(sw *OFSwitch, msg *FlowRemoved)
 }
 
 type Controller struct {
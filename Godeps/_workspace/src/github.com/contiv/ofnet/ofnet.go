@@ -38,6 +38,9 @@ type OfnetDatapath interface {
 	// Process Incoming packet
 	PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn)
 
+	// Process a flow removed by the switch
+	FlowRemoved(sw *ofctrl.OFSwitch, msg *ofctrl.FlowRemoved)
+
 	// Add a local endpoint to forwarding DB
 	AddLocalEndpoint(endpoint OfnetEndpoint) error
 
@@ -78,7 +81,13 @@ type OfnetDatapath interface {
 	DelSvcSpec(svcName string, spec *ServiceSpec) error
 
 	// Service Proxy Back End update
-	SvcProviderUpdate(svcName string, providers []string)
+	SvcProviderUpdate(svcName string, providers []ProviderSpec)
+
+	// Send the traffic of the uplinks to a service VIP to the service proxy
+	AddServiceVIP(vip string) error
+
+	// Stop sending the traffic of the uplinks to a service VIP to the proxy
+	DeleteServiceVIP(vip string) error
 }
 
 // Interface implemented by each control protocol.
@@ -92,7 +101,7 @@ type OfnetProto interface {
 	//Add a Protocol Neighbor
 	AddProtoNeighbor(neighborInfo *OfnetProtoNeighborInfo) error
 
-	//Delete a Protocol Neighbor
+	//Delete all Protocol Neighbors
 	DeleteProtoNeighbor() error
 
 	//Get Protocol router info
@@ -106,6 +115,9 @@ type OfnetProto interface {
 
 	//Modify protocol Rib (Could be used for testing)
 	ModifyProtoRib(path interface{})
+
+	//Get the state of the neighbors and the routes
+	GetProtoOperState() (*OfnetProtoOperState, error)
 }
 
 // Default port numbers
@@ -157,21 +169,58 @@ type OfnetProtoNeighborInfo struct {
 	ProtocolType string // type of protocol
 	NeighborIP   string // ip address of the neighbor
 	As           string // As of neighbor if applicable
+	Password     string // MD5 password of the neighbor if applicable
 }
 
 type OfnetProtoRouterInfo struct {
-	ProtocolType string // type of protocol
-	RouterIP     string // ip address of the router
-	VlanIntf     string // uplink L2 intf
-	As           string // As for Bgp protocol
+	ProtocolType      string   // type of protocol
+	RouterIP          string   // ip address of the router
+	VlanIntf          string   // uplink L2 intf
+	As                string   // As for Bgp protocol
+	HoldTime          uint32   // neighbor hold time in seconds, default if 0
+	KeepaliveInterval uint32   // neighbor keepalive interval in seconds, default if 0
+	ImportPrefixes    []string // prefixes accepted from the neighbors, all if empty
+	ExportPrefixes    []string // prefixes advertised to the neighbors, all if empty
+	ExportVrfs        []string // vrfs whose endpoints are advertised, all if empty
 }
 
 type OfnetProtoRouteInfo struct {
 	ProtocolType string // type of protocol
 	localEpIP    string
 	nextHopIP    string
+	vrf          string // vrf of the local endpoint, empty for other routes
+}
+
+type OfnetProtoNeighborState struct {
+	NeighborIP string // ip address of the neighbor
+	As         string // As of the neighbor
+	State      string // session state
+	AdminState string // admin state of the neighbor
+	Uptime     uint64 // seconds the session is established
+	Received   uint32 // number of prefixes received
+	Accepted   uint32 // number of prefixes accepted
+	Advertised uint32 // number of prefixes advertised
 }
 
+type OfnetProtoRouteState struct {
+	Prefix  string // destination of the route
+	NextHop string // next hop of the route
+	AsPath  string // As path of the route if applicable
+	Best    bool   // route is the best path to the destination
+}
+
+type OfnetProtoOperState struct {
+	ProtocolType string // type of protocol
+	RouterIP     string // ip address of the router
+	As           string // As for Bgp protocol
+	Neighbors    []OfnetProtoNeighborState
+	Routes       []OfnetProtoRouteState // host routes of the rib, at most MaxOperRoutes
+	RibSize      int                    // number of routes in the rib
+}
+
+// Max number of routes of the protocol oper state
+const MaxOperRoutes = 1000
+
 type OfnetVrfInfo struct {
 	VrfName     string //vrf name
 	VrfId       uint16 //local vrf id
@@ -78,6 +78,13 @@ type OfnetAgent struct {
 	fwdMode      string                   ///forwarding mode routing or bridge
 	GARPStats    map[int]uint32           // per EPG garp stats.
 
+	serviceVIPs map[string]bool // service VIPs advertised to the protocol neighbors
+
+	nameServer      NameServer              // answers the dns queries of the vlans
+	nameServerIPs   map[uint16]net.IP       // name server address by vlan
+	nameServerFlows map[string]*ofctrl.Flow // flows punting dns queries by address
+	nameServerMutex sync.Mutex
+
 }
 
 // local End point information
@@ -139,6 +146,9 @@ func NewOfnetAgent(bridgeName string, dpName string, localIp net.IP, rpcPort uin
 	agent.vrfIdBmp = bitset.New(256)
 	agent.vlanVrf = make(map[uint16]*string)
 	agent.GARPStats = make(map[int]uint32)
+	agent.serviceVIPs = make(map[string]bool)
+	agent.nameServerIPs = make(map[uint16]net.IP)
+	agent.nameServerFlows = make(map[string]*ofctrl.Flow)
 
 	// Create an openflow controller
 	agent.ctrler = ofctrl.NewController(agent)
@@ -248,6 +258,9 @@ func (self *OfnetAgent) SwitchConnected(sw *ofctrl.OFSwitch) {
 	// Inform the datapath
 	self.datapath.SwitchConnected(sw)
 
+	// punt the dns queries of the name servers
+	self.restoreNameServerFlows()
+
 	self.isConnected = true
 }
 
@@ -289,10 +302,20 @@ func (self *OfnetAgent) WaitForSwitchConnection() {
 func (self *OfnetAgent) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
 	log.Debugf("Packet received from switch %v. Packet: %+v", sw.DPID(), pkt)
 
+	// Answer the packets sent to the name servers
+	if self.handleNameServerPkt(pkt) {
+		return
+	}
+
 	// Inform the datapath
 	self.datapath.PacketRcvd(sw, pkt)
 }
 
+// Receive a flow removed by the switch.
+func (self *OfnetAgent) FlowRemoved(sw *ofctrl.OFSwitch, msg *ofctrl.FlowRemoved) {
+	self.datapath.FlowRemoved(sw, msg)
+}
+
 // Add a master
 // ofnet agent tries to connect to the master and download routes
 func (self *OfnetAgent) AddMaster(masterInfo *OfnetNode, ret *bool) error {
@@ -368,9 +391,6 @@ func (self *OfnetAgent) InjectGARPs(epgID int, resp *bool) error {
 // Add a local endpoint.
 // This takes ofp port number, mac address, vlan , VrfId and IP address of the port.
 func (self *OfnetAgent) AddLocalEndpoint(endpoint EndpointInfo) error {
-	// Add port vlan mapping
-	self.portVlanMap[endpoint.PortNo] = &endpoint.Vlan
-
 	// Map Vlan to VNI
 	vni := self.vlanVniMap[endpoint.Vlan]
 	if vni == nil {
@@ -380,12 +400,23 @@ func (self *OfnetAgent) AddLocalEndpoint(endpoint EndpointInfo) error {
 
 	epId := self.getEndpointIdByIpVlan(endpoint.IpAddr, endpoint.Vlan)
 
-	// ignore duplicate adds
-	if (self.localEndpointDb[endpoint.PortNo] != nil) &&
-		(self.localEndpointDb[endpoint.PortNo].EndpointID == epId) {
-		return nil
+	// ignore duplicate adds. An endpoint moving to another group is
+	// removed and added again with the flows of the new group
+	if oldEp := self.localEndpointDb[endpoint.PortNo]; oldEp != nil && oldEp.EndpointID == epId {
+		if oldEp.EndpointGroup == endpoint.EndpointGroup {
+			return nil
+		}
+
+		log.Infof("Moving endpoint %s from group %d to %d", epId, oldEp.EndpointGroup,
+			endpoint.EndpointGroup)
+		if err := self.RemoveLocalEndpoint(endpoint.PortNo); err != nil {
+			return err
+		}
 	}
 
+	// Add port vlan mapping
+	self.portVlanMap[endpoint.PortNo] = &endpoint.Vlan
+
 	vrf := self.vlanVrf[endpoint.Vlan]
 	if vrf == nil {
 		log.Errorf("Invalid vlan to vrf mapping for %v", endpoint.Vlan)
@@ -547,6 +578,13 @@ func (self *OfnetAgent) AddNetwork(vlanId uint16, vni uint32, Gw string, Vrf str
 		return err
 	}
 
+	self.addGateway(vlanId, vni, Gw)
+
+	return nil
+}
+
+// addGateway adds the gateway of a subnet of a vlan to the endpoint db
+func (self *OfnetAgent) addGateway(vlanId uint16, vni uint32, Gw string) {
 	vrf := self.vlanVrf[vlanId]
 	gwEpid := self.getEndpointIdByIpVrf(net.ParseIP(Gw), *vrf)
 
@@ -565,6 +603,30 @@ func (self *OfnetAgent) AddNetwork(vlanId uint16, vni uint32, Gw string, Vrf str
 		}
 		self.endpointDb[gwEpid] = epreg
 	}
+}
+
+// AddNetworkGateway adds the gateway of an additional subnet of a vlan
+func (self *OfnetAgent) AddNetworkGateway(vlanId uint16, Gw string) error {
+	vni, ok := self.vlanVniMap[vlanId]
+	if !ok || self.vlanVrf[vlanId] == nil {
+		return fmt.Errorf("Vlan %d not found", vlanId)
+	}
+
+	log.Infof("ofnet Adding gateway %s to Vlan %d", Gw, vlanId)
+	self.addGateway(vlanId, *vni, Gw)
+
+	return nil
+}
+
+// RemoveNetworkGateway removes the gateway of an additional subnet of a vlan
+func (self *OfnetAgent) RemoveNetworkGateway(vlanId uint16, Gw string) error {
+	vrf := self.vlanVrf[vlanId]
+	if vrf == nil {
+		return fmt.Errorf("Vlan %d not found", vlanId)
+	}
+
+	log.Infof("ofnet Removing gateway %s from Vlan %d", Gw, vlanId)
+	delete(self.endpointDb, self.getEndpointIdByIpVrf(net.ParseIP(Gw), *vrf))
 
 	return nil
 }
@@ -631,7 +693,7 @@ func (self *OfnetAgent) DelSvcSpec(svcName string, spec *ServiceSpec) error {
 }
 
 // SvcProviderUpdate Service Proxy Back End update
-func (self *OfnetAgent) SvcProviderUpdate(svcName string, providers []string) {
+func (self *OfnetAgent) SvcProviderUpdate(svcName string, providers []ProviderSpec) {
 	self.datapath.SvcProviderUpdate(svcName, providers)
 }
 
@@ -717,20 +779,11 @@ func (self *OfnetAgent) DummyRpc(arg *string, ret *bool) error {
 	return nil
 }
 
-//AddBgpNeighbors add bgp neighbor
-func (self *OfnetAgent) AddBgp(routerIP string, As string, neighborAs string, peer string) error {
+//AddBgp starts the bgp router and adds its neighbors
+func (self *OfnetAgent) AddBgp(routerInfo *OfnetProtoRouterInfo, neighbors []*OfnetProtoNeighborInfo) error {
 
-	log.Infof("Received request add bgp config: RouterIp:%v,As:%v,NeighborAs:%v,PeerIP:%v", routerIP, As, neighborAs, peer)
-	routerInfo := &OfnetProtoRouterInfo{
-		ProtocolType: "bgp",
-		RouterIP:     routerIP,
-		As:           As,
-	}
-	neighborInfo := &OfnetProtoNeighborInfo{
-		ProtocolType: "bgp",
-		NeighborIP:   peer,
-		As:           neighborAs,
-	}
+	log.Infof("Received request add bgp config: RouterIp:%v,As:%v,Neighbors:%d", routerInfo.RouterIP, routerInfo.As, len(neighbors))
+	routerInfo.ProtocolType = "bgp"
 	rinfo := self.protopath.GetRouterInfo()
 	if rinfo != nil && rinfo.RouterIP != "" {
 		self.DeleteBgp()
@@ -738,10 +791,13 @@ func (self *OfnetAgent) AddBgp(routerIP string, As string, neighborAs string, pe
 
 	go self.protopath.StartProtoServer(routerInfo)
 
-	err := self.protopath.AddProtoNeighbor(neighborInfo)
-	if err != nil {
-		log.Errorf("Error adding protocol neighbor")
-		return err
+	for _, neighborInfo := range neighbors {
+		neighborInfo.ProtocolType = "bgp"
+		err := self.protopath.AddProtoNeighbor(neighborInfo)
+		if err != nil {
+			log.Errorf("Error adding protocol neighbor %s", neighborInfo.NeighborIP)
+			return err
+		}
 	}
 	return nil
 }
@@ -760,6 +816,14 @@ func (self *OfnetAgent) GetRouterInfo() *OfnetProtoRouterInfo {
 	return self.protopath.GetRouterInfo()
 }
 
+// GetProtoOperState returns the state of the protocol neighbors and routes
+func (self *OfnetAgent) GetProtoOperState() (*OfnetProtoOperState, error) {
+	if self.protopath == nil {
+		return nil, errors.New("Routing protocols run in routing mode only")
+	}
+	return self.protopath.GetProtoOperState()
+}
+
 func (self *OfnetAgent) AddLocalProtoRoute(path *OfnetProtoRouteInfo) {
 	if self.protopath != nil {
 		self.protopath.AddLocalProtoRoute(path)
@@ -772,6 +836,51 @@ func (self *OfnetAgent) DeleteLocalProtoRoute(path *OfnetProtoRouteInfo) {
 	}
 }
 
+// AddServiceVIP advertises a service VIP to the protocol neighbors.
+// The VIP is advertised again whenever a neighbor is added.
+func (self *OfnetAgent) AddServiceVIP(vip string) error {
+	if self.protopath == nil {
+		return errors.New("Service VIPs are advertised in routing mode only")
+	}
+	if self.serviceVIPs[vip] {
+		return nil
+	}
+
+	log.Infof("Advertising service VIP %s", vip)
+	if err := self.datapath.AddServiceVIP(vip); err != nil {
+		return err
+	}
+	self.serviceVIPs[vip] = true
+	return self.protopath.AddLocalProtoRoute(self.serviceVIPRoute(vip))
+}
+
+// DeleteServiceVIP withdraws a service VIP from the protocol neighbors
+func (self *OfnetAgent) DeleteServiceVIP(vip string) error {
+	if self.protopath == nil || !self.serviceVIPs[vip] {
+		return nil
+	}
+
+	log.Infof("Withdrawing service VIP %s", vip)
+	delete(self.serviceVIPs, vip)
+	if err := self.datapath.DeleteServiceVIP(vip); err != nil {
+		log.Errorf("Error removing the uplink flows of service VIP %s. Err: %v", vip, err)
+	}
+	if self.protopath.GetRouterInfo().RouterIP == "" {
+		// nothing was advertised without a router
+		return nil
+	}
+	return self.protopath.DeleteLocalProtoRoute(self.serviceVIPRoute(vip))
+}
+
+// serviceVIPRoute returns the route of a service VIP
+func (self *OfnetAgent) serviceVIPRoute(vip string) *OfnetProtoRouteInfo {
+	return &OfnetProtoRouteInfo{
+		ProtocolType: "bgp",
+		localEpIP:    vip,
+		nextHopIP:    self.protopath.GetRouterInfo().RouterIP,
+	}
+}
+
 func (self *OfnetAgent) createVrf(Vrf string) (uint16, bool) {
 
 	log.Infof("Received create vrf for %v \n", Vrf)
@@ -49,12 +49,20 @@ type OfnetBgp struct {
 	grpcServer *bgpserver.Server    // grpc server to talk to gobgp
 
 	myRouterMac net.HardwareAddr //Router mac used for external proxy
-	myBgpPeer   string           // bgp neighbor
+	myBgpPeers  map[string]bool  // bgp neighbors
 	myBgpAs     uint32
 	cc          *grpc.ClientConn //grpc client connection
 	stop        chan bool
 	start       chan bool
+	started     bool
 	intfName    string //loopback intf to run bgp
+
+	//bgp neighbor timers and route policies
+	holdTime          uint32
+	keepaliveInterval uint32
+	importPrefixes    []*net.IPNet    // prefixes accepted from the neighbors
+	exportPrefixes    []*net.IPNet    // prefixes advertised to the neighbors
+	exportVrfs        map[string]bool // vrfs whose endpoints are advertised
 }
 
 // Create a new vlrouter instance
@@ -84,6 +92,7 @@ func NewOfnetBgp(agent *OfnetAgent, routerInfo []string) *OfnetBgp {
 	ofnetBgp.stop = make(chan bool, 1)
 	ofnetBgp.intfName = "inb01"
 	ofnetBgp.start = make(chan bool, 1)
+	ofnetBgp.myBgpPeers = make(map[string]bool)
 	return ofnetBgp
 }
 
@@ -102,6 +111,15 @@ func (self *OfnetBgp) StartProtoServer(routerInfo *OfnetProtoRouterInfo) error {
 	as, _ := strconv.Atoi(routerInfo.As)
 	self.myBgpAs = uint32(as)
 
+	self.holdTime = routerInfo.HoldTime
+	self.keepaliveInterval = routerInfo.KeepaliveInterval
+	self.importPrefixes = parsePrefixes(routerInfo.ImportPrefixes)
+	self.exportPrefixes = parsePrefixes(routerInfo.ExportPrefixes)
+	self.exportVrfs = make(map[string]bool)
+	for _, vrf := range routerInfo.ExportVrfs {
+		self.exportVrfs[vrf] = true
+	}
+
 	self.modRibCh = make(chan *api.Path, 16)
 	self.advPathCh = make(chan *api.Path, 16)
 
@@ -228,53 +246,65 @@ func (self *OfnetBgp) StopProtoServer() error {
 	}
 	self.routerIP = ""
 	self.myBgpAs = 0
+	self.Lock()
+	self.started = false
+	self.Unlock()
 	self.agent.deleteVrf("default")
 	self.stop <- true
 	return nil
 }
 
-//DeleteProtoNeighbor deletes bgp neighbor for the host
+//DeleteProtoNeighbor deletes the bgp neighbors of the host
 func (self *OfnetBgp) DeleteProtoNeighbor() error {
 
 	/*As a part of delete bgp neighbors
-	1) Search for BGP peer and remove from Bgp.
-	2) Delete endpoint info for peer
+	1) Search for BGP peers and remove from Bgp.
+	2) Delete endpoint info for peers
 	3) Finally delete all routes learnt on the nexthop bgp port.
 	4) Mark the routes learn via json rpc as unresolved
 	*/
-	log.Infof("Received DeleteProtoNeighbor to delete bgp neighbor %v", self.myBgpPeer)
 	client := api.NewGobgpApiClient(self.cc)
 	if client == nil {
 		log.Errorf("Invalid Gobgpapi client")
 		return errors.New("Error creating Gobgpapiclient")
 	}
-	arg := &api.Arguments{Name: self.myBgpPeer}
 
-	peer, err := client.GetNeighbor(context.Background(), arg)
-	if err != nil {
-		log.Errorf("GetNeighbor failed: %v", err)
-		return err
-	}
-	log.Infof("Deleteing Bgp peer from Bgp server")
-	p := bgpconf.Neighbor{}
-	setNeighborConfigValues(&p)
+	self.Lock()
+	peers := self.myBgpPeers
+	self.myBgpPeers = make(map[string]bool)
+	self.Unlock()
 
-	p.NeighborAddress = net.ParseIP(peer.Conf.NeighborAddress)
-	p.NeighborConfig.NeighborAddress = net.ParseIP(peer.Conf.NeighborAddress)
-	p.NeighborConfig.PeerAs = uint32(peer.Conf.PeerAs)
-	//FIX ME set ipv6 depending on peerip (for v6 BGP)
-	p.AfiSafis.AfiSafiList = []bgpconf.AfiSafi{
-		bgpconf.AfiSafi{AfiSafiName: "ipv4-unicast"}}
-	self.bgpServer.SetBmpConfig(bgpconf.BmpServers{
-		BmpServerList: []bgpconf.BmpServer{},
-	})
+	for bgpPeer := range peers {
+		log.Infof("Received DeleteProtoNeighbor to delete bgp neighbor %v", bgpPeer)
+		arg := &api.Arguments{Name: bgpPeer}
 
-	self.bgpServer.PeerDelete(p)
+		peer, err := client.GetNeighbor(context.Background(), arg)
+		if err != nil {
+			log.Errorf("GetNeighbor %v failed: %v", bgpPeer, err)
+		} else {
+			log.Infof("Deleteing Bgp peer from Bgp server")
+			p := bgpconf.Neighbor{}
+			setNeighborConfigValues(&p)
+
+			p.NeighborAddress = net.ParseIP(peer.Conf.NeighborAddress)
+			p.NeighborConfig.NeighborAddress = net.ParseIP(peer.Conf.NeighborAddress)
+			p.NeighborConfig.PeerAs = uint32(peer.Conf.PeerAs)
+			//FIX ME set ipv6 depending on peerip (for v6 BGP)
+			p.AfiSafis.AfiSafiList = []bgpconf.AfiSafi{
+				bgpconf.AfiSafi{AfiSafiName: "ipv4-unicast"}}
+			self.bgpServer.SetBmpConfig(bgpconf.BmpServers{
+				BmpServerList: []bgpconf.BmpServer{},
+			})
+
+			self.bgpServer.PeerDelete(p)
+		}
 
-	bgpEndpoint := self.agent.getEndpointByIpVrf(net.ParseIP(self.myBgpPeer), "default")
-	self.agent.datapath.RemoveEndpoint(bgpEndpoint)
-	delete(self.agent.endpointDb, bgpEndpoint.EndpointID)
-	self.myBgpPeer = ""
+		bgpEndpoint := self.agent.getEndpointByIpVrf(net.ParseIP(bgpPeer), "default")
+		if bgpEndpoint != nil {
+			self.agent.datapath.RemoveEndpoint(bgpEndpoint)
+			delete(self.agent.endpointDb, bgpEndpoint.EndpointID)
+		}
+	}
 
 	uplink, _ := self.agent.ovsDriver.GetOfpPortNo(self.vlanIntf)
 
@@ -298,7 +328,14 @@ func (self *OfnetBgp) DeleteProtoNeighbor() error {
 //AddProtoNeighbor adds bgp neighbor
 func (self *OfnetBgp) AddProtoNeighbor(neighborInfo *OfnetProtoNeighborInfo) error {
 
-	<-self.start
+	// wait for the bgp server to start before adding the first neighbor,
+	// holding the lock so that only the first neighbor waits
+	self.Lock()
+	if !self.started {
+		<-self.start
+		self.started = true
+	}
+	self.Unlock()
 
 	log.Infof("Received AddProtoNeighbor to Add bgp neighbor %v", neighborInfo.NeighborIP)
 
@@ -310,6 +347,14 @@ func (self *OfnetBgp) AddProtoNeighbor(neighborInfo *OfnetProtoNeighborInfo) err
 	p.NeighborAddress = net.ParseIP(neighborInfo.NeighborIP)
 	p.NeighborConfig.NeighborAddress = net.ParseIP(neighborInfo.NeighborIP)
 	p.NeighborConfig.PeerAs = uint32(peerAs)
+	p.NeighborConfig.AuthPassword = neighborInfo.Password
+	if self.holdTime != 0 {
+		p.Timers.TimersConfig.HoldTime = float64(self.holdTime)
+		p.Timers.TimersConfig.KeepaliveInterval = float64(self.holdTime / 3)
+	}
+	if self.keepaliveInterval != 0 {
+		p.Timers.TimersConfig.KeepaliveInterval = float64(self.keepaliveInterval)
+	}
 	//FIX ME set ipv6 depending on peerip (for v6 BGP)
 	p.AfiSafis.AfiSafiList = []bgpconf.AfiSafi{
 		bgpconf.AfiSafi{AfiSafiName: "ipv4-unicast"}}
@@ -341,8 +386,16 @@ func (self *OfnetBgp) AddProtoNeighbor(neighborInfo *OfnetProtoNeighborInfo) err
 		log.Errorf("Error adding endpoint: {%+v}. Err: %v", epreg, err)
 		return err
 	}
-	self.myBgpPeer = neighborInfo.NeighborIP
-	go self.sendArp()
+	self.Lock()
+	self.myBgpPeers[neighborInfo.NeighborIP] = true
+	firstPeer := len(self.myBgpPeers) == 1
+	self.Unlock()
+	go self.sendArp(neighborInfo.NeighborIP)
+
+	// the routes in the global rib are advertised to all the neighbors
+	if !firstPeer {
+		return nil
+	}
 
 	//Walk through all the localEndpointDb and them to protocol rib
 	for _, endpoint := range self.agent.localEndpointDb {
@@ -350,6 +403,17 @@ func (self *OfnetBgp) AddProtoNeighbor(neighborInfo *OfnetProtoNeighborInfo) err
 			ProtocolType: "bgp",
 			localEpIP:    endpoint.IpAddr.String(),
 			nextHopIP:    self.routerIP,
+			vrf:          endpoint.Vrf,
+		}
+		self.AddLocalProtoRoute(path)
+	}
+
+	//Walk through the service VIPs with local providers
+	for vip := range self.agent.serviceVIPs {
+		path := &OfnetProtoRouteInfo{
+			ProtocolType: "bgp",
+			localEpIP:    vip,
+			nextHopIP:    self.routerIP,
 		}
 		self.AddLocalProtoRoute(path)
 	}
@@ -375,6 +439,11 @@ func (self *OfnetBgp) AddLocalProtoRoute(pathInfo *OfnetProtoRouteInfo) error {
 		return nil
 	}
 
+	if !self.exportRoute(pathInfo) {
+		log.Infof("Not advertising %v, filtered by the export policy", pathInfo)
+		return nil
+	}
+
 	log.Infof("Received AddLocalProtoRoute to add local endpoint to protocol RIB: %v", pathInfo)
 
 	path := &api.Path{
@@ -432,6 +501,11 @@ func (self *OfnetBgp) AddLocalProtoRoute(pathInfo *OfnetProtoRouteInfo) error {
 //DeleteLocalProtoRoute withdraws local endpoints from protocol RIB
 func (self *OfnetBgp) DeleteLocalProtoRoute(pathInfo *OfnetProtoRouteInfo) error {
 
+	if !self.exportRoute(pathInfo) {
+		// the route was not advertised
+		return nil
+	}
+
 	log.Infof("Received DeleteLocalProtoRoute to withdraw local endpoint to protocol RIB: %v", pathInfo)
 
 	path := &api.Path{
@@ -523,7 +597,8 @@ func (self *OfnetBgp) monitorBest() {
 // monitorPeer is used to monitor the bgp peer state
 func (self *OfnetBgp) monitorPeer() {
 
-	var oldAdminState, oldState string
+	oldAdminStates := make(map[string]string)
+	oldStates := make(map[string]string)
 
 	client := api.NewGobgpApiClient(self.cc)
 	if client == nil {
@@ -547,36 +622,41 @@ func (self *OfnetBgp) monitorPeer() {
 		}
 		fmt.Printf("[NEIGH] %s fsm: %s admin: %s\n", s.Conf.NeighborAddress,
 			s.Info.BgpState, s.Info.AdminState)
-		if oldState == "BGP_FSM_ESTABLISHED" && oldAdminState == "ADMIN_STATE_UP" {
+		peerIP := s.Conf.NeighborAddress
+		if oldStates[peerIP] == "BGP_FSM_ESTABLISHED" && oldAdminStates[peerIP] == "ADMIN_STATE_UP" {
 			uplink, _ := self.agent.ovsDriver.GetOfpPortNo(self.vlanIntf)
 			/*If the state changed from being established to idle or active:
 			   1) delete all endpoints learnt via bgp Peer
-				 2) mark routes pointing to the bgp nexthop as unresolved
+				 2) move routes pointing to the bgp nexthop to the other peers
+				    or mark them as unresolved
 				 3) mark the bgp peer reachbility as unresolved
 			*/
-			endpoint := self.agent.getEndpointByIpVrf(net.ParseIP(self.myBgpPeer), "default")
-			self.agent.datapath.RemoveEndpoint(endpoint)
-			endpoint.PortNo = 0
-			self.agent.endpointDb[endpoint.EndpointID] = endpoint
-			self.agent.datapath.AddEndpoint(endpoint)
-
-			for _, endpoint = range self.agent.endpointDb {
-				if endpoint.PortNo == uplink {
-					self.agent.datapath.RemoveEndpoint(endpoint)
-					if endpoint.EndpointType == "internal" {
-						endpoint.PortNo = 0
-						self.agent.endpointDb[endpoint.EndpointID] = endpoint
-						//We readd unresolved endpoints that were learnt via
-						//json rpc
-						self.agent.datapath.AddEndpoint(endpoint)
-					} else if endpoint.EndpointType == "external" {
-						delete(self.agent.endpointDb, endpoint.EndpointID)
+			endpoint := self.agent.getEndpointByIpVrf(net.ParseIP(peerIP), "default")
+			if endpoint != nil {
+				peerMac := endpoint.MacAddrStr
+				self.agent.datapath.RemoveEndpoint(endpoint)
+				endpoint.PortNo = 0
+				self.agent.endpointDb[endpoint.EndpointID] = endpoint
+				self.agent.datapath.AddEndpoint(endpoint)
+
+				for _, endpoint = range self.agent.endpointDb {
+					if endpoint.PortNo == uplink && endpoint.MacAddrStr == peerMac {
+						self.agent.datapath.RemoveEndpoint(endpoint)
+						if endpoint.EndpointType == "internal" {
+							endpoint.PortNo = 0
+							self.agent.endpointDb[endpoint.EndpointID] = endpoint
+							//We readd unresolved endpoints that were learnt via
+							//json rpc
+							self.agent.datapath.AddEndpoint(endpoint)
+						} else if endpoint.EndpointType == "external" {
+							delete(self.agent.endpointDb, endpoint.EndpointID)
+						}
 					}
 				}
 			}
 		}
-		oldState = s.Info.BgpState
-		oldAdminState = s.Info.AdminState
+		oldStates[peerIP] = s.Info.BgpState
+		oldAdminStates[peerIP] = s.Info.AdminState
 	}
 	return
 }
@@ -649,6 +729,11 @@ func (self *OfnetBgp) modRib(path *api.Path) error {
 	ipmask := net.ParseIP("255.255.255.255").Mask(endpointIPNet.Mask)
 
 	if path.IsWithdraw != true {
+		if len(self.importPrefixes) > 0 && !prefixMatch(self.importPrefixes, endpointIPNet) {
+			log.Infof("Ignoring %v, filtered by the import policy", endpointIPNet)
+			return nil
+		}
+
 		epreg := &OfnetEndpoint{
 			EndpointID:   epid,
 			EndpointType: "external",
@@ -737,14 +822,18 @@ func setNeighborConfigValues(neighbor *bgpconf.Neighbor) error {
 	return nil
 }
 
-func (self *OfnetBgp) sendArp() {
+//sendArp resolves a bgp peer until it is deleted
+func (self *OfnetBgp) sendArp(bgpPeer string) {
 
 	//Get the Mac of the vlan intf
 	//Get the portno of the uplink
 	//Build an arp packet and send on portno of uplink
 	time.Sleep(5 * time.Second)
 	for {
-		if self.myBgpPeer == "" {
+		self.Lock()
+		found := self.myBgpPeers[bgpPeer]
+		self.Unlock()
+		if !found {
 			return
 		}
 
@@ -754,7 +843,7 @@ func (self *OfnetBgp) sendArp() {
 		zeroMac, _ := net.ParseMAC("00:00:00:00:00:00")
 
 		srcIP := net.ParseIP(self.routerIP)
-		dstIP := net.ParseIP(self.myBgpPeer)
+		dstIP := net.ParseIP(bgpPeer)
 		arpReq, _ := protocol.NewARP(protocol.Type_Request)
 		arpReq.HWSrc = intf.HardwareAddr
 		arpReq.IPSrc = srcIP
@@ -788,3 +877,158 @@ func (self *OfnetBgp) sendArp() {
 func (self *OfnetBgp) ModifyProtoRib(path interface{}) {
 	self.modRibCh <- path.(*api.Path)
 }
+
+//GetProtoOperState returns the state of the bgp neighbors and the routes of
+//the rib
+func (self *OfnetBgp) GetProtoOperState() (*OfnetProtoOperState, error) {
+	if self.routerIP == "" || self.cc == nil {
+		return nil, errors.New("Bgp server is not running")
+	}
+
+	client := api.NewGobgpApiClient(self.cc)
+	if client == nil {
+		log.Errorf("Invalid Gobgpapi client")
+		return nil, errors.New("Error creating Gobgpapiclient")
+	}
+
+	operState := &OfnetProtoOperState{
+		ProtocolType: "bgp",
+		RouterIP:     self.routerIP,
+		As:           strconv.FormatUint(uint64(self.myBgpAs), 10),
+	}
+
+	stream, err := client.GetNeighbors(context.Background(), &api.Arguments{})
+	if err != nil {
+		log.Errorf("GetNeighbors failed: %v", err)
+		return nil, err
+	}
+	for {
+		peer, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Errorf("GetNeighbors stream failed: %v", err)
+			return nil, err
+		}
+		if peer.Conf == nil {
+			continue
+		}
+
+		neighbor := OfnetProtoNeighborState{
+			NeighborIP: peer.Conf.NeighborAddress,
+			As:         strconv.FormatUint(uint64(peer.Conf.PeerAs), 10),
+		}
+		if peer.Info != nil {
+			neighbor.State = peer.Info.BgpState
+			neighbor.AdminState = peer.Info.AdminState
+			neighbor.Received = peer.Info.Received
+			neighbor.Accepted = peer.Info.Accepted
+			neighbor.Advertised = peer.Info.Advertized
+		}
+		if neighbor.State == "BGP_FSM_ESTABLISHED" && peer.Timers != nil && peer.Timers.State != nil {
+			neighbor.Uptime = peer.Timers.State.Uptime
+		}
+		operState.Neighbors = append(operState.Neighbors, neighbor)
+	}
+
+	rib, err := client.GetRib(context.Background(), &api.Table{
+		Type:   api.Resource_GLOBAL,
+		Family: uint32(bgp.RF_IPv4_UC),
+	})
+	if err != nil {
+		log.Errorf("GetRib failed: %v", err)
+		return nil, err
+	}
+	operState.Routes, operState.RibSize = hostRoutes(rib.Destinations, MaxOperRoutes)
+
+	return operState, nil
+}
+
+//hostRoutes returns up to max host routes of the rib and the number of
+//routes in the rib. The endpoints and the service addresses are advertised
+//as host routes, the other routes are not of interest.
+func hostRoutes(dsts []*api.Destination, max int) ([]OfnetProtoRouteState, int) {
+	routes := []OfnetProtoRouteState{}
+	ribSize := 0
+	for _, dst := range dsts {
+		ribSize += len(dst.Paths)
+		_, ipNet, err := net.ParseCIDR(dst.Prefix)
+		if err != nil {
+			continue
+		}
+		if ones, bits := ipNet.Mask.Size(); ones != bits {
+			continue
+		}
+
+		for _, path := range dst.Paths {
+			if len(routes) >= max {
+				break
+			}
+			route := OfnetProtoRouteState{
+				Prefix: dst.Prefix,
+				Best:   path.Best,
+			}
+			for _, attr := range path.Pattrs {
+				p, err := bgp.GetPathAttribute(attr)
+				if err != nil || p.DecodeFromBytes(attr) != nil {
+					continue
+				}
+				switch p.GetType() {
+				case bgp.BGP_ATTR_TYPE_NEXT_HOP:
+					route.NextHop = p.(*bgp.PathAttributeNextHop).Value.String()
+				case bgp.BGP_ATTR_TYPE_AS_PATH:
+					route.AsPath = p.(*bgp.PathAttributeAsPath).String()
+				}
+			}
+			routes = append(routes, route)
+		}
+	}
+
+	return routes, ribSize
+}
+
+//exportRoute checks a local route against the export policy. The router
+//address is always advertised, endpoints of vrfs that are not exported are not.
+func (self *OfnetBgp) exportRoute(pathInfo *OfnetProtoRouteInfo) bool {
+	if pathInfo.localEpIP == self.routerIP {
+		return true
+	}
+	if pathInfo.vrf != "" && len(self.exportVrfs) > 0 && !self.exportVrfs[pathInfo.vrf] {
+		return false
+	}
+	if len(self.exportPrefixes) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(pathInfo.localEpIP)
+	if ip == nil || ip.To4() == nil {
+		return false
+	}
+	return prefixMatch(self.exportPrefixes, &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)})
+}
+
+//parsePrefixes parses the prefixes of a route policy
+func parsePrefixes(prefixes []string) []*net.IPNet {
+	ipNets := []*net.IPNet{}
+	for _, prefix := range prefixes {
+		_, ipNet, err := net.ParseCIDR(prefix)
+		if err != nil {
+			log.Errorf("Ignoring invalid prefix %s. Err: %v", prefix, err)
+			continue
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets
+}
+
+//prefixMatch checks if a prefix is within one of the prefixes of a list
+func prefixMatch(prefixes []*net.IPNet, prefix *net.IPNet) bool {
+	prefixLen, _ := prefix.Mask.Size()
+	for _, ipNet := range prefixes {
+		ipNetLen, _ := ipNet.Mask.Size()
+		if ipNet.Contains(prefix.IP) && prefixLen >= ipNetLen {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package ofnet
+
+import (
+	"net"
+	"testing"
+
+	api "github.com/osrg/gobgp/api"
+	"github.com/osrg/gobgp/packet"
+)
+
+func TestPrefixMatch(t *testing.T) {
+	prefixes := parsePrefixes([]string{"10.1.0.0/16", "bad", "20.1.1.0/24"})
+	if len(prefixes) != 2 {
+		t.Fatalf("Parsed %d prefixes, expected the 2 valid ones", len(prefixes))
+	}
+
+	testCases := []struct {
+		prefix string
+		match  bool
+	}{
+		{"10.1.2.3/32", true},
+		{"10.1.0.0/16", true},
+		{"10.0.0.0/8", false}, // shorter than the policy prefix
+		{"10.2.0.0/16", false},
+		{"20.1.1.128/25", true},
+		{"20.1.2.0/24", false},
+	}
+	for _, tc := range testCases {
+		_, ipNet, _ := net.ParseCIDR(tc.prefix)
+		if prefixMatch(prefixes, ipNet) != tc.match {
+			t.Errorf("prefixMatch of %s is %v, expected %v", tc.prefix, !tc.match, tc.match)
+		}
+	}
+
+	if prefixMatch(nil, &net.IPNet{IP: net.ParseIP("10.1.1.1"), Mask: net.CIDRMask(32, 32)}) {
+		t.Errorf("Prefix matched an empty list")
+	}
+}
+
+func TestExportRoute(t *testing.T) {
+	bgp := &OfnetBgp{
+		routerIP:       "50.1.1.1",
+		exportPrefixes: parsePrefixes([]string{"10.1.0.0/16"}),
+		exportVrfs:     map[string]bool{"blue": true},
+	}
+
+	testCases := []struct {
+		route  OfnetProtoRouteInfo
+		export bool
+	}{
+		// the router address is advertised regardless of the policies
+		{OfnetProtoRouteInfo{localEpIP: "50.1.1.1", vrf: "red"}, true},
+		{OfnetProtoRouteInfo{localEpIP: "10.1.1.2", vrf: "blue"}, true},
+		{OfnetProtoRouteInfo{localEpIP: "10.1.1.3", vrf: "red"}, false},
+		{OfnetProtoRouteInfo{localEpIP: "10.2.1.2", vrf: "blue"}, false},
+		// routes without a vrf are only filtered by prefix
+		{OfnetProtoRouteInfo{localEpIP: "10.1.1.4"}, true},
+		{OfnetProtoRouteInfo{localEpIP: "10.2.1.4"}, false},
+		{OfnetProtoRouteInfo{localEpIP: "2001::1", vrf: "blue"}, false},
+	}
+	for _, tc := range testCases {
+		if bgp.exportRoute(&tc.route) != tc.export {
+			t.Errorf("exportRoute of %+v is %v, expected %v", tc.route, !tc.export, tc.export)
+		}
+	}
+
+	// without policies every route is advertised
+	bgp = &OfnetBgp{routerIP: "50.1.1.1"}
+	for _, tc := range testCases {
+		if !bgp.exportRoute(&tc.route) {
+			t.Errorf("Route %+v not exported without policies", tc.route)
+		}
+	}
+}
+
+func TestHostRoutes(t *testing.T) {
+	nexthop, _ := bgp.NewPathAttributeNextHop("50.1.1.2").Serialize()
+	path := &api.Path{Pattrs: [][]byte{nexthop}, Best: true}
+	dsts := []*api.Destination{
+		{Prefix: "10.1.1.2/32", Paths: []*api.Path{path}},
+		{Prefix: "20.1.0.0/16", Paths: []*api.Path{path, {}}},
+		{Prefix: "10.1.1.3/32", Paths: []*api.Path{path}},
+		{Prefix: "10.1.1.4/32", Paths: []*api.Path{path}},
+	}
+
+	routes, ribSize := hostRoutes(dsts, 2)
+	if ribSize != 5 {
+		t.Errorf("Rib size is %d, expected 5", ribSize)
+	}
+	expRoutes := []OfnetProtoRouteState{
+		{Prefix: "10.1.1.2/32", NextHop: "50.1.1.2", Best: true},
+		{Prefix: "10.1.1.3/32", NextHop: "50.1.1.2", Best: true},
+	}
+	if len(routes) != len(expRoutes) {
+		t.Fatalf("Got routes %+v, expected %+v", routes, expRoutes)
+	}
+	for i := range routes {
+		if routes[i] != expRoutes[i] {
+			t.Errorf("Got route %+v, expected %+v", routes[i], expRoutes[i])
+		}
+	}
+}
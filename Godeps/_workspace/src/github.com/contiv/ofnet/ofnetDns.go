@@ -0,0 +1,293 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package ofnet
+
+// This file implements the name servers of the networks. Dns queries sent
+// to the name server address of a network are punted to the agent, which
+// answers them with the replies of the configured NameServer.
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/ofnet/ofctrl"
+	"github.com/shaleman/libOpenflow/openflow13"
+	"github.com/shaleman/libOpenflow/protocol"
+)
+
+// NameServer answers the dns queries sent to the name server of a network
+type NameServer interface {
+	// ServeDNS returns the reply to a dns query received on a vlan, nil to
+	// drop the query
+	ServeDNS(vlanID uint16, query []byte) []byte
+}
+
+const FLOW_NAMESERVER_PRIORITY = 200 // Priority for name server flows
+
+const dnsPort = 53
+
+// nameServerMac is the mac name server addresses resolve to
+var nameServerMac, _ = net.ParseMAC("02:02:00:00:00:53")
+
+// SetNameServer sets the name server answering the dns queries
+func (self *OfnetAgent) SetNameServer(nameServer NameServer) {
+	self.nameServerMutex.Lock()
+	defer self.nameServerMutex.Unlock()
+
+	self.nameServer = nameServer
+}
+
+// AddNameServerIP punts the dns queries sent to the name server address of
+// a vlan to the agent
+func (self *OfnetAgent) AddNameServerIP(vlanID uint16, ip net.IP) error {
+	self.nameServerMutex.Lock()
+	defer self.nameServerMutex.Unlock()
+
+	if ip.To4() == nil {
+		return errors.New("Name server address must be an IPv4 address")
+	}
+	if oldIP := self.nameServerIPs[vlanID]; oldIP != nil {
+		if oldIP.Equal(ip) {
+			return nil
+		}
+		self.releaseNameServerFlow(oldIP)
+	}
+
+	log.Infof("Adding name server %s on vlan %d", ip, vlanID)
+	self.nameServerIPs[vlanID] = ip
+	if self.nameServerFlows[ip.String()] == nil {
+		flow, err := self.installNameServerFlow(ip)
+		if err != nil {
+			delete(self.nameServerIPs, vlanID)
+			return err
+		}
+		self.nameServerFlows[ip.String()] = flow
+	}
+
+	return nil
+}
+
+// RemoveNameServerIP stops answering the dns queries of a vlan
+func (self *OfnetAgent) RemoveNameServerIP(vlanID uint16) error {
+	self.nameServerMutex.Lock()
+	defer self.nameServerMutex.Unlock()
+
+	ip := self.nameServerIPs[vlanID]
+	if ip == nil {
+		return nil
+	}
+
+	log.Infof("Removing name server %s on vlan %d", ip, vlanID)
+	delete(self.nameServerIPs, vlanID)
+	self.releaseNameServerFlow(ip)
+
+	return nil
+}
+
+// releaseNameServerFlow removes the flow of a name server address unless
+// other vlans use the address
+func (self *OfnetAgent) releaseNameServerFlow(ip net.IP) {
+	for _, vlanIP := range self.nameServerIPs {
+		if vlanIP.Equal(ip) {
+			return
+		}
+	}
+
+	if flow := self.nameServerFlows[ip.String()]; flow != nil {
+		flow.Delete()
+	}
+	delete(self.nameServerFlows, ip.String())
+}
+
+// installNameServerFlow punts the dns queries to a name server address
+func (self *OfnetAgent) installNameServerFlow(ip net.IP) (*ofctrl.Flow, error) {
+	if self.ofSwitch == nil {
+		// installed when the switch connects
+		return nil, nil
+	}
+
+	flow, err := self.ofSwitch.DefaultTable().NewFlow(ofctrl.FlowMatch{
+		Priority:   FLOW_NAMESERVER_PRIORITY,
+		Ethertype:  0x0800,
+		IpDa:       &ip,
+		IpProto:    ofctrl.IP_PROTO_UDP,
+		UdpDstPort: dnsPort,
+	})
+	if err != nil {
+		log.Errorf("Error creating name server flow for %s. Err: %v", ip, err)
+		return nil, err
+	}
+
+	err = flow.Next(self.ofSwitch.SendToController())
+	if err != nil {
+		log.Errorf("Error installing name server flow for %s. Err: %v", ip, err)
+		return nil, err
+	}
+
+	return flow, nil
+}
+
+// restoreNameServerFlows installs the name server flows on a new switch
+func (self *OfnetAgent) restoreNameServerFlows() {
+	self.nameServerMutex.Lock()
+	defer self.nameServerMutex.Unlock()
+
+	for ipStr := range self.nameServerFlows {
+		flow, err := self.installNameServerFlow(net.ParseIP(ipStr))
+		if err == nil {
+			self.nameServerFlows[ipStr] = flow
+		}
+	}
+}
+
+// nameServerOfPort returns the name server address of the vlan of a port
+func (self *OfnetAgent) nameServerOfPort(inPort uint32) (uint16, net.IP) {
+	vlan := self.portVlanMap[inPort]
+	if vlan == nil {
+		return 0, nil
+	}
+
+	self.nameServerMutex.Lock()
+	defer self.nameServerMutex.Unlock()
+
+	return *vlan, self.nameServerIPs[*vlan]
+}
+
+// handleNameServerPkt answers the arp requests and dns queries sent to a
+// name server. Returns false if the packet is not for a name server.
+func (self *OfnetAgent) handleNameServerPkt(pkt *ofctrl.PacketIn) bool {
+	inPort := getInPort(pkt)
+
+	switch t := pkt.Data.Data.(type) {
+	case *protocol.ARP:
+		if t.Operation != protocol.Type_Request {
+			return false
+		}
+		_, nsIP := self.nameServerOfPort(inPort)
+		if nsIP == nil || !nsIP.Equal(t.IPDst) {
+			return false
+		}
+
+		arpPkt, _ := protocol.NewARP(protocol.Type_Reply)
+		arpPkt.HWSrc = nameServerMac
+		arpPkt.IPSrc = t.IPDst
+		arpPkt.HWDst = t.HWSrc
+		arpPkt.IPDst = t.IPSrc
+
+		ethPkt := protocol.NewEthernet()
+		ethPkt.VLANID.VID = pkt.Data.VLANID.VID
+		ethPkt.HWDst = t.HWSrc
+		ethPkt.HWSrc = nameServerMac
+		ethPkt.Ethertype = 0x0806
+		ethPkt.Data = arpPkt
+
+		self.sendToPort(ethPkt, inPort)
+		return true
+
+	case *protocol.IPv4:
+		udp, ok := t.Data.(*protocol.UDP)
+		if !ok || t.Protocol != protocol.Type_UDP || udp.PortDst != dnsPort {
+			return false
+		}
+		vlanID, nsIP := self.nameServerOfPort(inPort)
+		if nsIP == nil || !nsIP.Equal(t.NWDst) {
+			return false
+		}
+
+		self.nameServerMutex.Lock()
+		nameServer := self.nameServer
+		self.nameServerMutex.Unlock()
+		if nameServer == nil {
+			return true
+		}
+
+		// the query may be padded by the ethernet frame
+		query := udp.Data
+		if int(udp.Length) >= 8 && int(udp.Length)-8 < len(query) {
+			query = query[:udp.Length-8]
+		}
+
+		// upstream queries take a while, answer in the background
+		go func() {
+			reply := nameServer.ServeDNS(vlanID, query)
+			if reply == nil {
+				return
+			}
+			self.sendToPort(buildDNSReply(&pkt.Data, t, udp, reply), inPort)
+		}()
+		return true
+	}
+
+	return false
+}
+
+// sendToPort sends a packet out of a port
+func (self *OfnetAgent) sendToPort(ethPkt *protocol.Ethernet, portNo uint32) {
+	if self.ofSwitch == nil {
+		return
+	}
+
+	pktOut := openflow13.NewPacketOut()
+	pktOut.Data = ethPkt
+	pktOut.AddAction(openflow13.NewActionOutput(portNo))
+
+	self.ofSwitch.Send(pktOut)
+}
+
+// buildDNSReply builds the packet carrying the reply to a dns query
+func buildDNSReply(queryEth *protocol.Ethernet, queryIP *protocol.IPv4,
+	queryUDP *protocol.UDP, reply []byte) *protocol.Ethernet {
+	udp := protocol.NewUDP()
+	udp.PortSrc = dnsPort
+	udp.PortDst = queryUDP.PortSrc
+	udp.Data = reply
+	udp.Length = udp.Len()
+
+	ip := protocol.NewIPv4()
+	ip.Version = 4
+	ip.IHL = 5
+	ip.TTL = 64
+	ip.Protocol = protocol.Type_UDP
+	ip.NWSrc = queryIP.NWDst
+	ip.NWDst = queryIP.NWSrc
+	ip.Data = udp
+	ip.Length = ip.Len()
+	hdr, _ := ip.MarshalBinary()
+	ip.Checksum = ipv4Checksum(hdr[:20])
+
+	ethPkt := protocol.NewEthernet()
+	ethPkt.VLANID.VID = queryEth.VLANID.VID
+	ethPkt.HWDst = queryEth.HWSrc
+	ethPkt.HWSrc = nameServerMac
+	ethPkt.Ethertype = 0x0800
+	ethPkt.Data = ip
+
+	return ethPkt
+}
+
+// ipv4Checksum returns the checksum of an IPv4 header
+func ipv4Checksum(hdr []byte) uint16 {
+	var sum uint32
+	for idx := 0; idx+1 < len(hdr); idx += 2 {
+		sum += uint32(binary.BigEndian.Uint16(hdr[idx:]))
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+
+	return ^uint16(sum)
+}
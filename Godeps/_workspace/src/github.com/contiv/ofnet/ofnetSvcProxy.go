@@ -16,13 +16,17 @@ limitations under the License.
 package ofnet
 
 import (
+	"encoding/binary"
 	"errors"
 	"github.com/contiv/ofnet/pqueue"
 	"github.com/shaleman/libOpenflow/openflow13"
 	"github.com/shaleman/libOpenflow/protocol"
+	"github.com/shaleman/libOpenflow/util"
 	"net"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/ofnet/ofctrl"
@@ -34,24 +38,56 @@ const (
 	watchedFlowMax = 2
 	spDNAT         = "Dst"
 	spSNAT         = "Src"
+
+	// how long a client sticks to its provider with ClientIP affinity,
+	// unless the service sets its own timeout
+	defaultAffinityTimeout = 3 * time.Hour
+
+	// how often the clients whose affinity expired are removed
+	affinitySweepInterval = time.Minute
+
+	// seconds the NAT flows of a client may go unused, the client is
+	// balanced again on its next request after they are removed
+	natIdleTimeout = 300
+)
+
+// Load balancing modes of a service
+const (
+	LBModeRoundRobin = "roundrobin" // weighted round robin over the providers
+	LBModeLeastConn  = "leastconn"  // provider with the least clients per weight
 )
 
 // PortSpec defines protocol/port info required to host the service
 type PortSpec struct {
+	Name     string // name of the port, unique within the service
 	Protocol string
 	SvcPort  uint16 // advertised port
-	ProvPort uint16 // actual port of provider
+	ProvPort uint16 // actual port of provider, 0 if set per provider
+	NodePort uint16 // port exposed on the node IP, 0 if none
 }
 
 // ServiceSpec defines a service to be proxied
 type ServiceSpec struct {
+	IpAddress        string
+	Ports            []PortSpec
+	ExternalIPs      []string // additional IPs the service is exposed on
+	NodeIP           string   // IP the node ports are exposed on
+	ClientIPAffinity bool     // send all requests of a client to the same provider
+	AffinityTimeout  uint32   // seconds a client sticks to its provider after its last request, the default if 0
+	LBMode           string   // LBModeRoundRobin or LBModeLeastConn, leastconn if empty
+}
+
+// ProviderSpec defines a provider of a service
+type ProviderSpec struct {
 	IpAddress string
-	Ports     []PortSpec
+	Ports     map[string]uint16 // actual port of the provider by port name
+	Weight    int               // relative share of the clients, 1 if 0
 }
 
 // Providers holds the current providers of a given service
 type Providers struct {
-	providers map[string]bool // Provider IP as key
+	providers map[string]map[string]uint16 // Provider IP as key, ports as value
+	weights   map[string]int               // Provider IP as key
 }
 
 // svcCatalogue holds information about all services to be proxied
@@ -63,13 +99,61 @@ type svcCatalogue struct {
 
 // provOper holds operational info for each provider
 type provOper struct {
-	clientEPs map[string]bool // IP's of endpoints served by the provider
-	pqHdl     *pqueue.Item    // handle into the providers pq
+	clientEPs map[string]bool   // IP's of endpoints served by the provider
+	pqHdl     *pqueue.Item      // handle into the providers pq
+	ports     map[string]uint16 // actual ports of the provider by port name
+	weight    int               // relative share of the clients
+	current   int               // current weight for weighted round robin
+}
+
+// svcFrontend is an address and port a service is exposed on. Services
+// may share an IP on different ports, e.g. the node IP or an external IP.
+type svcFrontend struct {
+	key   string     // key of the operational state
+	ip    net.IP     // service IP clients send to
+	ports []PortSpec // the port exposed on the IP
+}
+
+// natClient is the client of a dNAT flow, looked up when the flow idles out
+type natClient struct {
+	svcKey   string // key of the operational state
+	clientIP string
+	port     PortSpec
+}
+
+// clientAffinity is the provider a client sticks to
+type clientAffinity struct {
+	provIP   string
+	lastUsed time.Time
+}
+
+// affinityTable holds the providers the clients of a service stick to,
+// across all frontends of the service
+type affinityTable struct {
+	clients   map[string]*clientAffinity // client IP as key
+	timeout   time.Duration
+	lastSweep time.Time
+}
+
+// sweep removes the clients whose affinity expired
+func (at *affinityTable) sweep(now time.Time) {
+	if now.Sub(at.lastSweep) < affinitySweepInterval {
+		return
+	}
+	at.lastSweep = now
+
+	for clientIP, aff := range at.clients {
+		if now.Sub(aff.lastUsed) >= at.timeout {
+			delete(at.clients, clientIP)
+		}
+	}
 }
 
 // proxyOper is operational state of the proxy
 type proxyOper struct {
 	ports        []PortSpec
+	lbMode       string
+	affinity     *affinityTable          // nil without affinity
 	provHdl      map[string]provOper     // provider IP as key
 	provPQ       *pqueue.MinPQueue       // provider priority queue for load balancing
 	watchedFlows []*ofctrl.Flow          // flows this service is watching
@@ -85,7 +169,10 @@ type ServiceProxy struct {
 	sNATNext  *ofctrl.Table         // Next table to goto for sNAT'ed packets
 	catalogue svcCatalogue          // Services and providers added to the proxy
 	oMutex    sync.Mutex            // mutex between management and datapath
-	operState map[string]*proxyOper // Operational state info, with service IP as key
+	operState map[string]*proxyOper // Operational state info, with frontend key as key
+	natOwners map[uint64]natClient  // clients of the dNAT flows, cookie as key
+	uplinks   map[uint32]bool       // uplink ports, their clients only get local providers
+	localEPs  map[string]bool       // IPs of the endpoints on this switch
 }
 
 func getIPProto(prot string) uint8 {
@@ -100,10 +187,21 @@ func getIPProto(prot string) uint8 {
 }
 
 func matchSpec(s1, s2 *ServiceSpec) bool {
-	if s1.IpAddress != s2.IpAddress {
+	if s1.IpAddress != s2.IpAddress || s1.NodeIP != s2.NodeIP ||
+		s1.ClientIPAffinity != s2.ClientIPAffinity ||
+		s1.AffinityTimeout != s2.AffinityTimeout || s1.LBMode != s2.LBMode {
 		return false
 	}
 
+	if len(s1.ExternalIPs) != len(s2.ExternalIPs) {
+		return false
+	}
+	for ix := range s1.ExternalIPs {
+		if s1.ExternalIPs[ix] != s2.ExternalIPs[ix] {
+			return false
+		}
+	}
+
 	if len(s1.Ports) != len(s2.Ports) {
 		return false
 	}
@@ -111,6 +209,9 @@ func matchSpec(s1, s2 *ServiceSpec) bool {
 	// if the order or ports changes, this will treat as a mismatch
 	// but, not a big deal...
 	for ix := 0; ix < len(s1.Ports); ix++ {
+		if s1.Ports[ix].Name != s2.Ports[ix].Name {
+			return false
+		}
 		if s1.Ports[ix].Protocol != s2.Ports[ix].Protocol {
 			return false
 		}
@@ -120,32 +221,193 @@ func matchSpec(s1, s2 *ServiceSpec) bool {
 		if s1.Ports[ix].ProvPort != s2.Ports[ix].ProvPort {
 			return false
 		}
+		if s1.Ports[ix].NodePort != s2.Ports[ix].NodePort {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchPorts checks if the provider ports are the same
+func matchPorts(p1, p2 map[string]uint16) bool {
+	if len(p1) != len(p2) {
+		return false
+	}
+	for name, port := range p1 {
+		if p2[name] != port {
+			return false
+		}
 	}
 
 	return true
 }
 
-// allocateProvider gets the provider with least load
+// frontendKey returns the operational state key of a service IP and port
+func frontendKey(ip, protocol string, port uint16) string {
+	return ip + "." + protocol + strconv.Itoa(int(port))
+}
+
+// svcFrontends returns the addresses and ports the endpoints reach the
+// service on: the ports on the service IP and the external IPs, and the
+// node ports on the node IP. Only the traffic of the endpoints goes through
+// the proxy, the node ports and external IPs are not served to the hosts
+// and the uplinks.
+func svcFrontends(spec *ServiceSpec) []svcFrontend {
+	frontends := []svcFrontend{}
+	for _, ip := range append([]string{spec.IpAddress}, spec.ExternalIPs...) {
+		for _, p := range spec.Ports {
+			frontends = append(frontends, svcFrontend{
+				key:   frontendKey(ip, p.Protocol, p.SvcPort),
+				ip:    net.ParseIP(ip),
+				ports: []PortSpec{p},
+			})
+		}
+	}
+
+	if spec.NodeIP == "" {
+		return frontends
+	}
+
+	for _, p := range spec.Ports {
+		if p.NodePort == 0 {
+			continue
+		}
+		frontends = append(frontends, svcFrontend{
+			key: frontendKey(spec.NodeIP, p.Protocol, p.NodePort),
+			ip:  net.ParseIP(spec.NodeIP),
+			ports: []PortSpec{{Name: p.Name, Protocol: p.Protocol,
+				SvcPort: p.NodePort, ProvPort: p.ProvPort}},
+		})
+	}
+
+	return frontends
+}
+
+// allocateProvider gets a provider by the load balancing mode, or the
+// provider the client sticks to with ClientIP affinity. Only the providers
+// in only are picked, unless it is nil.
 // also updates the provider to client linkage
-func (svcOp *proxyOper) allocateProvider(clientIP string) (net.IP, error) {
-	if svcOp.provPQ.Len() <= 0 {
+func (svcOp *proxyOper) allocateProvider(clientIP string, only map[string]bool) (net.IP, error) {
+	if svcOp.provPQ.Len() <= 0 || len(svcOp.sortedProviders(only)) == 0 {
 		return net.ParseIP("0.0.0.0"), errors.New("No provider")
 	}
-	prov := svcOp.provPQ.GetMin()
-	svcOp.provPQ.IncreaseMin()
+
+	prov := ""
+	now := time.Now()
+	if svcOp.affinity != nil {
+		svcOp.affinity.sweep(now)
+		aff, found := svcOp.affinity.clients[clientIP]
+		if found && now.Sub(aff.lastUsed) < svcOp.affinity.timeout {
+			if _, ok := svcOp.provHdl[aff.provIP]; ok && (only == nil || only[aff.provIP]) {
+				prov = aff.provIP
+			}
+		}
+	}
+	if prov == "" {
+		prov = svcOp.selectProvider(only)
+	}
+	svcOp.provPQ.IncreaseItem(svcOp.provHdl[prov].pqHdl)
+
+	if svcOp.affinity != nil {
+		svcOp.affinity.clients[clientIP] = &clientAffinity{provIP: prov, lastUsed: now}
+	}
 	svcOp.provHdl[prov].clientEPs[clientIP] = true
 	return net.ParseIP(prov), nil
 }
 
+// selectProvider picks the provider of a new client among the providers
+// in only, or all of them if it is nil
+func (svcOp *proxyOper) selectProvider(only map[string]bool) string {
+	if svcOp.lbMode == LBModeRoundRobin {
+		return svcOp.nextRoundRobin(only)
+	}
+
+	// the pq holds the load of the providers, which is all
+	// that matters when they weigh the same
+	if only == nil && !svcOp.weighted() {
+		return svcOp.provPQ.GetMin()
+	}
+
+	return svcOp.leastWeightedLoad(only)
+}
+
+// weighted checks if the providers have different weights
+func (svcOp *proxyOper) weighted() bool {
+	weight := 0
+	for _, hdl := range svcOp.provHdl {
+		if weight != 0 && hdl.weight != weight {
+			return true
+		}
+		weight = hdl.weight
+	}
+
+	return false
+}
+
+// sortedProviders returns the provider IPs in only, or all of them if it
+// is nil, in a stable order
+func (svcOp *proxyOper) sortedProviders(only map[string]bool) []string {
+	provs := make([]string, 0, len(svcOp.provHdl))
+	for provIP := range svcOp.provHdl {
+		if only == nil || only[provIP] {
+			provs = append(provs, provIP)
+		}
+	}
+	sort.Strings(provs)
+	return provs
+}
+
+// leastWeightedLoad returns the provider with the least clients per weight
+func (svcOp *proxyOper) leastWeightedLoad(only map[string]bool) string {
+	best := ""
+	for _, provIP := range svcOp.sortedProviders(only) {
+		hdl := svcOp.provHdl[provIP]
+		if best == "" {
+			best = provIP
+			continue
+		}
+		bestHdl := svcOp.provHdl[best]
+		// clients/weight < bestClients/bestWeight
+		if len(hdl.clientEPs)*bestHdl.weight < len(bestHdl.clientEPs)*hdl.weight {
+			best = provIP
+		}
+	}
+
+	return best
+}
+
+// nextRoundRobin returns the next provider by smooth weighted round robin,
+// which interleaves the providers in proportion to their weights
+func (svcOp *proxyOper) nextRoundRobin(only map[string]bool) string {
+	best := ""
+	total := 0
+	for _, provIP := range svcOp.sortedProviders(only) {
+		hdl := svcOp.provHdl[provIP]
+		hdl.current += hdl.weight
+		total += hdl.weight
+		svcOp.provHdl[provIP] = hdl
+		if best == "" || hdl.current > svcOp.provHdl[best].current {
+			best = provIP
+		}
+	}
+
+	hdl := svcOp.provHdl[best]
+	hdl.current -= total
+	svcOp.provHdl[best] = hdl
+	return best
+}
+
 func getNATKey(epIP, natT string, p *PortSpec) string {
 	key := epIP + "." + natT + "." + p.Protocol + strconv.Itoa(int(p.SvcPort))
 	return key
 }
 
-// addNATFlow sets up a NAT flow
+// addNATFlow sets up a NAT flow, removed by the switch once it is idle
+// for idleTimeout seconds unless that is 0. It returns the new flow.
 // natT must be "Src" or "Dst"
 func (svcOp *proxyOper) addNATFlow(this, next *ofctrl.Table, p *PortSpec,
-	ipSa, ipDa, ipNew *net.IP, natT string) {
+	ipSa, ipDa, ipNew *net.IP, natT string, idleTimeout uint16) *ofctrl.Flow {
 
 	// Check if we already installed this flow
 	key := ""
@@ -157,7 +419,7 @@ func (svcOp *proxyOper) addNATFlow(this, next *ofctrl.Table, p *PortSpec,
 	f, found := svcOp.natFlows[key]
 	if found && f != nil {
 		log.Infof("Flow already exists for %v", key)
-		return
+		return nil
 	}
 
 	match := ofctrl.FlowMatch{
@@ -186,8 +448,9 @@ func (svcOp *proxyOper) addNATFlow(this, next *ofctrl.Table, p *PortSpec,
 
 	if err != nil {
 		log.Errorf("Proxy addNATFlow failed")
-		return
+		return nil
 	}
+	natFlow.IdleTimeout = idleTimeout
 
 	l4field := p.Protocol + natT // evaluates to TCP[Src,Dst] or UDP[Src,Dst]
 
@@ -201,6 +464,7 @@ func (svcOp *proxyOper) addNATFlow(this, next *ofctrl.Table, p *PortSpec,
 	natFlow.Next(next)
 	svcOp.natFlows[key] = natFlow
 	log.Infof("Added NAT %s to %s", key, ipNew.String())
+	return natFlow
 }
 
 func (svcOp *proxyOper) delNATFlow(epIP, natT string, p *PortSpec) {
@@ -216,17 +480,74 @@ func (svcOp *proxyOper) delNATFlow(epIP, natT string, p *PortSpec) {
 	}
 }
 
-func (svcOp *proxyOper) addProvHdl(provIP string) {
+// clientProvider returns the provider of a client with NAT flows on any
+// port of the service
+func (svcOp *proxyOper) clientProvider(clientIP string) (net.IP, bool) {
+	for _, p := range svcOp.ports {
+		if flow, found := svcOp.natFlows[getNATKey(clientIP, spDNAT, &p)]; found {
+			return *flow.Match.IpDa, true
+		}
+	}
+
+	return nil, false
+}
+
+// delClientNAT deletes the NAT flows of a client on a port. The client is
+// released from its provider once it has no flows left on the service.
+func (svcOp *proxyOper) delClientNAT(clientIP string, p *PortSpec) {
+	flow, found := svcOp.natFlows[getNATKey(clientIP, spDNAT, p)]
+	if !found {
+		return
+	}
+	provIP := flow.Match.IpDa.String()
+	svcOp.delNATFlow(clientIP, spDNAT, p)
+	svcOp.delNATFlow(clientIP, spSNAT, p)
+
+	if _, found := svcOp.clientProvider(clientIP); found {
+		return
+	}
+	if hdl, ok := svcOp.provHdl[provIP]; ok {
+		delete(hdl.clientEPs, clientIP)
+		svcOp.provPQ.DecreaseItem(hdl.pqHdl)
+	}
+
+	// the affinity runs from the last request of the client
+	if svcOp.affinity != nil {
+		if aff, found := svcOp.affinity.clients[clientIP]; found && aff.provIP == provIP {
+			aff.lastUsed = time.Now()
+		}
+	}
+}
+
+// provPort returns the port spec with the actual port of the provider
+func (svcOp *proxyOper) provPort(provIP string, p PortSpec) PortSpec {
+	if port, found := svcOp.provHdl[provIP].ports[p.Name]; found {
+		p.ProvPort = port
+	}
+	return p
+}
+
+func (svcOp *proxyOper) addProvHdl(provIP string, ports map[string]uint16, weight int) {
 	clientMap := make(map[string]bool)
 	item := pqueue.NewItem(provIP)
 	pOper := provOper{
 		clientEPs: clientMap,
 		pqHdl:     item,
+		ports:     ports,
+		weight:    provWeight(weight),
 	}
 	svcOp.provHdl[provIP] = pOper
 	svcOp.provPQ.PushItem(item)
 }
 
+// provWeight returns the weight of a provider, 1 if not set
+func provWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
+
 func (proxy *ServiceProxy) addService(svcName string) error {
 	// make sure we have a spec and at least one provider
 	services := proxy.catalogue.svcMap
@@ -247,65 +568,93 @@ func (proxy *ServiceProxy) addService(svcName string) error {
 	oper := proxy.operState
 	proxy.oMutex.Lock()
 	defer proxy.oMutex.Unlock()
-	_, found = oper[spec.IpAddress]
-	if found {
-		log.Errorf("Unexpected... operstate found for %s", spec.IpAddress)
-		return errors.New("Service IP already exists")
+	frontends := svcFrontends(&spec)
+	for _, fe := range frontends {
+		_, found = oper[fe.key]
+		if found {
+			log.Errorf("Unexpected... operstate found for %s", fe.key)
+			return errors.New("Service IP already exists")
+		}
 	}
 
-	wFlows := make([]*ofctrl.Flow, watchedFlowMax)
-	pq := pqueue.NewMinPQueue()
-	pHdl := make(map[string]provOper)
-	nFlows := make(map[string]*ofctrl.Flow)
-	oState := &proxyOper{ports: spec.Ports,
-		provPQ:       pq,
-		watchedFlows: wFlows,
-		provHdl:      pHdl,
-		natFlows:     nFlows,
+	// clients stick to a provider across all frontends of the service
+	var affinity *affinityTable
+	if spec.ClientIPAffinity {
+		affinity = &affinityTable{
+			clients: make(map[string]*clientAffinity),
+			timeout: defaultAffinityTimeout,
+		}
+		if spec.AffinityTimeout != 0 {
+			affinity.timeout = time.Duration(spec.AffinityTimeout) * time.Second
+		}
 	}
 
-	// add all providers
-	for p, _ := range prov.providers {
-		oState.addProvHdl(p)
+	for _, fe := range frontends {
+		wFlows := make([]*ofctrl.Flow, 0, watchedFlowMax)
+		pq := pqueue.NewMinPQueue()
+		pHdl := make(map[string]provOper)
+		nFlows := make(map[string]*ofctrl.Flow)
+		oState := &proxyOper{ports: fe.ports,
+			lbMode:       spec.LBMode,
+			affinity:     affinity,
+			provPQ:       pq,
+			watchedFlows: wFlows,
+			provHdl:      pHdl,
+			natFlows:     nFlows,
+		}
+
+		// add all providers
+		for p, ports := range prov.providers {
+			oState.addProvHdl(p, ports, prov.weights[p])
+		}
+
+		// add the service state to oper map
+		oper[fe.key] = oState
+
+		proxy.addWatchedFlows(oState, &fe)
 	}
 
-	// add the service state to oper map
-	oper[spec.IpAddress] = oState
+	return nil
+}
 
-	// add ovs rule to catch service traffic
+// addWatchedFlows adds ovs rules to catch the service traffic of a frontend
+func (proxy *ServiceProxy) addWatchedFlows(oState *proxyOper, fe *svcFrontend) {
 	// TBD -- handle arps to cover service in same subnet case
 	protMap := make(map[string]uint8)
 	protMap["TCP"] = 6
 	protMap["UDP"] = 17
 
-	count := 0
-	svcDA := net.ParseIP(spec.IpAddress)
-	for _, port := range spec.Ports {
+	for _, port := range fe.ports {
 		prot, found := protMap[port.Protocol]
-		if found {
-			if count >= watchedFlowMax {
-				log.Errorf("Flow count exceeded")
-				break
-			}
-			watchedFlow, err := proxy.dNATTable.NewFlow(ofctrl.FlowMatch{
-				Priority:  FLOW_FLOOD_PRIORITY,
-				Ethertype: 0x0800,
-				IpDa:      &svcDA,
-				IpProto:   prot,
-			})
-			if err != nil {
-				log.Errorf("Watch %s proto: %d err: %v", spec.IpAddress,
-					prot, err)
-				continue
-			}
-			watchedFlow.Next(proxy.ofSwitch.SendToController())
-			oState.watchedFlows[count] = watchedFlow
-			delete(protMap, port.Protocol) // add only once
-			count++
+		if !found {
+			continue
+		}
+		if len(oState.watchedFlows) >= watchedFlowMax {
+			log.Errorf("Flow count exceeded")
+			break
 		}
-	}
 
-	return nil
+		// other services may be exposed on other ports of the IP
+		match := ofctrl.FlowMatch{
+			Priority:  FLOW_FLOOD_PRIORITY,
+			Ethertype: 0x0800,
+			IpDa:      &fe.ip,
+			IpProto:   prot,
+		}
+		if port.Protocol == "TCP" {
+			match.TcpDstPort = port.SvcPort
+		} else {
+			match.UdpDstPort = port.SvcPort
+		}
+
+		watchedFlow, err := proxy.dNATTable.NewFlow(match)
+		if err != nil {
+			log.Errorf("Watch %s proto: %d err: %v", fe.key, prot, err)
+			continue
+		}
+		watchedFlow.Next(proxy.ofSwitch.SendToController())
+		oState.watchedFlows = append(oState.watchedFlows, watchedFlow)
+	}
 }
 
 // delService deletes a service
@@ -322,31 +671,33 @@ func (proxy *ServiceProxy) delService(svcName string) {
 	proxy.oMutex.Lock()
 	defer proxy.oMutex.Unlock()
 	oper := proxy.operState
-	operEntry, found := oper[spec.IpAddress]
-	if !found {
-		log.Debugf("delService operEntry not found for %s", svcName)
-		return
-	}
+	for _, fe := range svcFrontends(&spec) {
+		operEntry, found := oper[fe.key]
+		if !found {
+			log.Debugf("delService operEntry not found for %s %s", svcName, fe.key)
+			continue
+		}
 
-	// delete the watched flows from OVS
-	for _, flow := range operEntry.watchedFlows {
-		if flow != nil {
-			flow.Delete()
+		// delete the watched flows from OVS
+		for _, flow := range operEntry.watchedFlows {
+			if flow != nil {
+				flow.Delete()
+			}
 		}
-	}
 
-	// delete the nat'ed flows
-	for key, flow := range operEntry.natFlows {
-		if flow != nil {
-			flow.Delete()
-			log.Infof("NAT flow %s deleted", key)
-		} else {
-			log.Infof("NAT flow %s not found", key)
+		// delete the nat'ed flows
+		for key, flow := range operEntry.natFlows {
+			if flow != nil {
+				flow.Delete()
+				log.Infof("NAT flow %s deleted", key)
+			} else {
+				log.Infof("NAT flow %s not found", key)
+			}
 		}
-	}
 
-	// remove the operEntry
-	delete(oper, spec.IpAddress)
+		// remove the operEntry
+		delete(oper, fe.key)
+	}
 }
 
 // AddSvcSpec adds or updates a service spec.
@@ -392,7 +743,7 @@ func (proxy *ServiceProxy) DelSvcSpec(svcName string, spec *ServiceSpec) error {
 }
 
 // addProvider adds the given provider to operational State
-func (proxy *ServiceProxy) addProvider(svcIP, provIP string) error {
+func (proxy *ServiceProxy) addProvider(svcIP, provIP string, ports map[string]uint16, weight int) error {
 	oper := proxy.operState
 	proxy.oMutex.Lock()
 	defer proxy.oMutex.Unlock()
@@ -401,11 +752,32 @@ func (proxy *ServiceProxy) addProvider(svcIP, provIP string) error {
 		log.Errorf("addProvider operEntry not found for %s", svcIP)
 		return errors.New("operEntry not found")
 	}
-	operEntry.addProvHdl(provIP)
+	operEntry.addProvHdl(provIP, ports, weight)
 	log.Infof("Added provider %s for serviceIP %s", provIP, svcIP)
 	return nil
 }
 
+// setProviderWeight changes the weight of a provider, keeping its clients
+func (proxy *ServiceProxy) setProviderWeight(svcIP, provIP string, weight int) error {
+	oper := proxy.operState
+	proxy.oMutex.Lock()
+	defer proxy.oMutex.Unlock()
+	operEntry, found := oper[svcIP]
+	if !found {
+		log.Errorf("setProviderWeight operEntry not found for %s", svcIP)
+		return errors.New("operEntry not found")
+	}
+	hdl, found := operEntry.provHdl[provIP]
+	if !found {
+		return errors.New("provider not found")
+	}
+	hdl.weight = provWeight(weight)
+	hdl.current = 0
+	operEntry.provHdl[provIP] = hdl
+	log.Infof("Provider %s for serviceIP %s weighs %d", provIP, svcIP, hdl.weight)
+	return nil
+}
+
 // delProvider deletes the given provider from operational State
 func (proxy *ServiceProxy) delProvider(svcIP, provIP string) error {
 	oper := proxy.operState
@@ -425,6 +797,15 @@ func (proxy *ServiceProxy) delProvider(svcIP, provIP string) error {
 		}
 	}
 
+	// clients sticking to this provider move to another one
+	if operEntry.affinity != nil {
+		for clientIP, aff := range operEntry.affinity.clients {
+			if aff.provIP == provIP {
+				delete(operEntry.affinity.clients, clientIP)
+			}
+		}
+	}
+
 	// Remove provider from the loadbalancer pq
 	pqItem := operEntry.provHdl[provIP].pqHdl
 	operEntry.provPQ.RemoveItem(pqItem)
@@ -435,16 +816,19 @@ func (proxy *ServiceProxy) delProvider(svcIP, provIP string) error {
 }
 
 // ProviderUpdate updates the list of providers of the service
-func (proxy *ServiceProxy) ProviderUpdate(svcName string, providers []string) {
+func (proxy *ServiceProxy) ProviderUpdate(svcName string, providers []ProviderSpec) {
 	log.Infof("ProviderUpdate %s %v", svcName, providers)
-	newProvs := make(map[string]bool)
+	newProvs := make(map[string]map[string]uint16)
+	newWeights := make(map[string]int)
 
 	for _, p := range providers {
-		newProvs[p] = true
+		newProvs[p.IpAddress] = p.Ports
+		newWeights[p.IpAddress] = provWeight(p.Weight)
 	}
 
 	pMap := Providers{
 		providers: newProvs,
+		weights:   newWeights,
 	}
 	// if we don't have the service spec yet, just save the provider
 	// map and return
@@ -457,8 +841,11 @@ func (proxy *ServiceProxy) ProviderUpdate(svcName string, providers []string) {
 
 	// if the service is not created, just use the new map and
 	// add the service
-	svcIP := sSpec.IpAddress
-	_, found = proxy.operState[svcIP]
+	frontends := svcFrontends(&sSpec)
+	found = false
+	if len(frontends) > 0 {
+		_, found = proxy.operState[frontends[0].key]
+	}
 	if !found && len(providers) == 0 {
 		log.Debugf("Service %s -- no providers", svcName)
 		return
@@ -484,18 +871,32 @@ func (proxy *ServiceProxy) ProviderUpdate(svcName string, providers []string) {
 	}
 
 	// Add any new providers first
-	for _, p := range providers {
+	for p, ports := range newProvs {
 		_, found = currProvs.providers[p]
 		if !found {
-			proxy.addProvider(sSpec.IpAddress, p)
+			for _, fe := range frontends {
+				proxy.addProvider(fe.key, p, ports, newWeights[p])
+			}
 		}
 	}
 
-	// Delete any providers that disappeared
-	for p, _ := range currProvs.providers {
-		_, found = newProvs[p]
-		if !found {
-			proxy.delProvider(sSpec.IpAddress, p)
+	// Delete any providers that disappeared, and re-add the ones
+	// whose ports changed. Reweighted providers keep their clients.
+	for p, currPorts := range currProvs.providers {
+		ports, found := newProvs[p]
+		if !found || !matchPorts(currPorts, ports) {
+			for _, fe := range frontends {
+				proxy.delProvider(fe.key, p)
+			}
+		}
+		if found && !matchPorts(currPorts, ports) {
+			for _, fe := range frontends {
+				proxy.addProvider(fe.key, p, ports, newWeights[p])
+			}
+		} else if found && provWeight(currProvs.weights[p]) != newWeights[p] {
+			for _, fe := range frontends {
+				proxy.setProviderWeight(fe.key, p, newWeights[p])
+			}
 		}
 	}
 }
@@ -508,6 +909,9 @@ func NewServiceProxy() *ServiceProxy {
 	svcProxy.catalogue.svcMap = make(map[string]ServiceSpec)
 	svcProxy.catalogue.provMap = make(map[string]Providers)
 	svcProxy.operState = make(map[string]*proxyOper)
+	svcProxy.natOwners = make(map[uint64]natClient)
+	svcProxy.uplinks = make(map[uint32]bool)
+	svcProxy.localEPs = make(map[string]bool)
 
 	return svcProxy
 }
@@ -525,6 +929,22 @@ func (proxy *ServiceProxy) SwitchDisconnected(sw *ofctrl.OFSwitch) {
 	// FIXME: ??
 }
 
+// AddUplink handles an uplink added to the switch. The replies of the
+// providers on other hosts would not pass the sNAT of this switch, so
+// the clients of an uplink are only served by local providers.
+func (proxy *ServiceProxy) AddUplink(portNo uint32) {
+	proxy.oMutex.Lock()
+	defer proxy.oMutex.Unlock()
+	proxy.uplinks[portNo] = true
+}
+
+// AddLocalEndpoint handles an endpoint added to the switch
+func (proxy *ServiceProxy) AddLocalEndpoint(endpoint *OfnetEndpoint) {
+	proxy.oMutex.Lock()
+	defer proxy.oMutex.Unlock()
+	proxy.localEPs[endpoint.IpAddr.String()] = true
+}
+
 // DelEndpoint handles an endpoint delete
 func (proxy *ServiceProxy) DelEndpoint(endpoint *OfnetEndpoint) {
 	epIP := endpoint.IpAddr.String()
@@ -532,28 +952,46 @@ func (proxy *ServiceProxy) DelEndpoint(endpoint *OfnetEndpoint) {
 	// delete all nat'ed flows and update loadbalancer
 	proxy.oMutex.Lock()
 	defer proxy.oMutex.Unlock()
+	delete(proxy.localEPs, epIP)
 	for _, operEntry := range proxy.operState {
 		for _, p := range operEntry.ports {
-			// this client exists iff DNAT flow exists
-			key := getNATKey(epIP, "Dst", &p)
-			flow, found := operEntry.natFlows[key]
-			if found {
-				provIP := flow.Match.IpDa.String()
-				// delete both flows and remove the client
-				operEntry.delNATFlow(epIP, "Dst", &p)
-				operEntry.delNATFlow(epIP, "Src", &p)
-				hdl, ok := operEntry.provHdl[provIP]
-				if ok {
-					delete(hdl.clientEPs, epIP)
-					pqItem := hdl.pqHdl
-					operEntry.provPQ.DecreaseItem(pqItem)
-				}
-			}
-
+			// delete both flows and remove the client
+			operEntry.delClientNAT(epIP, &p)
+		}
+		if operEntry.affinity != nil {
+			delete(operEntry.affinity.clients, epIP)
 		}
 	}
 }
 
+// FlowRemoved handles a flow removed by the switch. The NAT flows of a
+// client idle on a port of a service are deleted with the dNAT flow.
+func (proxy *ServiceProxy) FlowRemoved(msg *ofctrl.FlowRemoved) {
+	proxy.oMutex.Lock()
+	defer proxy.oMutex.Unlock()
+
+	client, found := proxy.natOwners[msg.Cookie]
+	if !found {
+		return
+	}
+	delete(proxy.natOwners, msg.Cookie)
+	if msg.Reason != openflow13.RR_IDLE_TIMEOUT {
+		return // deleted by the proxy
+	}
+
+	operEntry, found := proxy.operState[client.svcKey]
+	if !found {
+		return
+	}
+	flow, found := operEntry.natFlows[getNATKey(client.clientIP, spDNAT, &client.port)]
+	if !found || flow.Cookie() != msg.Cookie {
+		return
+	}
+
+	log.Infof("NAT of %s to %s idled out", client.clientIP, client.svcKey)
+	operEntry.delClientNAT(client.clientIP, &client.port)
+}
+
 func getInPort(pkt *ofctrl.PacketIn) uint32 {
 	if (pkt.Match.Type == openflow13.MatchType_OXM) &&
 		(pkt.Match.Fields[0].Class == openflow13.OXM_CLASS_OPENFLOW_BASIC) &&
@@ -570,6 +1008,22 @@ func getInPort(pkt *ofctrl.PacketIn) uint32 {
 	return openflow13.P_ANY
 }
 
+// getDstPort returns the destination port of a TCP or UDP pkt
+func getDstPort(ip *protocol.IPv4) uint16 {
+	switch t := ip.Data.(type) {
+	case *protocol.UDP:
+		return t.PortDst
+	case *util.Buffer:
+		// TCP is not decoded, the port follows the source port
+		b := t.Bytes()
+		if len(b) >= 4 {
+			return binary.BigEndian.Uint16(b[2:4])
+		}
+	}
+
+	return 0
+}
+
 // HandlePkt processes a received pkt from a matching table entry
 func (proxy *ServiceProxy) HandlePkt(pkt *ofctrl.PacketIn) {
 
@@ -594,15 +1048,34 @@ func (proxy *ServiceProxy) HandlePkt(pkt *ofctrl.PacketIn) {
 	proxy.oMutex.Lock()
 	defer proxy.oMutex.Unlock()
 
-	operEntry, found := proxy.operState[svcIP]
+	svcKey := ""
+	switch ip.Protocol {
+	case protocol.Type_TCP:
+		svcKey = frontendKey(svcIP, "TCP", getDstPort(ip))
+	case protocol.Type_UDP:
+		svcKey = frontendKey(svcIP, "UDP", getDstPort(ip))
+	}
+	operEntry, found := proxy.operState[svcKey]
 	if !found {
 		return // this means service was just deleted
 	}
+
+	// clients on the uplinks are served by the providers on this host
+	var only map[string]bool
+	if proxy.uplinks[getInPort(pkt)] {
+		only = proxy.localEPs
+	}
+
+	// a client keeps its provider while it has flows on other ports
 	clientIP := ip.NWSrc.String()
-	provIP, err := operEntry.allocateProvider(clientIP)
-	if err != nil {
-		log.Warnf("allocateProvider failed for %s - %v", svcIP, err)
-		return
+	provIP, found := operEntry.clientProvider(clientIP)
+	if !found {
+		var err error
+		provIP, err = operEntry.allocateProvider(clientIP, only)
+		if err != nil {
+			log.Warnf("allocateProvider failed for %s - %v", svcIP, err)
+			return
+		}
 	}
 
 	// use copies of fields from the pkt
@@ -610,14 +1083,28 @@ func (proxy *ServiceProxy) HandlePkt(pkt *ofctrl.PacketIn) {
 	ipDst := net.ParseIP(ip.NWDst.String())
 
 	// setup nat rules in both directions for all ports of the service
+	provIPStr := provIP.String()
 	for _, p := range operEntry.ports {
-		// set up outgoing NAT
-		operEntry.addNATFlow(proxy.dNATTable, proxy.dNATNext, &p,
-			&ipSrc, &ipDst, &provIP, spDNAT)
+		p := operEntry.provPort(provIPStr, p)
+		if p.ProvPort == 0 {
+			log.Warnf("Provider %s has no port %s for %s", provIPStr, p.Name, svcIP)
+			continue
+		}
+
+		// set up outgoing NAT, the client is released when it idles out
+		natFlow := operEntry.addNATFlow(proxy.dNATTable, proxy.dNATNext, &p,
+			&ipSrc, &ipDst, &provIP, spDNAT, natIdleTimeout)
+		if natFlow != nil {
+			proxy.natOwners[natFlow.Cookie()] = natClient{
+				svcKey:   svcKey,
+				clientIP: clientIP,
+				port:     p,
+			}
+		}
 
 		// set up incoming NAT
 		operEntry.addNATFlow(proxy.sNATTable, proxy.sNATNext, &p,
-			&provIP, &ipSrc, &ipDst, spSNAT)
+			&provIP, &ipSrc, &ipDst, spSNAT, 0)
 	}
 
 	if pkt.Data.HWSrc.String() == "00:00:00:00:00:00" {
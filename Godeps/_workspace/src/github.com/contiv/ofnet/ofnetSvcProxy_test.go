@@ -0,0 +1,151 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ofnet
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/contiv/ofnet/ofctrl"
+	"github.com/contiv/ofnet/pqueue"
+)
+
+// newTestProxyOper creates the operational state of a service with the
+// providers and their weights
+func newTestProxyOper(lbMode string, weights map[string]int) *proxyOper {
+	svcOp := &proxyOper{
+		lbMode:   lbMode,
+		provHdl:  make(map[string]provOper),
+		provPQ:   pqueue.NewMinPQueue(),
+		natFlows: make(map[string]*ofctrl.Flow),
+	}
+	for provIP, weight := range weights {
+		svcOp.addProvHdl(provIP, map[string]uint16{}, weight)
+	}
+
+	return svcOp
+}
+
+func TestWeighted(t *testing.T) {
+	testCases := []struct {
+		weights  map[string]int
+		weighted bool
+	}{
+		{map[string]int{}, false},
+		{map[string]int{"10.1.1.1": 3}, false},
+		{map[string]int{"10.1.1.1": 0, "10.1.1.2": 1}, false},
+		{map[string]int{"10.1.1.1": 2, "10.1.1.2": 2, "10.1.1.3": 2}, false},
+		{map[string]int{"10.1.1.1": 1, "10.1.1.2": 1, "10.1.1.3": 2}, true},
+	}
+
+	for _, tc := range testCases {
+		svcOp := newTestProxyOper(LBModeLeastConn, tc.weights)
+		if svcOp.weighted() != tc.weighted {
+			t.Errorf("weighted() of %v is %v, expected %v", tc.weights, !tc.weighted, tc.weighted)
+		}
+	}
+}
+
+func TestNextRoundRobin(t *testing.T) {
+	svcOp := newTestProxyOper(LBModeRoundRobin,
+		map[string]int{"10.1.1.1": 5, "10.1.1.2": 1, "10.1.1.3": 1})
+
+	// the picks are interleaved, ties go to the lowest IP
+	expPicks := []string{"10.1.1.1", "10.1.1.1", "10.1.1.2", "10.1.1.1",
+		"10.1.1.3", "10.1.1.1", "10.1.1.1"}
+	for round := 0; round < 2; round++ {
+		picks := []string{}
+		for range expPicks {
+			picks = append(picks, svcOp.nextRoundRobin(nil))
+		}
+		if !reflect.DeepEqual(picks, expPicks) {
+			t.Fatalf("Round %d picked %v, expected %v", round, picks, expPicks)
+		}
+	}
+
+	// a provider added later joins the rotation
+	svcOp.addProvHdl("10.1.1.4", map[string]uint16{}, 0)
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[svcOp.nextRoundRobin(nil)]++
+	}
+	expCounts := map[string]int{"10.1.1.1": 5, "10.1.1.2": 1, "10.1.1.3": 1, "10.1.1.4": 1}
+	if !reflect.DeepEqual(counts, expCounts) {
+		t.Fatalf("Picked %v, expected %v", counts, expCounts)
+	}
+}
+
+func TestLeastWeightedLoad(t *testing.T) {
+	svcOp := newTestProxyOper(LBModeLeastConn,
+		map[string]int{"10.1.1.1": 1, "10.1.1.2": 3, "10.1.1.3": 2})
+
+	addClients := func(provIP string, clients ...string) {
+		for _, clientIP := range clients {
+			svcOp.provHdl[provIP].clientEPs[clientIP] = true
+		}
+	}
+
+	// no clients yet, ties go to the lowest IP
+	if prov := svcOp.leastWeightedLoad(nil); prov != "10.1.1.1" {
+		t.Fatalf("Picked %s without clients, expected 10.1.1.1", prov)
+	}
+
+	// loads are 1/1, 2/3 and 1/2
+	addClients("10.1.1.1", "20.1.1.1")
+	addClients("10.1.1.2", "20.1.1.2", "20.1.1.3")
+	addClients("10.1.1.3", "20.1.1.4")
+	if prov := svcOp.leastWeightedLoad(nil); prov != "10.1.1.3" {
+		t.Fatalf("Picked %s, expected 10.1.1.3", prov)
+	}
+
+	// loads are 1/1, 2/3 and 2/2
+	addClients("10.1.1.3", "20.1.1.5")
+	if prov := svcOp.leastWeightedLoad(nil); prov != "10.1.1.2" {
+		t.Fatalf("Picked %s, expected 10.1.1.2", prov)
+	}
+
+	// selectProvider weighs the load unless the weights are equal
+	if prov := svcOp.selectProvider(nil); prov != "10.1.1.2" {
+		t.Fatalf("selectProvider picked %s, expected 10.1.1.2", prov)
+	}
+}
+
+func TestAllocateLocalProvider(t *testing.T) {
+	for _, lbMode := range []string{LBModeLeastConn, LBModeRoundRobin} {
+		svcOp := newTestProxyOper(lbMode,
+			map[string]int{"10.1.1.1": 1, "10.1.1.2": 1, "10.1.1.3": 1})
+
+		// the clients of an uplink only get the local providers
+		local := map[string]bool{"10.1.1.2": true, "20.1.1.9": true}
+		for _, clientIP := range []string{"30.1.1.1", "30.1.1.2", "30.1.1.3"} {
+			prov, err := svcOp.allocateProvider(clientIP, local)
+			if err != nil || prov.String() != "10.1.1.2" {
+				t.Fatalf("%s: allocated %v(%v) to %s, expected 10.1.1.2", lbMode, prov, err, clientIP)
+			}
+		}
+
+		// no local provider, no service
+		if _, err := svcOp.allocateProvider("30.1.1.4", map[string]bool{}); err == nil {
+			t.Fatalf("%s: allocated a provider without local providers", lbMode)
+		}
+
+		// the other clients get any provider
+		prov, err := svcOp.allocateProvider("20.1.1.1", nil)
+		if err != nil || prov.String() == "10.1.1.2" {
+			t.Fatalf("%s: allocated %v(%v), expected a provider with less clients", lbMode, prov, err)
+		}
+	}
+}
@@ -79,6 +79,21 @@ func (pq *MinPQueue) IncreaseMin() {
 	heap.Fix(pq, 0)
 }
 
+// IncreaseItem increments the priority of the specified item
+func (pq *MinPQueue) IncreaseItem(ip *Item) error {
+	// make sure index is valid
+	index := ip.index
+	queue := *pq
+	count := len(queue)
+	if !(index < count) {
+		return errors.New("Item index is invalid")
+	}
+
+	queue[index].priority += 1
+	heap.Fix(pq, index)
+	return nil
+}
+
 // DecreaseItem decrements the priority of the specified item
 func (pq *MinPQueue) DecreaseItem(ip *Item) error {
 	// make sure index is valid
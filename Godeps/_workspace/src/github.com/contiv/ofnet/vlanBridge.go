@@ -116,6 +116,11 @@ func (vl *VlanBridge) SwitchDisconnected(sw *ofctrl.OFSwitch) {
 	// FIXME: ??
 }
 
+// FlowRemoved Handle a flow removed by the switch
+func (vl *VlanBridge) FlowRemoved(sw *ofctrl.OFSwitch, msg *ofctrl.FlowRemoved) {
+	vl.svcProxy.FlowRemoved(msg)
+}
+
 // PacketRcvd Handle incoming packet
 func (vl *VlanBridge) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
 	if pkt.TableId == SRV_PROXY_SNAT_TBL_ID || pkt.TableId == SRV_PROXY_DNAT_TBL_ID {
@@ -409,10 +414,20 @@ func (vl *VlanBridge) DelSvcSpec(svcName string, spec *ServiceSpec) error {
 }
 
 // SvcProviderUpdate Service Proxy Back End update
-func (vl *VlanBridge) SvcProviderUpdate(svcName string, providers []string) {
+func (vl *VlanBridge) SvcProviderUpdate(svcName string, providers []ProviderSpec) {
 	vl.svcProxy.ProviderUpdate(svcName, providers)
 }
 
+// AddServiceVIP not implemented, service VIPs are advertised in routing mode
+func (vl *VlanBridge) AddServiceVIP(vip string) error {
+	return nil
+}
+
+// DeleteServiceVIP not implemented
+func (vl *VlanBridge) DeleteServiceVIP(vip string) error {
+	return nil
+}
+
 // initialize Fgraph on the switch
 func (vl *VlanBridge) initFgraph() error {
 	sw := vl.ofSwitch
@@ -27,10 +27,11 @@ package ofnet
 //
 
 import (
-	//"fmt"
 	"errors"
+	"fmt"
 	"net"
 	"net/rpc"
+	"sort"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -39,12 +40,17 @@ import (
 	"github.com/shaleman/libOpenflow/protocol"
 )
 
+// Priority of the flows sending the uplink traffic to a service VIP to the
+// service proxy, above the vlan mapping of the uplinks
+const FLOW_SERVICE_VIP_PRIORITY = FLOW_MATCH_PRIORITY + 10
+
 // Vlrouter state.
 // One Vlrouter instance exists on each host
 type Vlrouter struct {
 	agent       *OfnetAgent      // Pointer back to ofnet agent that owns this
 	ofSwitch    *ofctrl.OFSwitch // openflow switch we are talking to
 	policyAgent *PolicyAgent     // Policy agent
+	svcProxy    *ServiceProxy    // Service proxy
 
 	// Fgraph tables
 	inputTable *ofctrl.Table // Packet lookup starts here
@@ -54,9 +60,12 @@ type Vlrouter struct {
 	// Flow Database
 	flowDb         map[string]*ofctrl.Flow // Database of flow entries
 	portVlanFlowDb map[uint32]*ofctrl.Flow // Database of flow entries
+	uplinkPorts    map[uint32]bool         // uplink ports
+	serviceVIPs    map[string]bool         // service VIPs reachable from the uplinks
+	vipFlowDb      map[string]*ofctrl.Flow // uplink flows of the VIPs, keyed by VIP and port
 
 	myRouterMac   net.HardwareAddr  //Router mac used for external proxy
-	myBgpPeer     string            // bgp neighbor
+	myBgpPeers    map[string]bool   // bgp neighbors
 	unresolvedEPs map[string]string // unresolved endpoint map
 }
 
@@ -69,12 +78,17 @@ func NewVlrouter(agent *OfnetAgent, rpcServ *rpc.Server) *Vlrouter {
 
 	// Create policy agent
 	vlrouter.policyAgent = NewPolicyAgent(agent, rpcServ)
+	vlrouter.svcProxy = NewServiceProxy()
 
 	// Create a flow dbs and my router mac
 	vlrouter.flowDb = make(map[string]*ofctrl.Flow)
 	vlrouter.portVlanFlowDb = make(map[uint32]*ofctrl.Flow)
+	vlrouter.uplinkPorts = make(map[uint32]bool)
+	vlrouter.serviceVIPs = make(map[string]bool)
+	vlrouter.vipFlowDb = make(map[string]*ofctrl.Flow)
 	vlrouter.myRouterMac, _ = net.ParseMAC("00:00:11:11:11:11")
 	vlrouter.unresolvedEPs = make(map[string]string)
+	vlrouter.myBgpPeers = make(map[string]bool)
 
 	return vlrouter
 }
@@ -92,6 +106,7 @@ func (self *Vlrouter) SwitchConnected(sw *ofctrl.OFSwitch) {
 
 	log.Infof("Switch connected(vlrouter). installing flows")
 
+	self.svcProxy.SwitchConnected(sw)
 	// Tell the policy agent about the switch
 	self.policyAgent.SwitchConnected(sw)
 
@@ -104,8 +119,19 @@ func (self *Vlrouter) SwitchDisconnected(sw *ofctrl.OFSwitch) {
 	// FIXME
 }
 
+// Handle a flow removed by the switch
+func (self *Vlrouter) FlowRemoved(sw *ofctrl.OFSwitch, msg *ofctrl.FlowRemoved) {
+	self.svcProxy.FlowRemoved(msg)
+}
+
 // Handle incoming packet
 func (self *Vlrouter) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
+	if pkt.TableId == SRV_PROXY_SNAT_TBL_ID || pkt.TableId == SRV_PROXY_DNAT_TBL_ID {
+		// these are destined to service proxy
+		self.svcProxy.HandlePkt(pkt)
+		return
+	}
+
 	switch pkt.Data.Ethertype {
 	case 0x0806:
 		if (pkt.Match.Type == openflow13.MatchType_OXM) &&
@@ -171,9 +197,9 @@ func (self *Vlrouter) AddLocalEndpoint(endpoint OfnetEndpoint) error {
 	}
 	portVlanFlow.SetMetadata(metadata, metadataMask)
 
-	// Point it to dst group table for policy lookups
-	dstGrpTbl := self.ofSwitch.GetTable(DST_GRP_TBL_ID)
-	err = portVlanFlow.Next(dstGrpTbl)
+	// Point it to dnat table
+	dNATTbl := self.ofSwitch.GetTable(SRV_PROXY_DNAT_TBL_ID)
+	err = portVlanFlow.Next(dNATTbl)
 	if err != nil {
 		log.Errorf("Error installing portvlan entry. Err: %v", err)
 		return err
@@ -218,6 +244,8 @@ func (self *Vlrouter) AddLocalEndpoint(endpoint OfnetEndpoint) error {
 	self.flowDb[flowId] = ipFlow
 
 	if endpoint.EndpointType != "internal-bgp" {
+		self.svcProxy.AddLocalEndpoint(&endpoint)
+
 		// Install dst group entry for the endpoint
 		err = self.policyAgent.AddEndpoint(&endpoint)
 		if err != nil {
@@ -228,6 +256,7 @@ func (self *Vlrouter) AddLocalEndpoint(endpoint OfnetEndpoint) error {
 			ProtocolType: "bgp",
 			localEpIP:    endpoint.IpAddr.String(),
 			nextHopIP:    self.agent.GetRouterInfo().RouterIP,
+			vrf:          endpoint.Vrf,
 		}
 		self.agent.AddLocalProtoRoute(path)
 	}
@@ -272,6 +301,7 @@ func (self *Vlrouter) RemoveLocalEndpoint(endpoint OfnetEndpoint) error {
 
 	// Remove the endpoint from policy tables
 	if endpoint.EndpointType != "internal-bgp" {
+		self.svcProxy.DelEndpoint(&endpoint)
 		err = self.policyAgent.DelEndpoint(&endpoint)
 		if err != nil {
 			log.Errorf("Error deleting endpoint to policy agent{%+v}. Err: %v", endpoint, err)
@@ -282,6 +312,7 @@ func (self *Vlrouter) RemoveLocalEndpoint(endpoint OfnetEndpoint) error {
 		ProtocolType: "bgp",
 		localEpIP:    endpoint.IpAddr.String(),
 		nextHopIP:    self.agent.GetRouterInfo().RouterIP,
+		vrf:          endpoint.Vrf,
 	}
 	self.agent.DeleteLocalProtoRoute(path)
 
@@ -345,6 +376,7 @@ func (self *Vlrouter) AddLocalIpv6Flow(endpoint OfnetEndpoint) error {
 			ProtocolType: "bgp",
 			localEpIP:    endpoint.Ipv6Addr.String(),
 			nextHopIP:    self.agent.GetRouterInfo().RouterIP,
+			vrf:          endpoint.Vrf,
 		}
 		self.agent.AddLocalProtoRoute(path)
 	}
@@ -381,6 +413,7 @@ func (self *Vlrouter) RemoveLocalIpv6Flow(endpoint OfnetEndpoint) error {
 		ProtocolType: "bgp",
 		localEpIP:    endpoint.Ipv6Addr.String(),
 		nextHopIP:    self.agent.GetRouterInfo().RouterIP,
+		vrf:          endpoint.Vrf,
 	}
 	self.agent.DeleteLocalProtoRoute(path)
 
@@ -418,7 +451,7 @@ func (self *Vlrouter) AddEndpoint(endpoint *OfnetEndpoint) error {
 		return nil
 	}
 
-	nexthopEp := self.agent.getEndpointByIpVrf(net.ParseIP(self.myBgpPeer), "default")
+	nexthopEp := self.bgpNexthop(endpoint)
 	if nexthopEp != nil && nexthopEp.PortNo != 0 {
 		endpoint.MacAddrStr = nexthopEp.MacAddrStr
 		endpoint.PortNo = nexthopEp.PortNo
@@ -435,7 +468,7 @@ func (self *Vlrouter) AddEndpoint(endpoint *OfnetEndpoint) error {
 		}
 	}
 	if endpoint.EndpointType == "external-bgp" {
-		self.myBgpPeer = endpoint.IpAddr.String()
+		self.myBgpPeers[endpoint.IpAddr.String()] = true
 	}
 	log.Infof("AddEndpoint call for endpoint: %+v", endpoint)
 
@@ -509,6 +542,9 @@ func (self *Vlrouter) RemoveEndpoint(endpoint *OfnetEndpoint) error {
 
 	//Delete the endpoint if it is in the cache
 	delete(self.unresolvedEPs, endpoint.EndpointID)
+	if endpoint.EndpointType == "external-bgp" {
+		delete(self.myBgpPeers, endpoint.IpAddr.String())
+	}
 
 	// Find the flow entry
 	flowId := self.agent.getEndpointIdByIpVlan(endpoint.IpAddr, endpoint.Vlan)
@@ -547,7 +583,7 @@ func (self *Vlrouter) RemoveEndpoint(endpoint *OfnetEndpoint) error {
 func (self *Vlrouter) AddRemoteIpv6Flow(endpoint *OfnetEndpoint) error {
 	ipv6EpId := self.agent.getEndpointIdByIpVlan(endpoint.Ipv6Addr, endpoint.Vlan)
 
-	nexthopEp := self.agent.getEndpointByIpVrf(net.ParseIP(self.myBgpPeer), "default")
+	nexthopEp := self.bgpNexthop(endpoint)
 	if nexthopEp != nil && nexthopEp.PortNo != 0 {
 		endpoint.MacAddrStr = nexthopEp.MacAddrStr
 		endpoint.PortNo = nexthopEp.PortNo
@@ -564,7 +600,7 @@ func (self *Vlrouter) AddRemoteIpv6Flow(endpoint *OfnetEndpoint) error {
 		}
 	}
 	if endpoint.EndpointType == "external-bgp" {
-		self.myBgpPeer = endpoint.IpAddr.String()
+		self.myBgpPeers[endpoint.IpAddr.String()] = true
 	}
 	log.Infof("AddRemoteIpv6Flow for endpoint: %+v", endpoint)
 
@@ -662,13 +698,20 @@ func (self *Vlrouter) initFgraph() error {
 	self.vlanTable, _ = sw.NewTable(VLAN_TBL_ID)
 	self.ipTable, _ = sw.NewTable(IP_TBL_ID)
 
+	// setup SNAT table
+	// Matches in SNAT table (i.e. incoming) go to IP look up
+	self.svcProxy.InitSNATTable(IP_TBL_ID)
+
 	// Init policy tables
-	err := self.policyAgent.InitTables(IP_TBL_ID)
+	err := self.policyAgent.InitTables(SRV_PROXY_SNAT_TBL_ID)
 	if err != nil {
 		log.Fatalf("Error installing policy table. Err: %v", err)
 		return err
 	}
 
+	// Matches in DNAT go to Policy
+	self.svcProxy.InitDNATTable(DST_GRP_TBL_ID)
+
 	//Create all drop entries
 	// Drop mcast source mac
 	bcastMac, _ := net.ParseMAC("01:00:00:00:00:00")
@@ -837,6 +880,31 @@ func (self *Vlrouter) RemoveVtepPort(portNo uint32, remoteIp net.IP) error {
 	return nil
 }
 
+// bgpNexthop returns the bgp peer an endpoint is routed through. Routes
+// learnt from a directly connected peer keep that peer as the next hop, other
+// remote endpoints are routed through the first resolved peer.
+func (self *Vlrouter) bgpNexthop(endpoint *OfnetEndpoint) *OfnetEndpoint {
+	if endpoint.EndpointType == "external-bgp" {
+		return self.agent.getEndpointByIpVrf(endpoint.IpAddr, "default")
+	}
+	if endpoint.EndpointType == "external" && endpoint.PortNo != 0 {
+		return endpoint
+	}
+
+	peers := []string{}
+	for peer := range self.myBgpPeers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		nexthopEp := self.agent.getEndpointByIpVrf(net.ParseIP(peer), "default")
+		if nexthopEp != nil && nexthopEp.PortNo != 0 {
+			return nexthopEp
+		}
+	}
+	return nil
+}
+
 /*resolveUnresolvedEPs walks through the unresolved endpoint list and resolves
 over given mac and port*/
 
@@ -881,7 +949,83 @@ func (self *Vlrouter) AddUplink(portNo uint32) error {
 
 	// save the flow entry
 	self.portVlanFlowDb[portNo] = portVlanFlow
+	self.uplinkPorts[portNo] = true
+	self.svcProxy.AddUplink(portNo)
+
+	// external clients reach the service VIPs advertised to the peer
+	for vip := range self.serviceVIPs {
+		if err := self.addServiceVIPFlow(vip, portNo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddServiceVIP sends the traffic of the uplinks to a service VIP thru the
+// service proxy, then policy and iptable lookup. The proxy serves it with
+// the providers on this host only.
+func (self *Vlrouter) AddServiceVIP(vip string) error {
+	if net.ParseIP(vip) == nil {
+		return errors.New("Invalid service VIP " + vip)
+	}
+
+	self.serviceVIPs[vip] = true
+	for portNo := range self.uplinkPorts {
+		if err := self.addServiceVIPFlow(vip, portNo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteServiceVIP removes the uplink flows of a service VIP
+func (self *Vlrouter) DeleteServiceVIP(vip string) error {
+	delete(self.serviceVIPs, vip)
+	for portNo := range self.uplinkPorts {
+		key := serviceVIPFlowKey(vip, portNo)
+		if flow := self.vipFlowDb[key]; flow != nil {
+			flow.Delete()
+			delete(self.vipFlowDb, key)
+		}
+	}
+
+	return nil
+}
+
+// serviceVIPFlowKey returns the key of the flow of a VIP on an uplink
+func serviceVIPFlowKey(vip string, portNo uint32) string {
+	return fmt.Sprintf("%s-%d", vip, portNo)
+}
+
+// addServiceVIPFlow sends the traffic of an uplink to a VIP to the dnat table
+func (self *Vlrouter) addServiceVIPFlow(vip string, portNo uint32) error {
+	key := serviceVIPFlowKey(vip, portNo)
+	if self.vipFlowDb[key] != nil {
+		return nil
+	}
 
+	vipAddr := net.ParseIP(vip)
+	vipFlow, err := self.vlanTable.NewFlow(ofctrl.FlowMatch{
+		Priority:  FLOW_SERVICE_VIP_PRIORITY,
+		InputPort: portNo,
+		Ethertype: 0x0800,
+		IpDa:      &vipAddr,
+	})
+	if err != nil {
+		log.Errorf("Error creating service VIP flow for %s. Err: %v", key, err)
+		return err
+	}
+
+	dNATTbl := self.ofSwitch.GetTable(SRV_PROXY_DNAT_TBL_ID)
+	err = vipFlow.Next(dNATTbl)
+	if err != nil {
+		log.Errorf("Error installing service VIP flow for %s. Err: %v", key, err)
+		return err
+	}
+
+	self.vipFlowDb[key] = vipFlow
 	return nil
 }
 
@@ -891,14 +1035,15 @@ func (self *Vlrouter) RemoveUplink(portNo uint32) error {
 
 // AddSvcSpec adds a service spec to proxy
 func (self *Vlrouter) AddSvcSpec(svcName string, spec *ServiceSpec) error {
-	return nil
+	return self.svcProxy.AddSvcSpec(svcName, spec)
 }
 
 // DelSvcSpec removes a service spec from proxy
 func (self *Vlrouter) DelSvcSpec(svcName string, spec *ServiceSpec) error {
-	return nil
+	return self.svcProxy.DelSvcSpec(svcName, spec)
 }
 
 // SvcProviderUpdate Service Proxy Back End update
-func (self *Vlrouter) SvcProviderUpdate(svcName string, providers []string) {
+func (self *Vlrouter) SvcProviderUpdate(svcName string, providers []ProviderSpec) {
+	self.svcProxy.ProviderUpdate(svcName, providers)
 }
@@ -0,0 +1,63 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package ofnet
+
+import (
+	"net"
+	"testing"
+)
+
+func TestBgpNexthop(t *testing.T) {
+	agent := &OfnetAgent{endpointDb: make(map[string]*OfnetEndpoint)}
+	router := &Vlrouter{agent: agent, myBgpPeers: make(map[string]bool)}
+
+	addEndpoint := func(ip, epType string, portNo uint32) *OfnetEndpoint {
+		ep := &OfnetEndpoint{EndpointID: ip, EndpointType: epType, IpAddr: net.ParseIP(ip), PortNo: portNo}
+		agent.endpointDb[ip+":default"] = ep
+		return ep
+	}
+
+	// the second peer is resolved, the first is not
+	router.myBgpPeers["50.1.1.2"] = true
+	router.myBgpPeers["50.1.1.3"] = true
+	addEndpoint("50.1.1.2", "external-bgp", 0)
+	peer := addEndpoint("50.1.1.3", "external-bgp", 5)
+
+	// a learnt route keeps the peer it was learnt from
+	route := &OfnetEndpoint{EndpointType: "external-bgp", IpAddr: net.ParseIP("50.1.1.2")}
+	if nexthop := router.bgpNexthop(route); nexthop != agent.endpointDb["50.1.1.2:default"] {
+		t.Fatalf("Route learnt from 50.1.1.2 routed through %+v", nexthop)
+	}
+
+	// a directly connected endpoint is its own next hop
+	direct := &OfnetEndpoint{EndpointType: "external", IpAddr: net.ParseIP("60.1.1.2"), PortNo: 7}
+	if nexthop := router.bgpNexthop(direct); nexthop != direct {
+		t.Fatalf("Connected endpoint routed through %+v", nexthop)
+	}
+
+	// other remote endpoints use the first resolved peer
+	remote := &OfnetEndpoint{EndpointType: "internal", IpAddr: net.ParseIP("10.1.1.2")}
+	if nexthop := router.bgpNexthop(remote); nexthop != peer {
+		t.Fatalf("Remote endpoint routed through %+v, expected peer 50.1.1.3", nexthop)
+	}
+
+	// no next hop until a peer resolves
+	agent.endpointDb["50.1.1.3:default"].PortNo = 0
+	if nexthop := router.bgpNexthop(remote); nexthop != nil {
+		t.Fatalf("Remote endpoint routed through unresolved peer %+v", nexthop)
+	}
+}
@@ -107,6 +107,11 @@ func (self *Vrouter) SwitchDisconnected(sw *ofctrl.OFSwitch) {
 	// FIXME: ??
 }
 
+// Handle a flow removed by the switch
+func (self *Vrouter) FlowRemoved(sw *ofctrl.OFSwitch, msg *ofctrl.FlowRemoved) {
+	self.svcProxy.FlowRemoved(msg)
+}
+
 // Handle incoming packet
 func (self *Vrouter) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
 	if pkt.TableId == SRV_PROXY_SNAT_TBL_ID || pkt.TableId == SRV_PROXY_DNAT_TBL_ID {
@@ -791,10 +796,20 @@ func (vr *Vrouter) DelSvcSpec(svcName string, spec *ServiceSpec) error {
 }
 
 // SvcProviderUpdate Service Proxy Back End update
-func (vr *Vrouter) SvcProviderUpdate(svcName string, providers []string) {
+func (vr *Vrouter) SvcProviderUpdate(svcName string, providers []ProviderSpec) {
 	vr.svcProxy.ProviderUpdate(svcName, providers)
 }
 
+// AddServiceVIP not implemented, service VIPs are advertised in routing mode
+func (vr *Vrouter) AddServiceVIP(vip string) error {
+	return nil
+}
+
+// DeleteServiceVIP not implemented
+func (vr *Vrouter) DeleteServiceVIP(vip string) error {
+	return nil
+}
+
 // initialize Fgraph on the switch
 func (self *Vrouter) initFgraph() error {
 	sw := self.ofSwitch
@@ -124,6 +124,11 @@ func (self *Vxlan) SwitchDisconnected(sw *ofctrl.OFSwitch) {
 	// FIXME: ??
 }
 
+// Handle a flow removed by the switch
+func (self *Vxlan) FlowRemoved(sw *ofctrl.OFSwitch, msg *ofctrl.FlowRemoved) {
+	// no flows with idle timeouts
+}
+
 // Handle incoming packet
 func (self *Vxlan) PacketRcvd(sw *ofctrl.OFSwitch, pkt *ofctrl.PacketIn) {
 	switch pkt.Data.Ethertype {
@@ -671,7 +676,17 @@ func (vx *Vxlan) DelSvcSpec(svcName string, spec *ServiceSpec) error {
 }
 
 // SvcProviderUpdate Service Proxy Back End update
-func (vx *Vxlan) SvcProviderUpdate(svcName string, providers []string) {
+func (vx *Vxlan) SvcProviderUpdate(svcName string, providers []ProviderSpec) {
+}
+
+// AddServiceVIP not implemented, service VIPs are advertised in routing mode
+func (vx *Vxlan) AddServiceVIP(vip string) error {
+	return nil
+}
+
+// DeleteServiceVIP not implemented
+func (vx *Vxlan) DeleteServiceVIP(vip string) error {
+	return nil
 }
 
 // initialize Fgraph on the switch
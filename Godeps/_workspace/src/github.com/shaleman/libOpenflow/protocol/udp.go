@@ -45,8 +45,6 @@ func (u *UDP) UnmarshalBinary(data []byte) error {
     u.Length = binary.BigEndian.Uint16(data[4:6])
     u.Checksum = binary.BigEndian.Uint16(data[6:8])
 
-    for n, _ := range data[8:] {
-        u.Data = append(u.Data, data[n])
-    }
+    u.Data = append(u.Data, data[8:]...)
     return nil
 }
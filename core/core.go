@@ -82,19 +82,39 @@ type InstanceInfo struct {
 	RouterIP    string      `json:"router-ip"`
 	FwdMode     string      `json:"fwd-mode"`
 	DbURL       string      `json:"db-url"`
+	NameServer  NameServer  `json:"-"`
+}
+
+// NameServer answers the dns queries sent to the dns server of a network
+type NameServer interface {
+	// ServeDNS returns the reply to a dns query, nil to drop the query
+	ServeDNS(networkID string, query []byte) []byte
 }
 
 // PortSpec defines protocol/port info required to host the service
 type PortSpec struct {
+	Name     string // name of the port, unique within the service
 	Protocol string
 	SvcPort  uint16 // advertised port
-	ProvPort uint16 // actual port of provider
+	ProvPort uint16 // actual port of provider, 0 if set per provider
+	NodePort uint16 // port on the node IP the local endpoints reach the service on, 0 if none
 }
 
 // ServiceSpec defines a service to be proxied
 type ServiceSpec struct {
+	IPAddress        string
+	Ports            []PortSpec
+	ExternalIPs      []string // additional IPs the local endpoints reach the service on
+	ClientIPAffinity bool     // send all requests of a client to the same provider
+	AffinityTimeout  uint32   // seconds a client sticks to its provider, the default if 0
+	LBMode           string   // roundrobin or leastconn, leastconn if empty
+}
+
+// ProviderSpec defines a provider of a service
+type ProviderSpec struct {
 	IPAddress string
-	Ports     []PortSpec
+	Ports     map[string]uint16 // actual port of the provider by port name
+	Weight    int               // relative share of the clients, 1 if 0
 }
 
 // Driver implements the programming logic
@@ -120,7 +140,11 @@ type NetworkDriver interface {
 	// Remove a service spec from proxy
 	DelSvcSpec(svcName string, spec *ServiceSpec) error
 	// Service Proxy Back End update
-	SvcProviderUpdate(svcName string, providers []string)
+	SvcProviderUpdate(svcName string, providers []ProviderSpec)
+	// Advertise a service VIP to the routing neighbors
+	AddServiceVIP(vip string) error
+	// Withdraw a service VIP from the routing neighbors
+	DelServiceVIP(vip string) error
 }
 
 // WatchState is used to provide a difference between core.State structs by
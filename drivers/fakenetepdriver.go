@@ -81,5 +81,15 @@ func (d *FakeNetEpDriver) DelSvcSpec(svcName string, spec *core.ServiceSpec) err
 }
 
 // SvcProviderUpdate is not implemented.
-func (d *FakeNetEpDriver) SvcProviderUpdate(svcName string, providers []string) {
+func (d *FakeNetEpDriver) SvcProviderUpdate(svcName string, providers []core.ProviderSpec) {
+}
+
+// AddServiceVIP is not implemented.
+func (d *FakeNetEpDriver) AddServiceVIP(vip string) error {
+	return core.Errorf("Not implemented")
+}
+
+// DelServiceVIP is not implemented.
+func (d *FakeNetEpDriver) DelServiceVIP(vip string) error {
+	return core.Errorf("Not implemented")
 }
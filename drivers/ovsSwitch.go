@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/contiv/netplugin/core"
@@ -46,6 +47,11 @@ type OvsSwitch struct {
 	netType     string
 	ovsdbDriver *OvsdbDriver
 	ofnetAgent  *ofnet.OfnetAgent
+	nameServer  core.NameServer   // answers the dns queries of the networks
+	nsNetworks  map[uint16]string // network id keyed by vlan
+	nsMutex     sync.Mutex
+
+	poolGateways map[uint16][]string // gateways of the additional subnets keyed by vlan
 }
 
 // NewOvsSwitch Creates a new OVS switch instance
@@ -58,6 +64,8 @@ func NewOvsSwitch(bridgeName, netType, localIP string, fwdMode string,
 	sw := new(OvsSwitch)
 	sw.bridgeName = bridgeName
 	sw.netType = netType
+	sw.nsNetworks = make(map[uint16]string)
+	sw.poolGateways = make(map[uint16][]string)
 
 	// Create OVS db driver
 	sw.ovsdbDriver, err = NewOvsdbDriver(bridgeName, "secure")
@@ -147,8 +155,55 @@ func (sw *OvsSwitch) CreateNetwork(pktTag uint16, extPktTag uint32, defaultGw st
 	return nil
 }
 
+// SetPoolGateways sets the gateways of the additional subnets of a
+// network/vlan
+func (sw *OvsSwitch) SetPoolGateways(pktTag uint16, gateways []string) error {
+	if sw.ofnetAgent == nil {
+		return nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, gw := range gateways {
+		wanted[gw] = true
+	}
+
+	programmed := []string{}
+	for _, gw := range sw.poolGateways[pktTag] {
+		if wanted[gw] {
+			programmed = append(programmed, gw)
+			delete(wanted, gw)
+			continue
+		}
+		err := sw.ofnetAgent.RemoveNetworkGateway(pktTag, gw)
+		if err != nil {
+			log.Errorf("Error removing gateway %s of vlan %d. Err: %v", gw, pktTag, err)
+		}
+	}
+
+	var err error
+	for gw := range wanted {
+		err = sw.ofnetAgent.AddNetworkGateway(pktTag, gw)
+		if err != nil {
+			log.Errorf("Error adding gateway %s to vlan %d. Err: %v", gw, pktTag, err)
+			continue
+		}
+		programmed = append(programmed, gw)
+	}
+
+	if len(programmed) == 0 {
+		delete(sw.poolGateways, pktTag)
+	} else {
+		sw.poolGateways[pktTag] = programmed
+	}
+
+	return err
+}
+
 // DeleteNetwork deletes a network/vlan
 func (sw *OvsSwitch) DeleteNetwork(pktTag uint16, extPktTag uint32, gateway string, Vrf string) error {
+	// Delete the gateways of the additional subnets
+	sw.SetPoolGateways(pktTag, nil)
+
 	// Delete vlan/vni mapping
 	if sw.ofnetAgent != nil {
 		err := sw.ofnetAgent.RemoveNetwork(pktTag, extPktTag, gateway, Vrf)
@@ -160,6 +215,72 @@ func (sw *OvsSwitch) DeleteNetwork(pktTag uint16, extPktTag uint32, gateway stri
 	return nil
 }
 
+// SetNameServer sets the name server answering the dns queries of the
+// networks
+func (sw *OvsSwitch) SetNameServer(nameServer core.NameServer) {
+	sw.nsMutex.Lock()
+	sw.nameServer = nameServer
+	sw.nsMutex.Unlock()
+
+	if sw.ofnetAgent != nil {
+		sw.ofnetAgent.SetNameServer(sw)
+	}
+}
+
+// ServeDNS answers a dns query received on a vlan
+func (sw *OvsSwitch) ServeDNS(vlanID uint16, query []byte) []byte {
+	sw.nsMutex.Lock()
+	nameServer := sw.nameServer
+	networkID, found := sw.nsNetworks[vlanID]
+	sw.nsMutex.Unlock()
+
+	if nameServer == nil || !found {
+		return nil
+	}
+
+	return nameServer.ServeDNS(networkID, query)
+}
+
+// AddNameServer answers the dns queries sent to the dns server address of
+// a network
+func (sw *OvsSwitch) AddNameServer(pktTag uint16, dnsServer, networkID string) error {
+	ip := net.ParseIP(dnsServer)
+	if ip == nil {
+		return core.Errorf("Invalid dns server address %s", dnsServer)
+	}
+
+	sw.nsMutex.Lock()
+	sw.nsNetworks[pktTag] = networkID
+	sw.nsMutex.Unlock()
+
+	if sw.ofnetAgent != nil {
+		err := sw.ofnetAgent.AddNameServerIP(pktTag, ip)
+		if err != nil {
+			log.Errorf("Error adding name server %s on vlan %d. Err: %v", dnsServer, pktTag, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveNameServer stops answering the dns queries of a network
+func (sw *OvsSwitch) RemoveNameServer(pktTag uint16) error {
+	sw.nsMutex.Lock()
+	delete(sw.nsNetworks, pktTag)
+	sw.nsMutex.Unlock()
+
+	if sw.ofnetAgent != nil {
+		err := sw.ofnetAgent.RemoveNameServerIP(pktTag)
+		if err != nil {
+			log.Errorf("Error removing name server on vlan %d. Err: %v", pktTag, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
 // createVethPair creates veth interface pairs with specified name
 func createVethPair(name1, name2 string) error {
 	log.Infof("Creating Veth pairs with name: %s, %s", name1, name2)
@@ -590,10 +711,27 @@ func (sw *OvsSwitch) DeleteMaster(node core.ServiceInfo) error {
 }
 
 // AddBgp adds a bgp config to host
-func (sw *OvsSwitch) AddBgp(hostname string, routerIP string,
-	As string, neighborAs, neighbor string) error {
+func (sw *OvsSwitch) AddBgp(cfg *mastercfg.CfgBgpState) error {
 	if sw.netType == "vlan" && sw.ofnetAgent != nil {
-		err := sw.ofnetAgent.AddBgp(routerIP, As, neighborAs, neighbor)
+		routerInfo := &ofnet.OfnetProtoRouterInfo{
+			RouterIP:          cfg.RouterIP,
+			As:                cfg.As,
+			HoldTime:          uint32(cfg.HoldTime),
+			KeepaliveInterval: uint32(cfg.Keepalive),
+			ImportPrefixes:    cfg.ImportPrefixes,
+			ExportPrefixes:    cfg.ExportPrefixes,
+			ExportVrfs:        cfg.ExportTenants,
+		}
+		neighbors := []*ofnet.OfnetProtoNeighborInfo{}
+		for _, neighbor := range cfg.BgpNeighbors() {
+			neighbors = append(neighbors, &ofnet.OfnetProtoNeighborInfo{
+				NeighborIP: neighbor.Address,
+				As:         neighbor.As,
+				Password:   neighbor.Password,
+			})
+		}
+
+		err := sw.ofnetAgent.AddBgp(routerInfo, neighbors)
 		if err != nil {
 			log.Errorf("Error adding BGP server")
 			return err
@@ -603,6 +741,15 @@ func (sw *OvsSwitch) AddBgp(hostname string, routerIP string,
 	return nil
 }
 
+// GetBgpOper returns the state of the bgp neighbors and routes of the host
+func (sw *OvsSwitch) GetBgpOper() (*ofnet.OfnetProtoOperState, error) {
+	if sw.netType != "vlan" || sw.ofnetAgent == nil {
+		return nil, errors.New("bgp runs on the vlan switch only")
+	}
+
+	return sw.ofnetAgent.GetProtoOperState()
+}
+
 // DeleteBgp deletes bgp config from host
 func (sw *OvsSwitch) DeleteBgp() error {
 	if sw.netType == "vlan" && sw.ofnetAgent != nil {
@@ -629,6 +776,32 @@ func (sw *OvsSwitch) DelSvcSpec(svcName string, spec *ofnet.ServiceSpec) error {
 }
 
 // SvcProviderUpdate invokes ofnetAgent api
-func (sw *OvsSwitch) SvcProviderUpdate(svcName string, providers []string) {
+func (sw *OvsSwitch) SvcProviderUpdate(svcName string, providers []ofnet.ProviderSpec) {
 	sw.ofnetAgent.SvcProviderUpdate(svcName, providers)
 }
+
+// AddServiceVIP advertises a service VIP to the bgp neighbors
+func (sw *OvsSwitch) AddServiceVIP(vip string) error {
+	if sw.netType == "vlan" && sw.ofnetAgent != nil {
+		err := sw.ofnetAgent.AddServiceVIP(vip)
+		if err != nil {
+			log.Errorf("Error advertising service VIP %s. Err: %v", vip, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DelServiceVIP withdraws a service VIP from the bgp neighbors
+func (sw *OvsSwitch) DelServiceVIP(vip string) error {
+	if sw.netType == "vlan" && sw.ofnetAgent != nil {
+		err := sw.ofnetAgent.DeleteServiceVIP(vip)
+		if err != nil {
+			log.Errorf("Error withdrawing service VIP %s. Err: %v", vip, err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/ofnet"
@@ -34,6 +35,9 @@ type oper int
 
 const maxIntfRetry = 100
 
+// bgpOperInterval is how often the bgp state of the host is published
+const bgpOperInterval = 15 * time.Second
+
 // OvsDriverConfig defines the configuration required to initialize the
 // OvsDriver.
 type OvsDriverConfig struct {
@@ -76,9 +80,10 @@ func (s *OvsDriverOperState) Clear() error {
 // OvsDriver implements the Layer 2 Network and Endpoint Driver interfaces
 // specific to vlan based open-vswitch.
 type OvsDriver struct {
-	oper     OvsDriverOperState    // Oper state of the driver
-	localIP  string                // Local IP address
-	switchDb map[string]*OvsSwitch // OVS switch instances
+	oper        OvsDriverOperState    // Oper state of the driver
+	localIP     string                // Local IP address
+	switchDb    map[string]*OvsSwitch // OVS switch instances
+	bgpOperStop chan bool             // stops publishing the bgp state
 }
 
 func (d *OvsDriver) getIntfName() (string, error) {
@@ -157,6 +162,12 @@ func (d *OvsDriver) Init(info *core.InstanceInfo) error {
 		}
 	}
 
+	// Answer the dns queries of the networks
+	if info.NameServer != nil {
+		d.switchDb["vxlan"].SetNameServer(info.NameServer)
+		d.switchDb["vlan"].SetNameServer(info.NameServer)
+	}
+
 	return nil
 }
 
@@ -192,7 +203,36 @@ func (d *OvsDriver) CreateNetwork(id string) error {
 		sw = d.switchDb["vlan"]
 	}
 
-	return sw.CreateNetwork(uint16(cfgNw.PktTag), uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
+	err = sw.CreateNetwork(uint16(cfgNw.PktTag), uint32(cfgNw.ExtPktTag), cfgNw.Gateway, cfgNw.Tenant)
+	if err != nil {
+		return err
+	}
+
+	// gateways of the additional subnets, the network is created again when
+	// its subnets change
+	gateways := []string{}
+	for _, sp := range cfgNw.SubnetPools {
+		if sp.Gateway != "" {
+			gateways = append(gateways, sp.Gateway)
+		}
+	}
+	err = sw.SetPoolGateways(uint16(cfgNw.PktTag), gateways)
+	if err != nil {
+		return err
+	}
+
+	// Answer the dns queries sent to the dns server of the network, the
+	// network is created again when its dns server changes
+	if cfgNw.DNSServer != "" {
+		err = sw.AddNameServer(uint16(cfgNw.PktTag), cfgNw.DNSServer, cfgNw.ID)
+		if err != nil {
+			log.Errorf("Error adding the dns server of network %s. Err: %v", cfgNw.ID, err)
+		}
+	} else {
+		sw.RemoveNameServer(uint16(cfgNw.PktTag))
+	}
+
+	return nil
 }
 
 // DeleteNetwork deletes a network by named identifier
@@ -224,6 +264,8 @@ func (d *OvsDriver) DeleteNetwork(id, nwType, encap string, pktTag, extPktTag in
 		}
 	}
 
+	sw.RemoveNameServer(uint16(pktTag))
+
 	return sw.DeleteNetwork(uint16(pktTag), uint32(extPktTag), gateway, tenant)
 }
 
@@ -478,7 +520,78 @@ func (d *OvsDriver) AddBgp(id string) error {
 	// Find the switch based on network type
 	sw = d.switchDb["vlan"]
 
-	return sw.AddBgp(cfg.Hostname, cfg.RouterIP, cfg.As, cfg.NeighborAs, cfg.Neighbor)
+	err = sw.AddBgp(&cfg)
+	if err != nil {
+		return err
+	}
+
+	if d.bgpOperStop == nil {
+		d.bgpOperStop = make(chan bool)
+		go d.publishBgpOper(cfg.Hostname, d.bgpOperStop)
+	}
+	return nil
+}
+
+// publishBgpOper periodically writes the bgp neighbor and route state of
+// the host to the state store, until stopped
+func (d *OvsDriver) publishBgpOper(hostname string, stop chan bool) {
+	ticker := time.NewTicker(bgpOperInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := d.writeBgpOper(hostname)
+			if err != nil {
+				log.Debugf("Error publishing the bgp state of %s. Err: %v", hostname, err)
+			}
+		}
+	}
+}
+
+// writeBgpOper writes the bgp state of the host. Only the host routes of
+// the RIB are written, these are the routes of the endpoints and services.
+func (d *OvsDriver) writeBgpOper(hostname string) error {
+	protoState, err := d.switchDb["vlan"].GetBgpOper()
+	if err != nil {
+		return err
+	}
+
+	bgpOper := &mastercfg.OperBgpState{
+		Hostname:   hostname,
+		RouterIP:   protoState.RouterIP,
+		As:         protoState.As,
+		Neighbors:  []mastercfg.BgpNeighborOper{},
+		Routes:     []mastercfg.BgpRoute{},
+		RibSize:    protoState.RibSize,
+		UpdateTime: time.Now(),
+	}
+	for _, neighbor := range protoState.Neighbors {
+		bgpOper.Neighbors = append(bgpOper.Neighbors, mastercfg.BgpNeighborOper{
+			Address:    neighbor.NeighborIP,
+			As:         neighbor.As,
+			State:      neighbor.State,
+			AdminState: neighbor.AdminState,
+			Uptime:     neighbor.Uptime,
+			Received:   neighbor.Received,
+			Accepted:   neighbor.Accepted,
+			Advertised: neighbor.Advertised,
+		})
+	}
+	for _, route := range protoState.Routes {
+		bgpOper.Routes = append(bgpOper.Routes, mastercfg.BgpRoute{
+			Prefix:  route.Prefix,
+			NextHop: route.NextHop,
+			AsPath:  route.AsPath,
+			Best:    route.Best,
+		})
+	}
+
+	bgpOper.StateDriver = d.oper.StateDriver
+	bgpOper.ID = hostname
+	return bgpOper.Write()
 }
 
 // DeleteBgp deletes bgp config by named identifier
@@ -489,22 +602,53 @@ func (d *OvsDriver) DeleteBgp(id string) error {
 	// Find the switch based on network type
 	var sw *OvsSwitch
 	sw = d.switchDb["vlan"]
+
+	if d.bgpOperStop != nil {
+		d.bgpOperStop <- true
+		d.bgpOperStop = nil
+
+		bgpOper := &mastercfg.OperBgpState{Hostname: id}
+		bgpOper.StateDriver = d.oper.StateDriver
+		if err := bgpOper.Clear(); err != nil {
+			log.Errorf("Error clearing the bgp state of %s. Err: %v", id, err)
+		}
+	}
+
 	return sw.DeleteBgp()
 
 }
 
-// convSvcSpec converts core.ServiceSpec to ofnet.ServiceSpec
-func convSvcSpec(spec *core.ServiceSpec) *ofnet.ServiceSpec {
+// AddServiceVIP advertises a service VIP to the bgp neighbors
+func (d *OvsDriver) AddServiceVIP(vip string) error {
+	// bgp runs on the vlan switch in routing mode
+	return d.switchDb["vlan"].AddServiceVIP(vip)
+}
+
+// DelServiceVIP withdraws a service VIP from the bgp neighbors
+func (d *OvsDriver) DelServiceVIP(vip string) error {
+	return d.switchDb["vlan"].DelServiceVIP(vip)
+}
+
+// convSvcSpec converts core.ServiceSpec to ofnet.ServiceSpec, exposing
+// the node ports on the given node IP
+func convSvcSpec(spec *core.ServiceSpec, nodeIP string) *ofnet.ServiceSpec {
 	pSpec := make([]ofnet.PortSpec, len(spec.Ports))
 	for ix, p := range spec.Ports {
+		pSpec[ix].Name = p.Name
 		pSpec[ix].Protocol = p.Protocol
 		pSpec[ix].SvcPort = p.SvcPort
 		pSpec[ix].ProvPort = p.ProvPort
+		pSpec[ix].NodePort = p.NodePort
 	}
 
 	ofnetSS := ofnet.ServiceSpec{
-		IpAddress: spec.IPAddress,
-		Ports:     pSpec,
+		IpAddress:        spec.IPAddress,
+		Ports:            pSpec,
+		ExternalIPs:      spec.ExternalIPs,
+		NodeIP:           nodeIP,
+		ClientIPAffinity: spec.ClientIPAffinity,
+		AffinityTimeout:  spec.AffinityTimeout,
+		LBMode:           spec.LBMode,
 	}
 	return &ofnetSS
 }
@@ -512,7 +656,7 @@ func convSvcSpec(spec *core.ServiceSpec) *ofnet.ServiceSpec {
 // AddSvcSpec invokes switch api
 func (d *OvsDriver) AddSvcSpec(svcName string, spec *core.ServiceSpec) error {
 	log.Infof("AddSvcSpec: %s", svcName)
-	ss := convSvcSpec(spec)
+	ss := convSvcSpec(spec, d.localIP)
 	errs := ""
 	for _, sw := range d.switchDb {
 		log.Infof("sw AddSvcSpec: %s", svcName)
@@ -531,7 +675,7 @@ func (d *OvsDriver) AddSvcSpec(svcName string, spec *core.ServiceSpec) error {
 
 // DelSvcSpec invokes switch api
 func (d *OvsDriver) DelSvcSpec(svcName string, spec *core.ServiceSpec) error {
-	ss := convSvcSpec(spec)
+	ss := convSvcSpec(spec, d.localIP)
 	errs := ""
 	for _, sw := range d.switchDb {
 		err := sw.DelSvcSpec(svcName, ss)
@@ -548,8 +692,15 @@ func (d *OvsDriver) DelSvcSpec(svcName string, spec *core.ServiceSpec) error {
 }
 
 // SvcProviderUpdate invokes switch api
-func (d *OvsDriver) SvcProviderUpdate(svcName string, providers []string) {
+func (d *OvsDriver) SvcProviderUpdate(svcName string, providers []core.ProviderSpec) {
+	provs := make([]ofnet.ProviderSpec, len(providers))
+	for ix, p := range providers {
+		provs[ix].IpAddress = p.IPAddress
+		provs[ix].Ports = p.Ports
+		provs[ix].Weight = p.Weight
+	}
+
 	for _, sw := range d.switchDb {
-		sw.SvcProviderUpdate(svcName, providers)
+		sw.SvcProviderUpdate(svcName, provs)
 	}
 }
@@ -37,6 +37,7 @@ type OvsOperEndpointState struct {
 	IntfName    string `json:"intfName"`
 	PortName    string `json:"portName"`
 	VtepIP      string `json:"vtepIP"`
+	SandboxKey  string `json:"sandboxKey"` // container sandbox the endpoint joined
 }
 
 // Matches matches the fields updated from configuration state
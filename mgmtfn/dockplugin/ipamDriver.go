@@ -19,7 +19,6 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"strings"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/netmaster/master"
@@ -77,20 +76,28 @@ func requestPool(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("Received RequestPoolRequest: %+v", preq)
 
-	// build response
-	PoolID := preq.Pool
-
-	// Docker 1.10+ supports IPAM options. so, we pass the network id as pool-id
-	// In docker 1.9, we pass the address pool back as pool id
-	// HACK alert: This is very fragile. SImplify this when we stop supporting docker 1.9
-	tenant, okt := preq.Options["tenant"]
-	network, okn := preq.Options["network"]
-	if okt && okn {
-		PoolID = network + "." + tenant + ":" + preq.Pool
+	// Docker networks created by netmaster pass the tenant and network as
+	// ipam options, their pools belong to the contiv network
+	poolReq := master.IpamPoolRequest{
+		TenantName:  preq.Options["tenant"],
+		NetworkName: preq.Options["network"],
+		Pool:        preq.Pool,
+		SubPool:     preq.SubPool,
+		IPv6:        preq.V6,
+	}
+
+	// Make a REST call to master
+	var poolResp master.IpamPoolResponse
+	err = cluster.MasterPostReq("/plugin/requestPool", &poolReq, &poolResp)
+	if err != nil {
+		httpError(w, "master failed to create pool", err)
+		return
 	}
+
+	// build response
 	presp := api.RequestPoolResponse{
-		PoolID: PoolID,
-		Pool:   preq.Pool,
+		PoolID: poolResp.PoolID,
+		Pool:   poolResp.Pool,
 	}
 
 	log.Infof("Sending RequestPoolResponse: %+v", presp)
@@ -125,6 +132,15 @@ func releasePool(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("Received ReleasePoolRequest: %+v", preq)
 
+	// Make a REST call to master
+	var relResult string
+	err = cluster.MasterPostReq("/plugin/releasePool",
+		&master.IpamPoolReleaseRequest{PoolID: preq.PoolID}, &relResult)
+	if err != nil {
+		httpError(w, "master failed to release pool", err)
+		return
+	}
+
 	// response
 	relResp := api.ReleasePoolResponse{}
 
@@ -160,55 +176,25 @@ func requestAddress(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("Received RequestAddressRequest: %+v", areq)
 
-	networkID := ""
-	addrPool := areq.PoolID
-	subnetLen := strings.Split(areq.PoolID, "/")[1]
-
-	// check if pool id contains address pool or network id
-	// HACK alert: This is very fragile. SImplify this when we stop supporting docker 1.9
-	if strings.Count(areq.PoolID, ":") == 1 {
-		addrPool = strings.Split(areq.PoolID, ":")[1]
-		networkID = strings.Split(areq.PoolID, ":")[0]
-	}
-
 	// Build an alloc request to be sent to master
+	reqType, ok := areq.Options["RequestAddressType"]
 	allocReq := master.AddressAllocRequest{
-		AddressPool:          addrPool,
-		NetworkID:            networkID,
+		PoolID:               areq.PoolID,
 		PreferredIPv4Address: areq.Address,
+		Gateway:              ok && reqType == netlabel.Gateway,
 	}
 
-	var addr string
-
-	// check if this request is for gateway
-	reqType, ok := areq.Options["RequestAddressType"]
-	if ok && reqType == netlabel.Gateway {
-		if areq.Address != "" {
-			addr = areq.Address + "/" + subnetLen
-		} else {
-			// simply return a dummy address
-			addr = addrPool
-		}
-	} else if areq.Address != "" {
-		// This is a special case for docker 1.9 gateway request which does not
-		// come with 'RequestAddressType' label
-		// FIXME: Remove this hack when we stop supporting docker 1.9
-		addr = areq.Address + "/" + subnetLen
-	} else {
-		// Make a REST call to master
-		var allocResp master.AddressAllocResponse
-		err = cluster.MasterPostReq("/plugin/allocAddress", &allocReq, &allocResp)
-		if err != nil {
-			httpError(w, "master failed to allocate address", err)
-			return
-		}
-
-		addr = allocResp.IPv4Address
+	// Make a REST call to master
+	var allocResp master.AddressAllocResponse
+	err = cluster.MasterPostReq("/plugin/allocAddress", &allocReq, &allocResp)
+	if err != nil {
+		httpError(w, "master failed to allocate address", err)
+		return
 	}
 
 	// build response
 	aresp := api.RequestAddressResponse{
-		Address: addr,
+		Address: allocResp.IPv4Address,
 	}
 
 	log.Infof("Sending RequestAddressResponse: %+v", aresp)
@@ -243,6 +229,18 @@ func releaseAddress(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("Received ReleaseAddressRequest: %+v", areq)
 
+	// Make a REST call to master
+	var relResult string
+	relReq := master.AddressReleaseRequest{
+		PoolID:      areq.PoolID,
+		IPv4Address: areq.Address,
+	}
+	err = cluster.MasterPostReq("/plugin/releaseAddress", &relReq, &relResult)
+	if err != nil {
+		httpError(w, "master failed to release address", err)
+		return
+	}
+
 	// response
 	relResp := api.ReleaseAddressResponse{}
 
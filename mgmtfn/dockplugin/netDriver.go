@@ -29,7 +29,9 @@ import (
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netplugin/cluster"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/docker/libnetwork/drivers/remote/api"
+	"github.com/docker/libnetwork/types"
 	"github.com/samalba/dockerclient"
 )
 
@@ -333,9 +335,24 @@ func join(w http.ResponseWriter, r *http.Request) {
 			SrcName:   ep.PortName,
 			DstPrefix: "eth",
 		},
-		Gateway: nw.Gateway,
+		StaticRoutes: joinStaticRoutes(nw),
 	}
 
+	// a container on several contiv networks gets its default gateway
+	// from the first of them by name
+	isGateway, err := joinSandbox(ep, jr.SandboxKey)
+	if err != nil {
+		httpError(w, "Could not record the sandbox of the endpoint", err)
+		return
+	}
+	if isGateway {
+		_, joinResp.Gateway = nw.GetSubnetOfAddress(ep.IPAddress)
+		joinResp.GatewayIPv6 = nw.IPv6Gateway
+	}
+
+	// The dns config of the network can not be passed to docker here,
+	// containers get it from the --dns options of docker run
+
 	log.Infof("Sending JoinResponse: {%+v}, InterfaceName: %s", joinResp, ep.PortName)
 
 	content, err = json.Marshal(joinResp)
@@ -347,6 +364,96 @@ func join(w http.ResponseWriter, r *http.Request) {
 	w.Write(content)
 }
 
+// joinStaticRoutes returns the static routes of a network that apply to its
+// containers, ipv6 routes need an ipv6 subnet
+func joinStaticRoutes(nw *mastercfg.CfgNetworkState) []api.StaticRoute {
+	routes := []api.StaticRoute{}
+	for _, route := range nw.StaticRoutes {
+		if netutils.IsIPv6(route.Destination) && nw.IPv6Subnet == "" {
+			continue
+		}
+
+		staticRoute := api.StaticRoute{Destination: route.Destination, RouteType: types.CONNECTED}
+		if route.NextHop != "" {
+			staticRoute.NextHop = route.NextHop
+			staticRoute.RouteType = types.NEXTHOP
+		}
+		routes = append(routes, staticRoute)
+	}
+
+	return routes
+}
+
+// networkBefore checks if a network sorts before another, by network name
+// and then by tenant, the order docker sorts the networks of a container by
+func networkBefore(netID, otherNetID string) bool {
+	name, tenant := netID, ""
+	if idx := strings.LastIndex(netID, "."); idx >= 0 {
+		name, tenant = netID[:idx], netID[idx+1:]
+	}
+	otherName, otherTenant := otherNetID, ""
+	if idx := strings.LastIndex(otherNetID, "."); idx >= 0 {
+		otherName, otherTenant = otherNetID[:idx], otherNetID[idx+1:]
+	}
+
+	if name != otherName {
+		return name < otherName
+	}
+	return tenant < otherTenant
+}
+
+// joinSandbox records the sandbox an endpoint joins in its oper state and
+// checks if the network of the endpoint is the first by name of the contiv
+// networks of the sandbox on this host, the network that offers the
+// default gateway. Docker keeps the gateway of the first network that
+// offers one, so a network joining later that sorts first takes over.
+// The networks joining while another network sorts first offer no gateway,
+// none of them takes over when the gateway network leaves the container.
+func joinSandbox(ep *drivers.OvsOperEndpointState, sandboxKey string) (bool, error) {
+	if sandboxKey == "" {
+		return true, nil
+	}
+
+	ep.SandboxKey = sandboxKey
+	if err := ep.Write(); err != nil {
+		return false, err
+	}
+
+	epOpers, err := ep.ReadAll()
+	if err != nil {
+		return false, err
+	}
+	for _, state := range epOpers {
+		other := state.(*drivers.OvsOperEndpointState)
+		if other.ID == ep.ID || other.SandboxKey != sandboxKey || other.HomingHost != ep.HomingHost {
+			continue
+		}
+		if networkBefore(other.NetID, ep.NetID) {
+			log.Infof("Network %s is not the default gateway network of sandbox %s, %s is",
+				ep.NetID, sandboxKey, other.NetID)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// leaveSandbox removes the sandbox an endpoint leaves from its oper state
+func leaveSandbox(lr *api.LeaveRequest) error {
+	tenantName, netName, _, err := GetDockerNetworkName(lr.NetworkID)
+	if err != nil {
+		return err
+	}
+
+	ep, err := netdGetEndpoint(netName + "." + tenantName + "-" + lr.EndpointID)
+	if err != nil {
+		return err
+	}
+
+	ep.SandboxKey = ""
+	return ep.Write()
+}
+
 func leave(w http.ResponseWriter, r *http.Request) {
 	var (
 		content []byte
@@ -365,6 +472,12 @@ func leave(w http.ResponseWriter, r *http.Request) {
 
 	log.Infof("LeaveRequest: %+v", lr)
 
+	// the endpoint may be gone already, it no longer counts for the
+	// gateway network of the sandbox either way
+	if err := leaveSandbox(&lr); err != nil {
+		log.Warnf("Error removing the sandbox of endpoint %s. Err: %v", lr.EndpointID, err)
+	}
+
 	// Send response
 	leaveResp := api.LeaveResponse{}
 
@@ -0,0 +1,101 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockplugin
+
+import (
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/utils"
+)
+
+func TestJoinSandbox(t *testing.T) {
+	instInfo := core.InstanceInfo{}
+	stateDriver, err := utils.NewStateDriver("fakedriver", &instInfo)
+	if err != nil {
+		t.Fatalf("failed to init statedriver. Error: %s", err)
+	}
+	defer utils.ReleaseStateDriver()
+
+	newEp := func(netID, host string) *drivers.OvsOperEndpointState {
+		ep := &drivers.OvsOperEndpointState{NetID: netID, HomingHost: host}
+		ep.StateDriver = stateDriver
+		ep.ID = netID + "-" + host
+		if err := ep.Write(); err != nil {
+			t.Fatalf("error writing endpoint %s. Err: %v", ep.ID, err)
+		}
+		return ep
+	}
+	blue := newEp("blue.default", "host1")
+	red := newEp("red.default", "host1")
+	green := newEp("green.default", "host1")
+	otherHost := newEp("aqua.default", "host2")
+
+	// a sandbox key of another host does not count
+	if isGateway, err := joinSandbox(otherHost, "/var/run/docker/netns/1"); !isGateway || err != nil {
+		t.Fatalf("endpoint of another host took the gateway. Err: %v", err)
+	}
+
+	// the first network by name offers the gateway, whatever the order of
+	// the joins
+	testCases := []struct {
+		ep        *drivers.OvsOperEndpointState
+		isGateway bool
+	}{
+		{red, true},
+		{blue, true},
+		{green, false},
+		{red, false},
+	}
+	for _, tc := range testCases {
+		isGateway, err := joinSandbox(tc.ep, "/var/run/docker/netns/1")
+		if err != nil || isGateway != tc.isGateway {
+			t.Errorf("network %s offers the gateway: %v, expected %v. Err: %v", tc.ep.NetID,
+				isGateway, tc.isGateway, err)
+		}
+	}
+
+	// a sandbox key is kept in the oper state, the joins without one offer
+	// the gateway
+	readEp := &drivers.OvsOperEndpointState{}
+	readEp.StateDriver = stateDriver
+	if err := readEp.Read(blue.ID); err != nil || readEp.SandboxKey != "/var/run/docker/netns/1" {
+		t.Errorf("sandbox of endpoint %s not recorded: %+v. Err: %v", blue.ID, readEp, err)
+	}
+	if isGateway, _ := joinSandbox(green, ""); !isGateway {
+		t.Errorf("join without a sandbox offers no gateway")
+	}
+}
+
+func TestNetworkBefore(t *testing.T) {
+	testCases := []struct {
+		netID, otherNetID string
+		before            bool
+	}{
+		{"blue.default", "red.default", true},
+		{"red.default", "blue.default", false},
+		{"blue.default", "blue.tenant1", true},
+		// network names sort before the tenants
+		{"a.zebra", "a-b.default", true},
+		{"blue.default", "blue.default", false},
+	}
+	for _, tc := range testCases {
+		if networkBefore(tc.netID, tc.otherNetID) != tc.before {
+			t.Errorf("%s before %s is %v, expected %v", tc.netID, tc.otherNetID, !tc.before, tc.before)
+		}
+	}
+}
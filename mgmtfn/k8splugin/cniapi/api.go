@@ -29,6 +29,9 @@ const EPAddURL = "/ContivCNI.AddPod"
 // EPDelURL is the rest point for deleting an endpoint
 const EPDelURL = "/ContivCNI.DelPod"
 
+// EPCheckURL is the rest point for checking an endpoint
+const EPCheckURL = "/ContivCNI.CheckPod"
+
 // CNIPodAttr holds attributes of the pod to be attached or detached
 type CNIPodAttr struct {
 	Name             string `json:"K8S_POD_NAME,omitempty"`
@@ -36,10 +39,31 @@ type CNIPodAttr struct {
 	InfraContainerID string `json:"K8S_POD_INFRA_CONTAINER_ID,omitempty"`
 	NwNameSpace      string `json:"CNI_NETNS,omitempty"`
 	IntfName         string `json:"CNI_IFNAME,omitempty"`
+
+	// network config of the cni plugin, used for pods without labels
+	Tenant  string `json:"tenant,omitempty"`
+	Network string `json:"network,omitempty"`
+	Group   string `json:"group,omitempty"`
 }
 
 // RspAddPod contains the response to the AddPod
 type RspAddPod struct {
-	EndpointID string `json:"endpointid,omitempty"`
-	IPAddress  string `json:"ipaddress,omitempty"`
+	EndpointID  string `json:"endpointid,omitempty"`
+	IPAddress   string `json:"ipaddress,omitempty"`
+	IPv6Address string `json:"ipv6address,omitempty"`
+	Gateway     string `json:"gateway,omitempty"`
+	IPv6Gateway string `json:"ipv6gateway,omitempty"`
+	MacAddress  string `json:"macaddress,omitempty"`
+
+	// static routes and dns config of the network
+	Routes     []Route  `json:"routes,omitempty"`
+	DNSServers []string `json:"dnsservers,omitempty"`
+	DNSSearch  []string `json:"dnssearch,omitempty"`
+	DNSOptions []string `json:"dnsoptions,omitempty"`
+}
+
+// Route is a static route of the pod, directly connected when GW is empty
+type Route struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
 }
@@ -40,6 +40,11 @@ type ContivConfig struct {
 	K8sCa        string `json:"K8S_CA,omitempty"`
 	K8sKey       string `json:"K8S_KEY,omitempty"`
 	K8sCert      string `json:"K8S_CERT,omitempty"`
+
+	// mapping of pods that are not mapped by annotations or labels
+	DefaultTenant  string `json:"DEFAULT_TENANT,omitempty"`
+	DefaultNetwork string `json:"DEFAULT_NETWORK,omitempty"`
+	DefaultGroup   string `json:"DEFAULT_GROUP,omitempty"`
 }
 
 type restAPIFunc func(r *http.Request) (interface{}, error)
@@ -47,6 +52,7 @@ type restAPIFunc func(r *http.Request) (interface{}, error)
 var netPlugin *plugin.NetPlugin
 var kubeAPIClient *APIClient
 var pluginHost string
+var contivCfg ContivConfig
 
 // getConfig reads and parses the contivKubeCfgFile
 func getConfig(cfgFile string, pCfg *ContivConfig) error {
@@ -111,52 +117,7 @@ func InitKubServiceWatch(np *plugin.NetPlugin) {
 		log.Fatalf("Could not init kubernetes API client")
 	}
 
-	svcCh := make(chan SvcWatchResp, 1)
-	epCh := make(chan EpWatchResp, 1)
-	go func() {
-		for {
-			select {
-			case svcEvent := <-svcCh:
-				switch svcEvent.opcode {
-				case "WARN":
-					log.Debugf("epWatch : %s", svcEvent.errStr)
-					break
-				case "FATAL":
-					log.Errorf("epWatch : %s", svcEvent.errStr)
-					break
-				case "ERROR":
-					log.Warnf("svcWatch : %s", svcEvent.errStr)
-					watchClient.WatchServices(svcCh)
-					break
-
-				case "DELETED":
-					np.NetworkDriver.DelSvcSpec(svcEvent.svcName, &svcEvent.svcSpec)
-					break
-				default:
-					np.NetworkDriver.AddSvcSpec(svcEvent.svcName, &svcEvent.svcSpec)
-				}
-			case epEvent := <-epCh:
-				switch epEvent.opcode {
-				case "WARN":
-					log.Debugf("epWatch : %s", epEvent.errStr)
-					break
-				case "FATAL":
-					log.Errorf("epWatch : %s", epEvent.errStr)
-					break
-				case "ERROR":
-					log.Warnf("epWatch : %s", epEvent.errStr)
-					watchClient.WatchSvcEps(epCh)
-					break
-
-				default:
-					np.NetworkDriver.SvcProviderUpdate(epEvent.svcName, epEvent.providers)
-				}
-			}
-		}
-	}()
-
-	watchClient.WatchServices(svcCh)
-	watchClient.WatchSvcEps(epCh)
+	newSvcWatcher(watchClient, np.NetworkDriver).run()
 }
 
 // InitCNIServer initializes the k8s cni server
@@ -176,6 +137,11 @@ func InitCNIServer(netplugin *plugin.NetPlugin) error {
 		log.Fatalf("Could not init kubernetes API client")
 	}
 
+	// Read the cluster defaults of the pod mapping
+	if err := getConfig(contivKubeCfgFile, &contivCfg); err != nil {
+		log.Fatalf("Could not read %s: %v", contivKubeCfgFile, err)
+	}
+
 	log.Debugf("Configuring router")
 
 	router := mux.NewRouter()
@@ -184,6 +150,7 @@ func InitCNIServer(netplugin *plugin.NetPlugin) error {
 	t := router.Headers("Content-Type", "application/json").Methods("POST").Subrouter()
 	t.HandleFunc(cniapi.EPAddURL, makeHTTPHandler(addPod))
 	t.HandleFunc(cniapi.EPDelURL, makeHTTPHandler(deletePod))
+	t.HandleFunc(cniapi.EPCheckURL, makeHTTPHandler(checkPod))
 	t.HandleFunc("/ContivCNI.{*}", unknownAction)
 
 	driverPath := cniapi.ContivCniSocket
@@ -52,8 +52,8 @@ func NewNWClient() *NWClient {
 	return &c
 }
 
-// AddPod adds a pod to contiv using the cni api
-func (c *NWClient) AddPod(podInfo interface{}) (*cniapi.RspAddPod, error) {
+// postPod posts the pod to the netplugin url and returns the response
+func (c *NWClient) postPod(url string, podInfo interface{}) (*cniapi.RspAddPod, error) {
 
 	buf, err := json.Marshal(podInfo)
 	if err != nil {
@@ -61,26 +61,25 @@ func (c *NWClient) AddPod(podInfo interface{}) (*cniapi.RspAddPod, error) {
 	}
 
 	body := bytes.NewBuffer(buf)
-	url := c.baseURL + cniapi.EPAddURL
-	r, err := c.client.Post(url, "application/json", body)
+	r, err := c.client.Post(c.baseURL+url, "application/json", body)
 	if err != nil {
 		return nil, err
 	}
 	defer r.Body.Close()
 
+	response, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
 	switch {
 	case r.StatusCode == int(404):
 		return nil, fmt.Errorf("Page not found!")
 	case r.StatusCode == int(403):
 		return nil, fmt.Errorf("Access denied!")
 	case r.StatusCode != int(200):
-		log.Errorf("GET Status '%s' status code %d \n", r.Status, r.StatusCode)
-		return nil, fmt.Errorf("%s", r.Status)
-	}
-
-	response, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		return nil, err
+		log.Errorf("POST Status '%s' status code %d \n", r.Status, r.StatusCode)
+		return nil, fmt.Errorf("%s: %s", r.Status, bytes.TrimSpace(response))
 	}
 
 	data := cniapi.RspAddPod{}
@@ -92,6 +91,16 @@ func (c *NWClient) AddPod(podInfo interface{}) (*cniapi.RspAddPod, error) {
 	return &data, nil
 }
 
+// AddPod adds a pod to contiv using the cni api
+func (c *NWClient) AddPod(podInfo interface{}) (*cniapi.RspAddPod, error) {
+	return c.postPod(cniapi.EPAddURL, podInfo)
+}
+
+// CheckPod checks that a pod is attached to contiv using the cni api
+func (c *NWClient) CheckPod(podInfo interface{}) (*cniapi.RspAddPod, error) {
+	return c.postPod(cniapi.EPCheckURL, podInfo)
+}
+
 // DelPod deletes a pod from contiv using the cni api
 func (c *NWClient) DelPod(podInfo interface{}) error {
 
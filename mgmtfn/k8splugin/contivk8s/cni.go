@@ -0,0 +1,271 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/contiv/netplugin/mgmtfn/k8splugin/cniapi"
+)
+
+// definitions of the cni specification
+
+const (
+	// cniVersion is the latest version of the spec that is supported
+	cniVersion = "0.4.0"
+
+	// version assumed when the network config does not specify one
+	cniDefaultVersion = "0.1.0"
+
+	// version that introduced the CHECK command
+	cniCheckVersion = "0.4.0"
+)
+
+// error codes of the spec
+const (
+	cniErrIncompatibleVersion = 1
+	cniErrUnsupportedField    = 2
+	cniErrUnknownContainer    = 3
+	cniErrInvalidEnv          = 4
+	cniErrIOFailure           = 5
+	cniErrDecodeFailure       = 6
+	cniErrInvalidNetConfig    = 7
+	cniErrTryAgainLater       = 11
+)
+
+// contiv specific error codes
+const (
+	cniErrAddFailed   = 100
+	cniErrDelFailed   = 101
+	cniErrCheckFailed = 102
+)
+
+// cniSupportedVersions are the versions of the spec that are supported
+var cniSupportedVersions = []string{"0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0"}
+
+// cniDNS is the dns config of a container
+type cniDNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// cniNetConf is the network config passed on stdin
+type cniNetConf struct {
+	CNIVersion string          `json:"cniVersion,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Type       string          `json:"type,omitempty"`
+	DNS        cniDNS          `json:"dns,omitempty"`
+	PrevResult json.RawMessage `json:"prevResult,omitempty"`
+
+	// contiv tenant, network and epg of pods without labels
+	Tenant  string `json:"tenant,omitempty"`
+	Network string `json:"network,omitempty"`
+	Group   string `json:"group,omitempty"`
+
+	// log file of the plugin
+	LogFile string `json:"logFile,omitempty"`
+}
+
+// cniInterface is an interface in the result
+type cniInterface struct {
+	Name    string `json:"name"`
+	Mac     string `json:"mac,omitempty"`
+	Sandbox string `json:"sandbox,omitempty"`
+}
+
+// cniIP is an address in the result
+type cniIP struct {
+	Version   string `json:"version"`
+	Address   string `json:"address"`
+	Gateway   string `json:"gateway,omitempty"`
+	Interface *int   `json:"interface,omitempty"`
+}
+
+// cniRoute is a route in the result
+type cniRoute struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
+
+// cniResult is the result of the ADD command
+type cniResult struct {
+	CNIVersion string         `json:"cniVersion"`
+	Interfaces []cniInterface `json:"interfaces,omitempty"`
+	IPs        []cniIP        `json:"ips,omitempty"`
+	Routes     []cniRoute     `json:"routes,omitempty"`
+	DNS        cniDNS         `json:"dns,omitempty"`
+}
+
+// cniIPConfig is an address in the result of versions before 0.3.0
+type cniIPConfig struct {
+	IP      string     `json:"ip"`
+	Gateway string     `json:"gateway,omitempty"`
+	Routes  []cniRoute `json:"routes,omitempty"`
+}
+
+// cniLegacyResult is the result of versions before 0.3.0
+type cniLegacyResult struct {
+	CNIVersion string       `json:"cniVersion"`
+	IP4        *cniIPConfig `json:"ip4,omitempty"`
+	IP6        *cniIPConfig `json:"ip6,omitempty"`
+	DNS        cniDNS       `json:"dns,omitempty"`
+}
+
+// cniVersionInfo is the result of the VERSION command
+type cniVersionInfo struct {
+	CNIVersion        string   `json:"cniVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+// cniError is the result of a failed command
+type cniError struct {
+	CNIVersion string `json:"cniVersion"`
+	Code       uint   `json:"code"`
+	Msg        string `json:"msg"`
+	Details    string `json:"details,omitempty"`
+}
+
+func (e *cniError) Error() string {
+	if e.Details == "" {
+		return e.Msg
+	}
+	return e.Msg + ": " + e.Details
+}
+
+// newCNIError creates a cni error, with the error as details
+func newCNIError(code uint, msg string, err error) *cniError {
+	cniErr := &cniError{CNIVersion: cniVersion, Code: code, Msg: msg}
+	if err != nil {
+		cniErr.Details = err.Error()
+	}
+	return cniErr
+}
+
+// isSupportedVersion checks if the version of the spec is supported
+func isSupportedVersion(version string) bool {
+	for _, v := range cniSupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// isLegacyVersion checks if the version uses the result format before 0.3.0
+func isLegacyVersion(version string) bool {
+	return version == "0.1.0" || version == "0.2.0"
+}
+
+// getNetConf reads the network config from stdin
+func getNetConf(stdin io.Reader) (*cniNetConf, *cniError) {
+	content, err := ioutil.ReadAll(stdin)
+	if err != nil {
+		return nil, newCNIError(cniErrIOFailure, "error reading network config", err)
+	}
+
+	netConf := &cniNetConf{}
+	if len(bytes.TrimSpace(content)) != 0 {
+		if err := json.Unmarshal(content, netConf); err != nil {
+			return nil, newCNIError(cniErrDecodeFailure, "error parsing network config", err)
+		}
+	}
+
+	if netConf.CNIVersion == "" {
+		netConf.CNIVersion = cniDefaultVersion
+	}
+	if !isSupportedVersion(netConf.CNIVersion) {
+		return nil, newCNIError(cniErrIncompatibleVersion,
+			fmt.Sprintf("cni version %s is not supported", netConf.CNIVersion), nil)
+	}
+
+	return netConf, nil
+}
+
+// getCNIResult builds the cni result from the attributes of the endpoint
+func getCNIResult(pInfo *cniapi.CNIPodAttr, rsp *cniapi.RspAddPod, dns cniDNS) *cniResult {
+	res := &cniResult{CNIVersion: cniVersion, DNS: dns}
+	res.Interfaces = []cniInterface{{
+		Name:    pInfo.IntfName,
+		Mac:     rsp.MacAddress,
+		Sandbox: pInfo.NwNameSpace,
+	}}
+
+	intfIdx := 0
+	if rsp.IPAddress != "" {
+		res.IPs = append(res.IPs, cniIP{Version: "4", Address: rsp.IPAddress,
+			Gateway: rsp.Gateway, Interface: &intfIdx})
+		if rsp.Gateway != "" {
+			res.Routes = append(res.Routes, cniRoute{Dst: "0.0.0.0/0", GW: rsp.Gateway})
+		}
+	}
+	if rsp.IPv6Address != "" {
+		res.IPs = append(res.IPs, cniIP{Version: "6", Address: rsp.IPv6Address,
+			Gateway: rsp.IPv6Gateway, Interface: &intfIdx})
+		if rsp.IPv6Gateway != "" {
+			res.Routes = append(res.Routes, cniRoute{Dst: "::/0", GW: rsp.IPv6Gateway})
+		}
+	}
+	for _, route := range rsp.Routes {
+		res.Routes = append(res.Routes, cniRoute{Dst: route.Dst, GW: route.GW})
+	}
+
+	// the dns config of the network applies unless the network config sets it
+	if len(res.DNS.Nameservers) == 0 {
+		res.DNS.Nameservers = rsp.DNSServers
+	}
+	if len(res.DNS.Search) == 0 {
+		res.DNS.Search = rsp.DNSSearch
+	}
+	if len(res.DNS.Options) == 0 {
+		res.DNS.Options = rsp.DNSOptions
+	}
+
+	return res
+}
+
+// convert returns the result in the format of the version
+func (res *cniResult) convert(version string) interface{} {
+	if !isLegacyVersion(version) {
+		converted := *res
+		converted.CNIVersion = version
+		return &converted
+	}
+
+	legacy := &cniLegacyResult{CNIVersion: version, DNS: res.DNS}
+	for _, ip := range res.IPs {
+		ipConfig := &cniIPConfig{IP: ip.Address, Gateway: ip.Gateway}
+		for _, route := range res.Routes {
+			if strings.Contains(route.Dst, ":") == (ip.Version == "6") {
+				ipConfig.Routes = append(ipConfig.Routes, route)
+			}
+		}
+
+		if ip.Version == "6" {
+			legacy.IP6 = ipConfig
+		} else {
+			legacy.IP4 = ipConfig
+		}
+	}
+
+	return legacy
+}
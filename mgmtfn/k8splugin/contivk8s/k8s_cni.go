@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -29,6 +30,8 @@ import (
 	logger "github.com/Sirupsen/logrus"
 )
 
+const defaultLogFile = "/var/log/contivk8s.log"
+
 var log *logger.Entry
 
 func getPodInfo(ppInfo *cniapi.CNIPodAttr) error {
@@ -52,30 +55,69 @@ func getPodInfo(ppInfo *cniapi.CNIPodAttr) error {
 	return nil
 }
 
-func addPodToContiv(nc *clients.NWClient, pInfo *cniapi.CNIPodAttr) {
+func addPodToContiv(nc *clients.NWClient, pInfo *cniapi.CNIPodAttr, netConf *cniNetConf,
+	stdout io.Writer) *cniError {
 
 	// Add to contiv network
 	result, err := nc.AddPod(pInfo)
 	if err != nil {
-		log.Fatalf("EP create failed -- %s", err)
-	} else {
-		log.Infof("EP created IP: %s\n", result.IPAddress)
+		log.Errorf("EP create failed -- %s", err)
+		return newCNIError(cniErrAddFailed, "failed to add pod to contiv", err)
 	}
+	log.Infof("EP created IP: %s IPv6: %s\n", result.IPAddress, result.IPv6Address)
 
-	// Write the ip address of the created endpoint to stdout
-	fmt.Printf("{\n\"cniVersion\": \"0.1.0\",\n")
-	fmt.Printf("\"ip4\": {\n")
-	fmt.Printf("\"ip\": \"%s\"\n}\n}\n", result.IPAddress)
+	// Write the result in the version of the network config to stdout
+	res := getCNIResult(pInfo, result, netConf.DNS)
+	out, err := json.MarshalIndent(res.convert(netConf.CNIVersion), "", "  ")
+	if err != nil {
+		return newCNIError(cniErrIOFailure, "error encoding cni result", err)
+	}
+	fmt.Fprintf(stdout, "%s\n", out)
+	return nil
 }
 
-func deletePodFromContiv(nc *clients.NWClient, pInfo *cniapi.CNIPodAttr) {
+func deletePodFromContiv(nc *clients.NWClient, pInfo *cniapi.CNIPodAttr) *cniError {
 
 	err := nc.DelPod(pInfo)
 	if err != nil {
 		log.Errorf("DelEndpoint returned %v", err)
-	} else {
-		log.Infof("EP deleted pod: %s\n", pInfo.Name)
+		return newCNIError(cniErrDelFailed, "failed to delete pod from contiv", err)
 	}
+
+	log.Infof("EP deleted pod: %s\n", pInfo.Name)
+	return nil
+}
+
+func checkPodInContiv(nc *clients.NWClient, pInfo *cniapi.CNIPodAttr, netConf *cniNetConf) *cniError {
+	// the supported versions compare as strings
+	if netConf.CNIVersion < cniCheckVersion {
+		return newCNIError(cniErrIncompatibleVersion,
+			fmt.Sprintf("CHECK is not supported in cni version %s", netConf.CNIVersion), nil)
+	}
+
+	result, err := nc.CheckPod(pInfo)
+	if err != nil {
+		log.Errorf("EP check failed -- %s", err)
+		return newCNIError(cniErrCheckFailed, "pod check failed", err)
+	}
+
+	// the addresses of the previous result must still be assigned
+	if len(netConf.PrevResult) != 0 {
+		prevResult := cniResult{}
+		if err := json.Unmarshal(netConf.PrevResult, &prevResult); err != nil {
+			return newCNIError(cniErrDecodeFailure, "error parsing previous result", err)
+		}
+
+		for _, ip := range prevResult.IPs {
+			if ip.Address != result.IPAddress && ip.Address != result.IPv6Address {
+				return newCNIError(cniErrCheckFailed, "pod check failed",
+					fmt.Errorf("address %s is not assigned to the pod", ip.Address))
+			}
+		}
+	}
+
+	log.Infof("EP checked pod: %s\n", pInfo.Name)
+	return nil
 }
 
 func getPrefixedLogger() *logger.Entry {
@@ -120,13 +162,39 @@ func main() {
 }
 
 func mainfunc() {
-	pInfo := cniapi.CNIPodAttr{}
-	cniCmd := os.Getenv("CNI_COMMAND")
+	if err := runCmd(os.Getenv("CNI_COMMAND"), os.Stdin, os.Stdout); err != nil {
+		out, _ := json.MarshalIndent(err, "", "  ")
+		fmt.Printf("%s\n", out)
+		os.Exit(1)
+	}
+}
+
+// runCmd runs the cni command with the network config in stdin and writes
+// the result to stdout
+func runCmd(cniCmd string, stdin io.Reader, stdout io.Writer) *cniError {
+	if cniCmd == "VERSION" {
+		out, err := json.MarshalIndent(&cniVersionInfo{CNIVersion: cniVersion,
+			SupportedVersions: cniSupportedVersions}, "", "  ")
+		if err != nil {
+			return newCNIError(cniErrIOFailure, "error encoding version info", err)
+		}
+		fmt.Fprintf(stdout, "%s\n", out)
+		return nil
+	}
+
+	netConf, cniErr := getNetConf(stdin)
+	if cniErr != nil {
+		return cniErr
+	}
 
 	// Open a logfile
-	f, err := os.OpenFile("/var/log/contivk8s.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	logFile := netConf.LogFile
+	if logFile == "" {
+		logFile = defaultLogFile
+	}
+	f, err := os.OpenFile(logFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		logger.Fatalf("error opening file: %v", err)
+		return newCNIError(cniErrIOFailure, "error opening log file", err)
 	}
 	defer f.Close()
 
@@ -137,16 +205,25 @@ func mainfunc() {
 	log.Infof("command: %s, cni_args: %s", cniCmd, os.Getenv("CNI_ARGS"))
 
 	// Collect information passed by CNI
+	pInfo := cniapi.CNIPodAttr{}
 	err = getPodInfo(&pInfo)
 	if err != nil {
-		log.Fatalf("Error parsing environment. Err: %v", err)
+		log.Errorf("Error parsing environment. Err: %v", err)
+		return newCNIError(cniErrInvalidEnv, "error parsing environment", err)
 	}
+	pInfo.Tenant = netConf.Tenant
+	pInfo.Network = netConf.Network
+	pInfo.Group = netConf.Group
 
 	nc := clients.NewNWClient()
-	if cniCmd == "ADD" {
-		addPodToContiv(nc, &pInfo)
-	} else if cniCmd == "DEL" {
-		deletePodFromContiv(nc, &pInfo)
+	switch cniCmd {
+	case "ADD":
+		return addPodToContiv(nc, &pInfo, netConf, stdout)
+	case "DEL":
+		return deletePodFromContiv(nc, &pInfo)
+	case "CHECK":
+		return checkPodInContiv(nc, &pInfo, netConf)
 	}
 
+	return newCNIError(cniErrInvalidEnv, fmt.Sprintf("unknown CNI_COMMAND %q", cniCmd), nil)
 }
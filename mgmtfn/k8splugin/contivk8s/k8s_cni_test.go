@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -35,6 +36,12 @@ import (
 
 const (
 	utPodIP    = "44.55.66.77/22"
+	utPodIPv6  = "2016:44::77/64"
+	utPodGW    = "44.55.64.1"
+	utPodGWv6  = "2016:44::1"
+	utPodMac   = "02:02:2c:37:42:4d"
+	utPodRoute = "10.96.0.0/12"
+	utPodDNS   = "44.55.64.53"
 	utCNIARG1  = "K8S_POD_NAMESPACE=utK8sNS"
 	utCNIARG2  = "K8S_POD_NAME=utPod"
 	utCNIARG3  = "K8S_POD_INFRA_CONTAINER_ID=8ec72deca647bfa60a4b815aa735c87de859b47e872828586749b9d852af1f49"
@@ -81,6 +88,13 @@ func stubAddPod(r *http.Request) (interface{}, error) {
 		} else {
 			// respond with success
 			resp.IPAddress = utPodIP
+			resp.IPv6Address = utPodIPv6
+			resp.Gateway = utPodGW
+			resp.IPv6Gateway = utPodGWv6
+			resp.MacAddress = utPodMac
+			resp.Routes = []cniapi.Route{{Dst: utPodRoute, GW: utPodGW}}
+			resp.DNSServers = []string{utPodDNS}
+			resp.DNSOptions = []string{"ndots:5"}
 			resp.EndpointID = pInfo.InfraContainerID
 			return resp, nil
 		}
@@ -148,6 +162,7 @@ func setupTestServer() {
 	t := router.Headers("Content-Type", "application/json").Methods("POST").Subrouter()
 	t.HandleFunc(cniapi.EPAddURL, httpWrapper(stubAddPod))
 	t.HandleFunc(cniapi.EPDelURL, httpWrapper(stubDeletePod))
+	t.HandleFunc(cniapi.EPCheckURL, httpWrapper(stubAddPod))
 
 	driverPath := cniapi.ContivCniSocket
 	os.Remove(driverPath)
@@ -191,16 +206,146 @@ func setupTestEnv() {
 	os.Setenv("CNI_IFNAME", "eth0")
 }
 
+const utNetConf = `{
+	"cniVersion": "0.4.0",
+	"name": "contiv-net",
+	"type": "contivk8s",
+	"dns": {"nameservers": ["10.254.0.10"], "search": ["default.svc.cluster.local"]}
+}`
+
 // TestAddpod tests the AddPod interface
 func TestAddpod(m *testing.T) {
 	setupTestEnv()
-	os.Setenv("CNI_COMMAND", "ADD")
-	mainfunc()
+	out := &bytes.Buffer{}
+	if err := runCmd("ADD", strings.NewReader(utNetConf), out); err != nil {
+		m.Fatalf("ADD failed: %v", err)
+	}
+
+	res := cniResult{}
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		m.Fatalf("Error parsing result %s: %v", out.String(), err)
+	}
+
+	if res.CNIVersion != "0.4.0" || len(res.Interfaces) != 1 ||
+		res.Interfaces[0].Name != "eth0" || res.Interfaces[0].Mac != utPodMac ||
+		res.Interfaces[0].Sandbox != utCNINETNS {
+		m.Errorf("unexpected interfaces in result %s", out.String())
+	}
+	if len(res.IPs) != 2 || res.IPs[0].Address != utPodIP || res.IPs[0].Gateway != utPodGW ||
+		res.IPs[0].Interface == nil || *res.IPs[0].Interface != 0 ||
+		res.IPs[1].Version != "6" || res.IPs[1].Address != utPodIPv6 {
+		m.Errorf("unexpected ips in result %s", out.String())
+	}
+	if len(res.Routes) != 3 || res.Routes[0].GW != utPodGW || res.Routes[1].Dst != "::/0" ||
+		res.Routes[2].Dst != utPodRoute || res.Routes[2].GW != utPodGW {
+		m.Errorf("unexpected routes in result %s", out.String())
+	}
+
+	// the network config takes precedence over the dns config of the network
+	if len(res.DNS.Nameservers) != 1 || res.DNS.Nameservers[0] != "10.254.0.10" ||
+		len(res.DNS.Options) != 1 || res.DNS.Options[0] != "ndots:5" {
+		m.Errorf("unexpected dns in result %s", out.String())
+	}
+}
+
+// TestAddpodLegacy tests the result of versions before 0.3.0
+func TestAddpodLegacy(m *testing.T) {
+	setupTestEnv()
+	out := &bytes.Buffer{}
+	if err := runCmd("ADD", strings.NewReader(""), out); err != nil {
+		m.Fatalf("ADD failed: %v", err)
+	}
+
+	res := cniLegacyResult{}
+	if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+		m.Fatalf("Error parsing result %s: %v", out.String(), err)
+	}
+
+	if res.CNIVersion != cniDefaultVersion || res.IP4 == nil || res.IP4.IP != utPodIP ||
+		res.IP4.Gateway != utPodGW || len(res.IP4.Routes) != 2 ||
+		res.IP6 == nil || res.IP6.IP != utPodIPv6 || len(res.IP6.Routes) != 1 ||
+		len(res.DNS.Nameservers) != 1 || res.DNS.Nameservers[0] != utPodDNS {
+		m.Errorf("unexpected legacy result %s", out.String())
+	}
 }
 
-// TestAddpod tests the DeletePod interface
+// TestDelpod tests the DeletePod interface
 func TestDelpod(m *testing.T) {
 	setupTestEnv()
-	os.Setenv("CNI_COMMAND", "DEL")
-	mainfunc()
+	out := &bytes.Buffer{}
+	if err := runCmd("DEL", strings.NewReader(utNetConf), out); err != nil {
+		m.Fatalf("DEL failed: %v", err)
+	}
+	if out.Len() != 0 {
+		m.Errorf("unexpected output of DEL: %s", out.String())
+	}
+}
+
+// TestCheckpod tests the CheckPod interface
+func TestCheckpod(m *testing.T) {
+	setupTestEnv()
+
+	prevResult := fmt.Sprintf(`{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "%s"}]}`, utPodIP)
+	netConf := `{"cniVersion": "0.4.0", "name": "contiv-net", "prevResult": ` + prevResult + `}`
+	if err := runCmd("CHECK", strings.NewReader(netConf), &bytes.Buffer{}); err != nil {
+		m.Fatalf("CHECK failed: %v", err)
+	}
+
+	// an address that is no longer assigned
+	prevResult = `{"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "44.55.66.78/22"}]}`
+	netConf = `{"cniVersion": "0.4.0", "name": "contiv-net", "prevResult": ` + prevResult + `}`
+	err := runCmd("CHECK", strings.NewReader(netConf), &bytes.Buffer{})
+	if err == nil || err.Code != cniErrCheckFailed {
+		m.Fatalf("CHECK of unassigned address returned %v", err)
+	}
+
+	// CHECK was added in 0.4.0
+	netConf = `{"cniVersion": "0.3.1", "name": "contiv-net"}`
+	err = runCmd("CHECK", strings.NewReader(netConf), &bytes.Buffer{})
+	if err == nil || err.Code != cniErrIncompatibleVersion {
+		m.Fatalf("CHECK with cni version 0.3.1 returned %v", err)
+	}
+}
+
+// TestVersion tests the VERSION command
+func TestVersion(m *testing.T) {
+	out := &bytes.Buffer{}
+	if err := runCmd("VERSION", strings.NewReader(""), out); err != nil {
+		m.Fatalf("VERSION failed: %v", err)
+	}
+
+	info := cniVersionInfo{}
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		m.Fatalf("Error parsing version info %s: %v", out.String(), err)
+	}
+	if info.CNIVersion != cniVersion || len(info.SupportedVersions) != len(cniSupportedVersions) {
+		m.Errorf("unexpected version info %s", out.String())
+	}
+}
+
+// TestCNIErrors tests the errors of invalid config and environment
+func TestCNIErrors(m *testing.T) {
+	setupTestEnv()
+	testCases := []struct {
+		cmd     string
+		netConf string
+		code    uint
+	}{
+		{"ADD", `{"cniVersion": "9.9.9"}`, cniErrIncompatibleVersion},
+		{"ADD", `{"cniVersion": `, cniErrDecodeFailure},
+		{"FOO", utNetConf, cniErrInvalidEnv},
+	}
+
+	for _, tc := range testCases {
+		err := runCmd(tc.cmd, strings.NewReader(tc.netConf), &bytes.Buffer{})
+		if err == nil || err.Code != tc.code {
+			m.Errorf("%s with %s returned %v, expected code %d", tc.cmd, tc.netConf, err, tc.code)
+		}
+	}
+
+	os.Setenv("CNI_ARGS", "")
+	err := runCmd("ADD", strings.NewReader(utNetConf), &bytes.Buffer{})
+	if err == nil || err.Code != cniErrInvalidEnv {
+		m.Errorf("ADD without CNI_ARGS returned %v", err)
+	}
 }
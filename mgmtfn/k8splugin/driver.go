@@ -33,6 +33,7 @@ import (
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netplugin/cluster"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/vishvananda/netlink"
 )
 
@@ -42,13 +43,21 @@ type epSpec struct {
 	Network    string `json:"network,omitempty"`
 	Group      string `json:"group,omitempty"`
 	EndpointID string `json:"endpointid,omitempty"`
+	PodName    string `json:"podname,omitempty"`
 }
 
 // epAttr contains the assigned attributes of the created ep
 type epAttr struct {
-	IPAddress string
-	PortName  string
-	Gateway   string
+	IPAddress   string
+	PortName    string
+	Gateway     string
+	IPv6Address string
+	IPv6Gateway string
+	MacAddress  string
+	Routes      []cniapi.Route
+	DNSServers  []string
+	DNSSearch   []string
+	DNSOptions  []string
 }
 
 // netdGetEndpoint is a utility that reads the EP oper state
@@ -69,6 +78,24 @@ func netdGetEndpoint(epID string) (*drivers.OvsOperEndpointState, error) {
 	return operEp, nil
 }
 
+// netdGetEndpointCfg is a utility that reads the EP config state
+func netdGetEndpointCfg(epID string) (*mastercfg.CfgEndpointState, error) {
+	// Get hold of the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	err = epCfg.Read(epID)
+	if err != nil {
+		return nil, err
+	}
+
+	return epCfg, nil
+}
+
 // netdGetNetwork is a utility that reads the n/w oper state
 func netdGetNetwork(networkID string) (*mastercfg.CfgNetworkState, error) {
 	// Get hold of the state driver
@@ -133,6 +160,7 @@ func createEP(req *epSpec) (*epAttr, error) {
 			Container:   req.EndpointID,
 			Host:        pluginHost,
 			ServiceName: req.Group,
+			Owner:       req.PodName,
 		},
 	}
 
@@ -168,8 +196,31 @@ func createEP(req *epSpec) (*epAttr, error) {
 
 	epResponse := epAttr{}
 	epResponse.PortName = ep.PortName
-	epResponse.IPAddress = ep.IPAddress + "/" + strconv.Itoa(int(nw.SubnetLen))
-	epResponse.Gateway = nw.Gateway
+	epResponse.MacAddress = ep.MacAddress
+	subnetLen, gateway := nw.GetSubnetOfAddress(ep.IPAddress)
+	epResponse.IPAddress = ep.IPAddress + "/" + strconv.Itoa(int(subnetLen))
+	epResponse.Gateway = gateway
+
+	// dual stack networks also get an ipv6 address from the master
+	if mresp.EndpointConfig.IPv6Address != "" {
+		epResponse.IPv6Address = mresp.EndpointConfig.IPv6Address + "/" + strconv.Itoa(int(nw.IPv6SubnetLen))
+		epResponse.IPv6Gateway = nw.IPv6Gateway
+	}
+
+	// ipv6 routes only apply to dual stack pods
+	for _, route := range nw.StaticRoutes {
+		if netutils.IsIPv6(route.Destination) && epResponse.IPv6Address == "" {
+			continue
+		}
+		epResponse.Routes = append(epResponse.Routes, cniapi.Route{Dst: route.Destination, GW: route.NextHop})
+	}
+	epResponse.DNSServers = nw.DNSServers
+	if len(epResponse.DNSServers) == 0 && nw.DNSServer != "" {
+		// the name server of the network, answered by netplugin
+		epResponse.DNSServers = []string{nw.DNSServer}
+	}
+	epResponse.DNSSearch = nw.DNSSearch
+	epResponse.DNSOptions = nw.DNSOptions
 
 	return &epResponse, nil
 }
@@ -208,7 +259,7 @@ func nsToPID(ns string) (int, error) {
 }
 
 // setIfAttrs sets the required attributes for the container interface
-func setIfAttrs(pid int, ifname, cidr, newname string) error {
+func setIfAttrs(pid int, ifname, cidr, cidr6, newname string) error {
 
 	nsenterPath, err := osexec.LookPath("nsenter")
 	if err != nil {
@@ -256,6 +307,18 @@ func setIfAttrs(pid int, ifname, cidr, newname string) error {
 	}
 	log.Infof("Output from ip assign: %v", assignIP)
 
+	// set the ipv6 address
+	if cidr6 != "" {
+		assignIPv6, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
+			"-6", "address", "add", cidr6, "dev", newname).CombinedOutput()
+		if err != nil {
+			log.Errorf("unable to assign ipv6 %s to %s. Error: %s",
+				cidr6, newname, err)
+			return nil
+		}
+		log.Infof("Output from ipv6 assign: %v", assignIPv6)
+	}
+
 	// Finally, mark the link up
 	bringUp, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
 		"link", "set", "dev", newname, "up").CombinedOutput()
@@ -292,28 +355,118 @@ func setDefGw(pid int, gw, intfName string) error {
 	return nil
 }
 
+// setIPv6DefGw sets the default ipv6 gateway for the container namespace
+func setIPv6DefGw(pid int, gw, intfName string) error {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+	// set default gw
+	nsPid := fmt.Sprintf("%d", pid)
+	_, err = osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath, "-6", "route", "add",
+		"default", "via", gw, "dev", intfName).CombinedOutput()
+	if err != nil {
+		log.Errorf("unable to set default ipv6 gw %s. Error: %s",
+			gw, err)
+		return nil
+	}
+	return nil
+}
+
+// setStaticRoutes adds the static routes of the network to the container namespace
+func setStaticRoutes(pid int, routes []cniapi.Route, intfName string) error {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+
+	nsPid := fmt.Sprintf("%d", pid)
+	for _, route := range routes {
+		args := []string{"-t", nsPid, "-n", "-F", "--", ipPath}
+		if netutils.IsIPv6(route.Dst) {
+			args = append(args, "-6")
+		}
+		args = append(args, "route", "add", route.Dst)
+		if route.GW != "" {
+			args = append(args, "via", route.GW)
+		}
+		args = append(args, "dev", intfName)
+
+		out, err := osexec.Command(nsenterPath, args...).CombinedOutput()
+		if err != nil {
+			log.Errorf("unable to add route %+v. Error: %s, Output: %s", route, err, out)
+			return fmt.Errorf("unable to add route to %s. Err: %v", route.Dst, err)
+		}
+	}
+
+	return nil
+}
+
+// checkIfAttrs checks that the container interface has the address
+func checkIfAttrs(pid int, ifname, cidr string) error {
+	nsenterPath, err := osexec.LookPath("nsenter")
+	if err != nil {
+		return err
+	}
+	ipPath, err := osexec.LookPath("ip")
+	if err != nil {
+		return err
+	}
+
+	nsPid := fmt.Sprintf("%d", pid)
+	out, err := osexec.Command(nsenterPath, "-t", nsPid, "-n", "-F", "--", ipPath,
+		"-o", "address", "show", "dev", ifname).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("interface %s not found. Error: %s", ifname, out)
+	}
+
+	for _, field := range strings.Fields(string(out)) {
+		if field == cidr {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("address %s is not assigned to %s", cidr, ifname)
+}
+
 // getEPSpec gets the EP spec using the pod attributes
 func getEPSpec(pInfo *cniapi.CNIPodAttr) (*epSpec, error) {
 	resp := epSpec{}
 
-	// Get labels from the kube api server
-	epg, err := kubeAPIClient.GetPodLabel(pInfo.K8sNameSpace, pInfo.Name,
-		"io.contiv.net-group")
+	// Map the pod from its annotations, labels and the defaults
+	sources, err := getNetSources(kubeAPIClient, pInfo, &contivCfg)
 	if err != nil {
-		log.Errorf("Error getting epg. Err: %v", err)
+		log.Errorf("Error getting the mapping of pod %s/%s. Err: %v",
+			pInfo.K8sNameSpace, pInfo.Name, err)
 		return &resp, err
 	}
 
-	// Safe to ignore the error return for subsequent invocations of GetPodLabel
-	netw, _ := kubeAPIClient.GetPodLabel(pInfo.K8sNameSpace, pInfo.Name,
-		"io.contiv.network")
-	tenant, _ := kubeAPIClient.GetPodLabel(pInfo.K8sNameSpace, pInfo.Name,
-		"io.contiv.tenant")
-	log.Infof("labels is %s/%s/%s for pod %s\n", tenant, netw, epg, pInfo.Name)
+	mapping, err := resolveNetMapping(sources)
+	if err == nil {
+		err = mapping.validate()
+	}
+	if err != nil {
+		log.Errorf("Invalid mapping of pod %s/%s. Err: %v", pInfo.K8sNameSpace, pInfo.Name, err)
+		return &resp, fmt.Errorf("pod %s/%s: %v", pInfo.K8sNameSpace, pInfo.Name, err)
+	}
+
+	log.Infof("pod %s/%s is mapped to tenant %s (from %s), network %s (from %s), epg %q (from %s)",
+		pInfo.K8sNameSpace, pInfo.Name, mapping.tenant, mapping.tenantSrc,
+		mapping.network, mapping.networkSrc, mapping.group, mapping.groupSrc)
+	tenant, netw, epg := mapping.tenant, mapping.network, mapping.group
 	resp.Tenant = tenant
 	resp.Network = netw
 	resp.Group = epg
 	resp.EndpointID = pInfo.InfraContainerID
+	resp.PodName = pInfo.Name
 
 	return &resp, nil
 }
@@ -356,7 +509,7 @@ func addPod(r *http.Request) (interface{}, error) {
 	}
 
 	// Set interface attributes for the new port
-	err = setIfAttrs(pid, ep.PortName, ep.IPAddress, pInfo.IntfName)
+	err = setIfAttrs(pid, ep.PortName, ep.IPAddress, ep.IPv6Address, pInfo.IntfName)
 	if err != nil {
 		log.Errorf("Error setting interface attributes. Err: %v", err)
 		return resp, err
@@ -369,7 +522,31 @@ func addPod(r *http.Request) (interface{}, error) {
 		return resp, err
 	}
 
+	// Set default ipv6 gateway
+	if ep.IPv6Address != "" && ep.IPv6Gateway != "" {
+		err = setIPv6DefGw(pid, ep.IPv6Gateway, pInfo.IntfName)
+		if err != nil {
+			log.Errorf("Error setting default ipv6 gateway. Err: %v", err)
+			return resp, err
+		}
+	}
+
+	// Set static routes of the network
+	err = setStaticRoutes(pid, ep.Routes, pInfo.IntfName)
+	if err != nil {
+		log.Errorf("Error setting static routes. Err: %v", err)
+		return resp, err
+	}
+
 	resp.IPAddress = ep.IPAddress
+	resp.IPv6Address = ep.IPv6Address
+	resp.Gateway = ep.Gateway
+	resp.IPv6Gateway = ep.IPv6Gateway
+	resp.MacAddress = ep.MacAddress
+	resp.Routes = ep.Routes
+	resp.DNSServers = ep.DNSServers
+	resp.DNSSearch = ep.DNSSearch
+	resp.DNSOptions = ep.DNSOptions
 	resp.EndpointID = pInfo.InfraContainerID
 	return resp, nil
 }
@@ -403,3 +580,66 @@ func deletePod(r *http.Request) (interface{}, error) {
 	resp.EndpointID = pInfo.InfraContainerID
 	return resp, err
 }
+
+// checkPod is the handler for pod checks
+func checkPod(r *http.Request) (interface{}, error) {
+
+	resp := cniapi.RspAddPod{}
+
+	logEvent("check pod")
+
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Errorf("Failed to read request: %v", err)
+		return resp, err
+	}
+
+	pInfo := cniapi.CNIPodAttr{}
+	if err := json.Unmarshal(content, &pInfo); err != nil {
+		return resp, err
+	}
+
+	// Get labels from the kube api server
+	epReq, err := getEPSpec(&pInfo)
+	if err != nil {
+		log.Errorf("Error getting labels. Err: %v", err)
+		return resp, err
+	}
+
+	netID := epReq.Network + "." + epReq.Tenant
+	ep, err := netdGetEndpoint(netID + "-" + epReq.EndpointID)
+	if err != nil {
+		return resp, fmt.Errorf("EP %s not found. Err: %v", epReq.EndpointID, err)
+	}
+	nw, err := netdGetNetwork(netID)
+	if err != nil {
+		return resp, err
+	}
+
+	subnetLen, gateway := nw.GetSubnetOfAddress(ep.IPAddress)
+	resp.IPAddress = ep.IPAddress + "/" + strconv.Itoa(int(subnetLen))
+	resp.Gateway = gateway
+	resp.MacAddress = ep.MacAddress
+	if epCfg, err := netdGetEndpointCfg(netID + "-" + epReq.EndpointID); err == nil &&
+		epCfg.IPv6Address != "" {
+		resp.IPv6Address = epCfg.IPv6Address + "/" + strconv.Itoa(int(nw.IPv6SubnetLen))
+		resp.IPv6Gateway = nw.IPv6Gateway
+	}
+
+	// verify the container interface
+	pid, err := nsToPID(pInfo.NwNameSpace)
+	if err != nil {
+		return resp, err
+	}
+	if err := checkIfAttrs(pid, pInfo.IntfName, resp.IPAddress); err != nil {
+		return resp, err
+	}
+	if resp.IPv6Address != "" {
+		if err := checkIfAttrs(pid, pInfo.IntfName, resp.IPv6Address); err != nil {
+			return resp, err
+		}
+	}
+
+	resp.EndpointID = pInfo.InfraContainerID
+	return resp, nil
+}
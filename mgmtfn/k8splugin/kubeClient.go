@@ -23,10 +23,9 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
-	"golang.org/x/net/context"
-	"golang.org/x/net/context/ctxhttp"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 )
 
 const (
@@ -35,26 +34,53 @@ const (
 
 // APIClient defines information needed for the k8s api client
 type APIClient struct {
-	baseURL   string
-	watchBase string
-	client    *http.Client
-	podCache  podInfo
+	baseURL      string
+	apiBase      string
+	watchBase    string
+	extAPIBase   string
+	extWatchBase string
+	client       *http.Client
+	podCache     podInfo
+	cancel       chan struct{} // closed to end the watches
 }
 
 // SvcWatchResp is the response to a service watch
 type SvcWatchResp struct {
-	opcode  string
-	errStr  string
-	svcName string
-	svcSpec core.ServiceSpec
+	opcode     string
+	errStr     string
+	svcName    string
+	svcSpec    core.ServiceSpec
+	resVersion string
 }
 
 // EpWatchResp is the response to service endpoints watch
 type EpWatchResp struct {
-	opcode    string
-	errStr    string
-	svcName   string
-	providers []string
+	opcode     string
+	errStr     string
+	svcName    string
+	providers  []core.ProviderSpec
+	resVersion string
+}
+
+// ObjWatchResp is the response to a watch of network policies, namespaces or pods
+type ObjWatchResp struct {
+	kind   string
+	opcode string
+	errStr string
+	object json.RawMessage
+}
+
+// objList is a list of objects that are decoded by the consumer
+type objList struct {
+	ListMeta `json:"metadata,omitempty"`
+	Items    []json.RawMessage `json:"items"`
+}
+
+type watchObjStatus struct {
+	// The type of watch update contained in the message
+	Type string `json:"type"`
+	// Object details, decoded by the consumer
+	Object json.RawMessage `json:"object"`
 }
 
 type watchSvcStatus struct {
@@ -72,16 +98,22 @@ type watchSvcEpStatus struct {
 }
 
 type podInfo struct {
-	nameSpace string
-	name      string
-	labels    map[string]string
+	nameSpace   string
+	name        string
+	labels      map[string]string
+	podLabels   map[string]bool
+	annotations map[string]string
 }
 
 // NewAPIClient creates an instance of the k8s api client
 func NewAPIClient(serverURL, caFile, keyFile, certFile string) *APIClient {
 	c := APIClient{}
 	c.baseURL = serverURL + "/api/v1/namespaces/"
+	c.apiBase = serverURL + "/api/v1/"
 	c.watchBase = serverURL + "/api/v1/watch/"
+	c.extAPIBase = serverURL + "/apis/extensions/v1beta1/"
+	c.extWatchBase = serverURL + "/apis/extensions/v1beta1/watch/"
+	c.cancel = make(chan struct{})
 
 	// Read client cert
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
@@ -120,6 +152,9 @@ func NewAPIClient(serverURL, caFile, keyFile, certFile string) *APIClient {
 func (p *podInfo) setDefaults(ns, name string) {
 	p.nameSpace = ns
 	p.name = name
+	p.labels = make(map[string]string)
+	p.podLabels = make(map[string]bool)
+	p.annotations = make(map[string]string)
 	p.labels["io.contiv.tenant"] = "default"
 	p.labels["io.contiv.network"] = "default-net"
 	p.labels["io.contiv.net-group"] = ""
@@ -176,6 +211,7 @@ func (c *APIClient) fetchPodLabels(ns, name string) error {
 
 			case string:
 				p.labels[key] = val.(string)
+				p.podLabels[key] = true
 
 			default:
 				log.Infof("Label %s type %v in pod %s.%s ignored",
@@ -186,6 +222,15 @@ func (c *APIClient) fetchPodLabels(ns, name string) error {
 		log.Infof("labels not found in podSpec metadata, using defaults")
 	}
 
+	if a, ok := meta["annotations"]; ok {
+		annotations, _ := a.(map[string]interface{})
+		for key, val := range annotations {
+			if strVal, ok := val.(string); ok {
+				p.annotations[key] = strVal
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -209,141 +254,327 @@ func (c *APIClient) GetPodLabel(ns, name, label string) (string, error) {
 	return "", nil
 }
 
-// WatchServices watches the services object on the api server
-func (c *APIClient) WatchServices(respCh chan SvcWatchResp) {
-	ctx, _ := context.WithCancel(context.Background())
+// GetPodAnnotations retrieves the annotations of the pod
+func (c *APIClient) GetPodAnnotations(ns, name string) (map[string]string, error) {
+
+	// If cache does not match, fetch
+	if c.podCache.nameSpace != ns || c.podCache.name != name {
+		err := c.fetchPodLabels(ns, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.podCache.annotations, nil
+}
+
+// GetNamespaceAnnotations retrieves the annotations of the namespace
+func (c *APIClient) GetNamespaceAnnotations(ns string) (map[string]string, error) {
+	nsObj := Namespace{}
+	if err := c.getObject(c.baseURL+ns, &nsObj); err != nil {
+		return nil, err
+	}
+
+	return nsObj.Annotations, nil
+}
+
+// isPodLabelSet checks if the label is set on the pod, rather than defaulted.
+// GetPodLabel must have fetched the labels of the pod.
+func (c *APIClient) isPodLabelSet(label string) bool {
+	return c.podCache.podLabels[label]
+}
+
+// svcSpecFromService converts a kubernetes service to a service spec
+func svcSpecFromService(svc *Service) *core.ServiceSpec {
+	sSpec := &core.ServiceSpec{}
+	sSpec.Ports = make([]core.PortSpec, 0, 1)
+	sSpec.IPAddress = svc.Spec.ClusterIP
+	sSpec.ClientIPAffinity = svc.Spec.SessionAffinity == ServiceAffinityClientIP
+
+	// node ports are only allocated to NodePort and LoadBalancer services.
+	// The pods reach the services on the node ports and external IPs
+	// through the service proxy, other clients through kube-proxy.
+	exposeNodePort := svc.Spec.Type == ServiceTypeNodePort ||
+		svc.Spec.Type == ServiceTypeLoadBalancer
+	for _, port := range svc.Spec.Ports {
+		ps := core.PortSpec{Name: port.Name,
+			Protocol: string(port.Protocol),
+			SvcPort:  uint16(port.Port),
+		}
+		switch {
+		case port.TargetPort.IsString:
+			// named ports are resolved per provider from the endpoints
+		case port.TargetPort.IntVal == 0:
+			// the target port defaults to the service port
+			ps.ProvPort = uint16(port.Port)
+		default:
+			ps.ProvPort = uint16(port.TargetPort.IntVal)
+		}
+		if exposeNodePort {
+			ps.NodePort = uint16(port.NodePort)
+		}
+		sSpec.Ports = append(sSpec.Ports, ps)
+	}
+
+	sSpec.ExternalIPs = append(sSpec.ExternalIPs, svc.Spec.ExternalIPs...)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			sSpec.ExternalIPs = append(sSpec.ExternalIPs, ingress.IP)
+		}
+	}
+
+	return sSpec
+}
+
+// providersFromEndpoints returns the providers of service endpoints, with
+// their ports by service port name. Pods of a service can serve a port on
+// different numbers, e.g. for a named target port, in which case they are
+// in different subsets.
+func providersFromEndpoints(eps *Endpoints) []core.ProviderSpec {
+	providers := make([]core.ProviderSpec, 0, 1)
+	provIdx := make(map[string]int)
+	for _, subset := range eps.Subsets {
+		// TODO: handle partially ready providers
+		for _, addr := range subset.Addresses {
+			idx, found := provIdx[addr.IP]
+			if !found {
+				idx = len(providers)
+				provIdx[addr.IP] = idx
+				providers = append(providers, core.ProviderSpec{IPAddress: addr.IP,
+					Ports: make(map[string]uint16)})
+			}
+			for _, port := range subset.Ports {
+				providers[idx].Ports[port.Name] = uint16(port.Port)
+			}
+		}
+	}
+
+	return providers
+}
+
+// getObject gets the object at the url and decodes it
+func (c *APIClient) getObject(getURL string, obj interface{}) error {
+	r, err := c.client.Get(getURL)
+	if err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", getURL, r.Status)
+	}
+
+	return json.NewDecoder(r.Body).Decode(obj)
+}
+
+// ListServices returns the service specs by name and the resource version
+// of the list, from which a watch picks up the changes
+func (c *APIClient) ListServices() (map[string]*core.ServiceSpec, string, error) {
+	list := ServiceList{}
+	if err := c.getObject(c.apiBase+"services", &list); err != nil {
+		return nil, "", err
+	}
+
+	svcs := make(map[string]*core.ServiceSpec)
+	for idx := range list.Items {
+		svcs[list.Items[idx].Name] = svcSpecFromService(&list.Items[idx])
+	}
+
+	return svcs, list.ResourceVersion, nil
+}
+
+// ListSvcEps returns the service providers by service name and the resource
+// version of the list, from which a watch picks up the changes
+func (c *APIClient) ListSvcEps() (map[string][]core.ProviderSpec, string, error) {
+	list := EndpointsList{}
+	if err := c.getObject(c.apiBase+"endpoints", &list); err != nil {
+		return nil, "", err
+	}
+
+	providers := make(map[string][]core.ProviderSpec)
+	for idx := range list.Items {
+		providers[list.Items[idx].Name] = providersFromEndpoints(&list.Items[idx])
+	}
+
+	return providers, list.ResourceVersion, nil
+}
+
+// watchURL returns the url of a watch that starts after the resource version
+func watchURL(base, resVersion string) string {
+	if resVersion == "" {
+		return base
+	}
+
+	return base + "?resourceVersion=" + url.QueryEscape(resVersion)
+}
 
+// streamWatch opens a watch at the url and passes the events to the handler.
+// The watch ends with a FATAL when it could not be opened, with a GONE when
+// the resource version it started from is no longer available and with an
+// ERROR when the server ends it.
+func (c *APIClient) streamWatch(getURL string, handler func(opcode, errStr string, object json.RawMessage)) {
 	go func() {
 		// Make request to Kubernetes API
-		getURL := c.watchBase + "services"
 		req, err := http.NewRequest("GET", getURL, nil)
 		if err != nil {
-			respCh <- SvcWatchResp{opcode: "FATAL", errStr: fmt.Sprintf("Req %v", err)}
+			handler("FATAL", fmt.Sprintf("Req %v", err), nil)
 			return
 		}
-		res, err := ctxhttp.Do(ctx, c.client, req)
+		req.Cancel = c.cancel
+		res, err := c.client.Do(req)
 		if err != nil {
 			log.Errorf("Watch error: %v", err)
-			respCh <- SvcWatchResp{opcode: "FATAL", errStr: fmt.Sprintf("Do %v", err)}
+			handler("FATAL", fmt.Sprintf("Do %v", err), nil)
 			return
 		}
 		defer res.Body.Close()
 
-		var wss watchSvcStatus
-		reader := bufio.NewReader(res.Body)
-
-		// bufio.Reader.ReadBytes is blocking, so we watch for
-		// context timeout or cancellation in a goroutine
-		// and close the response body when see see it. The
-		// response body is also closed via defer when the
-		// request is made, but closing twice is OK.
-		go func() {
-			<-ctx.Done()
-			res.Body.Close()
-		}()
+		switch {
+		case res.StatusCode == http.StatusGone:
+			handler("GONE", res.Status, nil)
+			return
+		case res.StatusCode != http.StatusOK:
+			handler("ERROR", fmt.Sprintf("status %s", res.Status), nil)
+			return
+		}
 
+		reader := bufio.NewReader(res.Body)
 		for {
 			line, err := reader.ReadBytes('\n')
-			if ctx.Err() != nil {
-				respCh <- SvcWatchResp{opcode: "ERROR", errStr: fmt.Sprintf("ctx %v", err)}
-				return
-			}
 			if err != nil {
-				respCh <- SvcWatchResp{opcode: "ERROR", errStr: fmt.Sprintf("read %v", err)}
+				handler("ERROR", fmt.Sprintf("read %v", err), nil)
 				return
 			}
-			if err := json.Unmarshal(line, &wss); err != nil {
-				respCh <- SvcWatchResp{opcode: "WARN", errStr: fmt.Sprintf("unmarshal %v", err)}
+
+			var wos watchObjStatus
+			if err := json.Unmarshal(line, &wos); err != nil {
+				handler("WARN", fmt.Sprintf("unmarshal %v", err), nil)
 				continue
 			}
 
-			//if wss.Object.ObjectMeta.Namespace != "default" {
-			//	continue
-			//}
-			resp := SvcWatchResp{opcode: wss.Type}
-			resp.svcName = wss.Object.ObjectMeta.Name
-			sSpec := core.ServiceSpec{}
-			sSpec.Ports = make([]core.PortSpec, 0, 1)
-			sSpec.IPAddress = wss.Object.Spec.ClusterIP
-			for _, port := range wss.Object.Spec.Ports {
-				ps := core.PortSpec{Protocol: string(port.Protocol),
-					SvcPort:  uint16(port.Port),
-					ProvPort: uint16(port.TargetPort),
+			// the server ends the watch with an error status
+			if wos.Type == "ERROR" {
+				status := Status{}
+				if err := json.Unmarshal(wos.Object, &status); err == nil &&
+					status.Code == http.StatusGone {
+					handler("GONE", status.Message, nil)
+				} else {
+					handler("ERROR", fmt.Sprintf("watch %s", status.Message), nil)
 				}
-				sSpec.Ports = append(sSpec.Ports, ps)
+				return
 			}
 
-			resp.svcSpec = sSpec
-			log.Infof("resp: %+v", resp)
-
-			respCh <- resp
+			handler(wos.Type, "", wos.Object)
 		}
 	}()
 }
 
-// WatchSvcEps watches the service endpoints object
-func (c *APIClient) WatchSvcEps(respCh chan EpWatchResp) {
-	ctx, _ := context.WithCancel(context.Background())
+// WatchServices watches the services object on the api server, starting
+// after the resource version
+func (c *APIClient) WatchServices(resVersion string, respCh chan SvcWatchResp) {
+	c.streamWatch(watchURL(c.watchBase+"services", resVersion),
+		func(opcode, errStr string, object json.RawMessage) {
+			if object == nil {
+				respCh <- SvcWatchResp{opcode: opcode, errStr: errStr}
+				return
+			}
 
-	go func() {
-		// Make request to Kubernetes API
-		getURL := c.watchBase + "endpoints"
-		req, err := http.NewRequest("GET", getURL, nil)
-		if err != nil {
-			respCh <- EpWatchResp{opcode: "FATAL", errStr: fmt.Sprintf("Req %v", err)}
-			return
-		}
-		res, err := ctxhttp.Do(ctx, c.client, req)
-		if err != nil {
-			log.Errorf("EP Watch error: %v", err)
-			respCh <- EpWatchResp{opcode: "FATAL", errStr: fmt.Sprintf("Do %v", err)}
-			return
-		}
-		defer res.Body.Close()
+			svc := Service{}
+			if err := json.Unmarshal(object, &svc); err != nil {
+				respCh <- SvcWatchResp{opcode: "WARN", errStr: fmt.Sprintf("unmarshal %v", err)}
+				return
+			}
 
-		var weps watchSvcEpStatus
-		reader := bufio.NewReader(res.Body)
+			resp := SvcWatchResp{opcode: opcode, resVersion: svc.ResourceVersion}
+			resp.svcName = svc.Name
+			resp.svcSpec = *svcSpecFromService(&svc)
+			log.Infof("resp: %+v", resp)
 
-		// bufio.Reader.ReadBytes is blocking, so we watch for
-		// context timeout or cancellation in a goroutine
-		// and close the response body when see see it. The
-		// response body is also closed via defer when the
-		// request is made, but closing twice is OK.
-		go func() {
-			<-ctx.Done()
-			res.Body.Close()
-		}()
+			respCh <- resp
+		})
+}
 
-		for {
-			line, err := reader.ReadBytes('\n')
-			if ctx.Err() != nil {
-				respCh <- EpWatchResp{opcode: "ERROR", errStr: fmt.Sprintf("ctx %v", err)}
+// WatchSvcEps watches the service endpoints object, starting after the
+// resource version
+func (c *APIClient) WatchSvcEps(resVersion string, respCh chan EpWatchResp) {
+	c.streamWatch(watchURL(c.watchBase+"endpoints", resVersion),
+		func(opcode, errStr string, object json.RawMessage) {
+			if object == nil {
+				respCh <- EpWatchResp{opcode: opcode, errStr: errStr}
 				return
 			}
-			if err != nil {
-				respCh <- EpWatchResp{opcode: "ERROR", errStr: fmt.Sprintf("read %v", err)}
-				return
-			}
-			if err := json.Unmarshal(line, &weps); err != nil {
+
+			eps := Endpoints{}
+			if err := json.Unmarshal(object, &eps); err != nil {
 				respCh <- EpWatchResp{opcode: "WARN", errStr: fmt.Sprintf("unmarshal %v", err)}
-				continue
-			}
-			//if weps.Object.ObjectMeta.Namespace != "default" {
-			//	continue
-			//}
-
-			resp := EpWatchResp{opcode: weps.Type}
-			resp.svcName = weps.Object.ObjectMeta.Name
-			resp.providers = make([]string, 0, 1)
-			for _, subset := range weps.Object.Subsets {
-				// TODO: handle partially ready providers
-				for _, addr := range subset.Addresses {
-					resp.providers = append(resp.providers, addr.IP)
-				}
+				return
 			}
 
+			resp := EpWatchResp{opcode: opcode, resVersion: eps.ResourceVersion}
+			resp.svcName = eps.Name
+			resp.providers = providersFromEndpoints(&eps)
+
 			log.Infof("kube ep watch: %v", resp)
 			respCh <- resp
+		})
+}
+
+// Close ends the watches of the client
+func (c *APIClient) Close() {
+	if c.cancel != nil {
+		close(c.cancel)
+	}
+}
+
+// listObjects lists the objects at the url, undecoded, and returns them
+// with the resource version of the list
+func (c *APIClient) listObjects(getURL string) ([]json.RawMessage, string, error) {
+	list := objList{}
+	if err := c.getObject(getURL, &list); err != nil {
+		return nil, "", err
+	}
+
+	return list.Items, list.ResourceVersion, nil
+}
+
+// watchObjects watches the objects at the url and passes them on undecoded
+func (c *APIClient) watchObjects(kind, getURL string, respCh chan ObjWatchResp) {
+	c.streamWatch(getURL, func(opcode, errStr string, object json.RawMessage) {
+		select {
+		case respCh <- ObjWatchResp{kind: kind, opcode: opcode, errStr: errStr, object: object}:
+		case <-c.cancel:
 		}
-	}()
+	})
+}
+
+// ListNetworkPolicies lists the network policy objects
+func (c *APIClient) ListNetworkPolicies() ([]json.RawMessage, string, error) {
+	return c.listObjects(c.extAPIBase + "networkpolicies")
+}
+
+// ListNamespaces lists the namespace objects
+func (c *APIClient) ListNamespaces() ([]json.RawMessage, string, error) {
+	return c.listObjects(c.apiBase + "namespaces")
+}
+
+// ListPods lists the pod objects
+func (c *APIClient) ListPods() ([]json.RawMessage, string, error) {
+	return c.listObjects(c.apiBase + "pods")
+}
+
+// WatchNetworkPolicies watches the network policy objects, starting after
+// the resource version
+func (c *APIClient) WatchNetworkPolicies(resVersion string, respCh chan ObjWatchResp) {
+	c.watchObjects("NetworkPolicy", watchURL(c.extWatchBase+"networkpolicies", resVersion), respCh)
+}
+
+// WatchNamespaces watches the namespace objects, starting after the
+// resource version
+func (c *APIClient) WatchNamespaces(resVersion string, respCh chan ObjWatchResp) {
+	c.watchObjects("Namespace", watchURL(c.watchBase+"namespaces", resVersion), respCh)
+}
+
+// WatchPods watches the pod objects, starting after the resource version
+func (c *APIClient) WatchPods(resVersion string, respCh chan ObjWatchResp) {
+	c.watchObjects("Pod", watchURL(c.watchBase+"pods", resVersion), respCh)
 }
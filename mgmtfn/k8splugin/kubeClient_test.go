@@ -38,6 +38,8 @@ const (
 	testPodURL       = "/api/v1/namespaces/default/pods/test-pod"
 	svcWatchURL      = "/api/v1/watch/services"
 	epWatchURL       = "/api/v1/watch/endpoints"
+	svcListURL       = "/api/v1/services"
+	epListURL        = "/api/v1/endpoints"
 	contivKubeCfgDir = "/opt/contiv/config"
 	testCfgFile      = "/tmp/certs/contiv.json"
 	testServerURL    = "0.0.0.0:443"
@@ -70,14 +72,14 @@ type KubeTestNetDrv struct {
 	numDelSvc  int
 	numProvUpd int
 	services   map[string]*core.ServiceSpec
-	providers  map[string][]string
+	providers  map[string][]core.ProviderSpec
 }
 type restFunc func(r *http.Request, iter int) (interface{}, bool, error)
 
 // Init is not implemented.
 func (d *KubeTestNetDrv) Init(nfo *core.InstanceInfo) error {
 	d.services = make(map[string]*core.ServiceSpec)
-	d.providers = make(map[string][]string)
+	d.providers = make(map[string][]core.ProviderSpec)
 	return nil
 }
 
@@ -150,11 +152,21 @@ func (d *KubeTestNetDrv) DelSvcSpec(svcName string, spec *core.ServiceSpec) erro
 }
 
 // SvcProviderUpdate is implemented.
-func (d *KubeTestNetDrv) SvcProviderUpdate(svcName string, provs []string) {
+func (d *KubeTestNetDrv) SvcProviderUpdate(svcName string, provs []core.ProviderSpec) {
 	d.providers[svcName] = provs
 	d.numProvUpd++
 }
 
+// AddServiceVIP is not implemented.
+func (d *KubeTestNetDrv) AddServiceVIP(vip string) error {
+	return nil
+}
+
+// DelServiceVIP is not implemented.
+func (d *KubeTestNetDrv) DelServiceVIP(vip string) error {
+	return nil
+}
+
 // Simple Wrapper for http handlers
 func restWrapper(handlerFunc restFunc) http.HandlerFunc {
 	// Create a closure and return an anonymous function
@@ -214,6 +226,14 @@ func testPodGet(r *http.Request, iter int) (interface{}, bool, error) {
 
 }
 
+func serviceList(r *http.Request, iter int) (interface{}, bool, error) {
+	return ServiceList{}, true, nil
+}
+
+func epList(r *http.Request, iter int) (interface{}, bool, error) {
+	return EndpointsList{}, true, nil
+}
+
 func serviceWatch(r *http.Request, iter int) (interface{}, bool, error) {
 	for totalSvcResp >= maxSvcResp {
 		time.Sleep(time.Second)
@@ -224,7 +244,7 @@ func serviceWatch(r *http.Request, iter int) (interface{}, bool, error) {
 	sPort := ServicePort{
 		Protocol:   ProtocolTCP,
 		Port:       testSvcPort,
-		TargetPort: testTgtPort,
+		TargetPort: IntOrString{IntVal: testTgtPort},
 	}
 	ports := make([]ServicePort, 1)
 	ports[0] = sPort
@@ -346,6 +366,8 @@ func setupTestServer(m *testing.M) {
 	t.HandleFunc(testPodURL, restWrapper(testPodGet))
 	t.HandleFunc(svcWatchURL, restWrapper(serviceWatch))
 	t.HandleFunc(epWatchURL, restWrapper(epWatch))
+	t.HandleFunc(svcListURL, restWrapper(serviceList))
+	t.HandleFunc(epListURL, restWrapper(epList))
 
 	server := &http.Server{Addr: testServerURL, Handler: router, TLSConfig: tlsCfg}
 
@@ -496,3 +518,39 @@ func TestKubeWatch(m *testing.T) {
 		}
 	}
 }
+
+// TestSvcSpecFromService tests the conversion of node ports, external IPs
+// and session affinity
+func TestSvcSpecFromService(t *testing.T) {
+	svc := &Service{
+		Spec: ServiceSpec{
+			ClusterIP: testClusterIP,
+			Ports: []ServicePort{{Protocol: ProtocolTCP, Port: testSvcPort,
+				TargetPort: IntOrString{IntVal: testTgtPort}, NodePort: 30080}},
+			ExternalIPs:     []string{"192.168.2.10"},
+			SessionAffinity: ServiceAffinityClientIP,
+		},
+	}
+
+	// node ports are only exposed by NodePort and LoadBalancer services
+	spec := svcSpecFromService(svc)
+	if spec.Ports[0].NodePort != 0 {
+		t.Errorf("node port exposed for ClusterIP service: %+v", spec)
+	}
+
+	svc.Spec.Type = ServiceTypeLoadBalancer
+	svc.Status.LoadBalancer.Ingress = []LoadBalancerIngress{{IP: "192.168.2.20"},
+		{Hostname: "lb.example.com"}}
+	spec = svcSpecFromService(svc)
+	if spec.IPAddress != testClusterIP || spec.Ports[0].NodePort != 30080 ||
+		spec.Ports[0].SvcPort != testSvcPort || spec.Ports[0].ProvPort != testTgtPort {
+		t.Errorf("incorrect ports in spec: %+v", spec)
+	}
+	if len(spec.ExternalIPs) != 2 || spec.ExternalIPs[0] != "192.168.2.10" ||
+		spec.ExternalIPs[1] != "192.168.2.20" {
+		t.Errorf("incorrect external IPs in spec: %+v", spec)
+	}
+	if !spec.ClientIPAffinity {
+		t.Errorf("ClientIP affinity not set in spec: %+v", spec)
+	}
+}
@@ -0,0 +1,156 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"fmt"
+
+	"github.com/contiv/netplugin/mgmtfn/k8splugin/cniapi"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+// keys of the labels and annotations that map a pod to contiv
+const (
+	tenantKey  = "io.contiv.tenant"
+	networkKey = "io.contiv.network"
+	groupKey   = "io.contiv.net-group"
+
+	// tenant of pods that are not mapped to one
+	defaultTenant = "default"
+)
+
+// netAttrSource is a source of the tenant, network and epg of a pod
+type netAttrSource struct {
+	name  string
+	attrs map[string]string
+}
+
+// netMapping is the tenant, network and epg a pod is mapped to, along with
+// where each of them came from
+type netMapping struct {
+	tenant     string
+	network    string
+	group      string
+	tenantSrc  string
+	networkSrc string
+	groupSrc   string
+}
+
+// getNetSources returns the sources of the mapping of a pod, in order of
+// precedence: pod annotations, pod labels, namespace annotations, the
+// network config of the cni plugin and the cluster defaults.
+func getNetSources(client *APIClient, pInfo *cniapi.CNIPodAttr, cfg *ContivConfig) ([]netAttrSource, error) {
+	podAnnotations, err := client.GetPodAnnotations(pInfo.K8sNameSpace, pInfo.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s. Err: %v", pInfo.K8sNameSpace, pInfo.Name, err)
+	}
+
+	// only labels set on the pod, not the defaults
+	podLabels := make(map[string]string)
+	for _, key := range []string{tenantKey, networkKey, groupKey} {
+		if client.isPodLabelSet(key) {
+			podLabels[key] = client.podCache.labels[key]
+		}
+	}
+
+	nsAnnotations, err := client.GetNamespaceAnnotations(pInfo.K8sNameSpace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s. Err: %v", pInfo.K8sNameSpace, err)
+	}
+
+	sources := []netAttrSource{
+		{name: "pod annotation", attrs: podAnnotations},
+		{name: "pod label", attrs: podLabels},
+		{name: "namespace " + pInfo.K8sNameSpace + " annotation", attrs: nsAnnotations},
+		{name: "cni network config", attrs: nonEmptyAttrs(pInfo.Tenant, pInfo.Network, pInfo.Group)},
+	}
+	if cfg != nil {
+		sources = append(sources, netAttrSource{name: "cluster default",
+			attrs: nonEmptyAttrs(cfg.DefaultTenant, cfg.DefaultNetwork, cfg.DefaultGroup)})
+	}
+
+	return sources, nil
+}
+
+// nonEmptyAttrs returns the attributes that are set
+func nonEmptyAttrs(tenant, network, group string) map[string]string {
+	attrs := make(map[string]string)
+	for key, val := range map[string]string{tenantKey: tenant, networkKey: network, groupKey: group} {
+		if val != "" {
+			attrs[key] = val
+		}
+	}
+
+	return attrs
+}
+
+// resolveNetMapping picks each attribute from the first source that sets it.
+// A pod must be mapped to a network; the tenant defaults to the default tenant.
+func resolveNetMapping(sources []netAttrSource) (*netMapping, error) {
+	pick := func(key string) (string, string) {
+		for _, src := range sources {
+			if val, ok := src.attrs[key]; ok {
+				return val, src.name
+			}
+		}
+		return "", ""
+	}
+
+	m := &netMapping{}
+	m.tenant, m.tenantSrc = pick(tenantKey)
+	m.network, m.networkSrc = pick(networkKey)
+	m.group, m.groupSrc = pick(groupKey)
+
+	if m.tenant == "" {
+		m.tenant, m.tenantSrc = defaultTenant, "default"
+	}
+	if m.network == "" {
+		return nil, fmt.Errorf("no network for the pod. Set the %s annotation on the pod or "+
+			"its namespace, or a default network in the cluster config", networkKey)
+	}
+
+	return m, nil
+}
+
+// validate checks that the network and epg of the mapping exist
+func (m *netMapping) validate() error {
+	if _, err := netdGetNetwork(m.network + "." + m.tenant); err != nil {
+		return fmt.Errorf("network %s of tenant %s (from %s) does not exist",
+			m.network, m.tenant, m.networkSrc)
+	}
+
+	if m.group == "" {
+		return nil
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+	epgCfg := &mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = stateDriver
+	if err := epgCfg.Read(mastercfg.GetEndpointGroupKey(m.group, m.tenant)); err != nil {
+		return fmt.Errorf("epg %s of tenant %s (from %s) does not exist",
+			m.group, m.tenant, m.groupSrc)
+	}
+	if epgCfg.NetworkName != m.network {
+		return fmt.Errorf("epg %s (from %s) is not in network %s (from %s)",
+			m.group, m.groupSrc, m.network, m.networkSrc)
+	}
+
+	return nil
+}
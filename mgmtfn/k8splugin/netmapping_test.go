@@ -0,0 +1,94 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/contiv/netplugin/mgmtfn/k8splugin/cniapi"
+)
+
+// TestNetMapping tests the precedence of the pod mapping sources
+func TestNetMapping(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/namespaces/dev/pods/web":
+			json.NewEncoder(w).Encode(&Pod{ObjectMeta: ObjectMeta{Name: "web", Namespace: "dev",
+				Labels:      map[string]string{groupKey: "label-epg"},
+				Annotations: map[string]string{networkKey: "pod-net"}}})
+		case "/api/v1/namespaces/dev":
+			json.NewEncoder(w).Encode(&Namespace{ObjectMeta: ObjectMeta{Name: "dev",
+				Annotations: map[string]string{tenantKey: "dev", networkKey: "dev-net",
+					groupKey: "dev-epg"}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{baseURL: server.URL + nsURL, client: &http.Client{}}
+	client.podCache.labels = make(map[string]string)
+
+	pInfo := &cniapi.CNIPodAttr{Name: "web", K8sNameSpace: "dev", Network: "cni-net"}
+	cfg := &ContivConfig{DefaultTenant: "blue", DefaultNetwork: "default-net"}
+	sources, err := getNetSources(client, pInfo, cfg)
+	if err != nil {
+		t.Fatalf("Error getting mapping sources. Err: %v", err)
+	}
+
+	m, err := resolveNetMapping(sources)
+	if err != nil {
+		t.Fatalf("Error resolving mapping. Err: %v", err)
+	}
+	if m.tenant != "dev" || m.tenantSrc != "namespace dev annotation" ||
+		m.network != "pod-net" || m.networkSrc != "pod annotation" ||
+		m.group != "label-epg" || m.groupSrc != "pod label" {
+		t.Errorf("unexpected mapping %+v", m)
+	}
+
+	// the cluster defaults apply when nothing else maps the pod
+	m, err = resolveNetMapping(sources[3:])
+	if err != nil {
+		t.Fatalf("Error resolving mapping. Err: %v", err)
+	}
+	if m.tenant != "blue" || m.tenantSrc != "cluster default" ||
+		m.network != "cni-net" || m.networkSrc != "cni network config" || m.group != "" {
+		t.Errorf("unexpected mapping %+v", m)
+	}
+
+	// a pod without a network is not mapped
+	m, err = resolveNetMapping([]netAttrSource{{name: "pod label",
+		attrs: map[string]string{groupKey: "epg"}}})
+	if err == nil {
+		t.Errorf("pod without a network was mapped to %+v", m)
+	}
+
+	// the tenant defaults to the default tenant
+	m, err = resolveNetMapping([]netAttrSource{{name: "pod label",
+		attrs: map[string]string{networkKey: "net"}}})
+	if err != nil || m.tenant != defaultTenant {
+		t.Errorf("got mapping %+v, err %v for pod without a tenant", m, err)
+	}
+
+	// a missing namespace fails the mapping
+	pInfo.K8sNameSpace = "prod"
+	if _, err := getNetSources(client, pInfo, cfg); err == nil {
+		t.Errorf("mapping of pod in missing namespace succeeded")
+	}
+}
@@ -0,0 +1,937 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	contivClient "github.com/contiv/contivmodel/client"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+const (
+	// prefix of the epgs and policies created for network policies
+	k8sPolicyPrefix = "k8s-"
+
+	// priorities of the synthesized rules, allow rules win over the default deny
+	k8sDenyPriority  = 1
+	k8sAllowPriority = 2
+
+	policyResyncInterval = 5 * time.Minute
+)
+
+// policyKinds are the kinds of objects network policies are built from
+var policyKinds = []string{"Namespace", "Pod", "NetworkPolicy"}
+
+// policyObjClient is the part of the contiv model client used to keep
+// the contiv objects in sync with network policies
+type policyObjClient interface {
+	EndpointGroupPost(obj *contivClient.EndpointGroup) error
+	EndpointGroupDelete(tenantName string, groupName string) error
+	PolicyPost(obj *contivClient.Policy) error
+	PolicyDelete(tenantName string, policyName string) error
+	RulePost(obj *contivClient.Rule) error
+	RuleDelete(tenantName string, policyName string, ruleID string) error
+}
+
+// contivPolicySpec holds the contiv objects synthesized from a network policy
+type contivPolicySpec struct {
+	tenant   string
+	network  string
+	group    string        // name of both the epg and the policy
+	selector LabelSelector // pods moved into the epg
+	rules    map[string]*contivClient.Rule
+}
+
+// podMove moves the endpoint of a pod in a network to an epg, or out of
+// the epgs of network policies when the group is empty
+type podMove struct {
+	key     string // pod key and network id
+	tenant  string
+	network string
+	podIP   string
+	group   string
+}
+
+// policyController maps kubernetes network policies onto contiv epgs,
+// policies and rules. Pods selected by a network policy are moved into its
+// epg, and traffic from the allowed peers is allowed by their pod address.
+// It runs on the netmaster leader, and like the service watch it lists the
+// objects, watches for changes from the resource version of the list, and
+// periodically lists them again to correct anything missed.
+type policyController struct {
+	sync.Mutex
+	client     *APIClient
+	objClient  policyObjClient
+	movePods   func(moves []podMove) error
+	policies   map[string]*NetworkPolicy // keyed by namespace/name
+	namespaces map[string]*Namespace
+	pods       map[string]*Pod // keyed by namespace/name
+	applied    map[string]*contivPolicySpec
+	podGroups  map[string]podMove // last move of a pod in a network
+	npWatch    resWatch
+	nsWatch    resWatch
+	podWatch   resWatch
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	resync     time.Duration
+	stop       chan bool
+}
+
+// newPolicyController creates a policy controller
+func newPolicyController(client *APIClient, objClient policyObjClient,
+	movePods func(moves []podMove) error) *policyController {
+	return &policyController{
+		client:     client,
+		objClient:  objClient,
+		movePods:   movePods,
+		policies:   make(map[string]*NetworkPolicy),
+		namespaces: make(map[string]*Namespace),
+		pods:       make(map[string]*Pod),
+		applied:    make(map[string]*contivPolicySpec),
+		podGroups:  make(map[string]podMove),
+		npWatch:    resWatch{name: "networkPolicyWatch"},
+		nsWatch:    resWatch{name: "namespaceWatch"},
+		podWatch:   resWatch{name: "podWatch"},
+		minBackoff: svcWatchMinBackoff,
+		maxBackoff: svcWatchMaxBackoff,
+		resync:     policyResyncInterval,
+		stop:       make(chan bool),
+	}
+}
+
+// selectorMatches checks if the labels match the selector
+func selectorMatches(sel *LabelSelector, labels map[string]string) bool {
+	if sel == nil {
+		return false
+	}
+
+	for key, val := range sel.MatchLabels {
+		if labels[key] != val {
+			return false
+		}
+	}
+
+	for _, req := range sel.MatchExpressions {
+		val, exists := labels[req.Key]
+		inValues := false
+		for _, reqVal := range req.Values {
+			if exists && val == reqVal {
+				inValues = true
+			}
+		}
+
+		switch req.Operator {
+		case LabelSelectorOpIn:
+			if !inValues {
+				return false
+			}
+		case LabelSelectorOpNotIn:
+			if inValues {
+				return false
+			}
+		case LabelSelectorOpExists:
+			if !exists {
+				return false
+			}
+		case LabelSelectorOpDoesNotExist:
+			if exists {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateSelector checks the requirements of a selector
+func validateSelector(sel *LabelSelector) error {
+	if sel == nil {
+		return nil
+	}
+
+	for _, req := range sel.MatchExpressions {
+		switch req.Operator {
+		case LabelSelectorOpIn, LabelSelectorOpNotIn:
+			if len(req.Values) == 0 {
+				return fmt.Errorf("operator %s of key %q needs values", req.Operator, req.Key)
+			}
+		case LabelSelectorOpExists, LabelSelectorOpDoesNotExist:
+			if len(req.Values) != 0 {
+				return fmt.Errorf("operator %s of key %q takes no values", req.Operator, req.Key)
+			}
+		default:
+			return fmt.Errorf("unknown operator %q of key %q", req.Operator, req.Key)
+		}
+	}
+
+	return nil
+}
+
+// validatePolicy checks the selectors of a network policy. A policy that
+// is not understood is not applied, rather than applied to other pods.
+func validatePolicy(np *NetworkPolicy) error {
+	if err := validateSelector(&np.Spec.PodSelector); err != nil {
+		return err
+	}
+
+	for _, ingress := range np.Spec.Ingress {
+		for _, peer := range ingress.From {
+			if peer.PodSelector == nil && peer.NamespaceSelector == nil {
+				return fmt.Errorf("peer without a pod or namespace selector")
+			}
+			if err := validateSelector(peer.PodSelector); err != nil {
+				return err
+			}
+			if err := validateSelector(peer.NamespaceSelector); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// objKey returns the key of a namespaced object
+func objKey(meta *ObjectMeta) string {
+	ns := meta.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+
+	return ns + "/" + meta.Name
+}
+
+// policyGroupName returns the name of the epg and policy of a network policy
+func policyGroupName(ns, name string) string {
+	return k8sPolicyPrefix + ns + "-" + name
+}
+
+// policyTenantNetwork returns the contiv tenant and network of a network policy,
+// which can be set with the same labels as on pods
+func policyTenantNetwork(np *NetworkPolicy) (string, string) {
+	tenant := np.Labels["io.contiv.tenant"]
+	if tenant == "" {
+		tenant = "default"
+	}
+	network := np.Labels["io.contiv.network"]
+	if network == "" {
+		network = "default-net"
+	}
+
+	return tenant, network
+}
+
+// peerAddresses returns the addresses of the pods selected by a peer
+func (pc *policyController) peerAddresses(ns string, peer *NetworkPolicyPeer) []string {
+	addrs := []string{}
+	for _, pod := range pc.pods {
+		if pod.Status.PodIP == "" {
+			continue
+		}
+
+		podNs := pod.Namespace
+		if podNs == "" {
+			podNs = "default"
+		}
+
+		if peer.PodSelector != nil {
+			if podNs == ns && selectorMatches(peer.PodSelector, pod.Labels) {
+				addrs = append(addrs, pod.Status.PodIP)
+			}
+		} else if peer.NamespaceSelector != nil {
+			podNamespace, ok := pc.namespaces[podNs]
+			if ok && selectorMatches(peer.NamespaceSelector, podNamespace.Labels) {
+				addrs = append(addrs, pod.Status.PodIP)
+			}
+		}
+	}
+
+	return addrs
+}
+
+// namedPortNumbers resolves a named port on the pods selected by a network
+// policy. A name with different numbers on the pods allows each number on
+// all of them.
+func (pc *policyController) namedPortNumbers(ns string, np *NetworkPolicy, name string,
+	protocol Protocol) []int {
+	numbers := make(map[int]bool)
+	for _, pod := range pc.pods {
+		if podNamespace(pod) != ns || !selectorMatches(&np.Spec.PodSelector, pod.Labels) {
+			continue
+		}
+
+		for _, container := range pod.Spec.Containers {
+			for _, port := range container.Ports {
+				portProtocol := port.Protocol
+				if portProtocol == "" {
+					portProtocol = ProtocolTCP
+				}
+				if port.Name == name && portProtocol == protocol {
+					numbers[port.ContainerPort] = true
+				}
+			}
+		}
+	}
+
+	sorted := []int{}
+	for number := range numbers {
+		sorted = append(sorted, number)
+	}
+	sort.Ints(sorted)
+
+	return sorted
+}
+
+// buildPolicy synthesizes the contiv objects of a network policy
+func (pc *policyController) buildPolicy(np *NetworkPolicy) *contivPolicySpec {
+	ns := np.Namespace
+	if ns == "" {
+		ns = "default"
+	}
+
+	spec := &contivPolicySpec{group: policyGroupName(ns, np.Name), selector: np.Spec.PodSelector}
+	spec.tenant, spec.network = policyTenantNetwork(np)
+	spec.rules = make(map[string]*contivClient.Rule)
+
+	newRule := func(action string, priority int) *contivClient.Rule {
+		rule := &contivClient.Rule{
+			TenantName: spec.tenant,
+			PolicyName: spec.group,
+			RuleID:     fmt.Sprintf("%d", len(spec.rules)+1),
+			Direction:  "in",
+			Action:     action,
+			Priority:   priority,
+		}
+		spec.rules[rule.RuleID] = rule
+		return rule
+	}
+
+	// selected pods are isolated, except for the allowed traffic
+	newRule("deny", k8sDenyPriority)
+
+	for _, ingress := range np.Spec.Ingress {
+		// empty from allows all sources
+		sources := []string{""}
+		if len(ingress.From) > 0 {
+			addrMap := make(map[string]bool)
+			for idx := range ingress.From {
+				for _, addr := range pc.peerAddresses(ns, &ingress.From[idx]) {
+					addrMap[addr] = true
+				}
+			}
+
+			sources = []string{}
+			for addr := range addrMap {
+				sources = append(sources, addr)
+			}
+			sort.Strings(sources)
+		}
+
+		// empty ports allows all ports, named ports allow their numbers on
+		// the selected pods, none when no pod has the name
+		ports := []NetworkPolicyPort{}
+		for _, port := range ingress.Ports {
+			if port.Port == nil || !port.Port.IsString {
+				ports = append(ports, port)
+				continue
+			}
+
+			protocol := ProtocolTCP
+			if port.Protocol != nil {
+				protocol = *port.Protocol
+			}
+			for _, number := range pc.namedPortNumbers(ns, np, port.Port.StrVal, protocol) {
+				ports = append(ports, NetworkPolicyPort{
+					Protocol: port.Protocol,
+					Port:     &IntOrString{IntVal: number},
+				})
+			}
+		}
+		if len(ingress.Ports) == 0 {
+			ports = []NetworkPolicyPort{{}}
+		}
+
+		for _, src := range sources {
+			for _, port := range ports {
+				rule := newRule("allow", k8sAllowPriority)
+				rule.FromIpAddress = src
+				if port.Port != nil {
+					rule.Port = port.Port.IntVal
+					rule.Protocol = "tcp"
+				}
+				if port.Protocol != nil {
+					rule.Protocol = strings.ToLower(string(*port.Protocol))
+				}
+			}
+		}
+	}
+
+	return spec
+}
+
+// applyPolicy creates or updates the contiv objects of a network policy
+func applyPolicy(objClient policyObjClient, spec, oldSpec *contivPolicySpec) error {
+	err := objClient.PolicyPost(&contivClient.Policy{
+		TenantName: spec.tenant,
+		PolicyName: spec.group,
+	})
+	if err != nil {
+		return err
+	}
+
+	// remove the rules that changed or are gone
+	if oldSpec != nil {
+		for ruleID, oldRule := range oldSpec.rules {
+			if rule, ok := spec.rules[ruleID]; !ok || !reflect.DeepEqual(rule, oldRule) {
+				if err := objClient.RuleDelete(oldSpec.tenant, oldSpec.group, ruleID); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	ruleIDs := []string{}
+	for ruleID := range spec.rules {
+		ruleIDs = append(ruleIDs, ruleID)
+	}
+	sort.Strings(ruleIDs)
+
+	for _, ruleID := range ruleIDs {
+		rule := spec.rules[ruleID]
+		if oldSpec != nil && reflect.DeepEqual(oldSpec.rules[ruleID], rule) {
+			continue
+		}
+		if err := objClient.RulePost(rule); err != nil {
+			return err
+		}
+	}
+
+	return objClient.EndpointGroupPost(&contivClient.EndpointGroup{
+		TenantName:  spec.tenant,
+		GroupName:   spec.group,
+		NetworkName: spec.network,
+		Policies:    []string{spec.group},
+	})
+}
+
+// removePolicy deletes the contiv objects of a network policy
+func removePolicy(objClient policyObjClient, spec *contivPolicySpec) error {
+	// detach the policy first, the epg can not be removed while it has pods
+	err := objClient.EndpointGroupPost(&contivClient.EndpointGroup{
+		TenantName:  spec.tenant,
+		GroupName:   spec.group,
+		NetworkName: spec.network,
+	})
+	if err != nil {
+		return err
+	}
+
+	for ruleID := range spec.rules {
+		if err := objClient.RuleDelete(spec.tenant, spec.group, ruleID); err != nil {
+			return err
+		}
+	}
+
+	if err := objClient.PolicyDelete(spec.tenant, spec.group); err != nil {
+		return err
+	}
+
+	if err := objClient.EndpointGroupDelete(spec.tenant, spec.group); err != nil {
+		log.Warnf("Could not remove epg %s, it still has pods. Err: %v", spec.group, err)
+	}
+
+	return nil
+}
+
+// podNamespace returns the namespace of a pod
+func podNamespace(pod *Pod) string {
+	if pod.Namespace == "" {
+		return "default"
+	}
+
+	return pod.Namespace
+}
+
+// podGroupMoves returns the moves that bring the pods into the epgs of the
+// applied network policies that select them, the first by name, and the
+// pods no longer selected out of them. Pods that set their own epg are
+// left alone.
+func (pc *policyController) podGroupMoves() []podMove {
+	keys := []string{}
+	for key := range pc.applied {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	want := make(map[string]podMove)
+	for podKey, pod := range pc.pods {
+		if pod.Status.PodIP == "" || pod.Labels[groupKey] != "" || pod.Annotations[groupKey] != "" {
+			continue
+		}
+
+		for _, key := range keys {
+			if _, ok := pc.policies[key]; !ok || !strings.HasPrefix(key, podNamespace(pod)+"/") {
+				continue
+			}
+
+			spec := pc.applied[key]
+			move, ok := want[podKey+"|"+spec.network+"."+spec.tenant]
+			if !ok {
+				move = podMove{key: podKey + "|" + spec.network + "." + spec.tenant,
+					tenant: spec.tenant, network: spec.network, podIP: pod.Status.PodIP}
+			}
+			if move.group == "" && selectorMatches(&spec.selector, pod.Labels) {
+				move.group = spec.group
+			}
+			want[move.key] = move
+		}
+	}
+
+	// pods in the epg of a network policy that is gone move out of it
+	for key, last := range pc.podGroups {
+		if _, ok := want[key]; ok {
+			continue
+		}
+
+		pod, ok := pc.pods[strings.Split(key, "|")[0]]
+		if !ok || pod.Status.PodIP == "" || last.group == "" {
+			delete(pc.podGroups, key)
+			continue
+		}
+		want[key] = podMove{key: key, tenant: last.tenant, network: last.network,
+			podIP: pod.Status.PodIP}
+	}
+
+	moves := []podMove{}
+	for key, move := range want {
+		if last, ok := pc.podGroups[key]; !ok || last != move {
+			moves = append(moves, move)
+		}
+	}
+	sort.Sort(podMoves(moves))
+
+	return moves
+}
+
+// podMoves sorts moves by key
+type podMoves []podMove
+
+func (m podMoves) Len() int           { return len(m) }
+func (m podMoves) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m podMoves) Less(i, j int) bool { return m[i].key < m[j].key }
+
+// syncPodGroups moves the pods between the epgs of the network policies
+func (pc *policyController) syncPodGroups() {
+	moves := pc.podGroupMoves()
+	if len(moves) == 0 {
+		return
+	}
+
+	if err := pc.movePods(moves); err != nil {
+		// retried on the next sync
+		log.Errorf("Error moving pods to the epgs of network policies. Err: %v", err)
+		return
+	}
+
+	for _, move := range moves {
+		pc.podGroups[move.key] = move
+	}
+}
+
+// moveEndpoints moves the endpoints of pods between epgs. Pods in another
+// network than the move, or in an epg that is not one of a network policy,
+// are skipped.
+func moveEndpoints(moves []podMove) error {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+
+	readEp := &mastercfg.CfgEndpointState{}
+	readEp.StateDriver = stateDriver
+	epCfgs, err := readEp.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	eps := make(map[string]*mastercfg.CfgEndpointState)
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		eps[ep.NetID+"|"+ep.IPAddress] = ep
+	}
+
+	for _, move := range moves {
+		ep, ok := eps[move.network+"."+move.tenant+"|"+move.podIP]
+		if !ok || ep.ServiceName == move.group ||
+			(ep.ServiceName != "" && !strings.HasPrefix(ep.ServiceName, k8sPolicyPrefix)) {
+			continue
+		}
+
+		groupID, err := mastercfg.GetEndpointGroupID(stateDriver, move.group, move.tenant)
+		if err != nil {
+			return err
+		}
+
+		log.Infof("Moving endpoint %s to epg %q", ep.ID, move.group)
+		ep.StateDriver = stateDriver
+		ep.ServiceName = move.group
+		ep.EndpointGroupID = groupID
+		ep.EndpointGroupKey = mastercfg.GetEndpointGroupKey(move.group, move.tenant)
+		if err := ep.Write(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sync brings the contiv objects in line with the network policies
+func (pc *policyController) sync() {
+	desired := make(map[string]*contivPolicySpec)
+	for key, np := range pc.policies {
+		if err := validatePolicy(np); err != nil {
+			// the objects of an earlier version of the policy are kept
+			log.Errorf("Not applying network policy %s. Err: %v", key, err)
+			if spec, ok := pc.applied[key]; ok {
+				desired[key] = spec
+			}
+			continue
+		}
+		desired[key] = pc.buildPolicy(np)
+	}
+
+	objClient := pc.objClient
+	for key, spec := range desired {
+		oldSpec := pc.applied[key]
+		if reflect.DeepEqual(spec, oldSpec) {
+			continue
+		}
+
+		// moving to another tenant or network needs new objects
+		if oldSpec != nil && (oldSpec.tenant != spec.tenant || oldSpec.network != spec.network) {
+			if err := removePolicy(objClient, oldSpec); err != nil {
+				log.Errorf("Error removing network policy %s. Err: %v", key, err)
+				continue
+			}
+			delete(pc.applied, key)
+			oldSpec = nil
+		}
+
+		log.Infof("Syncing network policy %s to contiv policy %s", key, spec.group)
+		if err := applyPolicy(objClient, spec, oldSpec); err != nil {
+			log.Errorf("Error syncing network policy %s. Err: %v", key, err)
+			delete(pc.applied, key)
+			continue
+		}
+		pc.applied[key] = spec
+	}
+
+	// the pods leave the epgs of removed policies before they are deleted
+	pc.syncPodGroups()
+
+	for key, spec := range pc.applied {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+
+		log.Infof("Removing contiv policy %s of network policy %s", spec.group, key)
+		if err := removePolicy(objClient, spec); err != nil {
+			log.Errorf("Error removing network policy %s. Err: %v", key, err)
+			continue
+		}
+		delete(pc.applied, key)
+	}
+}
+
+// decodeObject decodes an object of a kind, returning its cache key and
+// resource version
+func decodeObject(kind string, content json.RawMessage) (string, interface{}, string, error) {
+	var meta *ObjectMeta
+	var obj interface{}
+	switch kind {
+	case "NetworkPolicy":
+		np := &NetworkPolicy{}
+		meta, obj = &np.ObjectMeta, np
+	case "Namespace":
+		ns := &Namespace{}
+		meta, obj = &ns.ObjectMeta, ns
+	case "Pod":
+		pod := &Pod{}
+		meta, obj = &pod.ObjectMeta, pod
+	default:
+		return "", nil, "", fmt.Errorf("unknown kind %s", kind)
+	}
+
+	if err := json.Unmarshal(content, obj); err != nil {
+		return "", nil, "", err
+	}
+
+	key := objKey(meta)
+	if kind == "Namespace" {
+		key = meta.Name
+	}
+
+	return key, obj, meta.ResourceVersion, nil
+}
+
+// setObject caches or, when obj is nil, removes an object
+func (pc *policyController) setObject(kind, key string, obj interface{}) {
+	switch kind {
+	case "NetworkPolicy":
+		if obj == nil {
+			delete(pc.policies, key)
+		} else {
+			pc.policies[key] = obj.(*NetworkPolicy)
+		}
+	case "Namespace":
+		if obj == nil {
+			delete(pc.namespaces, key)
+		} else {
+			pc.namespaces[key] = obj.(*Namespace)
+		}
+	case "Pod":
+		if obj == nil {
+			delete(pc.pods, key)
+		} else {
+			pc.pods[key] = obj.(*Pod)
+		}
+	}
+}
+
+// cachedKeys returns the keys of the cached objects of a kind
+func (pc *policyController) cachedKeys(kind string) []string {
+	keys := []string{}
+	switch kind {
+	case "NetworkPolicy":
+		for key := range pc.policies {
+			keys = append(keys, key)
+		}
+	case "Namespace":
+		for key := range pc.namespaces {
+			keys = append(keys, key)
+		}
+	case "Pod":
+		for key := range pc.pods {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// kindWatch returns the watch of a kind
+func (pc *policyController) kindWatch(kind string) *resWatch {
+	switch kind {
+	case "NetworkPolicy":
+		return &pc.npWatch
+	case "Namespace":
+		return &pc.nsWatch
+	default:
+		return &pc.podWatch
+	}
+}
+
+// listKind lists the objects of a kind
+func (pc *policyController) listKind(kind string) ([]json.RawMessage, string, error) {
+	switch kind {
+	case "NetworkPolicy":
+		return pc.client.ListNetworkPolicies()
+	case "Namespace":
+		return pc.client.ListNamespaces()
+	default:
+		return pc.client.ListPods()
+	}
+}
+
+// watchKind watches the objects of a kind from a resource version
+func (pc *policyController) watchKind(kind, version string, events chan ObjWatchResp) {
+	switch kind {
+	case "NetworkPolicy":
+		pc.client.WatchNetworkPolicies(version, events)
+	case "Namespace":
+		pc.client.WatchNamespaces(version, events)
+	default:
+		pc.client.WatchPods(version, events)
+	}
+}
+
+// syncKind lists the objects of a kind, replaces the cached ones, which
+// drops the objects deleted while not watching, and syncs. It returns the
+// resource version of the list.
+func (pc *policyController) syncKind(kind string) (string, error) {
+	items, version, err := pc.listKind(kind)
+	if err != nil {
+		return "", err
+	}
+
+	pc.Lock()
+	defer pc.Unlock()
+
+	listed := make(map[string]bool)
+	for _, item := range items {
+		key, obj, _, err := decodeObject(kind, item)
+		if err != nil {
+			log.Errorf("Error decoding %s. Err: %v", kind, err)
+			continue
+		}
+		listed[key] = true
+		pc.setObject(kind, key, obj)
+	}
+
+	for _, key := range pc.cachedKeys(kind) {
+		if !listed[key] {
+			log.Infof("Resync : removing stale %s %s", kind, key)
+			pc.setObject(kind, key, nil)
+		}
+	}
+
+	pc.sync()
+
+	return version, nil
+}
+
+// processEvent updates the cached objects from a watch event and syncs
+func (pc *policyController) processEvent(event ObjWatchResp) {
+	pc.Lock()
+	defer pc.Unlock()
+
+	key, obj, version, err := decodeObject(event.kind, event.object)
+	if err != nil {
+		log.Errorf("Error decoding %s. Err: %v", event.kind, err)
+		return
+	}
+
+	if event.opcode == "DELETED" {
+		obj = nil
+	}
+	pc.setObject(event.kind, key, obj)
+	pc.kindWatch(event.kind).setVersion(version)
+
+	pc.sync()
+}
+
+// startWatch starts the watch of a kind, after listing the objects when
+// there is no resource version to resume from
+func (pc *policyController) startWatch(kind string, events chan ObjWatchResp) {
+	rw := pc.kindWatch(kind)
+	if rw.version == "" {
+		version, err := pc.syncKind(kind)
+		if err != nil {
+			log.Errorf("%s : list failed. Err: %v", rw.name, err)
+			rw.retryLater(pc.minBackoff, pc.maxBackoff)
+			return
+		}
+		rw.version = version
+	}
+
+	pc.watchKind(kind, rw.version, events)
+}
+
+// handleEvent processes a watch event
+func (pc *policyController) handleEvent(event ObjWatchResp, events chan ObjWatchResp) {
+	rw := pc.kindWatch(event.kind)
+	switch event.opcode {
+	case "WARN":
+		log.Debugf("%s : %s", rw.name, event.errStr)
+	case "GONE":
+		// the resource version is too old, list again
+		log.Infof("%s : %s, resyncing", rw.name, event.errStr)
+		rw.version = ""
+		pc.startWatch(event.kind, events)
+	case "FATAL", "ERROR":
+		log.Warnf("%s : %s", rw.name, event.errStr)
+		rw.retryLater(pc.minBackoff, pc.maxBackoff)
+	default:
+		rw.backoff = 0
+		pc.processEvent(event)
+	}
+}
+
+// resyncAll lists all objects and corrects the contiv objects
+func (pc *policyController) resyncAll() {
+	for _, kind := range policyKinds {
+		if _, err := pc.syncKind(kind); err != nil {
+			log.Errorf("%s resync failed. Err: %v", kind, err)
+		}
+	}
+}
+
+// run starts the watches and processes their events until stopped
+func (pc *policyController) run() {
+	events := make(chan ObjWatchResp, 1)
+
+	go func() {
+		for _, kind := range policyKinds {
+			pc.startWatch(kind, events)
+		}
+
+		resync := time.NewTicker(pc.resync)
+		defer resync.Stop()
+
+		for {
+			select {
+			case event := <-events:
+				pc.handleEvent(event, events)
+			case <-pc.nsWatch.retry:
+				pc.nsWatch.retry = nil
+				pc.startWatch("Namespace", events)
+			case <-pc.podWatch.retry:
+				pc.podWatch.retry = nil
+				pc.startWatch("Pod", events)
+			case <-pc.npWatch.retry:
+				pc.npWatch.retry = nil
+				pc.startWatch("NetworkPolicy", events)
+			case <-resync.C:
+				pc.resyncAll()
+			case <-pc.stop:
+				pc.client.Close()
+				return
+			}
+		}
+	}()
+}
+
+// InitKubPolicyWatch starts the controller that maps network policies to
+// contiv epgs and policies through the contiv api at masterURL. It runs on
+// the netmaster leader, until the stop channel is closed.
+func InitKubPolicyWatch(masterURL string, stop chan bool) error {
+	watchClient := setUpAPIClient()
+	if watchClient == nil {
+		return fmt.Errorf("could not init kubernetes API client")
+	}
+
+	objClient, err := contivClient.NewContivClient(masterURL)
+	if err != nil {
+		return err
+	}
+
+	pc := newPolicyController(watchClient, objClient, moveEndpoints)
+	pc.stop = stop
+	pc.run()
+
+	return nil
+}
@@ -0,0 +1,442 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	contivClient "github.com/contiv/contivmodel/client"
+)
+
+// fakeObjClient records the contiv objects created by the policy controller
+type fakeObjClient struct {
+	sync.Mutex
+	epgs     map[string]*contivClient.EndpointGroup
+	policies map[string]*contivClient.Policy
+	rules    map[string]*contivClient.Rule
+}
+
+func newFakeObjClient() *fakeObjClient {
+	return &fakeObjClient{
+		epgs:     make(map[string]*contivClient.EndpointGroup),
+		policies: make(map[string]*contivClient.Policy),
+		rules:    make(map[string]*contivClient.Rule),
+	}
+}
+
+func (f *fakeObjClient) EndpointGroupPost(obj *contivClient.EndpointGroup) error {
+	f.Lock()
+	defer f.Unlock()
+	f.epgs[obj.TenantName+":"+obj.GroupName] = obj
+	return nil
+}
+
+func (f *fakeObjClient) EndpointGroupDelete(tenantName string, groupName string) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.epgs, tenantName+":"+groupName)
+	return nil
+}
+
+func (f *fakeObjClient) PolicyPost(obj *contivClient.Policy) error {
+	f.Lock()
+	defer f.Unlock()
+	f.policies[obj.TenantName+":"+obj.PolicyName] = obj
+	return nil
+}
+
+func (f *fakeObjClient) PolicyDelete(tenantName string, policyName string) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.policies, tenantName+":"+policyName)
+	return nil
+}
+
+func (f *fakeObjClient) RulePost(obj *contivClient.Rule) error {
+	f.Lock()
+	defer f.Unlock()
+	f.rules[obj.TenantName+":"+obj.PolicyName+":"+obj.RuleID] = obj
+	return nil
+}
+
+func (f *fakeObjClient) RuleDelete(tenantName string, policyName string, ruleID string) error {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.rules, tenantName+":"+policyName+":"+ruleID)
+	return nil
+}
+
+func (f *fakeObjClient) numRules() int {
+	f.Lock()
+	defer f.Unlock()
+	return len(f.rules)
+}
+
+// fakePolicyServer is an api server whose lists are scripted and whose
+// watches stay open
+type fakePolicyServer struct {
+	sync.Mutex
+	lists      map[string][]interface{}
+	version    string
+	watchCalls map[string][]string
+	done       chan bool
+}
+
+func (s *fakePolicyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	if items, ok := s.lists[r.URL.Path]; ok {
+		list := struct {
+			ListMeta `json:"metadata"`
+			Items    []interface{} `json:"items"`
+		}{ListMeta{ResourceVersion: s.version}, items}
+		s.Unlock()
+		json.NewEncoder(w).Encode(&list)
+		return
+	}
+
+	if !strings.Contains(r.URL.Path, "/watch/") {
+		s.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	s.watchCalls[r.URL.Path] = append(s.watchCalls[r.URL.Path], r.URL.Query().Get("resourceVersion"))
+	s.Unlock()
+
+	w.(http.Flusher).Flush()
+	<-s.done
+}
+
+func (s *fakePolicyServer) setList(path string, items []interface{}, version string) {
+	s.Lock()
+	defer s.Unlock()
+	s.lists[path] = items
+	s.version = version
+}
+
+func (s *fakePolicyServer) getWatchCalls(path string) []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.watchCalls[path]...)
+}
+
+// fakePodMover records the moves of pods between epgs
+type fakePodMover struct {
+	sync.Mutex
+	groups map[string]string // keyed by pod ip
+	moves  int
+}
+
+func (m *fakePodMover) movePods(moves []podMove) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, move := range moves {
+		m.groups[move.podIP] = move.group
+		m.moves++
+	}
+	return nil
+}
+
+func (m *fakePodMover) group(podIP string) (string, bool) {
+	m.Lock()
+	defer m.Unlock()
+	group, ok := m.groups[podIP]
+	return group, ok
+}
+
+const (
+	npListURL   = "/apis/extensions/v1beta1/networkpolicies"
+	nsListURL   = "/api/v1/namespaces"
+	podListURL  = "/api/v1/pods"
+	podWatchURL = "/api/v1/watch/pods"
+)
+
+// TestPolicyController tests network policies against a fake api server
+func TestPolicyController(t *testing.T) {
+	tcp := ProtocolTCP
+	dbPolicy := &NetworkPolicy{
+		ObjectMeta: ObjectMeta{Name: "db-policy", Namespace: "default"},
+		Spec: NetworkPolicySpec{
+			PodSelector: LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Ingress: []NetworkPolicyIngressRule{{
+				Ports: []NetworkPolicyPort{{Protocol: &tcp, Port: &IntOrString{IntVal: 5432}}},
+				From: []NetworkPolicyPeer{
+					{PodSelector: &LabelSelector{MatchLabels: map[string]string{"app": "web"}}},
+					{NamespaceSelector: &LabelSelector{MatchLabels: map[string]string{"env": "prod"}}},
+				},
+			}},
+		},
+	}
+
+	newPod := func(ns, name, app, ip string) *Pod {
+		return &Pod{
+			ObjectMeta: ObjectMeta{Name: name, Namespace: ns, Labels: map[string]string{"app": app}},
+			Status:     PodStatus{Phase: "Running", PodIP: ip},
+		}
+	}
+
+	fake := &fakePolicyServer{
+		lists: map[string][]interface{}{
+			npListURL: {dbPolicy},
+			nsListURL: {
+				&Namespace{ObjectMeta: ObjectMeta{Name: "default"}},
+				&Namespace{ObjectMeta: ObjectMeta{Name: "prod", Labels: map[string]string{"env": "prod"}}},
+			},
+			podListURL: {
+				newPod("default", "web", "web", "10.1.1.2"),
+				newPod("default", "db", "db", "10.1.1.3"),
+				newPod("prod", "client", "client", "10.1.1.4"),
+				newPod("default", "other", "other", "10.1.1.5"),
+			},
+		},
+		version:    "10",
+		watchCalls: make(map[string][]string),
+		done:       make(chan bool),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer close(fake.done)
+
+	watchClient := &APIClient{
+		apiBase:      server.URL + "/api/v1/",
+		watchBase:    server.URL + "/api/v1/watch/",
+		extAPIBase:   server.URL + "/apis/extensions/v1beta1/",
+		extWatchBase: server.URL + "/apis/extensions/v1beta1/watch/",
+		client:       &http.Client{},
+		cancel:       make(chan struct{}),
+	}
+
+	objClient := newFakeObjClient()
+	mover := &fakePodMover{groups: make(map[string]string)}
+	pc := newPolicyController(watchClient, objClient, mover.movePods)
+	pc.resync = time.Hour
+	pc.run()
+	defer close(pc.stop)
+
+	// default deny and an allow rule for each peer
+	if !waitFor(func() bool { return objClient.numRules() == 3 }) {
+		t.Fatalf("rules were not created: %+v", objClient.rules)
+	}
+
+	// the watches resume from the version of the lists
+	if !waitFor(func() bool { return reflect.DeepEqual(fake.getWatchCalls(podWatchURL), []string{"10"}) }) {
+		t.Errorf("got pod watches %v", fake.getWatchCalls(podWatchURL))
+	}
+
+	pc.Lock()
+	objClient.Lock()
+	group := "k8s-default-db-policy"
+	if epg, ok := objClient.epgs["default:"+group]; !ok || epg.NetworkName != "default-net" ||
+		len(epg.Policies) != 1 || epg.Policies[0] != group {
+		t.Errorf("epg %s was not created correctly: %+v", group, epg)
+	}
+	if _, ok := objClient.policies["default:"+group]; !ok {
+		t.Errorf("policy %s was not created", group)
+	}
+	if rule := objClient.rules["default:"+group+":1"]; rule == nil || rule.Action != "deny" ||
+		rule.Direction != "in" {
+		t.Errorf("default deny rule is incorrect: %+v", rule)
+	}
+	for ruleID, ipAddr := range map[string]string{"2": "10.1.1.2", "3": "10.1.1.4"} {
+		rule := objClient.rules["default:"+group+":"+ruleID]
+		if rule == nil || rule.Action != "allow" || rule.FromIpAddress != ipAddr ||
+			rule.Protocol != "tcp" || rule.Port != 5432 || rule.Priority <= k8sDenyPriority {
+			t.Errorf("allow rule %s is incorrect: %+v", ruleID, rule)
+		}
+	}
+	if len(objClient.rules) != 3 {
+		t.Errorf("unexpected rules %+v", objClient.rules)
+	}
+	objClient.Unlock()
+	pc.Unlock()
+
+	// the selected pod joins the epg, the others of the namespace stay out
+	for podIP, expGroup := range map[string]string{"10.1.1.2": "", "10.1.1.3": group, "10.1.1.5": ""} {
+		if g, ok := mover.group(podIP); !ok || g != expGroup {
+			t.Errorf("pod %s moved to %q, expected %q", podIP, g, expGroup)
+		}
+	}
+	if _, ok := mover.group("10.1.1.4"); ok {
+		t.Errorf("pod of another namespace was moved")
+	}
+
+	// a pod whose labels change joins the epg
+	content, _ := json.Marshal(newPod("default", "web", "db", "10.1.1.2"))
+	pc.processEvent(ObjWatchResp{kind: "Pod", opcode: "MODIFIED", object: content})
+	if g, _ := mover.group("10.1.1.2"); g != group {
+		t.Errorf("relabeled pod moved to %q, expected %q", g, group)
+	}
+
+	// peer going away removes its rule
+	content, _ = json.Marshal(newPod("prod", "client", "client", "10.1.1.4"))
+	pc.processEvent(ObjWatchResp{kind: "Pod", opcode: "DELETED", object: content})
+	if objClient.numRules() != 1 {
+		t.Errorf("rules of the changed peers were not removed: %+v", objClient.rules)
+	}
+
+	// a policy deleted while not watching is removed on resync, its pods
+	// leave the epg first
+	fake.setList(npListURL, []interface{}{}, "20")
+	pc.resyncAll()
+	objClient.Lock()
+	if len(objClient.rules) != 0 || len(objClient.policies) != 0 || len(objClient.epgs) != 0 {
+		t.Errorf("contiv objects were not removed. epgs: %+v, policies: %+v, rules: %+v",
+			objClient.epgs, objClient.policies, objClient.rules)
+	}
+	objClient.Unlock()
+	for _, podIP := range []string{"10.1.1.2", "10.1.1.3"} {
+		if g, _ := mover.group(podIP); g != "" {
+			t.Errorf("pod %s was not moved out of epg %q", podIP, g)
+		}
+	}
+}
+
+// TestPolicyWatchRetry tests the retries of the policy watches
+func TestPolicyWatchRetry(t *testing.T) {
+	pc := newPolicyController(nil, newFakeObjClient(), nil)
+	pc.minBackoff = time.Second
+	pc.maxBackoff = 5 * time.Second
+
+	// a failed watch is retried later, with backoff
+	pc.handleEvent(ObjWatchResp{kind: "Pod", opcode: "FATAL", errStr: "refused"}, nil)
+	pc.handleEvent(ObjWatchResp{kind: "Pod", opcode: "ERROR", errStr: "closed"}, nil)
+	if pc.podWatch.retry == nil || pc.podWatch.backoff != 2*time.Second {
+		t.Errorf("got retry %v backoff %v", pc.podWatch.retry, pc.podWatch.backoff)
+	}
+
+	// an event resets the backoff and is resumed from
+	content, _ := json.Marshal(&Namespace{ObjectMeta: ObjectMeta{Name: "prod", ResourceVersion: "12"}})
+	pc.handleEvent(ObjWatchResp{kind: "Namespace", opcode: "ADDED", object: content}, nil)
+	if pc.nsWatch.version != "12" || pc.namespaces["prod"] == nil {
+		t.Errorf("namespace event was not processed, version %q", pc.nsWatch.version)
+	}
+}
+
+// TestSelectorMatches tests the label selectors
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"app": "db", "tier": "backend"}
+	testCases := []struct {
+		sel   LabelSelector
+		match bool
+	}{
+		{LabelSelector{}, true},
+		{LabelSelector{MatchLabels: map[string]string{"app": "db"}}, true},
+		{LabelSelector{MatchLabels: map[string]string{"app": "web"}}, false},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "app", Operator: LabelSelectorOpIn, Values: []string{"web", "db"}}}}, true},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "app", Operator: LabelSelectorOpIn, Values: []string{"web"}}}}, false},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "app", Operator: LabelSelectorOpNotIn, Values: []string{"web"}}}}, true},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "env", Operator: LabelSelectorOpNotIn, Values: []string{"prod"}}}}, true},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "tier", Operator: LabelSelectorOpExists}}}, true},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "env", Operator: LabelSelectorOpExists}}}, false},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "env", Operator: LabelSelectorOpDoesNotExist}}}, true},
+		// labels and expressions are ANDed
+		{LabelSelector{MatchLabels: map[string]string{"app": "db"}, MatchExpressions: []LabelSelectorRequirement{
+			{Key: "tier", Operator: LabelSelectorOpDoesNotExist}}}, false},
+		{LabelSelector{MatchExpressions: []LabelSelectorRequirement{
+			{Key: "app", Operator: "Matches", Values: []string{"db"}}}}, false},
+	}
+	for idx, tc := range testCases {
+		if selectorMatches(&tc.sel, labels) != tc.match {
+			t.Errorf("case %d: selector %+v matched %v, expected %v", idx, tc.sel, !tc.match, tc.match)
+		}
+	}
+
+	// a policy with an expression-only selector selects some pods only
+	content := []byte(`{"metadata": {"name": "db-policy"}, "spec": {"podSelector":
+		{"matchExpressions": [{"key": "app", "operator": "In", "values": ["db"]}]}}}`)
+	np := &NetworkPolicy{}
+	if err := json.Unmarshal(content, np); err != nil {
+		t.Fatalf("error decoding network policy. Err: %v", err)
+	}
+	if err := validatePolicy(np); err != nil || selectorMatches(&np.Spec.PodSelector, map[string]string{"app": "web"}) {
+		t.Errorf("expression selector %+v is not applied. Err: %v", np.Spec.PodSelector, err)
+	}
+
+	// policies that are not understood are rejected
+	for _, sel := range []LabelSelector{
+		{MatchExpressions: []LabelSelectorRequirement{{Key: "app", Operator: "Matches"}}},
+		{MatchExpressions: []LabelSelectorRequirement{{Key: "app", Operator: LabelSelectorOpIn}}},
+		{MatchExpressions: []LabelSelectorRequirement{{Key: "app", Operator: LabelSelectorOpExists, Values: []string{"db"}}}},
+	} {
+		np := &NetworkPolicy{Spec: NetworkPolicySpec{PodSelector: sel}}
+		if validatePolicy(np) == nil {
+			t.Errorf("invalid selector %+v accepted", sel)
+		}
+	}
+	np = &NetworkPolicy{Spec: NetworkPolicySpec{Ingress: []NetworkPolicyIngressRule{{From: []NetworkPolicyPeer{{}}}}}}
+	if validatePolicy(np) == nil {
+		t.Errorf("peer without selectors accepted")
+	}
+}
+
+// TestPolicyNamedPorts tests the named ports of network policies
+func TestPolicyNamedPorts(t *testing.T) {
+	udp := ProtocolUDP
+	pc := newPolicyController(nil, newFakeObjClient(), nil)
+	newPod := func(name, app string, ports ...ContainerPort) *Pod {
+		return &Pod{
+			ObjectMeta: ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+			Spec:       PodSpec{Containers: []Container{{Name: "main", Ports: ports}}},
+			Status:     PodStatus{Phase: "Running", PodIP: "10.1.1.2"},
+		}
+	}
+	pc.pods["default/db1"] = newPod("db1", "db", ContainerPort{Name: "sql", ContainerPort: 5432},
+		ContainerPort{Name: "stats", ContainerPort: 9000, Protocol: ProtocolUDP})
+	pc.pods["default/db2"] = newPod("db2", "db", ContainerPort{Name: "sql", ContainerPort: 5433})
+	pc.pods["default/web"] = newPod("web", "web", ContainerPort{Name: "sql", ContainerPort: 8000})
+
+	np := &NetworkPolicy{
+		ObjectMeta: ObjectMeta{Name: "db-policy", Namespace: "default"},
+		Spec: NetworkPolicySpec{
+			PodSelector: LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			Ingress: []NetworkPolicyIngressRule{{
+				Ports: []NetworkPolicyPort{
+					{Port: &IntOrString{IsString: true, StrVal: "sql"}},
+					{Protocol: &udp, Port: &IntOrString{IsString: true, StrVal: "stats"}},
+					{Port: &IntOrString{IsString: true, StrVal: "missing"}},
+				},
+			}},
+		},
+	}
+
+	// the names resolve to their numbers on the selected pods only, a name
+	// no pod has allows nothing
+	spec := pc.buildPolicy(np)
+	expRules := map[string]string{"2": "tcp:5432", "3": "tcp:5433", "4": "udp:9000"}
+	if len(spec.rules) != len(expRules)+1 {
+		t.Fatalf("unexpected rules %+v", spec.rules)
+	}
+	for ruleID, exp := range expRules {
+		rule := spec.rules[ruleID]
+		if rule == nil || rule.Action != "allow" || rule.Protocol+":"+strconv.Itoa(rule.Port) != exp {
+			t.Errorf("rule %s is %+v, expected %s", ruleID, rule, exp)
+		}
+	}
+}
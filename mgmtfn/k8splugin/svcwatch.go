@@ -0,0 +1,302 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"reflect"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	svcWatchMinBackoff = time.Second
+	svcWatchMaxBackoff = 30 * time.Second
+	svcResyncInterval  = 5 * time.Minute
+)
+
+// svcDriver is the part of the network driver that programs services
+type svcDriver interface {
+	AddSvcSpec(svcName string, spec *core.ServiceSpec) error
+	DelSvcSpec(svcName string, spec *core.ServiceSpec) error
+	SvcProviderUpdate(svcName string, providers []core.ProviderSpec)
+}
+
+// resWatch tracks the watch of one kind of resource
+type resWatch struct {
+	name    string
+	version string
+	backoff time.Duration
+	retry   <-chan time.Time
+}
+
+// svcWatcher keeps the services and providers programmed in the network
+// driver in sync with the api server. It lists the objects, then watches
+// for changes from the resource version of the list, and periodically
+// lists them again to correct anything missed.
+type svcWatcher struct {
+	client     *APIClient
+	drv        svcDriver
+	services   map[string]*core.ServiceSpec
+	providers  map[string][]core.ProviderSpec
+	svcWatch   resWatch
+	epWatch    resWatch
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	resync     time.Duration
+}
+
+// newSvcWatcher creates a service watcher
+func newSvcWatcher(client *APIClient, drv svcDriver) *svcWatcher {
+	return &svcWatcher{
+		client:     client,
+		drv:        drv,
+		services:   make(map[string]*core.ServiceSpec),
+		providers:  make(map[string][]core.ProviderSpec),
+		svcWatch:   resWatch{name: "svcWatch"},
+		epWatch:    resWatch{name: "epWatch"},
+		minBackoff: svcWatchMinBackoff,
+		maxBackoff: svcWatchMaxBackoff,
+		resync:     svcResyncInterval,
+	}
+}
+
+// retryLater schedules a new watch after the backoff, which doubles on
+// consecutive failures
+func (rw *resWatch) retryLater(minBackoff, maxBackoff time.Duration) {
+	switch {
+	case rw.backoff == 0:
+		rw.backoff = minBackoff
+	case rw.backoff < maxBackoff:
+		rw.backoff *= 2
+		if rw.backoff > maxBackoff {
+			rw.backoff = maxBackoff
+		}
+	}
+
+	log.Infof("%s : retrying in %v", rw.name, rw.backoff)
+	rw.retry = time.After(rw.backoff)
+}
+
+// setVersion records the resource version of the last event seen
+func (rw *resWatch) setVersion(version string) {
+	if version != "" {
+		rw.version = version
+	}
+}
+
+// addService programs a service
+func (w *svcWatcher) addService(svcName string, spec *core.ServiceSpec) {
+	if err := w.drv.AddSvcSpec(svcName, spec); err != nil {
+		log.Errorf("Error adding service %s. Err: %v", svcName, err)
+		delete(w.services, svcName)
+		return
+	}
+
+	w.services[svcName] = spec
+}
+
+// delService removes a service
+func (w *svcWatcher) delService(svcName string, spec *core.ServiceSpec) {
+	if err := w.drv.DelSvcSpec(svcName, spec); err != nil {
+		log.Errorf("Error deleting service %s. Err: %v", svcName, err)
+	}
+
+	delete(w.services, svcName)
+}
+
+// updateProviders programs the providers of a service
+func (w *svcWatcher) updateProviders(svcName string, providers []core.ProviderSpec) {
+	w.drv.SvcProviderUpdate(svcName, providers)
+	if len(providers) == 0 {
+		delete(w.providers, svcName)
+	} else {
+		w.providers[svcName] = providers
+	}
+}
+
+// syncServices lists the services and programs the differences to what
+// is programmed. It returns the resource version of the list.
+func (w *svcWatcher) syncServices() (string, error) {
+	svcs, version, err := w.client.ListServices()
+	if err != nil {
+		return "", err
+	}
+
+	for svcName, spec := range w.services {
+		if _, ok := svcs[svcName]; !ok {
+			log.Infof("Resync : removing stale service %s", svcName)
+			w.delService(svcName, spec)
+		}
+	}
+
+	for svcName, spec := range svcs {
+		if old, ok := w.services[svcName]; !ok || !reflect.DeepEqual(old, spec) {
+			log.Infof("Resync : adding service %s", svcName)
+			w.addService(svcName, spec)
+		}
+	}
+
+	return version, nil
+}
+
+// syncProviders lists the service endpoints and programs the differences
+// to what is programmed. It returns the resource version of the list.
+func (w *svcWatcher) syncProviders() (string, error) {
+	providers, version, err := w.client.ListSvcEps()
+	if err != nil {
+		return "", err
+	}
+
+	for svcName := range w.providers {
+		if _, ok := providers[svcName]; !ok {
+			log.Infof("Resync : removing stale providers of %s", svcName)
+			w.updateProviders(svcName, []core.ProviderSpec{})
+		}
+	}
+
+	for svcName, provs := range providers {
+		if old := w.providers[svcName]; len(old) != len(provs) ||
+			(len(provs) != 0 && !reflect.DeepEqual(old, provs)) {
+			log.Infof("Resync : updating providers of %s", svcName)
+			w.updateProviders(svcName, provs)
+		}
+	}
+
+	return version, nil
+}
+
+// watchServices starts a service watch, after listing the services when
+// there is no resource version to resume from
+func (w *svcWatcher) watchServices(svcCh chan SvcWatchResp) {
+	if w.svcWatch.version == "" {
+		version, err := w.syncServices()
+		if err != nil {
+			log.Errorf("%s : list failed. Err: %v", w.svcWatch.name, err)
+			w.svcWatch.retryLater(w.minBackoff, w.maxBackoff)
+			return
+		}
+		w.svcWatch.version = version
+	}
+
+	w.client.WatchServices(w.svcWatch.version, svcCh)
+}
+
+// watchProviders starts a service endpoints watch, after listing the
+// endpoints when there is no resource version to resume from
+func (w *svcWatcher) watchProviders(epCh chan EpWatchResp) {
+	if w.epWatch.version == "" {
+		version, err := w.syncProviders()
+		if err != nil {
+			log.Errorf("%s : list failed. Err: %v", w.epWatch.name, err)
+			w.epWatch.retryLater(w.minBackoff, w.maxBackoff)
+			return
+		}
+		w.epWatch.version = version
+	}
+
+	w.client.WatchSvcEps(w.epWatch.version, epCh)
+}
+
+// handleSvcEvent processes a service watch event
+func (w *svcWatcher) handleSvcEvent(svcEvent SvcWatchResp, svcCh chan SvcWatchResp) {
+	switch svcEvent.opcode {
+	case "WARN":
+		log.Debugf("svcWatch : %s", svcEvent.errStr)
+	case "GONE":
+		// the resource version is too old, list again
+		log.Infof("svcWatch : %s, resyncing", svcEvent.errStr)
+		w.svcWatch.version = ""
+		w.watchServices(svcCh)
+	case "FATAL", "ERROR":
+		log.Warnf("svcWatch : %s", svcEvent.errStr)
+		w.svcWatch.retryLater(w.minBackoff, w.maxBackoff)
+	case "DELETED":
+		w.svcWatch.backoff = 0
+		w.svcWatch.setVersion(svcEvent.resVersion)
+		w.delService(svcEvent.svcName, &svcEvent.svcSpec)
+	default:
+		w.svcWatch.backoff = 0
+		w.svcWatch.setVersion(svcEvent.resVersion)
+		w.addService(svcEvent.svcName, &svcEvent.svcSpec)
+	}
+}
+
+// handleEpEvent processes a service endpoints watch event
+func (w *svcWatcher) handleEpEvent(epEvent EpWatchResp, epCh chan EpWatchResp) {
+	switch epEvent.opcode {
+	case "WARN":
+		log.Debugf("epWatch : %s", epEvent.errStr)
+	case "GONE":
+		// the resource version is too old, list again
+		log.Infof("epWatch : %s, resyncing", epEvent.errStr)
+		w.epWatch.version = ""
+		w.watchProviders(epCh)
+	case "FATAL", "ERROR":
+		log.Warnf("epWatch : %s", epEvent.errStr)
+		w.epWatch.retryLater(w.minBackoff, w.maxBackoff)
+	case "DELETED":
+		w.epWatch.backoff = 0
+		w.epWatch.setVersion(epEvent.resVersion)
+		w.updateProviders(epEvent.svcName, []core.ProviderSpec{})
+	default:
+		w.epWatch.backoff = 0
+		w.epWatch.setVersion(epEvent.resVersion)
+		w.updateProviders(epEvent.svcName, epEvent.providers)
+	}
+}
+
+// resyncAll lists services and endpoints and corrects what is programmed
+func (w *svcWatcher) resyncAll() {
+	if _, err := w.syncServices(); err != nil {
+		log.Errorf("Service resync failed. Err: %v", err)
+	}
+	if _, err := w.syncProviders(); err != nil {
+		log.Errorf("Service endpoints resync failed. Err: %v", err)
+	}
+}
+
+// run starts the watches and processes their events
+func (w *svcWatcher) run() {
+	svcCh := make(chan SvcWatchResp, 1)
+	epCh := make(chan EpWatchResp, 1)
+
+	go func() {
+		w.watchServices(svcCh)
+		w.watchProviders(epCh)
+
+		resync := time.NewTicker(w.resync)
+		defer resync.Stop()
+
+		for {
+			select {
+			case svcEvent := <-svcCh:
+				w.handleSvcEvent(svcEvent, svcCh)
+			case epEvent := <-epCh:
+				w.handleEpEvent(epEvent, epCh)
+			case <-w.svcWatch.retry:
+				w.svcWatch.retry = nil
+				w.watchServices(svcCh)
+			case <-w.epWatch.retry:
+				w.epWatch.retry = nil
+				w.watchProviders(epCh)
+			case <-resync.C:
+				w.resyncAll()
+			}
+		}
+	}()
+}
@@ -0,0 +1,318 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package k8splugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// fakeSvcDriver records the services programmed by the service watcher
+type fakeSvcDriver struct {
+	sync.Mutex
+	services  map[string]*core.ServiceSpec
+	providers map[string][]core.ProviderSpec
+}
+
+func (d *fakeSvcDriver) AddSvcSpec(svcName string, spec *core.ServiceSpec) error {
+	d.Lock()
+	defer d.Unlock()
+	d.services[svcName] = spec
+	return nil
+}
+
+func (d *fakeSvcDriver) DelSvcSpec(svcName string, spec *core.ServiceSpec) error {
+	d.Lock()
+	defer d.Unlock()
+	delete(d.services, svcName)
+	return nil
+}
+
+func (d *fakeSvcDriver) SvcProviderUpdate(svcName string, providers []core.ProviderSpec) {
+	d.Lock()
+	defer d.Unlock()
+	d.providers[svcName] = providers
+}
+
+func (d *fakeSvcDriver) serviceNames() []string {
+	d.Lock()
+	defer d.Unlock()
+	names := []string{}
+	for _, name := range []string{"svc-a", "svc-b", "svc-c"} {
+		if _, ok := d.services[name]; ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// fakeSvcServer is an api server whose service lists and watches are scripted
+type fakeSvcServer struct {
+	sync.Mutex
+	svcLists     [][]string
+	svcVersions  []string
+	svcListCalls int
+	watchCalls   []string
+	done         chan bool
+}
+
+func testService(name, version string) Service {
+	return Service{
+		ObjectMeta: ObjectMeta{Name: name, ResourceVersion: version},
+		Spec: ServiceSpec{
+			ClusterIP: testClusterIP,
+			Ports:     []ServicePort{{Protocol: ProtocolTCP, Port: testSvcPort, TargetPort: IntOrString{IntVal: testTgtPort}}},
+		},
+	}
+}
+
+func (s *fakeSvcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case svcListURL:
+		s.Lock()
+		idx := s.svcListCalls
+		if idx >= len(s.svcLists) {
+			idx = len(s.svcLists) - 1
+		}
+		s.svcListCalls++
+		list := ServiceList{ListMeta: ListMeta{ResourceVersion: s.svcVersions[idx]}}
+		for _, name := range s.svcLists[idx] {
+			list.Items = append(list.Items, testService(name, s.svcVersions[idx]))
+		}
+		s.Unlock()
+		json.NewEncoder(w).Encode(&list)
+
+	case epListURL:
+		eps := Endpoints{
+			ObjectMeta: ObjectMeta{Name: "svc-a"},
+			Subsets:    []EndpointSubset{{Addresses: []EndpointAddress{{IP: testEPIPAddr1}}}},
+		}
+		json.NewEncoder(w).Encode(&EndpointsList{ListMeta: ListMeta{ResourceVersion: "5"},
+			Items: []Endpoints{eps}})
+
+	case svcWatchURL:
+		s.Lock()
+		s.watchCalls = append(s.watchCalls, r.URL.Query().Get("resourceVersion"))
+		call := len(s.watchCalls)
+		s.Unlock()
+
+		switch call {
+		case 1:
+			// one event, then the watch is closed
+			content, _ := json.Marshal(watchSvcStatus{Type: "ADDED", Object: testService("svc-c", "11")})
+			fmt.Fprintf(w, "%s\n", content)
+		case 2:
+			// history was compacted
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.(http.Flusher).Flush()
+			<-s.done
+		}
+
+	case epWatchURL:
+		w.(http.Flusher).Flush()
+		<-s.done
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeSvcServer) setSvcList(names []string, version string) {
+	s.Lock()
+	defer s.Unlock()
+	s.svcLists = [][]string{names}
+	s.svcVersions = []string{version}
+}
+
+func (s *fakeSvcServer) getWatchCalls() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.watchCalls...)
+}
+
+func waitFor(cond func() bool) bool {
+	for count := 0; count < 50; count++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+// TestSvcWatchResync tests list and watch resumption, 410 handling and resync
+func TestSvcWatchResync(t *testing.T) {
+	fake := &fakeSvcServer{
+		svcLists:    [][]string{{"svc-a", "svc-b"}, {"svc-a", "svc-c"}},
+		svcVersions: []string{"10", "20"},
+		done:        make(chan bool),
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	defer close(fake.done)
+
+	client := &APIClient{
+		apiBase:   server.URL + "/api/v1/",
+		watchBase: server.URL + "/api/v1/watch/",
+		client:    &http.Client{},
+	}
+	drv := &fakeSvcDriver{
+		services:  make(map[string]*core.ServiceSpec),
+		providers: make(map[string][]core.ProviderSpec),
+	}
+
+	w := newSvcWatcher(client, drv)
+	w.minBackoff = 10 * time.Millisecond
+	w.maxBackoff = 50 * time.Millisecond
+	w.resync = 300 * time.Millisecond
+	w.run()
+
+	// list from 10, resume from the version of the event, list again on 410
+	expWatches := []string{"10", "11", "20"}
+	if !waitFor(func() bool { return reflect.DeepEqual(fake.getWatchCalls(), expWatches) }) {
+		t.Fatalf("got watches %v, expected %v", fake.getWatchCalls(), expWatches)
+	}
+
+	expSvcs := []string{"svc-a", "svc-c"}
+	if !waitFor(func() bool { return reflect.DeepEqual(drv.serviceNames(), expSvcs) }) {
+		t.Errorf("got services %v after relist, expected %v", drv.serviceNames(), expSvcs)
+	}
+
+	drv.Lock()
+	if spec := drv.services["svc-a"]; spec == nil || spec.IPAddress != testClusterIP ||
+		len(spec.Ports) != 1 || spec.Ports[0].SvcPort != testSvcPort {
+		t.Errorf("svc-a was not programmed correctly: %+v", spec)
+	}
+	expProvs := []core.ProviderSpec{{IPAddress: testEPIPAddr1, Ports: map[string]uint16{}}}
+	if provs := drv.providers["svc-a"]; !reflect.DeepEqual(provs, expProvs) {
+		t.Errorf("got providers %v for svc-a", provs)
+	}
+	drv.Unlock()
+
+	// a service removed without a watch event goes away on resync
+	fake.setSvcList([]string{"svc-c"}, "30")
+	expSvcs = []string{"svc-c"}
+	if !waitFor(func() bool { return reflect.DeepEqual(drv.serviceNames(), expSvcs) }) {
+		t.Errorf("got services %v after resync, expected %v", drv.serviceNames(), expSvcs)
+	}
+}
+
+// TestSvcWatchBackoff tests the watch retry backoff
+func TestSvcWatchBackoff(t *testing.T) {
+	drv := &fakeSvcDriver{
+		services:  make(map[string]*core.ServiceSpec),
+		providers: make(map[string][]core.ProviderSpec),
+	}
+	w := newSvcWatcher(nil, drv)
+	w.minBackoff = time.Second
+	w.maxBackoff = 5 * time.Second
+
+	expBackoff := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second,
+		5 * time.Second, 5 * time.Second}
+	for _, exp := range expBackoff {
+		w.svcWatch.retryLater(w.minBackoff, w.maxBackoff)
+		if w.svcWatch.backoff != exp {
+			t.Errorf("got backoff %v, expected %v", w.svcWatch.backoff, exp)
+		}
+	}
+
+	// a warning keeps the backoff, an event resets it
+	w.handleSvcEvent(SvcWatchResp{opcode: "WARN"}, nil)
+	if w.svcWatch.backoff != 5*time.Second {
+		t.Errorf("backoff was reset by a warning")
+	}
+	w.handleSvcEvent(SvcWatchResp{opcode: "ADDED", svcName: "svc-a", resVersion: "12"}, nil)
+	if w.svcWatch.backoff != 0 || w.svcWatch.version != "12" {
+		t.Errorf("got backoff %v version %s after event", w.svcWatch.backoff, w.svcWatch.version)
+	}
+}
+
+// sample watch streams written for the test in the format of the api
+// server, for a service whose pods serve the named target port http on
+// different port numbers
+const (
+	sampleSvcWatch = `{"type":"ADDED","object":{"kind":"Service","apiVersion":"v1","metadata":{"name":"web","namespace":"default","selfLink":"/api/v1/namespaces/default/services/web","uid":"6b7d3c9e-5a0e-11e6-9b2c-080027a1d0f5","resourceVersion":"1742","creationTimestamp":"2016-08-03T18:22:41Z"},"spec":{"ports":[{"name":"http","protocol":"TCP","port":80,"targetPort":"http"},{"name":"metrics","protocol":"TCP","port":9090,"targetPort":9100}],"selector":{"app":"web"},"clusterIP":"10.254.20.31","type":"ClusterIP","sessionAffinity":"None"},"status":{"loadBalancer":{}}}}
+`
+	sampleEpWatch = `{"type":"ADDED","object":{"kind":"Endpoints","apiVersion":"v1","metadata":{"name":"web","namespace":"default","selfLink":"/api/v1/namespaces/default/endpoints/web","uid":"6b7f2a41-5a0e-11e6-9b2c-080027a1d0f5","resourceVersion":"1788","creationTimestamp":"2016-08-03T18:22:41Z"},"subsets":[{"addresses":[{"ip":"10.1.1.2","targetRef":{"kind":"Pod","namespace":"default","name":"web-v1-3hx0z","uid":"7c0e1f3a-5a0e-11e6-9b2c-080027a1d0f5","resourceVersion":"1781"}}],"ports":[{"name":"http","port":8080,"protocol":"TCP"},{"name":"metrics","port":9100,"protocol":"TCP"}]},{"addresses":[{"ip":"10.1.1.3","targetRef":{"kind":"Pod","namespace":"default","name":"web-v2-k2m9q","uid":"7c11a0d2-5a0e-11e6-9b2c-080027a1d0f5","resourceVersion":"1785"}}],"ports":[{"name":"http","port":8000,"protocol":"TCP"},{"name":"metrics","port":9100,"protocol":"TCP"}]}]}}
+`
+)
+
+// TestSvcWatchNamedPorts tests named target ports and per provider ports
+// with sample watch streams
+func TestSvcWatchNamedPorts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case svcWatchURL:
+			fmt.Fprint(w, sampleSvcWatch)
+		case epWatchURL:
+			fmt.Fprint(w, sampleEpWatch)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := &APIClient{watchBase: server.URL + "/api/v1/watch/", client: &http.Client{}}
+
+	svcCh := make(chan SvcWatchResp, 2)
+	client.WatchServices("", svcCh)
+	svcResp := <-svcCh
+	if svcResp.opcode != "ADDED" || svcResp.svcName != "web" {
+		t.Fatalf("unexpected service watch response %+v", svcResp)
+	}
+	expPorts := []core.PortSpec{
+		{Name: "http", Protocol: "TCP", SvcPort: 80},
+		{Name: "metrics", Protocol: "TCP", SvcPort: 9090, ProvPort: 9100},
+	}
+	if !reflect.DeepEqual(svcResp.svcSpec.Ports, expPorts) {
+		t.Errorf("got ports %+v, expected %+v", svcResp.svcSpec.Ports, expPorts)
+	}
+
+	epCh := make(chan EpWatchResp, 2)
+	client.WatchSvcEps("", epCh)
+	epResp := <-epCh
+	if epResp.opcode != "ADDED" || epResp.svcName != "web" {
+		t.Fatalf("unexpected endpoints watch response %+v", epResp)
+	}
+	expProvs := []core.ProviderSpec{
+		{IPAddress: "10.1.1.2", Ports: map[string]uint16{"http": 8080, "metrics": 9100}},
+		{IPAddress: "10.1.1.3", Ports: map[string]uint16{"http": 8000, "metrics": 9100}},
+	}
+	if !reflect.DeepEqual(epResp.providers, expProvs) {
+		t.Errorf("got providers %+v, expected %+v", epResp.providers, expProvs)
+	}
+
+	// an address in several subsets serves the ports of all of them
+	eps := &Endpoints{Subsets: []EndpointSubset{
+		{Addresses: []EndpointAddress{{IP: "10.1.1.2"}}, Ports: []EndpointPort{{Name: "http", Port: 8080}}},
+		{Addresses: []EndpointAddress{{IP: "10.1.1.2"}}, Ports: []EndpointPort{{Name: "metrics", Port: 9100}}},
+	}}
+	expProvs = []core.ProviderSpec{
+		{IPAddress: "10.1.1.2", Ports: map[string]uint16{"http": 8080, "metrics": 9100}},
+	}
+	if provs := providersFromEndpoints(eps); !reflect.DeepEqual(provs, expProvs) {
+		t.Errorf("got providers %+v, expected %+v", provs, expProvs)
+	}
+}
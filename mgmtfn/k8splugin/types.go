@@ -1,6 +1,9 @@
 package k8splugin
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // ListMeta describes metadata that synthetic resources must have, including lists and
 // various status objects.
@@ -310,7 +313,7 @@ type ServicePort struct {
 	// of Port is used (an identity map).
 	// Defaults to the service port.
 	// More info: http://releases.k8s.io/HEAD/docs/user-guide/services.md#defining-a-service
-	TargetPort int `json:"targetPort,omitempty"`
+	TargetPort IntOrString `json:"targetPort,omitempty"`
 
 	// The port on each node on which this service is exposed when type=NodePort or LoadBalancer.
 	// Usually assigned by the system. If specified, it will be allocated to the service
@@ -449,3 +452,204 @@ type NodeSpec struct {
 	// More info: http://releases.k8s.io/HEAD/docs/admin/node.md#manual-node-administration"`
 	Unschedulable bool `json:"unschedulable,omitempty"`
 }
+
+// IntOrString is a type that can hold an int or a string, e.g. a port
+// number or a port name.
+type IntOrString struct {
+	IsString bool
+	IntVal   int
+	StrVal   string
+}
+
+// UnmarshalJSON decodes either a number or a string
+func (v *IntOrString) UnmarshalJSON(value []byte) error {
+	if len(value) > 0 && value[0] == '"' {
+		v.IsString = true
+		return json.Unmarshal(value, &v.StrVal)
+	}
+
+	v.IsString = false
+	return json.Unmarshal(value, &v.IntVal)
+}
+
+// MarshalJSON encodes the value as a number or a string
+func (v IntOrString) MarshalJSON() ([]byte, error) {
+	if v.IsString {
+		return json.Marshal(v.StrVal)
+	}
+
+	return json.Marshal(v.IntVal)
+}
+
+// LabelSelector is a label query over a set of resources. The result of
+// matchLabels and matchExpressions are ANDed. An empty label selector
+// matches all objects.
+type LabelSelector struct {
+	// matchLabels is a map of {key,value} pairs. All pairs must match
+	// for an object to be selected.
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+
+	// matchExpressions is a list of label selector requirements. The
+	// requirements are ANDed.
+	MatchExpressions []LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+// LabelSelectorOperator is the set of operators of a selector requirement.
+type LabelSelectorOperator string
+
+const (
+	// LabelSelectorOpIn requires the label to have one of the values.
+	LabelSelectorOpIn LabelSelectorOperator = "In"
+	// LabelSelectorOpNotIn requires the label to be missing or to have
+	// none of the values.
+	LabelSelectorOpNotIn LabelSelectorOperator = "NotIn"
+	// LabelSelectorOpExists requires the label to be set.
+	LabelSelectorOpExists LabelSelectorOperator = "Exists"
+	// LabelSelectorOpDoesNotExist requires the label to be missing.
+	LabelSelectorOpDoesNotExist LabelSelectorOperator = "DoesNotExist"
+)
+
+// LabelSelectorRequirement is a selector that contains values, a key, and
+// an operator that relates the key and values.
+type LabelSelectorRequirement struct {
+	// key is the label key that the selector applies to.
+	Key string `json:"key"`
+
+	// operator represents a key's relationship to a set of values.
+	Operator LabelSelectorOperator `json:"operator"`
+
+	// values is an array of string values. It must be non-empty for the
+	// In and NotIn operators, and empty for Exists and DoesNotExist.
+	Values []string `json:"values,omitempty"`
+}
+
+// NetworkPolicyPort describes a port traffic is allowed on.
+type NetworkPolicyPort struct {
+	// The protocol (TCP or UDP) which traffic must match.
+	// If not specified, this field defaults to TCP.
+	Protocol *Protocol `json:"protocol,omitempty"`
+
+	// If specified, the port on the given protocol. This can either be a
+	// numerical or named port on a pod. If not specified, all ports are
+	// allowed.
+	Port *IntOrString `json:"port,omitempty"`
+}
+
+// NetworkPolicyPeer selects the pods traffic is allowed from. Exactly one
+// of the selectors must be specified.
+type NetworkPolicyPeer struct {
+	// Selects pods in the namespace of the network policy.
+	PodSelector *LabelSelector `json:"podSelector,omitempty"`
+
+	// Selects all pods in the matching namespaces.
+	NamespaceSelector *LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// NetworkPolicyIngressRule describes traffic allowed to the selected pods.
+// Traffic must match both ports and from.
+type NetworkPolicyIngressRule struct {
+	// List of ports traffic is allowed on. If empty, traffic on all ports
+	// is allowed.
+	Ports []NetworkPolicyPort `json:"ports,omitempty"`
+
+	// List of sources traffic is allowed from. If empty, traffic from all
+	// sources is allowed.
+	From []NetworkPolicyPeer `json:"from,omitempty"`
+}
+
+// NetworkPolicySpec selects the pods a network policy applies to, and the
+// traffic allowed to them.
+type NetworkPolicySpec struct {
+	// Selects the pods in the namespace of the policy that the policy
+	// applies to. An empty selector selects all pods in the namespace.
+	PodSelector LabelSelector `json:"podSelector"`
+
+	// List of ingress rules applied to the selected pods.
+	Ingress []NetworkPolicyIngressRule `json:"ingress,omitempty"`
+}
+
+// NetworkPolicy describes the traffic allowed to a set of pods.
+type NetworkPolicy struct {
+	TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	ObjectMeta `json:"metadata,omitempty"`
+
+	// Specification of the desired behavior for this network policy.
+	Spec NetworkPolicySpec `json:"spec,omitempty"`
+}
+
+// Namespace provides a scope for names.
+type Namespace struct {
+	TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	ObjectMeta `json:"metadata,omitempty"`
+}
+
+// PodStatus represents information about the status of a pod.
+type PodStatus struct {
+	// Current condition of the pod, e.g. Pending, Running.
+	Phase string `json:"phase,omitempty"`
+
+	// IP address allocated to the pod. Empty if not yet allocated.
+	PodIP string `json:"podIP,omitempty"`
+}
+
+// ContainerPort represents a network port in a single container.
+type ContainerPort struct {
+	// If specified, this must be an IANA_SVC_NAME and unique within the
+	// pod. Each named port in a pod must have a unique name.
+	Name string `json:"name,omitempty"`
+
+	// Number of port to expose on the pod's IP address.
+	ContainerPort int `json:"containerPort"`
+
+	// Protocol for port. Must be UDP or TCP. Defaults to "TCP".
+	Protocol Protocol `json:"protocol,omitempty"`
+}
+
+// Container is a single application container of a pod.
+type Container struct {
+	// Name of the container.
+	Name string `json:"name"`
+
+	// List of ports to expose from the container.
+	Ports []ContainerPort `json:"ports,omitempty"`
+}
+
+// PodSpec is a description of a pod.
+type PodSpec struct {
+	// List of containers belonging to the pod.
+	Containers []Container `json:"containers"`
+}
+
+// Pod is a collection of containers that can run on a host.
+type Pod struct {
+	TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	ObjectMeta `json:"metadata,omitempty"`
+
+	// Specification of the desired behavior of the pod.
+	Spec PodSpec `json:"spec,omitempty"`
+
+	// Most recently observed status of the pod.
+	Status PodStatus `json:"status,omitempty"`
+}
+
+// Status is the result of an operation, e.g. of a watch that failed.
+type Status struct {
+	TypeMeta `json:",inline"`
+	// Standard list metadata.
+	ListMeta `json:"metadata,omitempty"`
+
+	// Status of the operation, either Success or Failure.
+	Status string `json:"status,omitempty"`
+
+	// A human-readable description of the status of this operation.
+	Message string `json:"message,omitempty"`
+
+	// A machine-readable description of why this operation failed.
+	Reason string `json:"reason,omitempty"`
+
+	// Suggested HTTP return code for this status, 0 if not set.
+	Code int `json:"code,omitempty"`
+}
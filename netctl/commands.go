@@ -98,6 +98,34 @@ var Commands = []cli.Command{
 			},
 		},
 	},
+	{
+		Name:  "trace",
+		Usage: "Compute the packet path between endpoints from the netmaster state",
+		Flags: []cli.Flag{
+			jsonFlag,
+			cli.StringFlag{
+				Name:  "from, f",
+				Usage: "source endpoint id, container id or ip address",
+			},
+			cli.StringFlag{
+				Name:  "to, t",
+				Usage: "destination endpoint id, container id, ip address or service ip",
+			},
+			cli.StringFlag{
+				Name:  "protocol, p",
+				Usage: "protocol (e.g., tcp, udp, icmp)",
+			},
+			cli.IntFlag{
+				Name:  "port",
+				Usage: "destination port",
+			},
+			cli.StringFlag{
+				Name:  "tenant",
+				Usage: "tenant of the source endpoint, needed when its ip address is used by several tenants",
+			},
+		},
+		Action: traceEndpoints,
+	},
 	{
 		Name:  "netprofile",
 		Usage: "Network profile manipulation tools",
@@ -201,9 +229,73 @@ var Commands = []cli.Command{
 						Name:  "gatewayv6, g6",
 						Usage: "IPv6 Gateway",
 					},
+					cli.StringSliceFlag{
+						Name:  "route, r",
+						Usage: "Static route of the containers. Usage: --route=10.2.0.0/16 --route=\"10.3.0.0/16 via 10.1.1.254\"",
+					},
+					cli.StringSliceFlag{
+						Name:  "dns-server",
+						Usage: "DNS server of the containers",
+					},
+					cli.StringSliceFlag{
+						Name:  "dns-search",
+						Usage: "DNS search domain of the containers",
+					},
+					cli.StringSliceFlag{
+						Name:  "dns-option",
+						Usage: "DNS resolver option of the containers",
+					},
 				},
 				Action: createNetwork,
 			},
+			{
+				Name:      "subnet-add",
+				Usage:     "Add a subnet to a network",
+				ArgsUsage: "[network] [subnet]",
+				Flags: []cli.Flag{
+					tenantFlag,
+					cli.StringFlag{
+						Name:  "gateway, g",
+						Usage: "Gateway",
+					},
+				},
+				Action: addNetworkSubnet,
+			},
+			{
+				Name:      "subnet-rm",
+				Usage:     "Remove a subnet from a network",
+				ArgsUsage: "[network] [subnet]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    deleteNetworkSubnet,
+			},
+			{
+				Name:      "reservation-add",
+				Usage:     "Reserve an address range or a sticky address in a network",
+				ArgsUsage: "[network] [name]",
+				Flags: []cli.Flag{
+					tenantFlag,
+					cli.StringFlag{
+						Name:  "range, r",
+						Usage: "Range of addresses excluded from allocation, e.g. 10.1.1.200-10.1.1.250",
+					},
+					cli.StringFlag{
+						Name:  "ip",
+						Usage: "Sticky address for the owner, picked automatically if not specified",
+					},
+					cli.StringFlag{
+						Name:  "owner, o",
+						Usage: "Container or pod name the sticky address is bound to",
+					},
+				},
+				Action: addNetworkReservation,
+			},
+			{
+				Name:      "reservation-rm",
+				Usage:     "Remove an address reservation from a network",
+				ArgsUsage: "[network] [name]",
+				Flags:     []cli.Flag{tenantFlag},
+				Action:    deleteNetworkReservation,
+			},
 		},
 	},
 	{
@@ -410,6 +502,10 @@ var Commands = []cli.Command{
 						Usage: "Allowed Vxlan VNID range",
 						Value: "1-10000",
 					},
+					cli.StringFlag{
+						Name:  "service-vip-pool",
+						Usage: "subnet of the VIPs of external services, advertised over bgp",
+					},
 				},
 				Action: setGlobal,
 			},
@@ -427,6 +523,13 @@ var Commands = []cli.Command{
 				Flags:     []cli.Flag{jsonFlag, quietFlag},
 				Action:    listBgp,
 			},
+			{
+				Name:      "inspect",
+				Usage:     "Inspect BGP neighbors and routes of a host",
+				ArgsUsage: "[hostname]",
+				Flags:     []cli.Flag{jsonFlag},
+				Action:    inspectBgp,
+			},
 			{
 				Name:      "rm",
 				Aliases:   []string{"delete"},
@@ -460,11 +563,55 @@ var Commands = []cli.Command{
 						Name:  "neighbor",
 						Usage: "BGP neighbor to be added",
 					},
+					cli.StringFlag{
+						Name:  "password",
+						Usage: "MD5 password of the BGP neighbors without their own. Updates without the passwords keep them",
+					},
+					cli.BoolFlag{
+						Name:  "clear-password",
+						Usage: "Remove the MD5 password of the BGP neighbors without their own",
+					},
+					cli.StringSliceFlag{
+						Name:  "neighbors",
+						Usage: "Additional BGP neighbor. Usage: --neighbors=20.1.1.253:500 --neighbors=20.1.1.252:600:password",
+					},
+					cli.IntFlag{
+						Name:  "hold-time",
+						Usage: "BGP hold time in seconds",
+					},
+					cli.IntFlag{
+						Name:  "keepalive",
+						Usage: "BGP keepalive interval in seconds",
+					},
+					cli.StringSliceFlag{
+						Name:  "import-prefix",
+						Usage: "Prefix of the routes accepted from the neighbors, all routes if none",
+					},
+					cli.StringSliceFlag{
+						Name:  "export-prefix",
+						Usage: "Prefix of the routes advertised to the neighbors, all routes if none",
+					},
+					cli.StringSliceFlag{
+						Name:  "export-tenant",
+						Usage: "Tenant whose endpoints are advertised to the neighbors, all tenants if none",
+					},
 				},
 				Action: addBgp,
 			},
 		},
 	},
+	{
+		Name:  "node",
+		Usage: "Host information",
+		Subcommands: []cli.Command{
+			{
+				Name:      "inspect",
+				Usage:     "Inspect the LLDP neighbors of the host uplinks",
+				ArgsUsage: "[hostname]",
+				Action:    inspectNode,
+			},
+		},
+	},
 	{
 		Name:  "app-profile",
 		Usage: "Application Profile manipulation tools",
@@ -561,7 +708,7 @@ var Commands = []cli.Command{
 					},
 					cli.StringSliceFlag{
 						Name:  "selector,l",
-						Usage: "service selector .Usage: --selector=key1=value1 --selector=key2=value2",
+						Usage: "service selector .Usage: --selector=key1=value1 --selector=key2!=value2 --selector=\"key3 in (value1,value2)\" --selector=\"key4 notin (value1)\" --selector=key5 --selector=!key6",
 					},
 					cli.StringSliceFlag{
 						Name:  "port,p",
@@ -571,6 +718,54 @@ var Commands = []cli.Command{
 						Name:  "preferred-ip,ip",
 						Usage: "preferred ip address",
 					},
+					cli.StringFlag{
+						Name:  "health-check",
+						Usage: "health check of the providers, tcp or http",
+					},
+					cli.IntFlag{
+						Name:  "health-check-port",
+						Usage: "provider port to health check, defaults to the first provider port",
+					},
+					cli.StringFlag{
+						Name:  "health-check-path",
+						Usage: "path of the http health check (default: /)",
+					},
+					cli.IntFlag{
+						Name:  "health-check-interval",
+						Usage: "seconds between health checks (default: 10)",
+					},
+					cli.IntFlag{
+						Name:  "health-check-timeout",
+						Usage: "health check timeout in seconds (default: 5)",
+					},
+					cli.IntFlag{
+						Name:  "healthy-threshold",
+						Usage: "passed checks for a provider to become healthy (default: 2)",
+					},
+					cli.IntFlag{
+						Name:  "unhealthy-threshold",
+						Usage: "failed checks for a provider to become unhealthy (default: 3)",
+					},
+					cli.StringFlag{
+						Name:  "lb-mode",
+						Usage: "load balancing mode, roundrobin or leastconn (default: leastconn)",
+					},
+					cli.StringFlag{
+						Name:  "session-affinity",
+						Usage: "session affinity, none or clientip (default: none)",
+					},
+					cli.IntFlag{
+						Name:  "affinity-timeout",
+						Usage: "seconds a client sticks to its provider after its last request (default: 10800)",
+					},
+					cli.StringSliceFlag{
+						Name:  "weight,w",
+						Usage: "provider weight as selector:weight, e.g. --weight=track=canary:1 --weight=track=stable:9",
+					},
+					cli.BoolFlag{
+						Name:  "external",
+						Usage: "allocate the service ip from the service VIP pool and advertise it over bgp",
+					},
 				},
 				Action: createServiceLB,
 			},
@@ -1,6 +1,7 @@
 package netctl
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -26,6 +27,22 @@ func versionURL(ctx *cli.Context) string {
 	return fmt.Sprintf("%s/version", baseURL(ctx))
 }
 
+func traceURL(ctx *cli.Context) string {
+	return fmt.Sprintf("%s/trace", baseURL(ctx))
+}
+
+func nodeURL(ctx *cli.Context, hostname string) string {
+	return fmt.Sprintf("%s/node/%s", baseURL(ctx), hostname)
+}
+
+func networkSubnetURL(ctx *cli.Context, action string) string {
+	return fmt.Sprintf("%s/network-subnet-%s", baseURL(ctx), action)
+}
+
+func networkReservationURL(ctx *cli.Context, action string) string {
+	return fmt.Sprintf("%s/network-reservation-%s", baseURL(ctx), action)
+}
+
 func writeBody(resp *http.Response, ctx *cli.Context) {
 	content, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -47,3 +64,20 @@ func getObject(ctx *cli.Context, url string, jdata interface{}) error {
 
 	return nil
 }
+
+func postObject(ctx *cli.Context, url string, jreq interface{}, jdata interface{}) error {
+	body, err := json.Marshal(jreq)
+	handleBasicError(ctx, err)
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	handleBasicError(ctx, err)
+
+	respCheck(resp, ctx)
+
+	content, err := ioutil.ReadAll(resp.Body)
+	handleBasicError(ctx, err)
+
+	handleBasicError(ctx, json.Unmarshal(content, jdata))
+
+	return nil
+}
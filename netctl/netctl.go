@@ -6,8 +6,10 @@ import (
 	"net"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -350,15 +352,19 @@ func createNetwork(ctx *cli.Context) {
 	nwType := ctx.String("nw-type")
 
 	errCheck(ctx, getClient(ctx).NetworkPost(&contivClient.Network{
-		TenantName:  tenant,
-		NetworkName: network,
-		Encap:       encap,
-		Subnet:      subnet,
-		Gateway:     gateway,
-		Ipv6Subnet:  subnetv6,
-		Ipv6Gateway: gatewayv6,
-		PktTag:      pktTag,
-		NwType:      nwType,
+		TenantName:   tenant,
+		NetworkName:  network,
+		Encap:        encap,
+		Subnet:       subnet,
+		Gateway:      gateway,
+		Ipv6Subnet:   subnetv6,
+		Ipv6Gateway:  gatewayv6,
+		PktTag:       pktTag,
+		NwType:       nwType,
+		StaticRoutes: ctx.StringSlice("route"),
+		DnsServers:   ctx.StringSlice("dns-server"),
+		DnsSearch:    ctx.StringSlice("dns-search"),
+		DnsOptions:   ctx.StringSlice("dns-option"),
 	}))
 }
 
@@ -374,6 +380,72 @@ func deleteNetwork(ctx *cli.Context) {
 
 }
 
+// networkSubnetUpdate adds or removes an additional subnet of a network
+func networkSubnetUpdate(ctx *cli.Context, url string) {
+	argCheck(2, ctx)
+
+	req := map[string]string{
+		"TenantName":  ctx.String("tenant"),
+		"NetworkName": ctx.Args()[0],
+		"SubnetCIDR":  ctx.Args()[1],
+		"Gateway":     ctx.String("gateway"),
+	}
+
+	resp := struct {
+		NetworkID   string
+		SubnetPools []string
+	}{}
+	postObject(ctx, url, req, &resp)
+
+	for _, pool := range resp.SubnetPools {
+		fmt.Println(pool)
+	}
+}
+
+func addNetworkSubnet(ctx *cli.Context) {
+	logrus.Infof("Adding subnet %s to network %s", ctx.Args().Get(1), ctx.Args().Get(0))
+	networkSubnetUpdate(ctx, networkSubnetURL(ctx, "add"))
+}
+
+func deleteNetworkSubnet(ctx *cli.Context) {
+	logrus.Infof("Removing subnet %s from network %s", ctx.Args().Get(1), ctx.Args().Get(0))
+	networkSubnetUpdate(ctx, networkSubnetURL(ctx, "del"))
+}
+
+// networkReservationUpdate adds or removes an ip reservation of a network
+func networkReservationUpdate(ctx *cli.Context, url string) {
+	argCheck(2, ctx)
+
+	req := map[string]string{
+		"TenantName":  ctx.String("tenant"),
+		"NetworkName": ctx.Args()[0],
+		"Name":        ctx.Args()[1],
+		"IPRange":     ctx.String("range"),
+		"IPAddress":   ctx.String("ip"),
+		"Owner":       ctx.String("owner"),
+	}
+
+	resp := struct {
+		NetworkID    string
+		Reservations []string
+	}{}
+	postObject(ctx, url, req, &resp)
+
+	for _, resv := range resp.Reservations {
+		fmt.Println(resv)
+	}
+}
+
+func addNetworkReservation(ctx *cli.Context) {
+	logrus.Infof("Adding reservation %s to network %s", ctx.Args().Get(1), ctx.Args().Get(0))
+	networkReservationUpdate(ctx, networkReservationURL(ctx, "add"))
+}
+
+func deleteNetworkReservation(ctx *cli.Context) {
+	logrus.Infof("Removing reservation %s from network %s", ctx.Args().Get(1), ctx.Args().Get(0))
+	networkReservationUpdate(ctx, networkReservationURL(ctx, "del"))
+}
+
 func inspectNetwork(ctx *cli.Context) {
 	argCheck(1, ctx)
 
@@ -507,6 +579,105 @@ func inspectEndpoint(ctx *cli.Context) {
 	os.Stdout.WriteString("\n")
 }
 
+// traceHop mirrors the endpoint details of a netmaster trace response
+type traceHop struct {
+	EndpointID string
+	Container  string
+	IPAddress  string
+	Network    string
+	Tenant     string
+	Host       string
+}
+
+// traceInfo mirrors the netmaster trace response
+type traceInfo struct {
+	Source        *traceHop
+	Destination   *traceHop
+	Path          string
+	Encap         string
+	PktTag        int
+	PolicyVerdict string
+	Hops          []string
+	TraceCommands []struct {
+		Host    string
+		Command string
+	}
+}
+
+func traceEndpoints(ctx *cli.Context) {
+	argCheck(0, ctx)
+
+	from := ctx.String("from")
+	to := ctx.String("to")
+	if from == "" || to == "" {
+		errExit(ctx, exitHelp, "Both --from and --to must be specified", true)
+	}
+
+	// endpoints can be specified either by id or by ip address
+	req := map[string]interface{}{
+		"Protocol": ctx.String("protocol"),
+		"DstPort":  ctx.Int("port"),
+		"Tenant":   ctx.String("tenant"),
+	}
+	if net.ParseIP(from) != nil {
+		req["SrcIP"] = from
+	} else {
+		req["SrcEndpoint"] = from
+	}
+	if net.ParseIP(to) != nil {
+		req["DstIP"] = to
+	} else {
+		req["DstEndpoint"] = to
+	}
+
+	if ctx.Bool("json") {
+		var trace interface{}
+		postObject(ctx, traceURL(ctx), req, &trace)
+		dumpJSONList(ctx, trace)
+		return
+	}
+
+	trace := traceInfo{}
+	postObject(ctx, traceURL(ctx), req, &trace)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte("Side\tContainer\tIP\tNetwork\tTenant\tHost\n"))
+	writer.Write([]byte("------\t---------\t--\t-------\t------\t----\n"))
+	for idx, ep := range []*traceHop{trace.Source, trace.Destination} {
+		side := "src"
+		if idx == 1 {
+			side = "dst"
+		}
+		if ep == nil {
+			continue
+		}
+		writer.Write(
+			[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\n",
+				side,
+				ep.Container,
+				ep.IPAddress,
+				ep.Network,
+				ep.Tenant,
+				ep.Host,
+			)))
+	}
+	writer.Flush()
+
+	fmt.Printf("\nPath: %s  Encap: %s  Tag: %d  Verdict: %s\n",
+		trace.Path, trace.Encap, trace.PktTag, trace.PolicyVerdict)
+	for idx, hop := range trace.Hops {
+		fmt.Printf("  %d. %s\n", idx+1, hop)
+	}
+
+	if len(trace.TraceCommands) > 0 {
+		fmt.Printf("\nTo verify, run on the hosts:\n")
+		for _, ht := range trace.TraceCommands {
+			fmt.Printf("  %s: %s\n", ht.Host, ht.Command)
+		}
+	}
+}
+
 func createEndpointGroup(ctx *cli.Context) {
 	argCheck(2, ctx)
 
@@ -598,6 +769,7 @@ func addBgp(ctx *cli.Context) {
 	asid := ctx.String("as")
 	neighboras := ctx.String("neighbor-as")
 	neighbor := ctx.String("neighbor")
+	neighbors := ctx.StringSlice("neighbors")
 
 	//Error checks
 	_, _, err := net.ParseCIDR(routerip)
@@ -605,21 +777,61 @@ func addBgp(ctx *cli.Context) {
 		errExit(ctx, exitHelp, "Wrong CIDR format. Enter in x.x.x.x/len format", true)
 	}
 
-	ip := net.ParseIP(neighbor)
-	if ip == nil {
+	if neighbor != "" && net.ParseIP(neighbor) == nil {
 		errExit(ctx, exitHelp, "Wrong IP format. Enter in x.x.x.x format", true)
 	}
 
-	if routerip == "" || asid == "" || neighbor == "" || neighboras == "" {
+	if routerip == "" || asid == "" || (neighbor == "" && len(neighbors) == 0) ||
+		(neighbor != "" && neighboras == "") {
 		errExit(ctx, exitHelp, "Missing attributes", true)
 	}
 
+	for _, prefix := range append(ctx.StringSlice("import-prefix"), ctx.StringSlice("export-prefix")...) {
+		if _, _, err := net.ParseCIDR(prefix); err != nil {
+			errExit(ctx, exitHelp, fmt.Sprintf("Wrong prefix %s. Enter in x.x.x.x/len format", prefix), true)
+		}
+	}
+
+	// the neighbors without a password of their own use --password, or
+	// remove their password with --clear-password
+	password := ctx.String("password")
+	clearPassword := ctx.Bool("clear-password")
+	if password != "" && clearPassword {
+		errExit(ctx, exitHelp, "Cannot set and clear the password", true)
+	}
+	bgpNeighbors := []contivClient.BgpNeighbor{}
+	if neighbor != "" {
+		bgpNeighbors = append(bgpNeighbors, contivClient.BgpNeighbor{
+			Address:       neighbor,
+			As:            neighboras,
+			Password:      password,
+			ClearPassword: clearPassword,
+		})
+	}
+	for _, neighborStr := range neighbors {
+		fields := strings.SplitN(neighborStr, ":", 3)
+		if len(fields) < 2 {
+			errExit(ctx, exitHelp, fmt.Sprintf("Wrong neighbor %s. Enter in ip:as[:password] format", neighborStr), true)
+		}
+		bgpNeighbor := contivClient.BgpNeighbor{Address: fields[0], As: fields[1], Password: password,
+			ClearPassword: clearPassword}
+		if len(fields) == 3 {
+			bgpNeighbor.Password = fields[2]
+			bgpNeighbor.ClearPassword = false
+		}
+		bgpNeighbors = append(bgpNeighbors, bgpNeighbor)
+	}
+
 	errCheck(ctx, getClient(ctx).BgpPost(&contivClient.Bgp{
-		As:         asid,
-		Hostname:   hostname,
-		Neighbor:   neighbor,
-		NeighborAs: neighboras,
-		Routerip:   routerip,
+		As:             asid,
+		ExportPrefixes: ctx.StringSlice("export-prefix"),
+		ExportTenants:  ctx.StringSlice("export-tenant"),
+		HoldTime:       ctx.Int("hold-time"),
+		Hostname:       hostname,
+		ImportPrefixes: ctx.StringSlice("import-prefix"),
+		Keepalive:      ctx.Int("keepalive"),
+		Neighbors:      bgpNeighbors,
+		Routerip:       routerip,
 	}))
 
 }
@@ -635,6 +847,77 @@ func deleteBgp(ctx *cli.Context) {
 	errCheck(ctx, getClient(ctx).BgpDelete(hostname))
 }
 
+func inspectBgp(ctx *cli.Context) {
+	argCheck(1, ctx)
+
+	hostname := ctx.Args()[0]
+
+	logrus.Infof("Inspecting bgp: %s", hostname)
+
+	bgp, err := getClient(ctx).BgpInspect(hostname)
+	errCheck(ctx, err)
+
+	if ctx.Bool("json") {
+		dumpJSONList(ctx, bgp)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte(fmt.Sprintf("HostName: %v\n", bgp.Config.Hostname)))
+	writer.Write([]byte(fmt.Sprintf("RouterIP: %v\n", bgp.Oper.RouterIP)))
+	writer.Write([]byte(fmt.Sprintf("AS: %v\n", bgp.Oper.As)))
+	writer.Write([]byte(fmt.Sprintf("LastUpdated: %v\n\n", bgp.Oper.LastUpdated)))
+
+	writer.Write([]byte("Neighbor\tAS\tState\tUptime\tReceived\tAccepted\tAdvertised\n"))
+	writer.Write([]byte("--------\t-------\t-----\t------\t--------\t--------\t----------\n"))
+	for _, neighbor := range bgp.Oper.Neighbors {
+		writer.Write(
+			[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				neighbor.Address,
+				neighbor.As,
+				bgpStateString(neighbor),
+				bgpUptimeString(neighbor),
+				neighbor.Received,
+				neighbor.Accepted,
+				neighbor.Advertised,
+			)))
+	}
+
+	writer.Write([]byte("\nPrefix\tNextHop\tASPath\tBest\n"))
+	writer.Write([]byte("------\t-------\t------\t----\n"))
+	for _, route := range bgp.Oper.Routes {
+		writer.Write(
+			[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\n",
+				route.Prefix,
+				route.NextHop,
+				route.AsPath,
+				route.Best,
+			)))
+	}
+	if bgp.Oper.RibSize > len(bgp.Oper.Routes) {
+		writer.Write([]byte(fmt.Sprintf("\nShowing %d host routes of the %d routes in the RIB\n",
+			len(bgp.Oper.Routes), bgp.Oper.RibSize)))
+	}
+}
+
+// bgpStateString returns the session state of a bgp neighbor
+func bgpStateString(neighbor contivClient.BgpNeighborOper) string {
+	state := strings.ToLower(strings.TrimPrefix(neighbor.State, "BGP_FSM_"))
+	if neighbor.AdminState == "ADMIN_STATE_DOWN" {
+		state += " (admin-down)"
+	}
+	return state
+}
+
+// bgpUptimeString returns how long the session of a bgp neighbor is up
+func bgpUptimeString(neighbor contivClient.BgpNeighborOper) string {
+	if neighbor.State != "BGP_FSM_ESTABLISHED" {
+		return "-"
+	}
+	return (time.Duration(neighbor.Uptime) * time.Second).String()
+}
+
 //listBgpNeighbors is netctl interface routine to list
 //Bgp neighbor configs for a given host
 func listBgp(ctx *cli.Context) {
@@ -659,21 +942,62 @@ func listBgp(ctx *cli.Context) {
 
 		writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
 		defer writer.Flush()
-		writer.Write([]byte("HostName\tRouterIP\tAS\tNeighbor\tNeighborAS\n"))
-		writer.Write([]byte("---------\t--------\t-------\t--------\t-------\n"))
+		writer.Write([]byte("HostName\tRouterIP\tAS\tNeighbors\tHoldTime\tKeepalive\tImportPrefixes\tExportPrefixes\tExportTenants\n"))
+		writer.Write([]byte("---------\t--------\t-------\t---------\t--------\t---------\t--------------\t--------------\t-------------\n"))
 		for _, group := range filtered {
 			writer.Write(
-				[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\n",
+				[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
 					group.Hostname,
 					group.Routerip,
 					group.As,
-					group.Neighbor,
-					group.NeighborAs,
+					bgpNeighborsString(group),
+					bgpTimerString(group.HoldTime),
+					bgpTimerString(group.Keepalive),
+					strings.Join(group.ImportPrefixes, ","),
+					strings.Join(group.ExportPrefixes, ","),
+					strings.Join(group.ExportTenants, ","),
 				)))
 		}
 	}
 }
 
+// bgpNeighborsString returns the neighbors of a bgp config as ip:as, the
+// passwords are not returned by netmaster
+func bgpNeighborsString(bgp *contivClient.Bgp) string {
+	neighbors := []string{}
+	if bgp.Neighbor != "" {
+		neighbors = append(neighbors, bgp.Neighbor+":"+bgp.NeighborAs)
+	}
+	for _, neighbor := range bgp.Neighbors {
+		neighbors = append(neighbors, neighbor.Address+":"+neighbor.As)
+	}
+	return strings.Join(neighbors, ",")
+}
+
+// bgpTimerString returns a bgp timer, 0 is the default
+func bgpTimerString(seconds int) string {
+	if seconds == 0 {
+		return "default"
+	}
+	return strconv.Itoa(seconds)
+}
+
+func inspectNode(ctx *cli.Context) {
+	argCheck(1, ctx)
+
+	hostname := ctx.Args()[0]
+
+	logrus.Infof("Inspecting node: %s", hostname)
+
+	nodes := []interface{}{}
+	getObject(ctx, nodeURL(ctx, hostname), &nodes)
+	if len(nodes) == 0 {
+		errExit(ctx, exitRequest, fmt.Sprintf("Node %s not found", hostname), false)
+	}
+
+	dumpJSONList(ctx, nodes[0])
+}
+
 func showGlobal(ctx *cli.Context) {
 	argCheck(0, ctx)
 
@@ -689,6 +1013,9 @@ func showGlobal(ctx *cli.Context) {
 			writer.Write([]byte(fmt.Sprintf("Fabric mode: %v\n", gl.NetworkInfraType)))
 			writer.Write([]byte(fmt.Sprintf("Vlan Range: %v\n", gl.Vlans)))
 			writer.Write([]byte(fmt.Sprintf("Vxlan range: %v\n", gl.Vxlans)))
+			if gl.ServiceVIPPool != "" {
+				writer.Write([]byte(fmt.Sprintf("Service VIP pool: %v\n", gl.ServiceVIPPool)))
+			}
 		}
 	}
 }
@@ -711,11 +1038,20 @@ func setGlobal(ctx *cli.Context) {
 	vlans := ctx.String("vlan-range")
 	vxlans := ctx.String("vxlan-range")
 
+	// the service VIP pool is kept unless it is set
+	vipPool := ctx.String("service-vip-pool")
+	if !ctx.IsSet("service-vip-pool") {
+		if gl, err := getClient(ctx).GlobalGet("global"); err == nil {
+			vipPool = gl.ServiceVIPPool
+		}
+	}
+
 	errCheck(ctx, getClient(ctx).GlobalPost(&contivClient.Global{
 		Name:             "global",
 		NetworkInfraType: fabMode,
 		Vlans:            vlans,
 		Vxlans:           vxlans,
+		ServiceVIPPool:   vipPool,
 	}))
 }
 
@@ -873,12 +1209,24 @@ func createServiceLB(ctx *cli.Context) {
 	ports := ctx.StringSlice("port")
 	ipAddress := ctx.String("preferred-ip")
 	errCheck(ctx, getClient(ctx).ServiceLBPost(&contivClient.ServiceLB{
-		ServiceName: serviceName,
-		TenantName:  tenantName,
-		NetworkName: serviceSubnet,
-		Selectors:   selectors,
-		Ports:       ports,
-		IpAddress:   ipAddress,
+		ServiceName:         serviceName,
+		TenantName:          tenantName,
+		NetworkName:         serviceSubnet,
+		Selectors:           selectors,
+		Ports:               ports,
+		IpAddress:           ipAddress,
+		HealthCheck:         ctx.String("health-check"),
+		HealthCheckPort:     ctx.Int("health-check-port"),
+		HealthCheckPath:     ctx.String("health-check-path"),
+		HealthCheckInterval: ctx.Int("health-check-interval"),
+		HealthCheckTimeout:  ctx.Int("health-check-timeout"),
+		HealthyThreshold:    ctx.Int("healthy-threshold"),
+		UnhealthyThreshold:  ctx.Int("unhealthy-threshold"),
+		LbMode:              ctx.String("lb-mode"),
+		SessionAffinity:     ctx.String("session-affinity"),
+		AffinityTimeout:     ctx.Int("affinity-timeout"),
+		Weights:             ctx.StringSlice("weight"),
+		External:            ctx.Bool("external"),
 	}))
 }
 
@@ -920,20 +1268,46 @@ func listServiceLB(ctx *cli.Context) {
 
 		writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
 		defer writer.Flush()
-		writer.Write([]byte("ServiceName\tTenant\tNetwork\tSelectors\n"))
-		writer.Write([]byte("---------\t--------\t-------\t-------\n"))
+		writer.Write([]byte("ServiceName\tTenant\tNetwork\tSelectors\tProviders\n"))
+		writer.Write([]byte("---------\t--------\t-------\t-------\t-------\n"))
 		for _, group := range filtered {
 			writer.Write(
-				[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t\n",
+				[]byte(fmt.Sprintf("%v\t%v\t%v\t%v\t%v\t\n",
 					group.ServiceName,
 					group.TenantName,
 					group.NetworkName,
 					group.Selectors,
+					getServiceProviders(ctx, group),
 				)))
 		}
 	}
 }
 
+// getServiceProviders returns the providers of a service and their health
+// as ip(health)
+func getServiceProviders(ctx *cli.Context, svc *contivClient.ServiceLB) string {
+	inspect, err := getClient(ctx).ServiceLBInspect(svc.TenantName, svc.ServiceName)
+	if err != nil {
+		return ""
+	}
+
+	providers := []string{}
+	for _, provider := range inspect.Oper.Providers {
+		// providers are listed as "ip container health"
+		fields := strings.Fields(provider)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[2] == "-" {
+			providers = append(providers, fields[0])
+		} else {
+			providers = append(providers, fmt.Sprintf("%s(%s)", fields[0], fields[2]))
+		}
+	}
+
+	return strings.Join(providers, ",")
+}
+
 func listExternalContracts(ctx *cli.Context) {
 	argCheck(0, ctx)
 
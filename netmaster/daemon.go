@@ -19,10 +19,12 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/mgmtfn/k8splugin"
 	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netmaster/objApi"
@@ -138,9 +140,17 @@ func (d *daemon) registerRoutes(router *mux.Router) {
 
 	s.HandleFunc("/plugin/allocAddress", makeHTTPHandler(master.AllocAddressHandler))
 	s.HandleFunc("/plugin/releaseAddress", makeHTTPHandler(master.ReleaseAddressHandler))
+	s.HandleFunc("/plugin/requestPool", makeHTTPHandler(master.RequestPoolHandler))
+	s.HandleFunc("/plugin/releasePool", makeHTTPHandler(master.ReleasePoolHandler))
 	s.HandleFunc("/plugin/createEndpoint", makeHTTPHandler(master.CreateEndpointHandler))
 	s.HandleFunc("/plugin/deleteEndpoint", makeHTTPHandler(master.DeleteEndpointHandler))
 	s.HandleFunc("/plugin/svcProviderUpdate", makeHTTPHandler(master.ServiceProviderUpdateHandler))
+	s.HandleFunc("/plugin/svcProviderHealth", makeHTTPHandler(master.ServiceProviderHealthHandler))
+	s.HandleFunc(fmt.Sprintf("/%s", master.TraceRESTEndpoint), makeHTTPHandler(master.TraceHandler))
+	s.HandleFunc(fmt.Sprintf("/%s", master.AddNetworkSubnetRESTEndpoint), makeHTTPHandler(master.AddNetworkSubnetHandler))
+	s.HandleFunc(fmt.Sprintf("/%s", master.DelNetworkSubnetRESTEndpoint), makeHTTPHandler(master.DeleteNetworkSubnetHandler))
+	s.HandleFunc(fmt.Sprintf("/%s", master.AddIPReservationRESTEndpoint), makeHTTPHandler(master.AddIPReservationHandler))
+	s.HandleFunc(fmt.Sprintf("/%s", master.DelIPReservationRESTEndpoint), makeHTTPHandler(master.DeleteIPReservationHandler))
 
 	s = router.Methods("Get").Subrouter()
 	s.HandleFunc(fmt.Sprintf("/%s/%s", master.GetEndpointRESTEndpoint, "{id}"),
@@ -156,6 +166,10 @@ func (d *daemon) registerRoutes(router *mux.Router) {
 		get(false, d.services))
 	s.HandleFunc(fmt.Sprintf("/%s", master.GetServicesRESTEndpoint),
 		get(true, d.services))
+	s.HandleFunc(fmt.Sprintf("/%s/%s", master.GetNodeRESTEndpoint, "{id}"),
+		get(false, d.nodes))
+	s.HandleFunc(fmt.Sprintf("/%s", master.GetNodesRESTEndpoint),
+		get(true, d.nodes))
 
 }
 
@@ -208,6 +222,50 @@ func (d *daemon) networks(id string) ([]core.State, error) {
 	return nil, core.Errorf("Unexpected code path")
 }
 
+// Returns state of hosts, a host is known once its lldp neighbors are
+// published
+func (d *daemon) nodes(id string) ([]core.State, error) {
+	var (
+		err  error
+		node *mastercfg.OperNodeState
+	)
+
+	node = &mastercfg.OperNodeState{}
+	if node.StateDriver, err = utils.GetStateDriver(); err != nil {
+		return nil, err
+	}
+
+	if id == "all" {
+		nodes, err := node.ReadAll()
+		if err != nil && strings.Contains(err.Error(), "Key not found") {
+			// no host published its neighbors yet
+			return []core.State{}, nil
+		}
+		return nodes, err
+	}
+
+	err = node.Read(id)
+	if err == nil {
+		return []core.State{core.State(node)}, nil
+	}
+
+	return nil, core.Errorf("Error reading the state of node %s. Err: %v", id, err)
+}
+
+// localURL returns the url of the api of this netmaster
+func (d *daemon) localURL() string {
+	host, port, err := net.SplitHostPort(d.listenURL)
+	if err != nil {
+		return "http://" + d.listenURL
+	}
+
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
+	}
+
+	return "http://" + net.JoinHostPort(host, port)
+}
+
 // runLeader runs leader loop
 func (d *daemon) runLeader() {
 	router := mux.NewRouter()
@@ -247,10 +305,27 @@ func (d *daemon) runLeader() {
 	// start server
 	go server.Serve(listener)
 
+	// the networks of the skydns containers move to netplugin, written by
+	// the leader only
+	go func() {
+		if err := master.MigrateSkyDNS(d.stateDriver); err != nil {
+			log.Errorf("Error migrating the dns servers of the networks. Err: %v", err)
+		}
+	}()
+
+	// the network policies of kubernetes are synced by the leader only
+	stopPolicyWatch := make(chan bool)
+	if master.GetClusterMode() == "kubernetes" {
+		if err := k8splugin.InitKubPolicyWatch(d.localURL(), stopPolicyWatch); err != nil {
+			log.Errorf("Error starting the network policy watch. Err: %v", err)
+		}
+	}
+
 	// Wait till we are asked to stop
 	<-d.stopLeaderChan
 
 	// Close the listener and exit
+	close(stopPolicyWatch)
 	listener.Close()
 	log.Infof("Exiting Leader mode")
 }
@@ -19,9 +19,10 @@ package intent
 
 // ConfigGlobal keeps track of settings that are globally applicable
 type ConfigGlobal struct {
-	NwInfraType string
-	VLANs       string
-	VXLANs      string
+	NwInfraType    string
+	VLANs          string
+	VXLANs         string
+	ServiceVIPPool string // subnet of the VIPs of external services
 }
 
 // ConfigEP encapulsates an endpoint: a leg into a network
@@ -32,6 +33,7 @@ type ConfigEP struct {
 	IPAddress   string
 	IPv6Address string
 	ServiceName string
+	Owner       string
 }
 
 // ConfigNetwork is a multi-destination isolated containment of endpoints
@@ -49,6 +51,12 @@ type ConfigNetwork struct {
 	IPv6Gateway    string
 	Vrf            string
 
+	// routes and dns config of the containers, e.g. "10.2.0.0/16 via 10.1.1.254"
+	StaticRoutes []string
+	DNSServers   []string
+	DNSSearch    []string
+	DNSOptions   []string
+
 	// eps associated with the network
 	Endpoints []ConfigEP
 }
@@ -64,11 +72,27 @@ type ConfigTenant struct {
 
 //ConfigBgp keeps bgp specific configs
 type ConfigBgp struct {
-	Hostname   string
-	RouterIP   string
-	As         string
-	NeighborAs string
-	Neighbor   string
+	Hostname       string
+	RouterIP       string
+	As             string
+	NeighborAs     string
+	Neighbor       string
+	Neighbors      []ConfigBgpNeighbor // additional neighbors
+	HoldTime       int
+	Keepalive      int
+	ImportPrefixes []string
+	ExportPrefixes []string
+	ExportTenants  []string
+}
+
+//ConfigBgpNeighbor is a bgp neighbor of a host. A neighbor without a
+//password keeps the password it was configured with, unless ClearPassword
+//removes it.
+type ConfigBgpNeighbor struct {
+	Address       string
+	As            string
+	Password      string
+	ClearPassword bool
 }
 
 //ConfigServiceLB keeps servicelb specific configs
@@ -76,9 +100,37 @@ type ConfigServiceLB struct {
 	ServiceName string
 	Tenant      string
 	Selectors   map[string]string
-	Network     string
-	Ports       []string
-	IPAddress   string
+	// set based selectors, e.g. "tier in (web,app)", "version notin (1)",
+	// "release" or "!canary"
+	SelectorExprs []string
+	Network       string
+	Ports         []string
+	IPAddress     string
+	HealthCheck   *ConfigHealthCheck
+	LBPolicy      *ConfigLBPolicy
+	// external services get their VIP from the service VIP pool and are
+	// advertised over bgp by the hosts of their providers
+	External bool
+}
+
+// ConfigHealthCheck is the health check of the providers of a service
+type ConfigHealthCheck struct {
+	Type               string // tcp or http
+	Port               int    // provider port, the first provider port if 0
+	Path               string // path of http checks
+	Interval           int    // seconds between checks
+	Timeout            int    // seconds
+	HealthyThreshold   int    // passed checks to become healthy
+	UnhealthyThreshold int    // failed checks to become unhealthy
+}
+
+// ConfigLBPolicy is how the clients of a service are spread over its providers
+type ConfigLBPolicy struct {
+	Mode            string // roundrobin or leastconn
+	SessionAffinity string // none or clientip
+	AffinityTimeout int    // seconds a client sticks to its provider
+	// provider weights as selector:weight, e.g. "track=canary:1"
+	Weights []string
 }
 
 // Config is the top level configuration
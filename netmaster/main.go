@@ -31,13 +31,15 @@ import (
 )
 
 type cliOpts struct {
-	help         bool
-	debug        bool
-	clusterStore string
-	listenURL    string
-	clusterMode  string
-	dnsEnabled   bool
-	version      bool
+	help          bool
+	debug         bool
+	clusterStore  string
+	listenURL     string
+	clusterMode   string
+	dnsEnabled    bool
+	ipamSupernet  string
+	ipamSubnetLen uint
+	version       bool
 }
 
 var flagSet *flag.FlagSet
@@ -96,7 +98,15 @@ func parseOpts(opts *cliOpts) error {
 	flagSet.BoolVar(&opts.dnsEnabled,
 		"dns-enable",
 		true,
-		"Turn on DNS {true, false}")
+		"Serve the names of the tenants from the dns server address of the networks {true, false}")
+	flagSet.StringVar(&opts.ipamSupernet,
+		"ipam-supernet",
+		"",
+		"Supernet to allocate docker ipam pools without a subnet from, e.g. 10.200.0.0/16")
+	flagSet.UintVar(&opts.ipamSubnetLen,
+		"ipam-subnet-len",
+		24,
+		"Length of the subnets allocated from the ipam supernet")
 	flagSet.BoolVar(&opts.version,
 		"version",
 		false,
@@ -136,6 +146,10 @@ func execOpts(opts *cliOpts) core.StateDriver {
 		log.Fatalf("Failed to set dns-enable. Error: %s", err)
 	}
 
+	if err := master.SetIpamSupernet(opts.ipamSupernet, opts.ipamSubnetLen); err != nil {
+		log.Fatalf("Failed to set ipam-supernet. Error: %s", err)
+	}
+
 	sd, err := initStateDriver(opts)
 	if err != nil {
 		log.Fatalf("Failed to init state-store. Error: %s", err)
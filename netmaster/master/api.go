@@ -35,6 +35,9 @@ type AddressAllocRequest struct {
 	NetworkID            string // Unique identifier for the network
 	AddressPool          string // Address pool from which to allocate the address
 	PreferredIPv4Address string // Preferred address
+	Owner                string // container or pod name, used to look up sticky reservations
+	PoolID               string // ipam pool to allocate from, instead of the network
+	Gateway              bool   // request for the gateway of the pool
 }
 
 // AddressAllocResponse is the response from netmaster
@@ -47,6 +50,27 @@ type AddressAllocResponse struct {
 type AddressReleaseRequest struct {
 	NetworkID   string // Unique identifier for the network
 	IPv4Address string // Allocated address
+	PoolID      string // ipam pool the address was allocated from
+}
+
+// IpamPoolRequest is the pool request of docker's ipam from netplugin
+type IpamPoolRequest struct {
+	TenantName  string // tenant of the network, empty for standalone pools
+	NetworkName string // network of the pool, empty for standalone pools
+	Pool        string // subnet of the pool, allocated from the supernet when empty
+	SubPool     string // range to allocate addresses from, e.g. from --ip-range
+	IPv6        bool   // ipv6 pool
+}
+
+// IpamPoolResponse is the pool response from netmaster
+type IpamPoolResponse struct {
+	PoolID string // Unique identifier for the pool
+	Pool   string // subnet of the pool
+}
+
+// IpamPoolReleaseRequest is the pool release request from netplugin
+type IpamPoolReleaseRequest struct {
+	PoolID string // Unique identifier for the pool
 }
 
 // CreateEndpointRequest has the endpoint create request from netplugin
@@ -88,11 +112,52 @@ type SvcProvUpdateResponse struct {
 	IPAddress string // provider IP
 }
 
+// SvcProviderHealthRequest reports the health of a service provider
+type SvcProviderHealthRequest struct {
+	ServiceID  string // service the provider was checked for
+	ProviderID string // provider id in the service
+	Healthy    bool
+}
+
+// SvcProviderHealthResponse is the response to a provider health report
+type SvcProviderHealthResponse struct {
+}
+
 // DeleteEndpointResponse is the delete endpoint response from netmaster
 type DeleteEndpointResponse struct {
 	EndpointConfig mastercfg.CfgEndpointState // Endpoint config
 }
 
+// NetworkSubnetRequest is the request to add or remove an additional subnet of a network
+type NetworkSubnetRequest struct {
+	TenantName  string // tenant name
+	NetworkName string // network name
+	SubnetCIDR  string // subnet, optionally with a range, e.g. 10.1.2.10-100/24
+	Gateway     string // gateway of the subnet
+}
+
+// NetworkSubnetResponse has the subnet pools of the network after the update
+type NetworkSubnetResponse struct {
+	NetworkID   string   // Unique identifier for the network
+	SubnetPools []string // usage of each address pool
+}
+
+// NetworkReservationRequest is the request to add or remove an ip reservation of a network
+type NetworkReservationRequest struct {
+	TenantName  string // tenant name
+	NetworkName string // network name
+	Name        string // reservation name
+	IPRange     string // excluded addresses, e.g. 10.1.1.200-10.1.1.250
+	IPAddress   string // sticky address, picked automatically when empty
+	Owner       string // container or pod name the sticky address is bound to
+}
+
+// NetworkReservationResponse has the reservations of the network after the update
+type NetworkReservationResponse struct {
+	NetworkID    string   // Unique identifier for the network
+	Reservations []string // reservations of the network
+}
+
 // Global mutex for address allocation
 var addrMutex sync.Mutex
 
@@ -119,6 +184,16 @@ func AllocAddressHandler(w http.ResponseWriter, r *http.Request, vars map[string
 		return nil, err
 	}
 
+	// Allocate from the ipam pool of docker networks
+	if allocReq.PoolID != "" {
+		addr, err := ipamAllocAddress(stateDriver, allocReq.PoolID, allocReq.PreferredIPv4Address,
+			allocReq.Owner, allocReq.Gateway)
+		if err != nil {
+			return nil, err
+		}
+		return AddressAllocResponse{NetworkID: allocReq.NetworkID, IPv4Address: addr}, nil
+	}
+
 	isIPv6 := netutils.IsIPv6(allocReq.AddressPool)
 	networkID := ""
 
@@ -174,8 +249,20 @@ func AllocAddressHandler(w http.ResponseWriter, r *http.Request, vars map[string
 		return nil, err
 	}
 
+	// Use the sticky address of the owner unless an address was requested
+	reqAddr := allocReq.PreferredIPv4Address
+	if reqAddr == "" && !isIPv6 {
+		reqAddr = stickyAddress(nwCfg, allocReq.Owner)
+	}
+	if reqAddr != "" && !isIPv6 {
+		if err := checkReservedAddress(nwCfg, reqAddr, allocReq.Owner); err != nil {
+			log.Errorf("Failed to allocate address. Err: %v", err)
+			return nil, err
+		}
+	}
+
 	// Alloc addresses
-	addr, err := networkAllocAddress(nwCfg, allocReq.PreferredIPv4Address, netutils.IsIPv6(allocReq.AddressPool))
+	addr, err := networkAllocAddress(nwCfg, reqAddr, netutils.IsIPv6(allocReq.AddressPool))
 	if err != nil {
 		log.Errorf("Failed to allocate address. Err: %v", err)
 		return nil, err
@@ -185,7 +272,7 @@ func AllocAddressHandler(w http.ResponseWriter, r *http.Request, vars map[string
 	if isIPv6 {
 		subnetLen = nwCfg.IPv6SubnetLen
 	} else {
-		subnetLen = nwCfg.SubnetLen
+		subnetLen = findIPv4Pool(nwCfg, addr).subnetLen
 	}
 
 	// Build the response
@@ -210,11 +297,25 @@ func ReleaseAddressHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 
 	log.Infof("Received AddressReleaseRequest: %+v", relReq)
 
+	// Take a global lock for address release
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
 	stateDriver, err := utils.GetStateDriver()
 	if err != nil {
 		return nil, err
 	}
 
+	// Release to the ipam pool of docker networks
+	if relReq.PoolID != "" {
+		err = ipamReleaseAddress(stateDriver, relReq.PoolID, relReq.IPv4Address)
+		if err != nil {
+			log.Errorf("Failed to release address. Err: %v", err)
+			return nil, err
+		}
+		return "success", nil
+	}
+
 	// find the network from network id
 	nwCfg := &mastercfg.CfgNetworkState{}
 	nwCfg.StateDriver = stateDriver
@@ -234,6 +335,185 @@ func ReleaseAddressHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 	return "success", nil
 }
 
+// RequestPoolHandler creates or reuses an ipam pool
+func RequestPoolHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var poolReq IpamPoolRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&poolReq)
+	if err != nil {
+		log.Errorf("Error decoding RequestPoolHandler. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received IpamPoolRequest: %+v", poolReq)
+
+	// Take a global lock for address allocation
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	poolCfg, err := RequestIpamPool(stateDriver, &poolReq)
+	if err != nil {
+		log.Errorf("Failed to request pool. Err: %v", err)
+		return nil, err
+	}
+
+	return IpamPoolResponse{PoolID: poolCfg.ID, Pool: poolCfg.Pool}, nil
+}
+
+// ReleasePoolHandler releases an ipam pool
+func ReleasePoolHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var relReq IpamPoolReleaseRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&relReq)
+	if err != nil {
+		log.Errorf("Error decoding ReleasePoolHandler. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received IpamPoolReleaseRequest: %+v", relReq)
+
+	// Take a global lock for address allocation
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ReleaseIpamPool(stateDriver, relReq.PoolID)
+	if err != nil {
+		log.Errorf("Failed to release pool. Err: %v", err)
+		return nil, err
+	}
+
+	return "success", nil
+}
+
+// networkSubnetUpdate adds or removes an additional subnet of a network
+func networkSubnetUpdate(r *http.Request, isDelete bool) (interface{}, error) {
+	var subnetReq NetworkSubnetRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&subnetReq)
+	if err != nil {
+		log.Errorf("Error decoding NetworkSubnetRequest. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received NetworkSubnetRequest: %+v, delete: %v", subnetReq, isDelete)
+
+	// Take a global lock for address allocation
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	networkID := subnetReq.NetworkName + "." + subnetReq.TenantName
+	if isDelete {
+		err = DeleteNetworkSubnet(stateDriver, networkID, subnetReq.SubnetCIDR)
+	} else {
+		err = AddNetworkSubnet(stateDriver, networkID, subnetReq.SubnetCIDR, subnetReq.Gateway)
+	}
+	if err != nil {
+		log.Errorf("Error updating subnets of network %s. Err: %v", networkID, err)
+		return nil, err
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err = nwCfg.Read(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NetworkSubnetResponse{
+		NetworkID:   networkID,
+		SubnetPools: ListSubnetPools(nwCfg),
+	}, nil
+}
+
+// AddNetworkSubnetHandler adds an additional subnet to a network
+func AddNetworkSubnetHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	return networkSubnetUpdate(r, false)
+}
+
+// DeleteNetworkSubnetHandler removes an additional subnet from a network
+func DeleteNetworkSubnetHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	return networkSubnetUpdate(r, true)
+}
+
+// networkReservationUpdate adds or removes an ip reservation of a network
+func networkReservationUpdate(r *http.Request, isDelete bool) (interface{}, error) {
+	var resvReq NetworkReservationRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&resvReq)
+	if err != nil {
+		log.Errorf("Error decoding NetworkReservationRequest. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received NetworkReservationRequest: %+v, delete: %v", resvReq, isDelete)
+
+	// Take a global lock for address allocation
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	networkID := resvReq.NetworkName + "." + resvReq.TenantName
+	if isDelete {
+		err = DeleteIPReservation(stateDriver, networkID, resvReq.Name)
+	} else {
+		err = AddIPReservation(stateDriver, networkID, &mastercfg.IPReservation{
+			Name:      resvReq.Name,
+			IPRange:   resvReq.IPRange,
+			IPAddress: resvReq.IPAddress,
+			Owner:     resvReq.Owner,
+		})
+	}
+	if err != nil {
+		log.Errorf("Error updating reservations of network %s. Err: %v", networkID, err)
+		return nil, err
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err = nwCfg.Read(networkID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NetworkReservationResponse{
+		NetworkID:    networkID,
+		Reservations: ListIPReservations(nwCfg),
+	}, nil
+}
+
+// AddIPReservationHandler adds an ip reservation to a network
+func AddIPReservationHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	return networkReservationUpdate(r, false)
+}
+
+// DeleteIPReservationHandler removes an ip reservation from a network
+func DeleteIPReservationHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	return networkReservationUpdate(r, true)
+}
+
 // CreateEndpointHandler handles create endpoint requests
 func CreateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
 	var epReq CreateEndpointRequest
@@ -365,6 +645,7 @@ func ServiceProviderUpdateHandler(w http.ResponseWriter, r *http.Request, vars m
 		provider.Tenant = svcProvUpdReq.Tenant
 		provider.Network = svcProvUpdReq.Network
 		provider.ContainerID = svcProvUpdReq.ContainerID
+		provider.Host = epCfg.HomingHost
 		provider.Labels = make(map[string]string)
 
 		if epCfg.Labels == nil {
@@ -386,6 +667,12 @@ func ServiceProviderUpdateHandler(w http.ResponseWriter, r *http.Request, vars m
 			return nil, err
 		}
 
+		if len(svcProvUpdReq.Labels) == 0 {
+			//container without labels is not a service provider, only its
+			//containerId is maintained for endpoint inspect
+			return &SvcProvUpdateResponse{IPAddress: svcProvUpdReq.IPAddress}, nil
+		}
+
 		providerID := getProviderID(provider)
 		providerDbID := getProviderDbID(provider)
 		if providerID == "" || providerDbID == "" {
@@ -393,87 +680,51 @@ func ServiceProviderUpdateHandler(w http.ResponseWriter, r *http.Request, vars m
 		}
 
 		//update provider db
-		mastercfg.SvcMutex.Lock()
-		mastercfg.ProviderDb[providerDbID] = provider
-
-		for serviceID, service := range mastercfg.ServiceLBDb {
-			count := 0
-			if service.Tenant == svcProvUpdReq.Tenant {
-				for key, value := range svcProvUpdReq.Labels {
-					if val := service.Selectors[key]; val == value {
-						count++
-					}
-
-					if count == len(service.Selectors) {
-						//Container corresponds to the service since it
-						//matches all service Selectors
-						mastercfg.ProviderDb[providerDbID].Services =
-							append(mastercfg.ProviderDb[providerDbID].Services, serviceID)
-							//Update ServiceDB
-						mastercfg.ServiceLBDb[serviceID].Providers[providerID] = provider
-
-						serviceLbState := &mastercfg.CfgServiceLBState{}
-						serviceLbState.StateDriver = stateDriver
-						err = serviceLbState.Read(serviceID)
-						if err != nil {
-							mastercfg.SvcMutex.Unlock()
-							return nil, err
-						}
-						serviceLbState.Providers[providerID] = provider
-						serviceLbState.Write()
-						SvcProviderUpdate(serviceID, false)
-						break
-					}
-				}
-			}
+		err = addServiceProvider(stateDriver, provider)
+		if err != nil {
+			return nil, err
 		}
 
 	} else if svcProvUpdReq.Event == "die" {
 		//Received a container die event. If it was a service provider -
 		//clear the provider db and the service db and change the etcd state
 
-		providerDbID := svcProvUpdReq.ContainerID
-		if providerDbID == "" {
+		if svcProvUpdReq.ContainerID == "" {
 			return nil, fmt.Errorf("Invalid containerID in SvcProvUpdateRequest:(nil)")
 		}
 
-		mastercfg.SvcMutex.Lock()
-		provider := mastercfg.ProviderDb[providerDbID]
-		if provider == nil {
-			mastercfg.SvcMutex.Unlock()
-			// It is not a provider . Ignore event
-			return nil, nil
-		}
-
-		for _, serviceID := range provider.Services {
-			service := mastercfg.ServiceLBDb[serviceID]
-			providerID := getProviderID(provider)
-			if providerID == "" {
-				mastercfg.SvcMutex.Unlock()
-				return nil, fmt.Errorf("Invalid ProviderID from providerInfo:{%v}", provider)
-			}
-			if service.Providers[providerID] != nil {
-				delete(service.Providers, providerID)
-
-				serviceLbState := &mastercfg.CfgServiceLBState{}
-				serviceLbState.StateDriver = stateDriver
-				err = serviceLbState.Read(serviceID)
-				if err != nil {
-					mastercfg.SvcMutex.Unlock()
-					return nil, err
-				}
-				delete(serviceLbState.Providers, providerID)
-				serviceLbState.Write()
-				delete(mastercfg.ProviderDb, providerDbID)
-				SvcProviderUpdate(serviceID, false)
-
-			}
+		err = removeContainerProviders(stateDriver, svcProvUpdReq.ContainerID)
+		if err != nil {
+			return nil, err
 		}
-
 	}
-	mastercfg.SvcMutex.Unlock()
 	srvUpdResp := &SvcProvUpdateResponse{
 		IPAddress: svcProvUpdReq.IPAddress,
 	}
 	return srvUpdResp, nil
 }
+
+// ServiceProviderHealthHandler handles the health reports of service providers
+func ServiceProviderHealthHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var healthReq SvcProviderHealthRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&healthReq)
+	if err != nil {
+		log.Errorf("Error decoding SvcProviderHealthRequest. Err %v", err)
+		return nil, err
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	err = UpdateProviderHealth(stateDriver, &healthReq)
+	if err != nil {
+		log.Errorf("Error updating provider health {%+v}. Err: %v", healthReq, err)
+		return nil, err
+	}
+
+	return &SvcProviderHealthResponse{}, nil
+}
@@ -16,25 +16,68 @@ limitations under the License.
 package master
 
 import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 )
 
+// bgpDefaultHoldTime is the hold time of the neighbors in seconds when
+// none is configured
+const bgpDefaultHoldTime = 90
+
 //AddBgp adds to the etcd state
 func AddBgp(stateDriver core.StateDriver, bgpCfg *intent.ConfigBgp) error {
 
-	log.Infof("Adding bgp neighbor {%v}", bgpCfg)
+	log.Infof("Adding bgp config for host %s", bgpCfg.Hostname)
+
+	// the passwords of the neighbors are not returned by the api, updates
+	// leave them out
+	oldState := &mastercfg.CfgBgpState{}
+	oldState.StateDriver = stateDriver
+	err := oldState.Read(bgpCfg.Hostname)
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		return err
+	}
+	neighbors, err := bgpNeighbors(bgpCfg, oldState.BgpNeighbors())
+	if err != nil {
+		return err
+	}
+
+	err = validateBgpTimers(bgpCfg.HoldTime, bgpCfg.Keepalive)
+	if err != nil {
+		return err
+	}
+
+	for _, prefixes := range [][]string{bgpCfg.ImportPrefixes, bgpCfg.ExportPrefixes} {
+		for _, prefix := range prefixes {
+			ip, _, err := net.ParseCIDR(prefix)
+			if err != nil || ip.To4() == nil {
+				return core.Errorf("invalid bgp prefix %s", prefix)
+			}
+		}
+	}
+
 	bgpState := &mastercfg.CfgBgpState{}
 	bgpState.Hostname = bgpCfg.Hostname
 	bgpState.RouterIP = bgpCfg.RouterIP
 	bgpState.As = bgpCfg.As
 	bgpState.NeighborAs = bgpCfg.NeighborAs
 	bgpState.Neighbor = bgpCfg.Neighbor
+	bgpState.Neighbors = neighbors
+	bgpState.HoldTime = bgpCfg.HoldTime
+	bgpState.Keepalive = bgpCfg.Keepalive
+	bgpState.ImportPrefixes = bgpCfg.ImportPrefixes
+	bgpState.ExportPrefixes = bgpCfg.ExportPrefixes
+	bgpState.ExportTenants = bgpCfg.ExportTenants
 	bgpState.StateDriver = stateDriver
 	bgpState.ID = bgpCfg.Hostname
-	err := bgpState.Write()
+	err = bgpState.Write()
 
 	if err != nil {
 		return err
@@ -42,6 +85,89 @@ func AddBgp(stateDriver core.StateDriver, bgpCfg *intent.ConfigBgp) error {
 	return nil
 }
 
+// bgpNeighbors returns the neighbor and the additional neighbors of a bgp
+// config. Neighbors without a password keep their old password, unless
+// they clear it.
+func bgpNeighbors(bgpCfg *intent.ConfigBgp, oldNeighbors []mastercfg.BgpNeighbor) ([]mastercfg.BgpNeighbor, error) {
+	neighbors := []mastercfg.BgpNeighbor{}
+	if bgpCfg.Neighbor != "" {
+		neighbors = append(neighbors, mastercfg.BgpNeighbor{
+			Address: bgpCfg.Neighbor,
+			As:      bgpCfg.NeighborAs,
+		})
+	}
+	clearPassword := make(map[string]bool)
+	for _, neighbor := range bgpCfg.Neighbors {
+		if neighbor.ClearPassword {
+			if neighbor.Password != "" {
+				return nil, core.Errorf("bgp neighbor %s both sets and clears its password", neighbor.Address)
+			}
+			clearPassword[neighbor.Address] = true
+		}
+		neighbors = append(neighbors, mastercfg.BgpNeighbor{
+			Address:  neighbor.Address,
+			As:       neighbor.As,
+			Password: neighbor.Password,
+		})
+	}
+
+	for idx := range neighbors {
+		if clearPassword[neighbors[idx].Address] {
+			continue
+		}
+		for _, oldNeighbor := range oldNeighbors {
+			if neighbors[idx].Password == "" && neighbors[idx].Address == oldNeighbor.Address {
+				neighbors[idx].Password = oldNeighbor.Password
+			}
+		}
+	}
+
+	if len(neighbors) == 0 {
+		return nil, core.Errorf("no bgp neighbor for host %s", bgpCfg.Hostname)
+	}
+
+	addresses := make(map[string]bool)
+	for _, neighbor := range neighbors {
+		ip := net.ParseIP(neighbor.Address)
+		if ip == nil || ip.To4() == nil {
+			return nil, core.Errorf("invalid bgp neighbor address %s", neighbor.Address)
+		}
+		if addresses[ip.String()] {
+			return nil, core.Errorf("duplicate bgp neighbor %s", neighbor.Address)
+		}
+		addresses[ip.String()] = true
+
+		if _, err := strconv.ParseUint(neighbor.As, 10, 32); err != nil {
+			return nil, core.Errorf("invalid AS %s of bgp neighbor %s", neighbor.As, neighbor.Address)
+		}
+		if len(neighbor.Password) > 80 {
+			return nil, core.Errorf("password of bgp neighbor %s too long", neighbor.Address)
+		}
+	}
+
+	return neighbors, nil
+}
+
+// validateBgpTimers checks the hold time and keepalive interval of the
+// neighbors, 0 for the defaults
+func validateBgpTimers(holdTime, keepalive int) error {
+	if holdTime < 0 || keepalive < 0 {
+		return core.Errorf("invalid bgp timers, hold time %d keepalive %d", holdTime, keepalive)
+	}
+	if holdTime != 0 && holdTime < 3 {
+		return core.Errorf("bgp hold time %d should be 0 or at least 3 seconds", holdTime)
+	}
+
+	if holdTime == 0 {
+		holdTime = bgpDefaultHoldTime
+	}
+	if keepalive >= holdTime {
+		return core.Errorf("bgp keepalive %d should be less than the hold time %d", keepalive, holdTime)
+	}
+
+	return nil
+}
+
 //DeleteBgp deletes from etcd state
 func DeleteBgp(stateDriver core.StateDriver, hostname string) error {
 	log.Infof("Deleting bgp neighbor for {%v}", hostname)
@@ -60,3 +186,45 @@ func DeleteBgp(stateDriver core.StateDriver, hostname string) error {
 	return nil
 
 }
+
+// BgpOper is the bgp state of a host
+type BgpOper struct {
+	RouterIP    string
+	As          string
+	Neighbors   []mastercfg.BgpNeighborOper
+	Routes      []mastercfg.BgpRoute // host routes of the RIB
+	RibSize     int                  // number of routes in the RIB
+	LastUpdated time.Time
+}
+
+// GetBgpOper returns the bgp state a host published. The state is empty
+// until the host publishes it.
+func GetBgpOper(stateDriver core.StateDriver, hostname string) (*BgpOper, error) {
+	operState := &mastercfg.OperBgpState{}
+	operState.StateDriver = stateDriver
+	err := operState.Read(hostname)
+	if err != nil {
+		if strings.Contains(err.Error(), "Key not found") {
+			return &BgpOper{}, nil
+		}
+		log.Errorf("Error reading bgp state of host %s. Err: %v", hostname, err)
+		return nil, err
+	}
+
+	bgpOper := &BgpOper{
+		RouterIP:    operState.RouterIP,
+		As:          operState.As,
+		Neighbors:   operState.Neighbors,
+		Routes:      operState.Routes,
+		RibSize:     operState.RibSize,
+		LastUpdated: operState.UpdateTime,
+	}
+	for i, neighbor := range bgpOper.Neighbors {
+		if neighbor.State == "BGP_FSM_ESTABLISHED" {
+			// the uptime when the state was published plus its age
+			bgpOper.Neighbors[i].Uptime += uint64(time.Since(operState.UpdateTime) / time.Second)
+		}
+	}
+
+	return bgpOper, nil
+}
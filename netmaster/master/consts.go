@@ -38,4 +38,18 @@ const (
 	GetServiceRESTEndpoint = "service"
 	//GetServicesRESTEndpoint is the REST endpoint to request info of all services
 	GetServicesRESTEndpoint = "services"
+	//AddNetworkSubnetRESTEndpoint is the REST endpoint to add a subnet to a network
+	AddNetworkSubnetRESTEndpoint = "network-subnet-add"
+	//DelNetworkSubnetRESTEndpoint is the REST endpoint to remove a subnet from a network
+	DelNetworkSubnetRESTEndpoint = "network-subnet-del"
+	//AddIPReservationRESTEndpoint is the REST endpoint to add an ip reservation to a network
+	AddIPReservationRESTEndpoint = "network-reservation-add"
+	//DelIPReservationRESTEndpoint is the REST endpoint to remove an ip reservation from a network
+	DelIPReservationRESTEndpoint = "network-reservation-del"
+	//TraceRESTEndpoint is the REST endpoint to trace the packet path between endpoints
+	TraceRESTEndpoint = "trace"
+	//GetNodeRESTEndpoint is the REST endpoint to request info of a host
+	GetNodeRESTEndpoint = "node"
+	//GetNodesRESTEndpoint is the REST endpoint to request info of all hosts
+	GetNodesRESTEndpoint = "nodes"
 )
@@ -61,7 +61,19 @@ func validateEndpointConfig(stateDriver core.StateDriver, tenant *intent.ConfigT
 func allocSetEpAddress(ep *intent.ConfigEP, epCfg *mastercfg.CfgEndpointState,
 	nwCfg *mastercfg.CfgNetworkState) (err error) {
 
-	ipAddress, err := networkAllocAddress(nwCfg, ep.IPAddress, false)
+	// Use the sticky address of the owner unless an address was requested
+	reqAddr := ep.IPAddress
+	if reqAddr == "" {
+		reqAddr = stickyAddress(nwCfg, ep.Owner)
+	}
+	if reqAddr != "" {
+		if err = checkReservedAddress(nwCfg, reqAddr, ep.Owner); err != nil {
+			log.Errorf("Error allocating IP address. Err: %v", err)
+			return
+		}
+	}
+
+	ipAddress, err := networkAllocAddress(nwCfg, reqAddr, false)
 	if err != nil {
 		log.Errorf("Error allocating IP address. Err: %v", err)
 		return
@@ -113,6 +125,14 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 		return nil, err
 	}
 
+	// the address docker requested for the endpoint is now released with it
+	if ep.IPAddress != "" {
+		if err = claimIpamAddress(stateDriver, nwCfg.ID, epCfg.IPAddress); err != nil {
+			log.Errorf("Error claiming address %s. Err: %v", epCfg.IPAddress, err)
+			return nil, err
+		}
+	}
+
 	// Set endpoint group
 	// Skip for infra nw
 	if nwCfg.NwType != "infra" {
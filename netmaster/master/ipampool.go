@@ -0,0 +1,584 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+)
+
+// ipamPoolID returns the id of a pool. State keys can not contain slashes.
+func ipamPoolID(networkID, pool, subPool string) string {
+	id := pool
+	if subPool != "" {
+		id += ":" + subPool
+	}
+	if networkID != "" {
+		id = networkID + ":" + id
+	}
+
+	return strings.Replace(id, "/", "-", -1)
+}
+
+// legacyPoolNetwork returns the network and subnet of a pool id of docker
+// networks created before netmaster managed the pools, which is either the
+// subnet or network.tenant:subnet
+func legacyPoolNetwork(stateDriver core.StateDriver, poolID string) (string, string, error) {
+	if _, _, err := net.ParseCIDR(poolID); err != nil {
+		idx := strings.Index(poolID, ":")
+		if idx < 0 {
+			return "", "", core.Errorf("invalid pool id %s", poolID)
+		}
+		return poolID[:idx], poolID[idx+1:], nil
+	}
+
+	// find the network of the subnet
+	subnetIP, subnetLen, _ := netutils.ParseCIDR(poolID)
+	readNet := &mastercfg.CfgNetworkState{}
+	readNet.StateDriver = stateDriver
+	netList, err := readNet.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		return "", "", err
+	}
+	for _, ncfg := range netList {
+		nw := ncfg.(*mastercfg.CfgNetworkState)
+		if nw.IPv6Subnet == subnetIP && nw.IPv6SubnetLen == subnetLen {
+			return nw.ID, poolID, nil
+		}
+		for _, p := range networkIPv4Pools(nw) {
+			if p.subnetIP == subnetIP && p.subnetLen == subnetLen {
+				return nw.ID, poolID, nil
+			}
+		}
+	}
+
+	return "", "", core.Errorf("no network has the subnet of pool %s", poolID)
+}
+
+// readIpamPool reads a pool. The pools of docker networks created before
+// netmaster managed the pools are created on their first use, they are not
+// reference counted and are removed with their network.
+func readIpamPool(stateDriver core.StateDriver, poolID string) (*mastercfg.CfgIpamPoolState, error) {
+	poolCfg := &mastercfg.CfgIpamPoolState{}
+	poolCfg.StateDriver = stateDriver
+	err := poolCfg.Read(poolID)
+	if err == nil || !strings.Contains(poolID, "/") {
+		if err != nil {
+			log.Errorf("Error reading ipam pool %s. Err: %v", poolID, err)
+		}
+		return poolCfg, err
+	}
+
+	networkID, pool, err := legacyPoolNetwork(stateDriver, poolID)
+	if err != nil {
+		log.Errorf("Error finding the network of pool %s. Err: %v", poolID, err)
+		return nil, err
+	}
+
+	if err := poolCfg.Read(ipamPoolID(networkID, pool, "")); err == nil {
+		return poolCfg, nil
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return nil, err
+	}
+
+	poolCfg, err = RequestIpamPool(stateDriver, &IpamPoolRequest{
+		TenantName:  nwCfg.Tenant,
+		NetworkName: nwCfg.NetworkName,
+		Pool:        pool,
+		IPv6:        netutils.IsIPv6(pool),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Migrated ipam pool %s to %s", poolID, poolCfg.ID)
+	poolCfg.Legacy = true
+
+	return poolCfg, poolCfg.Write()
+}
+
+// clearIpamPools removes the pools of a network
+func clearIpamPools(stateDriver core.StateDriver, networkID string) error {
+	readPool := &mastercfg.CfgIpamPoolState{}
+	readPool.StateDriver = stateDriver
+	poolList, err := readPool.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		return err
+	}
+
+	for _, pcfg := range poolList {
+		pool := pcfg.(*mastercfg.CfgIpamPoolState)
+		if pool.NetworkID != networkID {
+			continue
+		}
+		pool.StateDriver = stateDriver
+		if err := pool.Clear(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claimIpamAddress hands an address requested from a pool of a network over
+// to the endpoint created with it. The address is then released with the
+// endpoint, and no longer when docker releases it from the pool.
+func claimIpamAddress(stateDriver core.StateDriver, networkID, ipAddress string) error {
+	readPool := &mastercfg.CfgIpamPoolState{}
+	readPool.StateDriver = stateDriver
+	poolList, err := readPool.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		return err
+	}
+
+	for _, pcfg := range poolList {
+		pool := pcfg.(*mastercfg.CfgIpamPoolState)
+		if pool.NetworkID != networkID || !pool.AuxAddresses[ipAddress] {
+			continue
+		}
+		delete(pool.AuxAddresses, ipAddress)
+		pool.StateDriver = stateDriver
+		if err := pool.Write(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parsePoolCIDR parses a subnet, returning it with the host bits cleared
+func parsePoolCIDR(cidr string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, core.Errorf("invalid subnet %q", cidr)
+	}
+
+	return ipNet, nil
+}
+
+// poolRange returns the first and last index of a sub-pool within a pool
+func poolRange(pool, subPool string) (uint, uint, error) {
+	poolNet, err := parsePoolCIDR(pool)
+	if err != nil {
+		return 0, 0, err
+	}
+	subNet, err := parsePoolCIDR(subPool)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	poolLen, _ := poolNet.Mask.Size()
+	subLen, _ := subNet.Mask.Size()
+	if subNet.IP.To4() == nil || !poolNet.Contains(subNet.IP) || subLen < poolLen {
+		return 0, 0, core.Errorf("sub-pool %s is not within pool %s", subPool, pool)
+	}
+
+	poolStart, _ := ipv4ToUint(poolNet.IP.String())
+	subStart, _ := ipv4ToUint(subNet.IP.String())
+	first := uint(subStart - poolStart)
+
+	return first, first + (1 << uint(32-subLen)) - 1, nil
+}
+
+// ipamUsedSubnets returns the ipv4 subnets in use, along with their owner.
+// Subnets of networks are skipped when asked for, pools of a network are
+// always within it.
+func ipamUsedSubnets(stateDriver core.StateDriver, withNetworks bool) (map[string]string, error) {
+	used := make(map[string]string)
+
+	if withNetworks {
+		readNet := &mastercfg.CfgNetworkState{}
+		readNet.StateDriver = stateDriver
+		netList, err := readNet.ReadAll()
+		if err != nil && !strings.Contains(err.Error(), "Key not found") {
+			return nil, err
+		}
+		for _, ncfg := range netList {
+			nw := ncfg.(*mastercfg.CfgNetworkState)
+			for _, p := range networkIPv4Pools(nw) {
+				used[fmt.Sprintf("%s/%d", p.subnetIP, p.subnetLen)] = "network " + nw.ID
+			}
+		}
+	}
+
+	readPool := &mastercfg.CfgIpamPoolState{}
+	readPool.StateDriver = stateDriver
+	poolList, err := readPool.ReadAll()
+	if err != nil && !strings.Contains(err.Error(), "Key not found") {
+		return nil, err
+	}
+	for _, pcfg := range poolList {
+		pool := pcfg.(*mastercfg.CfgIpamPoolState)
+		if pool.NetworkID == "" && !pool.IPv6 {
+			used[pool.Pool] = "pool " + pool.ID
+		}
+	}
+
+	return used, nil
+}
+
+// findOverlap returns the owner of a used subnet that overlaps a subnet
+func findOverlap(used map[string]string, subnetIP string, subnetLen uint) string {
+	for cidr, owner := range used {
+		usedIP, usedLen, err := netutils.ParseCIDR(cidr)
+		if err == nil && subnetsOverlap(subnetIP, subnetLen, usedIP, usedLen) {
+			return owner
+		}
+	}
+
+	return ""
+}
+
+// allocateIpamSubnet picks the first free subnet of the configured supernet
+func allocateIpamSubnet(used map[string]string) (string, error) {
+	if masterRTCfg.ipamSupernet == "" {
+		return "", core.Errorf("no subnet requested and no ipam supernet configured")
+	}
+
+	superIP, superLen, _ := netutils.ParseCIDR(masterRTCfg.ipamSupernet)
+	superStart, _ := ipv4ToUint(superIP)
+	subnetLen := masterRTCfg.ipamSubnetLen
+	for i := uint64(0); i < 1<<(subnetLen-superLen); i++ {
+		subnetIP := uintToIPv4(superStart + uint32(i<<(32-subnetLen)))
+		if findOverlap(used, subnetIP, subnetLen) == "" {
+			return fmt.Sprintf("%s/%d", subnetIP, subnetLen), nil
+		}
+	}
+
+	return "", core.Errorf("no free /%d subnet left in ipam supernet %s", subnetLen, masterRTCfg.ipamSupernet)
+}
+
+// RequestIpamPool creates an address pool for docker's ipam. When the tenant
+// and network are given the pool belongs to one of the subnets of the network,
+// otherwise it stands alone and may not overlap any other subnet. Standalone
+// pools without a subnet are allocated from the ipam supernet.
+func RequestIpamPool(stateDriver core.StateDriver, req *IpamPoolRequest) (*mastercfg.CfgIpamPoolState, error) {
+	var networkID, pool string
+
+	if req.TenantName != "" && req.NetworkName != "" {
+		networkID = req.NetworkName + "." + req.TenantName
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(networkID); err != nil {
+			log.Errorf("network %s is not operational", networkID)
+			return nil, err
+		}
+
+		// the primary subnet unless another one is requested
+		pools := []string{}
+		if req.IPv6 {
+			if nwCfg.IPv6Subnet == "" {
+				return nil, core.Errorf("network %s has no ipv6 subnet", networkID)
+			}
+			if req.SubPool != "" {
+				return nil, core.Errorf("ipv6 sub-pools are not supported")
+			}
+			pools = append(pools, fmt.Sprintf("%s/%d", nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen))
+		} else {
+			for _, p := range networkIPv4Pools(nwCfg) {
+				pools = append(pools, fmt.Sprintf("%s/%d", p.subnetIP, p.subnetLen))
+			}
+		}
+		if len(pools) == 0 {
+			return nil, core.Errorf("network %s has no ipv4 subnet", networkID)
+		}
+
+		pool = pools[0]
+		if req.Pool != "" {
+			reqNet, err := parsePoolCIDR(req.Pool)
+			if err != nil {
+				return nil, err
+			}
+			pool = ""
+			for _, p := range pools {
+				if pNet, _ := parsePoolCIDR(p); pNet.String() == reqNet.String() {
+					pool = p
+				}
+			}
+			if pool == "" {
+				return nil, core.Errorf("pool %s is not a subnet of network %s", req.Pool, networkID)
+			}
+		}
+
+		// standalone pools that came before the network take precedence
+		if !req.IPv6 {
+			used, err := ipamUsedSubnets(stateDriver, false)
+			if err != nil {
+				return nil, err
+			}
+			subnetIP, subnetLen, _ := netutils.ParseCIDR(pool)
+			if owner := findOverlap(used, subnetIP, subnetLen); owner != "" {
+				return nil, core.Errorf("pool %s overlaps with %s", pool, owner)
+			}
+		}
+	} else {
+		if req.IPv6 {
+			return nil, core.Errorf("ipv6 pools must belong to a network, set the tenant and network ipam options")
+		}
+
+		used, err := ipamUsedSubnets(stateDriver, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Pool == "" {
+			pool, err = allocateIpamSubnet(used)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			reqNet, err := parsePoolCIDR(req.Pool)
+			if err != nil {
+				return nil, err
+			}
+			if reqNet.IP.To4() == nil {
+				return nil, core.Errorf("ipv6 pools must belong to a network, set the tenant and network ipam options")
+			}
+			pool = reqNet.String()
+			subnetIP, subnetLen, _ := netutils.ParseCIDR(pool)
+			if owner := findOverlap(used, subnetIP, subnetLen); owner != "" {
+				return nil, core.Errorf("pool %s overlaps with %s", pool, owner)
+			}
+		}
+	}
+
+	subPool := ""
+	if req.SubPool != "" {
+		if _, _, err := poolRange(pool, req.SubPool); err != nil {
+			return nil, err
+		}
+		subNet, _ := parsePoolCIDR(req.SubPool)
+		subPool = subNet.String()
+	}
+
+	poolCfg := &mastercfg.CfgIpamPoolState{}
+	poolCfg.StateDriver = stateDriver
+	poolID := ipamPoolID(networkID, pool, subPool)
+	if err := poolCfg.Read(poolID); err == nil {
+		// docker networks of each epg share the pool of the network
+		poolCfg.RefCount++
+		return poolCfg, poolCfg.Write()
+	}
+
+	poolCfg = &mastercfg.CfgIpamPoolState{
+		NetworkID:    networkID,
+		Pool:         pool,
+		SubPool:      subPool,
+		IPv6:         req.IPv6,
+		RefCount:     1,
+		AuxAddresses: make(map[string]bool),
+	}
+	poolCfg.ID = poolID
+	poolCfg.StateDriver = stateDriver
+	if networkID == "" {
+		_, subnetLen, _ := netutils.ParseCIDR(pool)
+		netutils.InitSubnetBitset(&poolCfg.IPAllocMap, subnetLen)
+	}
+
+	log.Infof("Created ipam pool %s", poolID)
+
+	return poolCfg, poolCfg.Write()
+}
+
+// ReleaseIpamPool releases a pool, removing it when no docker network uses it
+func ReleaseIpamPool(stateDriver core.StateDriver, poolID string) error {
+	poolCfg, err := readIpamPool(stateDriver, poolID)
+	if err != nil {
+		return err
+	}
+
+	// legacy pool ids were not counted when they were requested
+	if poolID != poolCfg.ID {
+		return nil
+	}
+
+	poolCfg.RefCount--
+	if poolCfg.RefCount > 0 || poolCfg.Legacy {
+		return poolCfg.Write()
+	}
+
+	log.Infof("Removing ipam pool %s", poolID)
+
+	return poolCfg.Clear()
+}
+
+// nextFreeAddress returns the first free index of a range of a bitmap
+func nextFreeAddress(p ipv4Pool, first, last uint) (uint, error) {
+	idx, found := p.allocMap.NextClear(first)
+	if !found || idx > last || idx >= p.size() {
+		return 0, core.Errorf("address exhaustion in pool %s/%d", p.subnetIP, p.subnetLen)
+	}
+
+	return idx, nil
+}
+
+// ipamAllocAddress allocates an address from a pool, returning it with the
+// prefix length of the pool. Addresses of network pools come from the
+// network; explicitly requested addresses are reserved as auxiliary addresses
+// until an endpoint is created with them.
+// The owner gets its sticky address unless another address was requested.
+func ipamAllocAddress(stateDriver core.StateDriver, poolID, reqAddr, owner string, isGateway bool) (string, error) {
+	poolCfg, err := readIpamPool(stateDriver, poolID)
+	if err != nil {
+		return "", err
+	}
+
+	subnetIP, subnetLen, _ := netutils.ParseCIDR(poolCfg.Pool)
+	first, last := uint(0), ^uint(0)
+	if poolCfg.SubPool != "" && !isGateway {
+		first, last, _ = poolRange(poolCfg.Pool, poolCfg.SubPool)
+	}
+
+	var addr string
+	if poolCfg.NetworkID == "" {
+		p := ipv4Pool{subnetIP: subnetIP, subnetLen: subnetLen, allocMap: &poolCfg.IPAllocMap}
+		var idx uint
+		if reqAddr != "" {
+			idx, err = netutils.GetIPNumber(subnetIP, subnetLen, 32, reqAddr)
+			if err != nil {
+				return "", err
+			}
+			if p.allocMap.Test(idx) {
+				return "", core.Errorf("address %s is already in use in pool %s", reqAddr, poolCfg.Pool)
+			}
+		} else if idx, err = nextFreeAddress(p, first, last); err != nil {
+			return "", err
+		}
+
+		p.set(idx)
+		addr, _ = netutils.GetSubnetIP(subnetIP, subnetLen, 32, idx)
+	} else {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(poolCfg.NetworkID); err != nil {
+			log.Errorf("network %s is not operational", poolCfg.NetworkID)
+			return "", err
+		}
+
+		if isGateway {
+			// the gateway of the network is allocated with it
+			gateway := reqAddr
+			if gateway == "" && poolCfg.IPv6 {
+				gateway = nwCfg.IPv6Gateway
+			} else if gateway == "" {
+				gateway = findIPv4Pool(nwCfg, subnetIP).gateway
+			}
+			if gateway == "" {
+				return poolCfg.Pool, nil
+			}
+			return fmt.Sprintf("%s/%d", gateway, subnetLen), nil
+		}
+
+		if reqAddr == "" && !poolCfg.IPv6 {
+			reqAddr = stickyAddress(nwCfg, owner)
+		}
+
+		switch {
+		case reqAddr != "":
+			// reserved addresses are always set in the bitmap
+			if findReservation(nwCfg, reqAddr) == nil && networkAddressInUse(nwCfg, reqAddr) {
+				return "", core.Errorf("address %s is already in use in network %s", reqAddr, nwCfg.ID)
+			}
+			if err = checkReservedAddress(nwCfg, reqAddr, owner); err != nil {
+				return "", err
+			}
+			addr, err = networkAllocAddress(nwCfg, reqAddr, poolCfg.IPv6)
+		case poolCfg.SubPool != "":
+			var idx uint
+			p := findIPv4Pool(nwCfg, subnetIP)
+			if idx, err = nextFreeAddress(p, first, last); err != nil {
+				return "", err
+			}
+			addr, _ = netutils.GetSubnetIP(p.subnetIP, p.subnetLen, 32, idx)
+			// an address picked here is still an automatic allocation
+			nwCfg.EpAddrCount++
+			addr, err = networkAllocAddress(nwCfg, addr, false)
+		default:
+			addr, err = networkAllocAddress(nwCfg, "", poolCfg.IPv6)
+		}
+		if err != nil {
+			log.Errorf("Failed to allocate address from pool %s. Err: %v", poolID, err)
+			return "", err
+		}
+	}
+
+	if reqAddr != "" {
+		poolCfg.AuxAddresses[reqAddr] = true
+	}
+	if err := poolCfg.Write(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%d", addr, subnetLen), nil
+}
+
+// networkAddressInUse checks if an address of a network is allocated
+func networkAddressInUse(nwCfg *mastercfg.CfgNetworkState, ipAddress string) bool {
+	if netutils.IsIPv6(ipAddress) {
+		hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, ipAddress)
+		return err == nil && nwCfg.IPv6AllocRanges.Test(hostID)
+	}
+
+	p := findIPv4Pool(nwCfg, ipAddress)
+	idx, err := netutils.GetIPNumber(p.subnetIP, p.subnetLen, 32, ipAddress)
+	return err == nil && p.allocMap.Test(idx)
+}
+
+// ipamReleaseAddress releases an address of a pool. Addresses of network
+// pools that endpoints were created with are released with the endpoint.
+func ipamReleaseAddress(stateDriver core.StateDriver, poolID, ipAddress string) error {
+	poolCfg, err := readIpamPool(stateDriver, poolID)
+	if err != nil {
+		return err
+	}
+
+	ipAddress = strings.Split(ipAddress, "/")[0]
+	if poolCfg.NetworkID == "" {
+		subnetIP, subnetLen, _ := netutils.ParseCIDR(poolCfg.Pool)
+		idx, err := netutils.GetIPNumber(subnetIP, subnetLen, 32, ipAddress)
+		if err != nil {
+			return err
+		}
+		poolCfg.IPAllocMap.Clear(idx)
+	} else if poolCfg.AuxAddresses[ipAddress] {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		if err := nwCfg.Read(poolCfg.NetworkID); err != nil {
+			log.Errorf("network %s is not operational", poolCfg.NetworkID)
+			return err
+		}
+		if err := networkReleaseAddress(nwCfg, ipAddress); err != nil {
+			return err
+		}
+	} else {
+		return nil
+	}
+
+	delete(poolCfg.AuxAddresses, ipAddress)
+
+	return poolCfg.Write()
+}
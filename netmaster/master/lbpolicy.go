@@ -0,0 +1,129 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// limits of service load balancing
+const (
+	defaultAffinityTimeout = 10800 // seconds
+	maxAffinityTimeout     = 86400
+	maxProviderWeight      = 100
+)
+
+// session affinities of a service
+const (
+	sessionAffinityNone     = "none"
+	sessionAffinityClientIP = "clientip"
+)
+
+// getLBPolicy returns the load balancing policy of a service, nil if the
+// service uses the default of least connections without affinity
+func getLBPolicy(cfg *intent.ConfigLBPolicy) (*mastercfg.LBPolicy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	policy := &mastercfg.LBPolicy{Mode: cfg.Mode}
+	switch cfg.Mode {
+	case "":
+		policy.Mode = mastercfg.LBModeLeastConn
+	case mastercfg.LBModeLeastConn, mastercfg.LBModeRoundRobin:
+	default:
+		return nil, core.Errorf("invalid load balancing mode %s, expecting roundrobin or leastconn", cfg.Mode)
+	}
+
+	switch cfg.SessionAffinity {
+	case "", sessionAffinityNone:
+	case sessionAffinityClientIP:
+		policy.ClientIPAffinity = true
+	default:
+		return nil, core.Errorf("invalid session affinity %s, expecting none or clientip", cfg.SessionAffinity)
+	}
+
+	if cfg.AffinityTimeout < 0 || cfg.AffinityTimeout > maxAffinityTimeout {
+		return nil, core.Errorf("invalid session affinity timeout %d", cfg.AffinityTimeout)
+	}
+	if cfg.AffinityTimeout != 0 && !policy.ClientIPAffinity {
+		return nil, core.Errorf("session affinity timeout requires clientip session affinity")
+	}
+	if policy.ClientIPAffinity {
+		policy.AffinityTimeout = cfg.AffinityTimeout
+		if policy.AffinityTimeout == 0 {
+			policy.AffinityTimeout = defaultAffinityTimeout
+		}
+	}
+
+	for _, weight := range cfg.Weights {
+		providerWeight, err := parseProviderWeight(weight)
+		if err != nil {
+			return nil, err
+		}
+		policy.Weights = append(policy.Weights, *providerWeight)
+	}
+
+	if reflect.DeepEqual(policy, &mastercfg.LBPolicy{Mode: mastercfg.LBModeLeastConn}) {
+		return nil, nil
+	}
+
+	return policy, nil
+}
+
+// parseProviderWeight parses a provider weight given as selector:weight,
+// e.g. "track=canary:1" or "version in (2,3):5"
+func parseProviderWeight(weight string) (*mastercfg.ProviderWeight, error) {
+	idx := strings.LastIndex(weight, ":")
+	if idx <= 0 {
+		return nil, core.Errorf("invalid provider weight %s, expecting selector:weight", weight)
+	}
+
+	value, err := strconv.Atoi(strings.TrimSpace(weight[idx+1:]))
+	if err != nil || value < 1 || value > maxProviderWeight {
+		return nil, core.Errorf("invalid provider weight %s, expecting 1 to %d",
+			weight, maxProviderWeight)
+	}
+
+	expr, err := ParseSelector(weight[:idx])
+	if err != nil {
+		return nil, err
+	}
+
+	return &mastercfg.ProviderWeight{Selector: *expr, Weight: value}, nil
+}
+
+// providerWeight returns the weight of a provider of a service, the weight
+// of the first selector matching its labels or 1
+func providerWeight(service *mastercfg.ServiceLBInfo, provider *mastercfg.Provider) int {
+	if service.LBPolicy == nil {
+		return 1
+	}
+
+	for idx := range service.LBPolicy.Weights {
+		if exprMatches(&service.LBPolicy.Weights[idx].Selector, provider.Labels) {
+			return service.LBPolicy.Weights[idx].Weight
+		}
+	}
+
+	return 1
+}
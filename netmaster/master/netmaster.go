@@ -17,8 +17,8 @@ package master
 
 import (
 	"errors"
+	"fmt"
 
-	"github.com/cenkalti/backoff"
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/gstate"
 	"github.com/contiv/netplugin/netmaster/intent"
@@ -32,13 +32,14 @@ import (
 
 const (
 	defaultInfraNetName = "infra"
-	defaultSkyDNSImage  = "skynetservices/skydns:latest"
 )
 
 // Run Time config of netmaster
 type nmRunTimeConf struct {
-	clusterMode string
-	dnsEnabled  bool
+	clusterMode   string
+	dnsEnabled    bool
+	ipamSupernet  string
+	ipamSubnetLen uint
 }
 
 var masterRTCfg nmRunTimeConf
@@ -74,8 +75,26 @@ func SetDNSEnabled(dnsEnableFlag bool) error {
 	return nil
 }
 
-func getDNSName(tenantName string) string {
-	return tenantName + "dns"
+// SetIpamSupernet sets the supernet that ipam pools without a subnet are
+// allocated from, in subnets of the given length
+func SetIpamSupernet(supernet string, subnetLen uint) error {
+	if supernet == "" {
+		masterRTCfg.ipamSupernet = ""
+		return nil
+	}
+
+	superIP, superLen, err := netutils.ParseCIDR(supernet)
+	if err != nil || netutils.IsIPv6(superIP) {
+		return core.Errorf("invalid ipv4 supernet %q", supernet)
+	}
+	if subnetLen < superLen || subnetLen > 30 {
+		return core.Errorf("invalid subnet length %d for supernet %s", subnetLen, supernet)
+	}
+
+	log.Infof("Setting ipam supernet to %s, subnet length %d", supernet, subnetLen)
+	masterRTCfg.ipamSupernet = netutils.GetSubnetAddr(superIP, superLen) + fmt.Sprintf("/%d", superLen)
+	masterRTCfg.ipamSubnetLen = subnetLen
+	return nil
 }
 
 func getEpName(networkName string, ep *intent.ConfigEP) string {
@@ -126,6 +145,11 @@ func CreateGlobal(stateDriver core.StateDriver, gc *intent.ConfigGlobal) error {
 		return err
 	}
 
+	err = setServiceVIPPool(stateDriver, gc.ServiceVIPPool)
+	if err != nil {
+		return err
+	}
+
 	masterGc := &mastercfg.GlobConfig{}
 	masterGc.StateDriver = stateDriver
 	masterGc.NwInfraType = gc.NwInfraType
@@ -216,106 +240,133 @@ func CreateTenant(stateDriver core.StateDriver, tenant *intent.ConfigTenant) err
 		return err
 	}
 
-	if IsDNSEnabled() {
-		// start skydns container
-		err = startServiceContainer(tenant.Name)
-		if err != nil {
-			log.Errorf("Error starting service container. Err: %v. Disabling DNS option.", err)
-			SetDNSEnabled(false)
-		}
-	}
+	return nil
+}
 
+// DeleteTenantID deletes a tenant from the state store, by ID.
+func DeleteTenantID(stateDriver core.StateDriver, tenantID string) error {
 	return nil
 }
 
-func startServiceContainer(tenantName string) error {
-	var err error
+// getDNSName returns the name of the skydns container of a tenant, which
+// answered the dns queries of the networks before netplugin did
+func getDNSName(tenantName string) string {
+	return tenantName + "dns"
+}
+
+// removeDNSContainer removes the skydns container of a tenant
+var removeDNSContainer = func(tenantName string) error {
 	docker, err := utils.GetDockerClient()
 	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
 		return err
 	}
 
-	// pull the skydns image if it does not exist
-	imageName := defaultSkyDNSImage
-	_, err = docker.InspectImage(imageName)
-	if err != nil {
-		pullOperation := func() error {
-			err := docker.PullImage(imageName, nil)
-			if err != nil {
-				log.Errorf("Retrying to pull image: %s", imageName)
-				return err
-			}
-			return nil
-		}
+	err = docker.RemoveContainer(getDNSName(tenantName), true, true)
+	if err == dockerclient.ErrNotFound {
+		return nil
+	}
+	return err
+}
 
-		err = backoff.Retry(pullOperation, backoff.NewExponentialBackOff())
-		if err != nil {
-			log.Errorf("Unable to pull image: %s", imageName)
-			return err
+// MigrateSkyDNS moves the networks served by the skydns containers to the
+// name server of netplugin. The dns server of these networks is the
+// address of the skydns endpoint. The containers are removed and the
+// networks take over the address of their endpoint, so the containers
+// started with it keep resolving. The networks get a new address if the
+// endpoint stays. Netmaster must be serving, the endpoints are removed
+// through it.
+func MigrateSkyDNS(stateDriver core.StateDriver) error {
+	skyDNSNets, err := skyDNSNetworks(stateDriver)
+	if err != nil || len(skyDNSNets) == 0 {
+		return err
+	}
+
+	tenants := make(map[string]bool)
+	for _, nwCfg := range skyDNSNets {
+		tenants[nwCfg.Tenant] = true
+	}
+	for tenantName := range tenants {
+		log.Infof("Removing the dns container of tenant %s", tenantName)
+		if err := removeDNSContainer(tenantName); err != nil {
+			log.Errorf("Error removing the dns container of tenant %s. Err: %v", tenantName, err)
 		}
 	}
 
-	containerConfig := &dockerclient.ContainerConfig{
-		Image: imageName,
-		Env: []string{"ETCD_MACHINES=http://172.17.0.1:4001",
-			"SKYDNS_NAMESERVERS=8.8.8.8:53",
-			"SKYDNS_ADDR=0.0.0.0:53",
-			"SKYDNS_DOMAIN=" + tenantName}}
+	addrMutex.Lock()
+	defer addrMutex.Unlock()
 
-	containerID, err := docker.CreateContainer(containerConfig, getDNSName(tenantName), nil)
+	remaining, err := skyDNSNetworks(stateDriver)
 	if err != nil {
-		log.Errorf("Error creating DNS container for tenant: %s. Error: %s", tenantName, err)
 		return err
 	}
 
-	hostConfig := &dockerclient.HostConfig{
-		RestartPolicy: dockerclient.RestartPolicy{Name: "always"}}
+	for _, oldCfg := range skyDNSNets {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = stateDriver
+		err := nwCfg.Read(oldCfg.ID)
+		if err != nil || nwCfg.DNSServer != oldCfg.DNSServer {
+			// the network was deleted or changed meanwhile
+			continue
+		}
 
-	// Start the container
-	err = docker.StartContainer(containerID, hostConfig)
-	if err != nil {
-		log.Errorf("Error starting DNS container for tenant: %s. Error: %s", tenantName, err)
+		dnsServer := ""
+		if IsDNSEnabled() {
+			if remaining[nwCfg.ID] == nil {
+				// take over the address released by the endpoint
+				nwCfg.EpAddrCount++
+				dnsServer, err = networkAllocAddress(nwCfg, nwCfg.DNSServer, false)
+			} else {
+				dnsServer, err = networkAllocAddress(nwCfg, "", false)
+			}
+			if err != nil {
+				log.Errorf("Error allocating the dns server address of network %s. Err: %v", nwCfg.ID, err)
+				continue
+			}
+		}
+
+		log.Infof("Moving the dns server %s of network %s to netplugin at %q",
+			oldCfg.DNSServer, nwCfg.ID, dnsServer)
+		nwCfg.DNSServer = dnsServer
+		err = nwCfg.Write()
+		if err != nil {
+			return err
+		}
 	}
 
-	return err
+	return nil
 }
 
-func stopAndRemoveServiceContainer(tenantName string) error {
-	var err error
-	docker, err := utils.GetDockerClient()
-	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
-		return err
+// skyDNSNetworks returns the networks whose dns server is the address of an
+// endpoint, keyed by network id
+func skyDNSNetworks(stateDriver core.StateDriver) (map[string]*mastercfg.CfgNetworkState, error) {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	nwCfgs, err := nwCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
 	}
 
-	dnsContName := getDNSName(tenantName)
-	// Stop the container
-	err = docker.StopContainer(dnsContName, 10)
-	if err != nil {
-		log.Errorf("Error stopping DNS container for tenant: %s. Error: %s", tenantName, err)
-		return err
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
 	}
-
-	err = docker.RemoveContainer(dnsContName, true, true)
-	if err != nil {
-		log.Errorf("Error removing DNS container for tenant: %s. Error: %s", tenantName, err)
-		return err
+	epAddrs := make(map[string]bool)
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		epAddrs[ep.NetID+"/"+ep.IPAddress] = true
 	}
-	return err
-}
 
-// DeleteTenantID deletes a tenant from the state store, by ID.
-func DeleteTenantID(stateDriver core.StateDriver, tenantID string) error {
-	if IsDNSEnabled() {
-		err := stopAndRemoveServiceContainer(tenantID)
-		if err != nil {
-			log.Errorf("Error in stopping service container for tenant: %+v", tenantID)
-			return err
+	networks := make(map[string]*mastercfg.CfgNetworkState)
+	for _, state := range nwCfgs {
+		nw := state.(*mastercfg.CfgNetworkState)
+		if nw.DNSServer != "" && epAddrs[nw.ID+"/"+nw.DNSServer] {
+			networks[nw.ID] = nw
 		}
 	}
 
-	return nil
+	return networks, nil
 }
 
 // DeleteTenant deletes a tenant from the state store based on its ConfigTenant.
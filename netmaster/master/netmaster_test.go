@@ -17,8 +17,13 @@ package master
 
 import (
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 
@@ -29,6 +34,7 @@ import (
 	"github.com/contiv/netplugin/netmaster/resources"
 	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
 )
 
 var fakeDriver *state.FakeStateDriver
@@ -681,3 +687,1610 @@ func TestGetAllocatedIPs(t *testing.T) {
 		log.Fatalf("got networks '%s' expected '%s'", networks, expectedAllocedIPs)
 	}
 }
+
+func TestTraceEndpoints(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.1/24",
+			"Gateway"			: "10.1.1.254",
+            "Endpoints" : [
+            {
+                "Container"     : "myContainer1",
+                "Host"          : "host1"
+            },
+            {
+                "Container"     : "myContainer2",
+                "Host"          : "host1"
+            },
+            {
+                "Container"     : "myContainer3",
+                "Host"          : "host2"
+            }
+            ]
+        }]
+    },
+    {
+        "Name"                  : "tenant-two",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.1/24",
+			"Gateway"			: "10.1.1.254",
+            "Endpoints" : [
+            {
+                "Container"     : "otherContainer1",
+                "Host"          : "host1"
+            },
+            {
+                "Container"     : "otherContainer2",
+                "Host"          : "host1"
+            },
+            {
+                "Container"     : "otherContainer3",
+                "Host"          : "host1"
+            }
+            ]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	// endpoints on the same host
+	trace, err := TraceEndpoints(fakeDriver, &TraceRequest{
+		SrcEndpoint: "myContainer1",
+		DstEndpoint: "myContainer2",
+	})
+	if err != nil {
+		t.Fatalf("error tracing endpoints. Err: %v", err)
+	}
+	if trace.Path != TracePathLocal || trace.PolicyVerdict != TraceVerdictAllow {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+	if len(trace.TraceCommands) != 1 || trace.TraceCommands[0].Host != "host1" {
+		t.Fatalf("unexpected host traces %+v", trace.TraceCommands)
+	}
+
+	// endpoints on different hosts, destination by ip address
+	dstEp := &mastercfg.CfgEndpointState{}
+	dstEp.StateDriver = fakeDriver
+	if err := dstEp.Read(getEpName("orange.tenant-one", &intent.ConfigEP{Container: "myContainer3"})); err != nil {
+		t.Fatalf("error reading endpoint. Err: %v", err)
+	}
+	trace, err = TraceEndpoints(fakeDriver, &TraceRequest{
+		SrcEndpoint: "myContainer1",
+		DstIP:       dstEp.IPAddress,
+		Protocol:    "tcp",
+		DstPort:     80,
+	})
+	if err != nil {
+		t.Fatalf("error tracing endpoints. Err: %v", err)
+	}
+	if trace.Path == TracePathLocal || trace.Destination.Container != "myContainer3" ||
+		len(trace.TraceCommands) != 2 {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+
+	// the addresses are reused by the other tenant
+	srcEp := &mastercfg.CfgEndpointState{}
+	srcEp.StateDriver = fakeDriver
+	if err := srcEp.Read(getEpName("orange.tenant-one", &intent.ConfigEP{Container: "myContainer1"})); err != nil {
+		t.Fatalf("error reading endpoint. Err: %v", err)
+	}
+	if _, err := TraceEndpoints(fakeDriver, &TraceRequest{SrcIP: srcEp.IPAddress, DstIP: dstEp.IPAddress}); err == nil {
+		t.Fatalf("trace from ip %s used by two tenants succeeded", srcEp.IPAddress)
+	}
+	trace, err = TraceEndpoints(fakeDriver, &TraceRequest{
+		SrcIP:  srcEp.IPAddress,
+		DstIP:  dstEp.IPAddress,
+		Tenant: "tenant-two",
+	})
+	if err != nil {
+		t.Fatalf("error tracing endpoints. Err: %v", err)
+	}
+	if trace.Source.Container != "otherContainer1" || trace.Destination.Container != "otherContainer3" ||
+		trace.Destination.Tenant != "tenant-two" {
+		t.Fatalf("unexpected trace %+v", trace)
+	}
+
+	// service ip is translated to its provider
+	mastercfg.ServiceLBDb["lipService:tenant-one"] = &mastercfg.ServiceLBInfo{
+		ServiceName: "lipService",
+		IPAddress:   "20.1.1.1",
+		Tenant:      "tenant-one",
+		Ports:       []string{"8080:80:TCP"},
+		Providers: map[string]*mastercfg.Provider{
+			dstEp.IPAddress: {IPAddress: dstEp.IPAddress},
+		},
+	}
+	defer delete(mastercfg.ServiceLBDb, "lipService:tenant-one")
+
+	trace, err = TraceEndpoints(fakeDriver, &TraceRequest{
+		SrcEndpoint: "myContainer1",
+		DstIP:       "20.1.1.1",
+		Protocol:    "tcp",
+		DstPort:     8080,
+	})
+	if err != nil {
+		t.Fatalf("error tracing service. Err: %v", err)
+	}
+	if trace.Service == nil || trace.Service.Provider != dstEp.IPAddress ||
+		trace.Service.ProvPort != 80 || trace.Destination.Container != "myContainer3" {
+		t.Fatalf("unexpected service trace %+v", trace)
+	}
+}
+
+func TestTracePolicyVerdict(t *testing.T) {
+	verdict, rule := policyVerdict(nil)
+	if verdict != TraceVerdictAllow || rule != nil {
+		t.Fatalf("unexpected verdict %s of no rules", verdict)
+	}
+
+	rules := []TraceRule{
+		{RuleID: "1", Priority: 1, Action: TraceVerdictDeny},
+		{RuleID: "2", Priority: 5, Action: TraceVerdictAllow},
+		{RuleID: "3", Priority: 5, Action: TraceVerdictDeny},
+	}
+	verdict, rule = policyVerdict(rules)
+	if verdict != TraceVerdictDeny || rule.RuleID != "3" {
+		t.Fatalf("unexpected verdict %s of rule %+v", verdict, rule)
+	}
+
+	verdict, rule = policyVerdict([]TraceRule{
+		{RuleID: "1", Priority: 1, Action: TraceVerdictDeny},
+		{RuleID: "2", Priority: 5, Action: TraceVerdictAllow},
+	})
+	if verdict != TraceVerdictAllow || rule.RuleID != "2" {
+		t.Fatalf("unexpected verdict %s of rule %+v", verdict, rule)
+	}
+}
+
+func TestNetworkSubnetPools(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/30",
+			"Gateway"			: "10.1.1.2"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	networkID := "orange.tenant-one"
+	if err := AddNetworkSubnet(fakeDriver, networkID, "10.1.1.0/24", ""); err == nil {
+		t.Fatalf("overlapping subnet was added")
+	}
+	if err := AddNetworkSubnet(fakeDriver, networkID, "10.1.2.0/24", "10.1.2.254"); err != nil {
+		t.Fatalf("error adding subnet. Err: %v", err)
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+
+	// primary subnet is used first, then the additional subnet
+	for _, expIP := range []string{"10.1.1.1", "10.1.2.1"} {
+		ipAddr, err := networkAllocAddress(nwCfg, "", false)
+		if err != nil {
+			t.Fatalf("error allocating address. Err: %v", err)
+		}
+		if ipAddr != expIP {
+			t.Fatalf("got address %s expected %s", ipAddr, expIP)
+		}
+	}
+
+	if _, err := networkAllocAddress(nwCfg, "10.1.2.10", false); err != nil {
+		t.Fatalf("error allocating requested address. Err: %v", err)
+	}
+
+	expectedAllocedIPs := "10.1.1.1-10.1.1.2, 10.1.2.1, 10.1.2.10, 10.1.2.254"
+	if allocated := ListAllocatedIPs(nwCfg); allocated != expectedAllocedIPs {
+		t.Fatalf("got allocated IPs '%s' expected '%s'", allocated, expectedAllocedIPs)
+	}
+
+	pools := ListSubnetPools(nwCfg)
+	if len(pools) != 2 || !strings.Contains(pools[1], "10.1.2.0/24 gateway 10.1.2.254 allocated 2") {
+		t.Fatalf("unexpected subnet pools %v", pools)
+	}
+
+	if err := DeleteNetworkSubnet(fakeDriver, networkID, "10.1.2.0/24"); err == nil {
+		t.Fatalf("subnet with allocated addresses was removed")
+	}
+
+	for _, ipAddr := range []string{"10.1.2.1", "10.1.2.10"} {
+		if err := networkReleaseAddress(nwCfg, ipAddr); err != nil {
+			t.Fatalf("error releasing address %s. Err: %v", ipAddr, err)
+		}
+	}
+
+	if err := DeleteNetworkSubnet(fakeDriver, networkID, "10.1.2.0/24"); err != nil {
+		t.Fatalf("error removing subnet. Err: %v", err)
+	}
+	if err := DeleteNetworkSubnet(fakeDriver, networkID, "10.1.1.0/30"); err == nil {
+		t.Fatalf("primary subnet was removed")
+	}
+
+	// exhaustion is reported for all the subnets
+	if err := AddNetworkSubnet(fakeDriver, networkID, "10.1.3.0/30", "10.1.3.2"); err != nil {
+		t.Fatalf("error adding subnet. Err: %v", err)
+	}
+	nwCfg = &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+	var err error
+	for idx := 0; idx < 8 && err == nil; idx++ {
+		_, err = networkAllocAddress(nwCfg, "", false)
+	}
+	if err == nil || !strings.Contains(err.Error(), "subnets 10.1.1.0/30, 10.1.3.0/30") {
+		t.Fatalf("unexpected exhaustion error %v", err)
+	}
+}
+
+func TestIPReservations(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/28",
+			"Gateway"			: "10.1.1.14"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	networkID := "orange.tenant-one"
+	err := AddIPReservation(fakeDriver, networkID, &mastercfg.IPReservation{Name: "infra", IPRange: "10.1.1.1-10.1.1.3"})
+	if err != nil {
+		t.Fatalf("error adding exclude range. Err: %v", err)
+	}
+	err = AddIPReservation(fakeDriver, networkID, &mastercfg.IPReservation{Name: "web", Owner: "web1"})
+	if err != nil {
+		t.Fatalf("error adding sticky address. Err: %v", err)
+	}
+	err = AddIPReservation(fakeDriver, networkID, &mastercfg.IPReservation{Name: "gw", IPRange: "10.1.1.14"})
+	if err == nil {
+		t.Fatalf("reservation of the gateway was added")
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+
+	// sticky address is picked after the exclude range
+	if ipAddr := stickyAddress(nwCfg, "web1"); ipAddr != "10.1.1.4" {
+		t.Fatalf("got sticky address %s expected 10.1.1.4", ipAddr)
+	}
+
+	ipAddr, err := networkAllocAddress(nwCfg, "", false)
+	if err != nil || ipAddr != "10.1.1.5" {
+		t.Fatalf("got address %s expected 10.1.1.5. Err: %v", ipAddr, err)
+	}
+	if _, err := networkAllocAddress(nwCfg, "10.1.1.2", false); err == nil {
+		t.Fatalf("address in an exclude range was allocated")
+	}
+
+	// sticky address stays reserved after the owner releases it
+	if _, err := networkAllocAddress(nwCfg, "10.1.1.4", false); err != nil {
+		t.Fatalf("error allocating sticky address. Err: %v", err)
+	}
+	if err := networkReleaseAddress(nwCfg, "10.1.1.4"); err != nil {
+		t.Fatalf("error releasing sticky address. Err: %v", err)
+	}
+	ipAddr, err = networkAllocAddress(nwCfg, "", false)
+	if err != nil || ipAddr != "10.1.1.6" {
+		t.Fatalf("got address %s expected 10.1.1.6. Err: %v", ipAddr, err)
+	}
+
+	expectedResv := []string{"infra: 10.1.1.1-10.1.1.3 excluded", "web: 10.1.1.4 owner web1"}
+	if resv := ListIPReservations(nwCfg); !reflect.DeepEqual(resv, expectedResv) {
+		t.Fatalf("got reservations %v expected %v", resv, expectedResv)
+	}
+
+	err = AddIPReservation(fakeDriver, networkID, &mastercfg.IPReservation{Name: "db", IPAddress: "10.1.1.5", Owner: "db1"})
+	if err == nil {
+		t.Fatalf("allocated address was reserved")
+	}
+
+	if err := DeleteIPReservation(fakeDriver, networkID, "infra"); err != nil {
+		t.Fatalf("error removing exclude range. Err: %v", err)
+	}
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+	ipAddr, err = networkAllocAddress(nwCfg, "", false)
+	if err != nil || ipAddr != "10.1.1.1" {
+		t.Fatalf("got address %s expected 10.1.1.1. Err: %v", ipAddr, err)
+	}
+}
+
+func TestStickyAddressEndpoint(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/28",
+			"Gateway"			: "10.1.1.14"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	networkID := "orange.tenant-one"
+	err := AddIPReservation(fakeDriver, networkID, &mastercfg.IPReservation{Name: "web", Owner: "web1"})
+	if err != nil {
+		t.Fatalf("error adding sticky address. Err: %v", err)
+	}
+
+	createEP := func(container, owner, ipAddr string) (*mastercfg.CfgEndpointState, error) {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Read(networkID); err != nil {
+			t.Fatalf("unable to locate network: %s", networkID)
+		}
+		return CreateEndpoint(fakeDriver, nwCfg, &intent.ConfigEP{Container: container, Owner: owner, IPAddress: ipAddr})
+	}
+
+	// the pod gets its sticky address back when it is recreated
+	epCfg, err := createEP("pod1", "web1", "")
+	if err != nil || epCfg.IPAddress != "10.1.1.1" {
+		t.Fatalf("got address %+v expected 10.1.1.1. Err: %v", epCfg, err)
+	}
+	if _, err := createEP("pod2", "web1", ""); err == nil {
+		t.Fatalf("sticky address in use was allocated to another endpoint")
+	}
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint %s. Err: %v", epCfg.ID, err)
+	}
+	epCfg, err = createEP("pod2", "web1", "")
+	if err != nil || epCfg.IPAddress != "10.1.1.1" {
+		t.Fatalf("got address %+v expected 10.1.1.1. Err: %v", epCfg, err)
+	}
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint %s. Err: %v", epCfg.ID, err)
+	}
+
+	// other owners can not request the sticky address
+	if _, err := createEP("pod3", "db1", "10.1.1.1"); err == nil {
+		t.Fatalf("sticky address of web1 was allocated to db1")
+	}
+	epCfg, err = createEP("pod3", "db1", "")
+	if err != nil || epCfg.IPAddress != "10.1.1.2" {
+		t.Fatalf("got address %+v expected 10.1.1.2. Err: %v", epCfg, err)
+	}
+}
+
+func TestNetworkIPv6Allocation(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"IPv6SubnetCIDR"	: "2016:abc::/120",
+			"IPv6Gateway"		: "2016:abc::1"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	networkID := "orange.tenant-one"
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read(networkID); err != nil {
+		t.Fatalf("unable to locate network: %s", networkID)
+	}
+
+	for _, expIP := range []string{"2016:abc::2", "2016:abc::3", "2016:abc::4"} {
+		ipAddr, err := networkAllocAddress(nwCfg, "", true)
+		if err != nil || ipAddr != expIP {
+			t.Fatalf("got address %s expected %s. Err: %v", ipAddr, expIP, err)
+		}
+	}
+	if err := networkReleaseAddress(nwCfg, "2016:abc::3"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+
+	expectedAllocedIPs := "2016:abc::1-2016:abc::2, 2016:abc::4"
+	if allocated := ListAllocatedIPv6s(nwCfg); allocated != expectedAllocedIPs {
+		t.Fatalf("got allocated IPs '%s' expected '%s'", allocated, expectedAllocedIPs)
+	}
+
+	// host ids allocated by older versions are converted to ranges
+	legacyCfg := []byte(`{"id":"legacy","ipv6SubnetIP":"2016:abc::","ipv6SubnetLen":120,
+		"ipv6AllocMap":{"::1":true,"::2":true,"::3":true,"::7":true}}`)
+	legacyNw := &mastercfg.CfgNetworkState{}
+	if err := json.Unmarshal(legacyCfg, legacyNw); err != nil {
+		t.Fatalf("error decoding legacy network state. Err: %v", err)
+	}
+	expRanges := netutils.IPv6RangeSet{{Start: "::1", End: "::3"}, {Start: "::7", End: "::7"}}
+	if !reflect.DeepEqual(legacyNw.IPv6AllocRanges, expRanges) {
+		t.Fatalf("got ranges %v expected %v", legacyNw.IPv6AllocRanges, expRanges)
+	}
+}
+
+func TestIpamPools(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"Gateway"			: "10.1.1.254"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	// pool of the network, with a sub-pool
+	pool, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{TenantName: "tenant-one",
+		NetworkName: "orange", Pool: "10.1.1.0/24", SubPool: "10.1.1.128/25"})
+	if err != nil {
+		t.Fatalf("error requesting pool. Err: %v", err)
+	}
+	if pool.ID != "orange.tenant-one:10.1.1.0-24:10.1.1.128-25" {
+		t.Fatalf("got pool id %s", pool.ID)
+	}
+	if _, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{TenantName: "tenant-one",
+		NetworkName: "orange", Pool: "10.1.1.0/24", SubPool: "10.1.2.0/25"}); err == nil {
+		t.Fatalf("sub-pool outside of the pool was accepted")
+	}
+	if _, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{TenantName: "tenant-one",
+		NetworkName: "orange", Pool: "10.1.2.0/24"}); err == nil {
+		t.Fatalf("pool outside of the network was accepted")
+	}
+
+	gw, err := ipamAllocAddress(fakeDriver, pool.ID, "", "", true)
+	if err != nil || gw != "10.1.1.254/24" {
+		t.Fatalf("got gateway %s expected 10.1.1.254/24. Err: %v", gw, err)
+	}
+	addr, err := ipamAllocAddress(fakeDriver, pool.ID, "", "", false)
+	if err != nil || addr != "10.1.1.128/24" {
+		t.Fatalf("got address %s expected 10.1.1.128/24. Err: %v", addr, err)
+	}
+
+	// auxiliary addresses are reserved in the network
+	if _, err := ipamAllocAddress(fakeDriver, pool.ID, "10.1.1.10", "", false); err != nil {
+		t.Fatalf("error reserving aux address. Err: %v", err)
+	}
+	if _, err := ipamAllocAddress(fakeDriver, pool.ID, "10.1.1.10", "", false); err == nil {
+		t.Fatalf("aux address was allocated twice")
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if !networkAddressInUse(nwCfg, "10.1.1.10") || nwCfg.EpAddrCount != 1 {
+		t.Fatalf("aux address not reserved, address count %d", nwCfg.EpAddrCount)
+	}
+	if err := ipamReleaseAddress(fakeDriver, pool.ID, "10.1.1.10"); err != nil {
+		t.Fatalf("error releasing aux address. Err: %v", err)
+	}
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if networkAddressInUse(nwCfg, "10.1.1.10") {
+		t.Fatalf("aux address still reserved after release")
+	}
+
+	// standalone pools may not overlap networks or other pools
+	if _, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{Pool: "10.1.0.0/16"}); err == nil {
+		t.Fatalf("pool overlapping a network was accepted")
+	}
+	if _, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{}); err == nil {
+		t.Fatalf("pool was allocated without a supernet")
+	}
+	if err := SetIpamSupernet("10.1.0.0/22", 24); err != nil {
+		t.Fatalf("error setting supernet. Err: %v", err)
+	}
+	defer SetIpamSupernet("", 0)
+
+	standalone, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{})
+	if err != nil || standalone.Pool != "10.1.0.0/24" {
+		t.Fatalf("got pool %+v. Err: %v", standalone, err)
+	}
+	if _, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{Pool: "10.1.0.128/25"}); err == nil {
+		t.Fatalf("pool overlapping a pool was accepted")
+	}
+	next, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{})
+	if err != nil || next.Pool != "10.1.2.0/24" {
+		t.Fatalf("got pool %+v. Err: %v", next, err)
+	}
+	addr, err = ipamAllocAddress(fakeDriver, standalone.ID, "", "", false)
+	if err != nil || addr != "10.1.0.1/24" {
+		t.Fatalf("got address %s expected 10.1.0.1/24. Err: %v", addr, err)
+	}
+	if err := ipamReleaseAddress(fakeDriver, standalone.ID, "10.1.0.1"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+
+	// docker networks of each epg share the pool of the network
+	if _, err := RequestIpamPool(fakeDriver, &IpamPoolRequest{TenantName: "tenant-one",
+		NetworkName: "orange", SubPool: "10.1.1.128/25"}); err != nil {
+		t.Fatalf("error requesting pool again. Err: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		verifyKeys(t, []string{mastercfg.StateConfigPath + "ipamPools/" + pool.ID})
+		if err := ReleaseIpamPool(fakeDriver, pool.ID); err != nil {
+			t.Fatalf("error releasing pool. Err: %v", err)
+		}
+	}
+	verifyKeysDoNotExist(t, []string{mastercfg.StateConfigPath + "ipamPools/" + pool.ID})
+}
+
+func TestIpamPoolEndpoints(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"Gateway"			: "10.1.1.254"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	networkID := "orange.tenant-one"
+	createEP := func(container, ipAddr string) *mastercfg.CfgEndpointState {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Read(networkID); err != nil {
+			t.Fatalf("unable to locate network: %s", networkID)
+		}
+		epCfg, err := CreateEndpoint(fakeDriver, nwCfg, &intent.ConfigEP{Container: container, IPAddress: ipAddr})
+		if err != nil {
+			t.Fatalf("error creating endpoint %s. Err: %v", container, err)
+		}
+		return epCfg
+	}
+	addrInUse := func(ipAddr string) bool {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Read(networkID); err != nil {
+			t.Fatalf("unable to locate network: %s", networkID)
+		}
+		return networkAddressInUse(nwCfg, ipAddr)
+	}
+
+	// docker networks created before the pools were managed pass the subnet
+	legacyID := networkID + ":10.1.1.0/24"
+	addr, err := ipamAllocAddress(fakeDriver, legacyID, "10.1.1.20", "", false)
+	if err != nil || addr != "10.1.1.20/24" {
+		t.Fatalf("got address %s from legacy pool. Err: %v", addr, err)
+	}
+	poolKey := mastercfg.StateConfigPath + "ipamPools/" + ipamPoolID(networkID, "10.1.1.0/24", "")
+	verifyKeys(t, []string{poolKey})
+
+	// the address of the endpoint is released with it, not by docker
+	epCfg := createEP("web1", "10.1.1.20")
+	if _, err := DeleteEndpointID(fakeDriver, epCfg.ID); err != nil {
+		t.Fatalf("error deleting endpoint %s. Err: %v", epCfg.ID, err)
+	}
+	if addrInUse("10.1.1.20") {
+		t.Fatalf("address of deleted endpoint still in use")
+	}
+	if _, err := ipamAllocAddress(fakeDriver, legacyID, "10.1.1.20", "", false); err != nil {
+		t.Fatalf("error requesting released address. Err: %v", err)
+	}
+	createEP("web2", "10.1.1.20")
+	if err := ipamReleaseAddress(fakeDriver, legacyID, "10.1.1.20"); err != nil {
+		t.Fatalf("error releasing address. Err: %v", err)
+	}
+	if !addrInUse("10.1.1.20") {
+		t.Fatalf("address of web2 was released by the release of web1")
+	}
+
+	// legacy pools are not reference counted and go away with the network
+	if err := ReleaseIpamPool(fakeDriver, legacyID); err != nil {
+		t.Fatalf("error releasing legacy pool. Err: %v", err)
+	}
+	verifyKeys(t, []string{poolKey})
+	if err := clearIpamPools(fakeDriver, networkID); err != nil {
+		t.Fatalf("error removing pools. Err: %v", err)
+	}
+	verifyKeysDoNotExist(t, []string{poolKey})
+}
+
+func TestNetworkContainerConfig(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"Gateway"			: "10.1.1.254",
+			"IPv6SubnetCIDR"	: "2016:1::/64",
+			"StaticRoutes"		: ["10.2.0.0/16", "10.3.0.1/16 via 10.1.1.253", "2016:2::/64 via 2016:1::fe"],
+			"DNSServers"		: ["10.1.1.53"],
+			"DNSSearch"			: ["orange.tenant-one"],
+			"DNSOptions"		: ["ndots:2"]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+
+	expRoutes := []mastercfg.StaticRoute{
+		{Destination: "10.2.0.0/16"},
+		{Destination: "10.3.0.0/16", NextHop: "10.1.1.253"},
+		{Destination: "2016:2::/64", NextHop: "2016:1::fe"},
+	}
+	if !reflect.DeepEqual(nwCfg.StaticRoutes, expRoutes) {
+		t.Fatalf("got routes %+v expected %+v", nwCfg.StaticRoutes, expRoutes)
+	}
+	if len(nwCfg.DNSServers) != 1 || len(nwCfg.DNSSearch) != 1 || len(nwCfg.DNSOptions) != 1 {
+		t.Fatalf("unexpected dns config %v %v %v", nwCfg.DNSServers, nwCfg.DNSSearch, nwCfg.DNSOptions)
+	}
+
+	for _, network := range []intent.ConfigNetwork{
+		{Name: "bad-route", SubnetCIDR: "10.4.1.0/24", StaticRoutes: []string{"10.2.0.0/16 10.4.1.1"}},
+		{Name: "bad-nexthop", SubnetCIDR: "10.4.1.0/24", StaticRoutes: []string{"10.2.0.0/16 via 10.5.1.1"}},
+		{Name: "no-ipv6", SubnetCIDR: "10.4.1.0/24", StaticRoutes: []string{"2016:2::/64"}},
+		{Name: "bad-dns", SubnetCIDR: "10.4.1.0/24", DNSServers: []string{"dns.local"}},
+	} {
+		if err := CreateNetwork(network, fakeDriver, "tenant-one"); err == nil {
+			t.Errorf("network %s with invalid container config was created", network.Name)
+		}
+	}
+}
+
+func TestNetworkDNSServer(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"Gateway"			: "10.1.1.254"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+	SetDNSEnabled(true)
+	defer SetDNSEnabled(false)
+
+	applyConfig(t, cfgBytes)
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("unable to locate network. Err: %v", err)
+	}
+	if nwCfg.DNSServer != "10.1.1.1" || !networkAddressInUse(nwCfg, nwCfg.DNSServer) {
+		t.Fatalf("dns server address not reserved, got %q", nwCfg.DNSServer)
+	}
+
+	// the dns server is not an endpoint of the network
+	if err := DeleteNetworkID(fakeDriver, nwCfg.ID); err != nil {
+		t.Fatalf("error deleting network with dns server. Err: %v", err)
+	}
+}
+
+func TestMigrateSkyDNS(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"Gateway"			: "10.1.1.254"
+        },
+        {
+            "Name"              : "purple",
+			"SubnetCIDR"		: "10.1.2.0/24",
+			"Gateway"			: "10.1.2.254"
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	readNetwork := func(networkID string) *mastercfg.CfgNetworkState {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = fakeDriver
+		if err := nwCfg.Read(networkID); err != nil {
+			t.Fatalf("unable to locate network %s. Err: %v", networkID, err)
+		}
+		return nwCfg
+	}
+
+	// the dns server of the networks is the endpoint of the skydns container
+	dnsEPs := make(map[string]*mastercfg.CfgEndpointState)
+	for _, networkID := range []string{"orange.tenant-one", "purple.tenant-one"} {
+		nwCfg := readNetwork(networkID)
+		epCfg, err := CreateEndpoint(fakeDriver, nwCfg, &intent.ConfigEP{Container: getDNSName("tenant-one")})
+		if err != nil {
+			t.Fatalf("error creating dns endpoint. Err: %v", err)
+		}
+		nwCfg = readNetwork(networkID)
+		nwCfg.DNSServer = epCfg.IPAddress
+		if err := nwCfg.Write(); err != nil {
+			t.Fatalf("error writing network %s. Err: %v", networkID, err)
+		}
+		dnsEPs[networkID] = epCfg
+	}
+
+	// the endpoint of purple stays
+	removed := []string{}
+	defer func(remove func(string) error) { removeDNSContainer = remove }(removeDNSContainer)
+	removeDNSContainer = func(tenantName string) error {
+		removed = append(removed, tenantName)
+		_, err := DeleteEndpointID(fakeDriver, dnsEPs["orange.tenant-one"].ID)
+		return err
+	}
+
+	SetDNSEnabled(true)
+	defer SetDNSEnabled(false)
+	if err := MigrateSkyDNS(fakeDriver); err != nil {
+		t.Fatalf("error migrating the dns servers. Err: %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"tenant-one"}) {
+		t.Fatalf("removed the dns containers of %v, expected tenant-one", removed)
+	}
+
+	nwCfg := readNetwork("orange.tenant-one")
+	if nwCfg.DNSServer != dnsEPs["orange.tenant-one"].IPAddress || !networkAddressInUse(nwCfg, nwCfg.DNSServer) {
+		t.Fatalf("dns server %q did not take over address %s", nwCfg.DNSServer, dnsEPs["orange.tenant-one"].IPAddress)
+	}
+	nwCfg = readNetwork("purple.tenant-one")
+	if nwCfg.DNSServer == "" || nwCfg.DNSServer == dnsEPs["purple.tenant-one"].IPAddress ||
+		!networkAddressInUse(nwCfg, nwCfg.DNSServer) {
+		t.Fatalf("dns server %q of purple is not a new address", nwCfg.DNSServer)
+	}
+
+	// migrated networks are left alone
+	removed = []string{}
+	if err := MigrateSkyDNS(fakeDriver); err != nil || len(removed) != 0 {
+		t.Fatalf("migrated networks again, removed %v. Err: %v", removed, err)
+	}
+}
+
+func TestBgpConfig(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	bgpCfg := &intent.ConfigBgp{
+		Hostname:   "host1",
+		RouterIP:   "50.1.1.1/24",
+		As:         "65002",
+		Neighbor:   "50.1.1.2",
+		NeighborAs: "500",
+		Neighbors: []intent.ConfigBgpNeighbor{
+			{Address: "50.1.1.3", As: "600", Password: "secret"},
+			{Address: "50.1.1.4", As: "700", Password: "pass:word"},
+		},
+		HoldTime:       30,
+		Keepalive:      10,
+		ExportPrefixes: []string{"10.1.0.0/16"},
+		ExportTenants:  []string{"blue"},
+	}
+	if err := AddBgp(fakeDriver, bgpCfg); err != nil {
+		t.Fatalf("error adding bgp config. Err: %v", err)
+	}
+
+	bgpState := &mastercfg.CfgBgpState{}
+	bgpState.StateDriver = fakeDriver
+	if err := bgpState.Read("host1"); err != nil {
+		t.Fatalf("error reading bgp config. Err: %v", err)
+	}
+	expNeighbors := []mastercfg.BgpNeighbor{
+		{Address: "50.1.1.2", As: "500"},
+		{Address: "50.1.1.3", As: "600", Password: "secret"},
+		{Address: "50.1.1.4", As: "700", Password: "pass:word"},
+	}
+	if !reflect.DeepEqual(bgpState.BgpNeighbors(), expNeighbors) {
+		t.Fatalf("unexpected bgp neighbors %+v", bgpState.BgpNeighbors())
+	}
+	if bgpState.HoldTime != 30 || bgpState.Keepalive != 10 ||
+		!reflect.DeepEqual(bgpState.ExportTenants, []string{"blue"}) {
+		t.Fatalf("unexpected bgp config %+v", bgpState)
+	}
+
+	// updates without the passwords keep them
+	bgpCfg.Neighbors = []intent.ConfigBgpNeighbor{
+		{Address: "50.1.1.3", As: "600"},
+		{Address: "50.1.1.4", As: "700", Password: "changed"},
+		{Address: "50.1.1.5", As: "800"},
+	}
+	if err := AddBgp(fakeDriver, bgpCfg); err != nil {
+		t.Fatalf("error updating bgp config. Err: %v", err)
+	}
+	if err := bgpState.Read("host1"); err != nil {
+		t.Fatalf("error reading bgp config. Err: %v", err)
+	}
+	expNeighbors = []mastercfg.BgpNeighbor{
+		{Address: "50.1.1.2", As: "500"},
+		{Address: "50.1.1.3", As: "600", Password: "secret"},
+		{Address: "50.1.1.4", As: "700", Password: "changed"},
+		{Address: "50.1.1.5", As: "800"},
+	}
+	if !reflect.DeepEqual(bgpState.BgpNeighbors(), expNeighbors) {
+		t.Fatalf("unexpected bgp neighbors after update %+v", bgpState.BgpNeighbors())
+	}
+
+	// the passwords are removed explicitly
+	bgpCfg.Neighbors = []intent.ConfigBgpNeighbor{
+		{Address: "50.1.1.3", As: "600", ClearPassword: true},
+		{Address: "50.1.1.4", As: "700"},
+	}
+	if err := AddBgp(fakeDriver, bgpCfg); err != nil {
+		t.Fatalf("error updating bgp config. Err: %v", err)
+	}
+	bgpState = &mastercfg.CfgBgpState{}
+	bgpState.StateDriver = fakeDriver
+	if err := bgpState.Read("host1"); err != nil {
+		t.Fatalf("error reading bgp config. Err: %v", err)
+	}
+	expNeighbors = []mastercfg.BgpNeighbor{
+		{Address: "50.1.1.2", As: "500"},
+		{Address: "50.1.1.3", As: "600"},
+		{Address: "50.1.1.4", As: "700", Password: "changed"},
+	}
+	if !reflect.DeepEqual(bgpState.BgpNeighbors(), expNeighbors) {
+		t.Fatalf("unexpected bgp neighbors after clearing a password %+v", bgpState.BgpNeighbors())
+	}
+
+	// configs of a single neighbor are still read
+	oldState := &mastercfg.CfgBgpState{Neighbor: "50.1.1.2", NeighborAs: "500"}
+	if !reflect.DeepEqual(oldState.BgpNeighbors(), []mastercfg.BgpNeighbor{{Address: "50.1.1.2", As: "500"}}) {
+		t.Fatalf("unexpected bgp neighbors %+v", oldState.BgpNeighbors())
+	}
+
+	neighbors := []intent.ConfigBgpNeighbor{{Address: "50.1.1.3", As: "600"}}
+	invalidCfgs := []intent.ConfigBgp{
+		{Hostname: "host2", As: "65002"},
+		{Hostname: "host2", Neighbors: []intent.ConfigBgpNeighbor{{Address: "50.1.1.3"}}},
+		{Hostname: "host2", Neighbors: []intent.ConfigBgpNeighbor{{Address: "50.1.1", As: "600"}}},
+		{Hostname: "host2", Neighbors: []intent.ConfigBgpNeighbor{{Address: "50.1.1.3", As: "as"}}},
+		{Hostname: "host2", Neighbors: []intent.ConfigBgpNeighbor{{Address: "50.1.1.3", As: "600", Password: "secret", ClearPassword: true}}},
+		{Hostname: "host2", Neighbor: "50.1.1.3", NeighborAs: "600", Neighbors: neighbors},
+		{Hostname: "host2", Neighbors: neighbors, HoldTime: 2},
+		{Hostname: "host2", Neighbors: neighbors, HoldTime: 30, Keepalive: 30},
+		{Hostname: "host2", Neighbors: neighbors, Keepalive: 90},
+		{Hostname: "host2", Neighbors: neighbors, ImportPrefixes: []string{"10.1.0.0"}},
+		{Hostname: "host2", Neighbors: neighbors, ExportPrefixes: []string{"2001::/64"}},
+	}
+	for idx := range invalidCfgs {
+		if err := AddBgp(fakeDriver, &invalidCfgs[idx]); err == nil {
+			t.Fatalf("invalid bgp config %+v added", invalidCfgs[idx])
+		}
+	}
+
+	if err := DeleteBgp(fakeDriver, "host1"); err != nil {
+		t.Fatalf("error deleting bgp config. Err: %v", err)
+	}
+}
+
+func TestBgpOper(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	// the state is empty until the host publishes it
+	bgpOper, err := GetBgpOper(fakeDriver, "host1")
+	if err != nil || len(bgpOper.Neighbors) != 0 || !bgpOper.LastUpdated.IsZero() {
+		t.Fatalf("unexpected bgp state %+v. Err: %v", bgpOper, err)
+	}
+
+	operState := &mastercfg.OperBgpState{
+		Hostname: "host1",
+		RouterIP: "50.1.1.1",
+		As:       "65002",
+		Neighbors: []mastercfg.BgpNeighborOper{
+			{Address: "50.1.1.2", As: "500", State: "BGP_FSM_ESTABLISHED", AdminState: "ADMIN_STATE_UP",
+				Uptime: 60, Received: 3, Accepted: 2, Advertised: 4},
+			{Address: "50.1.1.3", As: "600", State: "BGP_FSM_IDLE", AdminState: "ADMIN_STATE_DOWN"},
+		},
+		Routes: []mastercfg.BgpRoute{
+			{Prefix: "10.1.1.2/32", NextHop: "50.1.1.1", AsPath: "65002", Best: true},
+			{Prefix: "10.1.1.3/32", NextHop: "50.1.1.2", AsPath: "500"},
+		},
+		RibSize:    10,
+		UpdateTime: time.Now().Add(-30 * time.Second),
+	}
+	operState.StateDriver = fakeDriver
+	operState.ID = "host1"
+	if err := operState.Write(); err != nil {
+		t.Fatalf("error writing bgp state. Err: %v", err)
+	}
+
+	bgpOper, err = GetBgpOper(fakeDriver, "host1")
+	if err != nil {
+		t.Fatalf("error getting bgp state. Err: %v", err)
+	}
+	// the uptime of established sessions includes the age of the state
+	expNeighbors := []mastercfg.BgpNeighborOper{
+		{Address: "50.1.1.2", As: "500", State: "BGP_FSM_ESTABLISHED", AdminState: "ADMIN_STATE_UP",
+			Uptime: 90, Received: 3, Accepted: 2, Advertised: 4},
+		{Address: "50.1.1.3", As: "600", State: "BGP_FSM_IDLE", AdminState: "ADMIN_STATE_DOWN"},
+	}
+	if !reflect.DeepEqual(bgpOper.Neighbors, expNeighbors) || !reflect.DeepEqual(bgpOper.Routes, operState.Routes) ||
+		bgpOper.RouterIP != "50.1.1.1" || bgpOper.As != "65002" || bgpOper.RibSize != 10 {
+		t.Fatalf("unexpected bgp state %+v", bgpOper)
+	}
+}
+
+func TestMultiNetworkProviders(t *testing.T) {
+	service := &mastercfg.ServiceLBInfo{
+		ServiceName: "web",
+		Tenant:      "tenant-one",
+		Network:     "orange",
+		Providers:   make(map[string]*mastercfg.Provider),
+	}
+	frontend := &mastercfg.Provider{IPAddress: "20.1.1.2", ContainerID: "c1",
+		Network: "purple", Tenant: "tenant-one"}
+	backend := &mastercfg.Provider{IPAddress: "10.1.1.2", ContainerID: "c1",
+		Network: "orange", Tenant: "tenant-one"}
+
+	// a container has a provider per network
+	if getProviderDbID(frontend) == getProviderDbID(backend) {
+		t.Fatalf("providers of a container on two networks have the same id")
+	}
+
+	// the container serves from another network until it has one on the service network
+	mastercfg.ProviderDb[getProviderDbID(frontend)] = frontend
+	defer delete(mastercfg.ProviderDb, getProviderDbID(frontend))
+	if !providerServesService(frontend, service) {
+		t.Fatalf("provider on another network of the tenant does not serve the service")
+	}
+
+	mastercfg.ProviderDb[getProviderDbID(backend)] = backend
+	defer delete(mastercfg.ProviderDb, getProviderDbID(backend))
+	if providerServesService(frontend, service) || !providerServesService(backend, service) {
+		t.Fatalf("container does not serve the service from the service network")
+	}
+
+	// providers of other tenants never serve the service
+	other := &mastercfg.Provider{IPAddress: "10.1.1.3", ContainerID: "c2",
+		Network: "orange", Tenant: "tenant-two"}
+	if providerServesService(other, service) {
+		t.Fatalf("provider of another tenant serves the service")
+	}
+
+	service.Providers[getProviderID(frontend)] = frontend
+	frontend.Services = []string{"web:tenant-one"}
+	mastercfg.ServiceLBDb["web:tenant-one"] = service
+	defer delete(mastercfg.ServiceLBDb, "web:tenant-one")
+	txn := &svcTxn{services: make(map[string]bool), providers: make(map[string]bool)}
+	removeServiceProvider(txn, frontend, "web:tenant-one")
+	if len(service.Providers) != 0 || len(frontend.Services) != 0 {
+		t.Fatalf("provider was not removed from the service: %+v %+v", service, frontend)
+	}
+	if !txn.services["web:tenant-one"] || !txn.providers[getProviderDbID(frontend)] {
+		t.Fatalf("removing the provider did not change the service and provider: %+v", txn)
+	}
+}
+
+func TestServiceSelectors(t *testing.T) {
+	for selector, expExpr := range map[string]mastercfg.SelectorExpr{
+		"app=web":                    {Key: "app", Operator: SelectorOpEquals, Values: []string{"web"}},
+		"app==web":                   {Key: "app", Operator: SelectorOpEquals, Values: []string{"web"}},
+		"version!=1":                 {Key: "version", Operator: mastercfg.SelectorOpNotIn, Values: []string{"1"}},
+		"tier in (web, app)":         {Key: "tier", Operator: mastercfg.SelectorOpIn, Values: []string{"web", "app"}},
+		"io.contiv/env notin (prod)": {Key: "io.contiv/env", Operator: mastercfg.SelectorOpNotIn, Values: []string{"prod"}},
+		"release":                    {Key: "release", Operator: mastercfg.SelectorOpExists},
+		"!canary":                    {Key: "canary", Operator: mastercfg.SelectorOpDoesNotExist},
+	} {
+		expr, err := ParseSelector(selector)
+		if err != nil {
+			t.Fatalf("error parsing selector %s. Err: %v", selector, err)
+		}
+		if !reflect.DeepEqual(*expr, expExpr) {
+			t.Fatalf("selector %s parsed to %+v, expected %+v", selector, *expr, expExpr)
+		}
+	}
+
+	for _, selector := range []string{"", "app=web=1", "tier in ()", "tier in (a,,b)", "!", "a b", "!app=web"} {
+		if _, err := ParseSelector(selector); err == nil {
+			t.Errorf("invalid selector %q was parsed", selector)
+		}
+	}
+
+	exprs, err := parseSelectorExprs([]string{"tier in (web,app)", "version notin (1)", "release", "!canary"})
+	if err != nil {
+		t.Fatalf("error parsing selectors. Err: %v", err)
+	}
+	selectors := map[string]string{"app": "store"}
+
+	for _, labels := range []map[string]string{
+		{"app": "store", "tier": "web", "release": "stable"},
+		{"app": "store", "tier": "app", "release": "stable", "version": "2", "extra": "label"},
+	} {
+		if !selectorsMatch(selectors, exprs, labels) {
+			t.Errorf("labels %v do not match the selectors", labels)
+		}
+	}
+	for _, labels := range []map[string]string{
+		{"tier": "web", "release": "stable"},
+		{"app": "db", "tier": "web", "release": "stable"},
+		{"app": "store", "tier": "db", "release": "stable"},
+		{"app": "store", "release": "stable"},
+		{"app": "store", "tier": "web", "release": "stable", "version": "1"},
+		{"app": "store", "tier": "web"},
+		{"app": "store", "tier": "web", "release": "stable", "canary": "true"},
+	} {
+		if selectorsMatch(selectors, exprs, labels) {
+			t.Errorf("labels %v match the selectors", labels)
+		}
+	}
+
+	if _, err := parseSelectorExprs([]string{"app=web"}); err == nil {
+		t.Errorf("equality selector was parsed as a set based selector")
+	}
+}
+
+func TestServiceProviderHealth(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	check, err := getHealthCheck(&intent.ConfigHealthCheck{Type: "http"}, []string{"80:8080:TCP"})
+	if err != nil {
+		t.Fatalf("error getting health check. Err: %v", err)
+	}
+	expCheck := &mastercfg.HealthCheck{Type: "http", Port: 8080, Path: "/", Interval: 10, Timeout: 5,
+		HealthyThreshold: 2, UnhealthyThreshold: 3}
+	if !reflect.DeepEqual(check, expCheck) {
+		t.Fatalf("got health check %+v expected %+v", check, expCheck)
+	}
+	for _, cfg := range []*intent.ConfigHealthCheck{
+		{Type: "udp"},
+		{Type: "tcp", Interval: 5, Timeout: 10},
+		{Type: "http", Path: "health"},
+		{Type: "tcp", Port: 70000},
+	} {
+		if _, err := getHealthCheck(cfg, []string{"80:8080:TCP"}); err == nil {
+			t.Errorf("invalid health check %+v was accepted", cfg)
+		}
+	}
+
+	serviceID := getServiceID("web", "tenant-one")
+	providers := map[string]*mastercfg.Provider{
+		"10.1.1.2:tenant-one": {IPAddress: "10.1.1.2", ContainerID: "c1", Tenant: "tenant-one"},
+		"10.1.1.3:tenant-one": {IPAddress: "10.1.1.3", ContainerID: "c2", Tenant: "tenant-one"},
+	}
+	mastercfg.ServiceLBDb[serviceID] = &mastercfg.ServiceLBInfo{
+		ServiceName:    "web",
+		Tenant:         "tenant-one",
+		Providers:      providers,
+		HealthCheck:    check,
+		ProviderHealth: make(map[string]string),
+	}
+	defer delete(mastercfg.ServiceLBDb, serviceID)
+
+	serviceLbState := &mastercfg.CfgServiceLBState{ServiceName: "web", Tenant: "tenant-one",
+		Providers: providers, HealthCheck: check}
+	serviceLbState.StateDriver = fakeDriver
+	serviceLbState.ID = serviceID
+	if err := serviceLbState.Write(); err != nil {
+		t.Fatalf("error writing service state. Err: %v", err)
+	}
+
+	verifyProviders := func(expProviders []string) {
+		svcProvider := &mastercfg.SvcProvider{}
+		svcProvider.StateDriver = fakeDriver
+		if err := svcProvider.Read(serviceID); err != nil {
+			t.Fatalf("error reading service providers. Err: %v", err)
+		}
+		sort.Strings(svcProvider.Providers)
+		if len(svcProvider.Providers) != len(expProviders) ||
+			(len(expProviders) != 0 && !reflect.DeepEqual(svcProvider.Providers, expProviders)) {
+			t.Fatalf("got providers %v expected %v", svcProvider.Providers, expProviders)
+		}
+	}
+
+	// providers receive traffic once they are healthy
+	if err := SvcProviderUpdate(serviceID, false); err != nil {
+		t.Fatalf("error updating service providers. Err: %v", err)
+	}
+	verifyProviders(nil)
+
+	for _, req := range []SvcProviderHealthRequest{
+		{ServiceID: serviceID, ProviderID: "10.1.1.2:tenant-one", Healthy: true},
+		{ServiceID: serviceID, ProviderID: "10.1.1.3:tenant-one", Healthy: true},
+		{ServiceID: serviceID, ProviderID: "10.1.1.3:tenant-one", Healthy: false},
+	} {
+		if err := UpdateProviderHealth(fakeDriver, &req); err != nil {
+			t.Fatalf("error updating provider health. Err: %v", err)
+		}
+	}
+	verifyProviders([]string{"10.1.1.2"})
+
+	if err := serviceLbState.Read(serviceID); err != nil ||
+		serviceLbState.ProviderHealth["10.1.1.3:tenant-one"] != mastercfg.ProviderUnhealthy {
+		t.Fatalf("provider health was not saved. state %+v, err %v", serviceLbState, err)
+	}
+
+	expOper := []string{"10.1.1.2 c1 healthy", "10.1.1.3 c2 unhealthy"}
+	if oper := GetServiceProviders("web", "tenant-one"); !reflect.DeepEqual(oper, expOper) {
+		t.Fatalf("got providers %v expected %v", oper, expOper)
+	}
+
+	err = UpdateProviderHealth(fakeDriver, &SvcProviderHealthRequest{ServiceID: serviceID,
+		ProviderID: "10.1.1.4:tenant-one", Healthy: true})
+	if err == nil {
+		t.Fatalf("health of an unknown provider was updated")
+	}
+}
+
+func TestServiceLBPolicy(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	policy, err := getLBPolicy(&intent.ConfigLBPolicy{Mode: "leastconn", SessionAffinity: "none"})
+	if err != nil || policy != nil {
+		t.Fatalf("default load balancing got policy %+v, err %v", policy, err)
+	}
+
+	policy, err = getLBPolicy(&intent.ConfigLBPolicy{Mode: "roundrobin", SessionAffinity: "clientip",
+		Weights: []string{"track=canary:1", "track in (stable):9"}})
+	if err != nil {
+		t.Fatalf("error getting load balancing policy. Err: %v", err)
+	}
+	if policy.Mode != mastercfg.LBModeRoundRobin || !policy.ClientIPAffinity ||
+		policy.AffinityTimeout != defaultAffinityTimeout || len(policy.Weights) != 2 {
+		t.Fatalf("got unexpected load balancing policy %+v", policy)
+	}
+	for _, cfg := range []*intent.ConfigLBPolicy{
+		{Mode: "random"},
+		{SessionAffinity: "cookie"},
+		{AffinityTimeout: 60},
+		{SessionAffinity: "clientip", AffinityTimeout: 100000},
+		{Weights: []string{"track=canary"}},
+		{Weights: []string{"track=canary:0"}},
+		{Weights: []string{"track=can:ary:1"}},
+	} {
+		if _, err := getLBPolicy(cfg); err == nil {
+			t.Errorf("invalid load balancing policy %+v was accepted", cfg)
+		}
+	}
+
+	serviceID := getServiceID("web", "tenant-one")
+	mastercfg.ServiceLBDb[serviceID] = &mastercfg.ServiceLBInfo{
+		ServiceName: "web",
+		Tenant:      "tenant-one",
+		Providers: map[string]*mastercfg.Provider{
+			"10.1.1.2:tenant-one": {IPAddress: "10.1.1.2", Tenant: "tenant-one",
+				Labels: map[string]string{"track": "stable"}},
+			"10.1.1.3:tenant-one": {IPAddress: "10.1.1.3", Tenant: "tenant-one",
+				Labels: map[string]string{"track": "canary"}},
+			"10.1.1.4:tenant-one": {IPAddress: "10.1.1.4", Tenant: "tenant-one"},
+		},
+		LBPolicy: policy,
+	}
+	defer delete(mastercfg.ServiceLBDb, serviceID)
+
+	// only providers not weighing 1 are published with a weight
+	if err := SvcProviderUpdate(serviceID, false); err != nil {
+		t.Fatalf("error updating service providers. Err: %v", err)
+	}
+	svcProvider := &mastercfg.SvcProvider{}
+	svcProvider.StateDriver = fakeDriver
+	if err := svcProvider.Read(serviceID); err != nil {
+		t.Fatalf("error reading service providers. Err: %v", err)
+	}
+	expWeights := map[string]int{"10.1.1.2": 9}
+	if !reflect.DeepEqual(svcProvider.Weights, expWeights) {
+		t.Fatalf("got provider weights %v expected %v", svcProvider.Weights, expWeights)
+	}
+}
+
+func TestServiceVIPPool(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	if _, err := allocServiceVIP(fakeDriver, ""); err == nil {
+		t.Fatalf("service VIP allocated without a pool")
+	}
+	for _, pool := range []string{"10.1.1.0", "2001::/64", "10.1.1.0/31"} {
+		if err := setServiceVIPPool(fakeDriver, pool); err == nil {
+			t.Errorf("invalid service VIP pool %s was accepted", pool)
+		}
+	}
+	if err := setServiceVIPPool(fakeDriver, "192.168.100.0/29"); err != nil {
+		t.Fatalf("error setting service VIP pool. Err: %v", err)
+	}
+
+	vip, err := allocServiceVIP(fakeDriver, "")
+	if err != nil || vip != "192.168.100.1" {
+		t.Fatalf("got service VIP %s expected 192.168.100.1, err %v", vip, err)
+	}
+	if vip, err = allocServiceVIP(fakeDriver, "192.168.100.5"); err != nil || vip != "192.168.100.5" {
+		t.Fatalf("got service VIP %s expected 192.168.100.5, err %v", vip, err)
+	}
+	for _, addr := range []string{"192.168.100.5", "192.168.100.7", "10.1.1.5"} {
+		if _, err := allocServiceVIP(fakeDriver, addr); err == nil {
+			t.Errorf("unavailable service VIP %s was allocated", addr)
+		}
+	}
+
+	// the pool stays until its VIPs are released
+	if err := setServiceVIPPool(fakeDriver, "192.168.200.0/24"); err == nil {
+		t.Fatalf("service VIP pool in use was changed")
+	}
+	for _, addr := range []string{"192.168.100.1", "192.168.100.5"} {
+		if err := releaseServiceVIP(fakeDriver, addr); err != nil {
+			t.Fatalf("error releasing service VIP %s. Err: %v", addr, err)
+		}
+	}
+	if err := setServiceVIPPool(fakeDriver, "192.168.200.0/24"); err != nil {
+		t.Fatalf("error changing service VIP pool. Err: %v", err)
+	}
+	if vip, err = allocServiceVIP(fakeDriver, ""); err != nil || vip != "192.168.200.1" {
+		t.Fatalf("got service VIP %s expected 192.168.200.1, err %v", vip, err)
+	}
+	releaseServiceVIP(fakeDriver, vip)
+
+	if err := setServiceVIPPool(fakeDriver, ""); err != nil {
+		t.Fatalf("error removing service VIP pool. Err: %v", err)
+	}
+	if _, err := allocServiceVIP(fakeDriver, ""); err == nil {
+		t.Fatalf("service VIP allocated from a removed pool")
+	}
+}
+
+const svcDbTestConfig = `{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+			"SubnetCIDR"		: "10.1.1.0/24",
+			"Gateway"			: "10.1.1.254"
+        }]
+    }]}`
+
+// initSvcDb empties the service and provider dbs
+func initSvcDb() {
+	mastercfg.SvcMutex.Lock()
+	mastercfg.ServiceLBDb = make(map[string]*mastercfg.ServiceLBInfo)
+	mastercfg.ProviderDb = make(map[string]*mastercfg.Provider)
+	mastercfg.SvcMutex.Unlock()
+}
+
+// checkSvcDb verifies the services and providers reference each other, the
+// services hold all the providers matching them and the dbs match the store
+func checkSvcDb(t *testing.T) {
+	mastercfg.SvcMutex.Lock()
+	defer mastercfg.SvcMutex.Unlock()
+
+	for serviceID, service := range mastercfg.ServiceLBDb {
+		for providerID, provider := range service.Providers {
+			if mastercfg.ProviderDb[getProviderDbID(provider)] != provider {
+				t.Errorf("provider %s of service %s is not in the provider db", providerID, serviceID)
+			}
+			if !stringInSlice(serviceID, provider.Services) {
+				t.Errorf("provider %s does not list its service %s", providerID, serviceID)
+			}
+		}
+		for providerDbID, provider := range mastercfg.ProviderDb {
+			if providerServesService(provider, service) && serviceMatchesLabels(service, provider.Labels) &&
+				service.Providers[getProviderID(provider)] == nil {
+				t.Errorf("provider %s is missing in service %s", providerDbID, serviceID)
+			}
+		}
+	}
+	for providerDbID, provider := range mastercfg.ProviderDb {
+		for _, serviceID := range provider.Services {
+			service := mastercfg.ServiceLBDb[serviceID]
+			if service == nil || service.Providers[getProviderID(provider)] != provider {
+				t.Errorf("provider %s lists service %s it does not serve", providerDbID, serviceID)
+			}
+		}
+	}
+
+	// the dbs as they are written to the store
+	encodeSvcDb := func() string {
+		services := make(map[string]*mastercfg.CfgServiceLBState)
+		for serviceID, service := range mastercfg.ServiceLBDb {
+			services[serviceID] = serviceLBState(fakeDriver, serviceID, service)
+		}
+		encoded, _ := json.Marshal([]interface{}{services, mastercfg.ProviderDb})
+		return string(encoded)
+	}
+
+	serviceLBDb, providerDb := mastercfg.ServiceLBDb, mastercfg.ProviderDb
+	defer func() { mastercfg.ServiceLBDb, mastercfg.ProviderDb = serviceLBDb, providerDb }()
+	inMemory := encodeSvcDb()
+	if err := loadSvcDb(fakeDriver); err != nil {
+		t.Fatalf("error loading service db. Err: %v", err)
+	}
+	if stored := encodeSvcDb(); stored != inMemory {
+		t.Errorf("service db does not match the state store:\n%s\n%s", inMemory, stored)
+	}
+}
+
+func testProvider(container, ipAddress, app string) *mastercfg.Provider {
+	return &mastercfg.Provider{IPAddress: ipAddress, ContainerID: container, Tenant: "tenant-one",
+		Network: "orange", Labels: map[string]string{"app": app}}
+}
+
+func TestServiceDb(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+	initSvcDb()
+
+	applyConfig(t, []byte(svcDbTestConfig))
+
+	for _, provider := range []*mastercfg.Provider{
+		testProvider("c1", "10.1.1.2", "web"),
+		testProvider("c2", "10.1.1.3", "web"),
+		testProvider("c3", "10.1.1.4", "db"),
+	} {
+		if err := addServiceProvider(fakeDriver, provider); err != nil {
+			t.Fatalf("error adding provider %+v. Err: %v", provider, err)
+		}
+	}
+
+	err := CreateServiceLB(fakeDriver, &intent.ConfigServiceLB{ServiceName: "web", Tenant: "tenant-one",
+		Network: "orange", Ports: []string{"80:8080:TCP"}, Selectors: map[string]string{"app": "web"}})
+	if err != nil {
+		t.Fatalf("error creating service. Err: %v", err)
+	}
+	serviceID := getServiceID("web", "tenant-one")
+	if len(mastercfg.ServiceLBDb[serviceID].Providers) != 2 {
+		t.Fatalf("service has providers %+v, expected 2", mastercfg.ServiceLBDb[serviceID].Providers)
+	}
+	checkSvcDb(t)
+
+	// a new leader restores the dbs from the store
+	initSvcDb()
+	RestoreServiceProviderLBDb()
+	if len(mastercfg.ServiceLBDb[serviceID].Providers) != 2 || len(mastercfg.ProviderDb) != 3 {
+		t.Fatalf("restored services %+v and providers %+v", mastercfg.ServiceLBDb, mastercfg.ProviderDb)
+	}
+	checkSvcDb(t)
+
+	// a failed transaction leaves no trace
+	err = updateSvcDb(fakeDriver, func(txn *svcTxn) error {
+		removeServiceProvider(txn, mastercfg.ProviderDb["c1:orange.tenant-one"], serviceID)
+		delete(mastercfg.ProviderDb, "c3:orange.tenant-one")
+		txn.providerChanged("c3:orange.tenant-one")
+		return core.Errorf("failed")
+	})
+	if err == nil {
+		t.Fatalf("failed transaction succeeded")
+	}
+	if len(mastercfg.ServiceLBDb[serviceID].Providers) != 2 || len(mastercfg.ProviderDb) != 3 {
+		t.Fatalf("failed transaction changed services %+v and providers %+v",
+			mastercfg.ServiceLBDb, mastercfg.ProviderDb)
+	}
+	checkSvcDb(t)
+
+	if err := removeContainerProviders(fakeDriver, "c1"); err != nil {
+		t.Fatalf("error removing providers. Err: %v", err)
+	}
+	svcProvider := &mastercfg.SvcProvider{}
+	svcProvider.StateDriver = fakeDriver
+	if err := svcProvider.Read(serviceID); err != nil {
+		t.Fatalf("error reading service providers. Err: %v", err)
+	}
+	if !reflect.DeepEqual(svcProvider.Providers, []string{"10.1.1.3"}) {
+		t.Fatalf("published providers %v, expected 10.1.1.3", svcProvider.Providers)
+	}
+	checkSvcDb(t)
+
+	if err := DeleteServiceLB(fakeDriver, "web", "tenant-one"); err != nil {
+		t.Fatalf("error deleting service. Err: %v", err)
+	}
+	if len(mastercfg.ServiceLBDb) != 0 || len(mastercfg.ProviderDb["c2:orange.tenant-one"].Services) != 0 {
+		t.Fatalf("service was not removed from services %+v and providers %+v",
+			mastercfg.ServiceLBDb, mastercfg.ProviderDb)
+	}
+	if DeleteServiceLB(fakeDriver, "web", "tenant-one") == nil {
+		t.Fatalf("deleted service was deleted again")
+	}
+	checkSvcDb(t)
+}
+
+// failingStateDriver fails the writes of the keys containing failKey
+type failingStateDriver struct {
+	core.StateDriver
+	failKey string
+}
+
+func (d *failingStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	if strings.Contains(key, d.failKey) {
+		return core.Errorf("write of %s failed", key)
+	}
+	return d.StateDriver.WriteState(key, value, marshal)
+}
+
+func TestServiceDbRollback(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+	initSvcDb()
+
+	applyConfig(t, []byte(svcDbTestConfig))
+
+	for _, provider := range []*mastercfg.Provider{
+		testProvider("c1", "10.1.1.2", "web"),
+		testProvider("c2", "10.1.1.3", "web"),
+	} {
+		if err := addServiceProvider(fakeDriver, provider); err != nil {
+			t.Fatalf("error adding provider %+v. Err: %v", provider, err)
+		}
+	}
+
+	webCfg := &intent.ConfigServiceLB{ServiceName: "web", Tenant: "tenant-one", Network: "orange",
+		Ports: []string{"80:8080:TCP"}, Selectors: map[string]string{"app": "web"},
+		HealthCheck: &intent.ConfigHealthCheck{Type: "tcp"}}
+	if err := CreateServiceLB(fakeDriver, webCfg); err != nil {
+		t.Fatalf("error creating service. Err: %v", err)
+	}
+	serviceID := getServiceID("web", "tenant-one")
+	service := mastercfg.ServiceLBDb[serviceID]
+	serviceIP := service.IPAddress
+	if err := UpdateProviderHealth(fakeDriver, &SvcProviderHealthRequest{ServiceID: serviceID,
+		ProviderID: getProviderID(service.Providers["10.1.1.2:tenant-one"]), Healthy: true}); err != nil {
+		t.Fatalf("error updating provider health. Err: %v", err)
+	}
+	checkSvcDb(t)
+
+	readPublished := func() []string {
+		svcProvider := &mastercfg.SvcProvider{}
+		svcProvider.StateDriver = fakeDriver
+		if err := svcProvider.Read(serviceID); err != nil {
+			t.Fatalf("error reading service providers. Err: %v", err)
+		}
+		return svcProvider.Providers
+	}
+	published := readPublished()
+
+	// the provider written before the failed service write is removed
+	failDriver := &failingStateDriver{StateDriver: fakeDriver, failKey: "serviceLB/" + serviceID}
+	if addServiceProvider(failDriver, testProvider("c3", "10.1.1.4", "web")) == nil {
+		t.Fatalf("adding a provider succeeded without writing its service")
+	}
+	if mastercfg.ProviderDb["c3:orange.tenant-one"] != nil || len(mastercfg.ServiceLBDb[serviceID].Providers) != 2 {
+		t.Fatalf("failed transaction changed services %+v and providers %+v",
+			mastercfg.ServiceLBDb, mastercfg.ProviderDb)
+	}
+	providerState := &mastercfg.CfgProviderState{}
+	providerState.StateDriver = fakeDriver
+	if providerState.Read("c3:orange.tenant-one") == nil {
+		t.Fatalf("failed transaction left provider c3 in the state store")
+	}
+	if !reflect.DeepEqual(readPublished(), published) {
+		t.Fatalf("failed transaction published providers %v", readPublished())
+	}
+	checkSvcDb(t)
+
+	// a failed update leaves the service as it was
+	webCfg.Ports = []string{"8080:8080:TCP"}
+	if CreateServiceLB(failDriver, webCfg) == nil {
+		t.Fatalf("updating a service succeeded without writing it")
+	}
+	service = mastercfg.ServiceLBDb[serviceID]
+	if service == nil || service.Ports[0] != "80:8080:TCP" || len(service.Providers) != 2 {
+		t.Fatalf("failed update changed service %+v", service)
+	}
+	checkSvcDb(t)
+
+	// an update keeps the ip, the providers and their health
+	if err := CreateServiceLB(fakeDriver, webCfg); err != nil {
+		t.Fatalf("error updating service. Err: %v", err)
+	}
+	service = mastercfg.ServiceLBDb[serviceID]
+	if service.IPAddress != serviceIP || service.Ports[0] != "8080:8080:TCP" || len(service.Providers) != 2 ||
+		service.ProviderHealth["10.1.1.2:tenant-one"] != mastercfg.ProviderHealthy {
+		t.Fatalf("updated service %+v, expected ip %s with the providers and their health", service, serviceIP)
+	}
+	if !reflect.DeepEqual(readPublished(), published) {
+		t.Fatalf("update published providers %v, expected %v", readPublished(), published)
+	}
+	checkSvcDb(t)
+}
+
+func TestConcurrentServiceProviders(t *testing.T) {
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+	initSvcDb()
+
+	applyConfig(t, []byte(svcDbTestConfig))
+
+	const numContainers, numUpdates = 8, 20
+	var wg sync.WaitGroup
+
+	// containers start and die while the services change
+	for i := 0; i < numContainers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			container := fmt.Sprintf("c%d", i)
+			for j := 0; j < numUpdates; j++ {
+				provider := testProvider(container, fmt.Sprintf("10.1.1.%d", 100+i), "web")
+				if err := addServiceProvider(fakeDriver, provider); err != nil {
+					t.Errorf("error adding provider %s. Err: %v", container, err)
+				}
+				if j%2 == 0 {
+					UpdateProviderHealth(fakeDriver, &SvcProviderHealthRequest{
+						ServiceID:  getServiceID("web", "tenant-one"),
+						ProviderID: getProviderID(provider),
+						Healthy:    j%4 == 0,
+					})
+				}
+				if j < numUpdates-1 || i%2 == 0 {
+					if err := removeContainerProviders(fakeDriver, container); err != nil {
+						t.Errorf("error removing provider %s. Err: %v", container, err)
+					}
+				}
+			}
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < numUpdates; j++ {
+			for _, name := range []string{"web", "all"} {
+				cfg := &intent.ConfigServiceLB{ServiceName: name, Tenant: "tenant-one", Network: "orange",
+					Ports: []string{fmt.Sprintf("%d:8080:TCP", 80+j)}, Selectors: map[string]string{"app": "web"},
+					HealthCheck: &intent.ConfigHealthCheck{Type: "tcp"}}
+				if name == "all" {
+					cfg.Selectors, cfg.SelectorExprs = nil, []string{"app"}
+				}
+				if err := CreateServiceLB(fakeDriver, cfg); err != nil {
+					t.Errorf("error updating service %s. Err: %v", name, err)
+				}
+			}
+			if j%5 == 3 {
+				if err := DeleteServiceLB(fakeDriver, "all", "tenant-one"); err != nil {
+					t.Errorf("error deleting service. Err: %v", err)
+				}
+			}
+		}
+	}()
+	wg.Wait()
+
+	// the containers with odd numbers are left running
+	for _, name := range []string{"web", "all"} {
+		service := mastercfg.ServiceLBDb[getServiceID(name, "tenant-one")]
+		if service == nil || len(service.Providers) != numContainers/2 {
+			t.Fatalf("service %s has providers %+v, expected %d", name, service, numContainers/2)
+		}
+	}
+	checkSvcDb(t)
+}
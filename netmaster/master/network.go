@@ -16,7 +16,7 @@ limitations under the License.
 package master
 
 import (
-	"errors"
+	"fmt"
 	"net"
 	"strings"
 
@@ -25,8 +25,8 @@ import (
 	"github.com/contiv/netplugin/netmaster/gstate"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
-	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/jainvipin/bitset"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -117,6 +117,11 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 	nwCfg.ID = networkID
 	nwCfg.StateDriver = stateDriver
 
+	err = setContainerNetConfig(nwCfg, &network)
+	if err != nil {
+		return err
+	}
+
 	// Allocate pkt tags
 	reqPktTag := uint(network.PktTag)
 	if nwCfg.PktTagType == "vlan" {
@@ -163,7 +168,19 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 			log.Errorf("Error parsing gateway address %s. Err: %v", nwCfg.IPv6Gateway, err)
 			return err
 		}
-		netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
+		if err = nwCfg.IPv6AllocRanges.Set(hostID); err != nil {
+			return err
+		}
+	}
+
+	// Reserve the address of the name server of the network, answered by
+	// netplugin
+	if IsDNSEnabled() && network.NwType != "infra" && nwCfg.SubnetIP != "" {
+		nwCfg.DNSServer, err = networkAllocAddress(nwCfg, "", false)
+		if err != nil {
+			log.Errorf("Error allocating the dns server address of network %s. Err: %v", networkID, err)
+			return err
+		}
 	}
 
 	err = nwCfg.Write()
@@ -171,7 +188,7 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		return err
 	}
 
-	// Skip docker and service container configs for infra nw
+	// Skip docker configs for infra nw
 	if network.NwType == "infra" {
 		return nil
 	}
@@ -185,173 +202,84 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		}
 	}
 
-	if IsDNSEnabled() {
-		// Attach service container endpoint to the network
-		err = attachServiceContainer(tenantName, network.Name, stateDriver)
-		if err != nil {
-			log.Errorf("Error attaching service container to network: %s. Err: %v",
-				networkID, err)
-			return err
-		}
-	}
-
 	return nil
 }
 
-func attachServiceContainer(tenantName, networkName string, stateDriver core.StateDriver) error {
-	contName := getDNSName(tenantName)
-	docker, err := utils.GetDockerClient()
-	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
-		return err
+// parseStaticRoute parses a route of the form "destination[ via next-hop]"
+func parseStaticRoute(route string) (mastercfg.StaticRoute, error) {
+	fields := strings.Fields(route)
+	if len(fields) != 1 && (len(fields) != 3 || fields[1] != "via") {
+		return mastercfg.StaticRoute{}, core.Errorf("invalid static route %q", route)
 	}
 
-	cinfo, err := docker.InspectContainer(contName)
+	_, dst, err := net.ParseCIDR(fields[0])
 	if err != nil {
-		if strings.Contains(err.Error(), "no such id") {
-			// DNS container not started for this tenant. Start skydns container
-			err = startServiceContainer(tenantName)
-			if err != nil {
-				log.Warnf("Error starting service container. "+
-					"Continuing without DNS provider. Error: %v", err)
-				return nil
-			}
-			cinfo, err = docker.InspectContainer(contName)
-			if err != nil {
-				log.Warnf("Error fetching container info after starting %s"+
-					"Continuing without DNS provider. Error: %s", contName, err)
-				return nil
-			}
-		}
+		return mastercfg.StaticRoute{}, core.Errorf("invalid destination of static route %q", route)
 	}
+	staticRoute := mastercfg.StaticRoute{Destination: dst.String()}
 
-	// If it's not in running state, restart the container.
-	// This case can occur if the host is reloaded
-	if !cinfo.State.Running {
-		log.Debugf("Container %s not running. Restarting the container", contName)
-		err = docker.RestartContainer(contName, 0)
-		if err != nil {
-			log.Warnf("Error restarting service container %s. "+
-				"Continuing without DNS provider. Error: %v",
-				contName, err)
-			return nil
+	if len(fields) == 3 {
+		nextHop := net.ParseIP(fields[2])
+		if nextHop == nil || (nextHop.To4() == nil) != (dst.IP.To4() == nil) {
+			return mastercfg.StaticRoute{}, core.Errorf("invalid next hop of static route %q", route)
 		}
-
-		// Refetch container info after restart
-		cinfo, err = docker.InspectContainer(contName)
-		if err != nil {
-			log.Warnf("Error fetching container info after restarting %s"+
-				"Continuing without DNS provider. Error: %s", contName, err)
-			return nil
-		}
-	}
-
-	log.Debugf("Container info: %+v\n Hostconfig: %+v", cinfo, cinfo.HostConfig)
-
-	// Trim default tenant
-	dnetName := docknet.GetDocknetName(tenantName, networkName, "")
-
-	err = docker.ConnectNetwork(dnetName, contName)
-	if err != nil {
-		log.Warnf("Could not attach container(%s) to network %s. "+
-			"Continuing without DNS provider. Error: %s",
-			contName, dnetName, err)
-		return nil
-	}
-
-	ninfo, err := docker.InspectNetwork(dnetName)
-	if err != nil {
-		log.Errorf("Error getting network info for %s. Err: %v", dnetName, err)
-		return err
-	}
-
-	log.Debugf("Network info: %+v", ninfo)
-
-	// find the container in network info
-	epInfo, ok := ninfo.Containers[cinfo.Id]
-	if !ok {
-		log.Errorf("Could not find container %s in network info", cinfo.Id)
-		return errors.New("Endpoint not found")
-	}
-
-	// read network Config
-	nwCfg := &mastercfg.CfgNetworkState{}
-	networkID := networkName + "." + tenantName
-	nwCfg.StateDriver = stateDriver
-	err = nwCfg.Read(networkID)
-	if err != nil {
-		return err
-	}
-
-	// set the dns server Info
-	nwCfg.DNSServer = strings.Split(epInfo.IPv4Address, "/")[0]
-	log.Infof("Dns server for network %s: %s", networkName, nwCfg.DNSServer)
-
-	// write the network config
-	err = nwCfg.Write()
-	if err != nil {
-		return err
+		staticRoute.NextHop = nextHop.String()
 	}
 
-	return nil
+	return staticRoute, nil
 }
 
-// detachServiceContainer detaches the service container's endpoint during network delete
-//      - detach happens only if all other endpoints in the network are already removed
-func detachServiceContainer(tenantName, networkName string) error {
-	docker, err := utils.GetDockerClient()
-	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
-		return errors.New("Unable to connect to docker")
+// setContainerNetConfig validates the static routes and dns config of the
+// containers of a network and saves them in the network state
+func setContainerNetConfig(nwCfg *mastercfg.CfgNetworkState, network *intent.ConfigNetwork) error {
+	subnets := []string{}
+	if nwCfg.SubnetIP != "" {
+		subnets = append(subnets, fmt.Sprintf("%s/%d",
+			netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen), nwCfg.SubnetLen))
 	}
-
-	dnsContName := getDNSName(tenantName)
-	cinfo, err := docker.InspectContainer(dnsContName)
-	if err != nil {
-		log.Errorf("Error inspecting the container %s. Err: %v", dnsContName, err)
-		return err
+	if nwCfg.IPv6Subnet != "" {
+		subnets = append(subnets, fmt.Sprintf("%s/%d", nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen))
 	}
 
-	// Trim default tenant
-	dnetName := docknet.GetDocknetName(tenantName, networkName, "")
-
-	// inspect docker network
-	nwState, err := docker.InspectNetwork(dnetName)
-	if err != nil {
-		log.Errorf("Error while inspecting network: %+v", dnetName)
-		return err
-	}
+	for _, route := range network.StaticRoutes {
+		staticRoute, err := parseStaticRoute(route)
+		if err != nil {
+			return err
+		}
 
-	log.Infof("Containers in network: %+v are {%+v}", dnetName, nwState.Containers)
-	dnsServerIP := strings.Split(nwState.Containers[cinfo.Id].IPv4Address, "/")[0]
+		// containers need an address of the family of the route, and
+		// the next hop must be reachable from them
+		isIPv6 := netutils.IsIPv6(staticRoute.Destination)
+		reachable := false
+		for _, subnet := range subnets {
+			_, ipNet, err := net.ParseCIDR(subnet)
+			if err != nil || netutils.IsIPv6(subnet) != isIPv6 {
+				continue
+			}
+			reachable = reachable || staticRoute.NextHop == "" || ipNet.Contains(net.ParseIP(staticRoute.NextHop))
+		}
+		if !reachable {
+			return core.Errorf("static route %q is not reachable from network %s", route, nwCfg.ID)
+		}
 
-	stateDriver, err := utils.GetStateDriver()
-	if err != nil {
-		log.Errorf("Could not get StateDriver while trying to disconnect dnsContainer from %+v", networkName)
-		return err
+		nwCfg.StaticRoutes = append(nwCfg.StaticRoutes, staticRoute)
 	}
 
-	// Read network config and get DNSServer information
-	nwCfg := &mastercfg.CfgNetworkState{}
-	nwCfg.StateDriver = stateDriver
-	networkID := networkName + "." + tenantName
-	err = nwCfg.Read(networkID)
-	if err != nil {
-		return err
+	for _, server := range network.DNSServers {
+		if net.ParseIP(server) == nil {
+			return core.Errorf("invalid dns server %q", server)
+		}
 	}
-
-	log.Infof("dnsServerIP: %+v, nwCfg.dnsip: %+v", dnsServerIP, nwCfg.DNSServer)
-	// Remove dns container from network if all other endpoints are withdrawn
-	if len(nwState.Containers) == 1 && (dnsServerIP == nwCfg.DNSServer) {
-		log.Infof("Disconnecting dns container from network as all other endpoints are removed: %+v", networkName)
-		err = docker.DisconnectNetwork(dnetName, dnsContName, false)
-		if err != nil {
-			log.Errorf("Could not detach container(%s) from network %s. Error: %s",
-				dnsContName, dnetName, err)
-			return err
+	for _, domain := range network.DNSSearch {
+		if domain == "" || strings.ContainsAny(domain, " \t") {
+			return core.Errorf("invalid dns search domain %q", domain)
 		}
 	}
 
+	nwCfg.DNSServers = network.DNSServers
+	nwCfg.DNSSearch = network.DNSSearch
+	nwCfg.DNSOptions = network.DNSOptions
+
 	return nil
 }
 
@@ -414,14 +342,6 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 			return core.Errorf("Error: Network has active endpoints")
 		}
 
-		if IsDNSEnabled() {
-			// detach Dns container
-			err = detachServiceContainer(nwCfg.Tenant, nwCfg.NetworkName)
-			if err != nil {
-				log.Errorf("Error detaching service container. Err: %v", err)
-			}
-		}
-
 		if GetClusterMode() == "docker" {
 			// Delete the docker network
 			err = docknet.DeleteDockNet(nwCfg.Tenant, nwCfg.NetworkName, "")
@@ -429,9 +349,6 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 				log.Errorf("Error deleting network %s. Err: %v", netID, err)
 				// DeleteDockNet will fail when network has active endpoints.
 				// No damage is done yet. It is safe to fail.
-				// We do not have to call attachServiceContainer here,
-				// as detachServiceContainer detaches only when there are no
-				// endpoints remaining.
 				return err
 			}
 		}
@@ -454,6 +371,13 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 		return err
 	}
 
+	// remove the pools left by docker networks with legacy pool ids
+	err = clearIpamPools(stateDriver, netID)
+	if err != nil {
+		log.Errorf("error removing the ipam pools of network %s. Error: %s", netID, err)
+		return err
+	}
+
 	err = nwCfg.Clear()
 	if err != nil {
 		log.Errorf("error writing nw config. Error: %s", err)
@@ -491,8 +415,8 @@ func DeleteNetworks(stateDriver core.StateDriver, tenant *intent.ConfigTenant) e
 	return err
 }
 
-func getIPRange(nwCfg *mastercfg.CfgNetworkState, startIdx, endIdx uint) string {
-	startAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, startIdx)
+func getIPRange(subnetIP string, subnetLen uint, startIdx, endIdx uint) string {
+	startAddress, err := netutils.GetSubnetIP(subnetIP, subnetLen, 32, startIdx)
 	if err != nil {
 		log.Errorf("GetAllocatedIPs: getting ipAddress for idx %d: %s", startIdx, err)
 		startAddress = ""
@@ -500,7 +424,7 @@ func getIPRange(nwCfg *mastercfg.CfgNetworkState, startIdx, endIdx uint) string
 	if startIdx == endIdx {
 		return startAddress
 	}
-	endAddress, err := netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, endIdx)
+	endAddress, err := netutils.GetSubnetIP(subnetIP, subnetLen, 32, endIdx)
 	if err != nil {
 		log.Errorf("GetAllocatedIPs: getting ipAddress for idx %d: %s", endIdx, err)
 		endAddress = ""
@@ -508,16 +432,16 @@ func getIPRange(nwCfg *mastercfg.CfgNetworkState, startIdx, endIdx uint) string
 	return startAddress + "-" + endAddress
 }
 
-// ListAllocatedIPs returns a string of allocated IPs in a network
-func ListAllocatedIPs(nwCfg *mastercfg.CfgNetworkState) string {
+// listPoolAllocatedIPs returns the ranges of allocated IPs in an address pool
+func listPoolAllocatedIPs(p ipv4Pool) []string {
 	idx := uint(0)
 	startIdx := idx
 	list := []string{}
 	inRange := false
 
-	netutils.ClearReservedEntries(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+	netutils.ClearReservedEntries(p.allocMap, p.subnetLen)
 	for {
-		foundValue, found := nwCfg.IPAllocMap.NextSet(idx)
+		foundValue, found := p.allocMap.NextSet(idx)
 		if !found {
 			break
 		}
@@ -526,7 +450,7 @@ func ListAllocatedIPs(nwCfg *mastercfg.CfgNetworkState) string {
 			startIdx = foundValue
 			inRange = true
 		} else if foundValue > idx { // end of range
-			thisRange := getIPRange(nwCfg, startIdx, idx-1)
+			thisRange := getIPRange(p.subnetIP, p.subnetLen, startIdx, idx-1)
 			list = append(list, thisRange)
 			startIdx = foundValue
 		}
@@ -535,13 +459,271 @@ func ListAllocatedIPs(nwCfg *mastercfg.CfgNetworkState) string {
 
 	// list end with allocated value
 	if inRange {
-		thisRange := getIPRange(nwCfg, startIdx, idx-1)
+		thisRange := getIPRange(p.subnetIP, p.subnetLen, startIdx, idx-1)
 		list = append(list, thisRange)
 	}
 
+	return list
+}
+
+// ListAllocatedIPs returns a string of allocated IPs in a network
+func ListAllocatedIPs(nwCfg *mastercfg.CfgNetworkState) string {
+	list := []string{}
+	for _, p := range networkIPv4Pools(nwCfg) {
+		list = append(list, listPoolAllocatedIPs(p)...)
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// ListAllocatedIPv6s returns the allocated ipv6 addresses of a network
+func ListAllocatedIPv6s(nwCfg *mastercfg.CfgNetworkState) string {
+	list := []string{}
+	for _, r := range nwCfg.IPv6AllocRanges {
+		startIP, err := netutils.GetSubnetIPv6(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, r.Start)
+		if err != nil {
+			continue
+		}
+		if r.Start == r.End {
+			list = append(list, startIP)
+			continue
+		}
+		endIP, err := netutils.GetSubnetIPv6(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, r.End)
+		if err != nil {
+			continue
+		}
+		list = append(list, startIP+"-"+endIP)
+	}
+
 	return strings.Join(list, ", ")
 }
 
+// ListSubnetPools returns the usage of each address pool in a network
+func ListSubnetPools(nwCfg *mastercfg.CfgNetworkState) []string {
+	list := []string{}
+	poolAddrCount := 0
+	for _, sp := range nwCfg.SubnetPools {
+		poolAddrCount += sp.AllocCount
+	}
+
+	for idx, p := range networkIPv4Pools(nwCfg) {
+		allocCount := nwCfg.EpAddrCount - poolAddrCount
+		if p.allocCount != nil {
+			allocCount = *p.allocCount
+		}
+		if allocCount < 0 {
+			allocCount = 0
+		}
+
+		usage := fmt.Sprintf("%s/%d", p.subnetIP, p.subnetLen)
+		if p.gateway != "" {
+			usage += fmt.Sprintf(" gateway %s", p.gateway)
+		}
+		if idx == 0 && p.allocCount == nil {
+			usage += " (primary)"
+		}
+		usage += fmt.Sprintf(" allocated %d", allocCount)
+		if allocated := listPoolAllocatedIPs(p); len(allocated) > 0 {
+			usage += ": " + strings.Join(allocated, ", ")
+		}
+		list = append(list, usage)
+	}
+
+	return list
+}
+
+// ipv4Pool is a view over one of the ipv4 address pools of a network
+type ipv4Pool struct {
+	subnetIP   string
+	subnetLen  uint
+	gateway    string
+	allocMap   *bitset.BitSet
+	allocCount *int
+}
+
+// networkIPv4Pools returns the primary subnet followed by the additional subnet pools
+func networkIPv4Pools(nwCfg *mastercfg.CfgNetworkState) []ipv4Pool {
+	pools := []ipv4Pool{}
+	if nwCfg.SubnetIP != "" {
+		pools = append(pools, ipv4Pool{
+			subnetIP:  nwCfg.SubnetIP,
+			subnetLen: nwCfg.SubnetLen,
+			gateway:   nwCfg.Gateway,
+			allocMap:  &nwCfg.IPAllocMap,
+		})
+	}
+
+	for _, sp := range nwCfg.SubnetPools {
+		pools = append(pools, ipv4Pool{
+			subnetIP:   sp.SubnetIP,
+			subnetLen:  sp.SubnetLen,
+			gateway:    sp.Gateway,
+			allocMap:   &sp.IPAllocMap,
+			allocCount: &sp.AllocCount,
+		})
+	}
+
+	return pools
+}
+
+// findIPv4Pool returns the pool an address belongs to.
+// Defaults to the primary subnet so that errors refer to it.
+func findIPv4Pool(nwCfg *mastercfg.CfgNetworkState, ipAddress string) ipv4Pool {
+	pools := networkIPv4Pools(nwCfg)
+	for _, p := range pools {
+		if _, err := netutils.GetIPNumber(p.subnetIP, p.subnetLen, 32, ipAddress); err == nil {
+			return p
+		}
+	}
+
+	if len(pools) == 0 {
+		return ipv4Pool{subnetIP: nwCfg.SubnetIP, subnetLen: nwCfg.SubnetLen, allocMap: &nwCfg.IPAllocMap}
+	}
+
+	return pools[0]
+}
+
+// size returns the number of addresses in the pool
+func (p ipv4Pool) size() uint {
+	return 1 << (32 - p.subnetLen)
+}
+
+func (p ipv4Pool) set(idx uint) {
+	if p.allocCount != nil && !p.allocMap.Test(idx) {
+		*p.allocCount++
+	}
+	p.allocMap.Set(idx)
+}
+
+func (p ipv4Pool) clear(idx uint) {
+	if p.allocCount != nil && p.allocMap.Test(idx) {
+		*p.allocCount--
+	}
+	p.allocMap.Clear(idx)
+}
+
+// subnetsOverlap checks if two ipv4 subnets overlap
+func subnetsOverlap(subnetIP1 string, subnetLen1 uint, subnetIP2 string, subnetLen2 uint) bool {
+	_, net1, err1 := net.ParseCIDR(fmt.Sprintf("%s/%d", subnetIP1, subnetLen1))
+	_, net2, err2 := net.ParseCIDR(fmt.Sprintf("%s/%d", subnetIP2, subnetLen2))
+	if err1 != nil || err2 != nil {
+		return false
+	}
+
+	return net1.Contains(net2.IP) || net2.Contains(net1.IP)
+}
+
+// AddNetworkSubnet adds an additional subnet to an existing network
+func AddNetworkSubnet(stateDriver core.StateDriver, networkID, subnetCIDR, gateway string) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(networkID)
+	if err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return err
+	}
+
+	subnetIP, subnetLen, err := netutils.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return err
+	}
+	if netutils.IsIPv6(subnetIP) {
+		return core.Errorf("additional subnets must be ipv4")
+	}
+	if subnetLen < 8 || subnetLen > 30 {
+		return core.Errorf("subnet length %d not supported", subnetLen)
+	}
+	err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
+	if err != nil {
+		return err
+	}
+
+	subnetAddr := netutils.GetSubnetAddr(subnetIP, subnetLen)
+	for _, p := range networkIPv4Pools(nwCfg) {
+		if subnetsOverlap(p.subnetIP, p.subnetLen, subnetAddr, subnetLen) {
+			return core.Errorf("subnet %s overlaps with subnet %s/%d of network %s",
+				subnetCIDR, p.subnetIP, p.subnetLen, networkID)
+		}
+	}
+
+	sp := &mastercfg.SubnetPool{
+		SubnetIP:  subnetAddr,
+		SubnetLen: subnetLen,
+	}
+	netutils.InitSubnetBitset(&sp.IPAllocMap, subnetLen)
+
+	if gateway != "" {
+		if net.ParseIP(gateway) == nil {
+			return core.Errorf("invalid gateway %s", gateway)
+		}
+
+		// Reserve gateway IP address
+		ipAddrValue, err := netutils.GetIPNumber(subnetAddr, subnetLen, 32, gateway)
+		if err != nil {
+			log.Errorf("Error parsing gateway address %s. Err: %v", gateway, err)
+			return err
+		}
+		sp.Gateway = gateway
+		sp.IPAllocMap.Set(ipAddrValue)
+	}
+
+	if strings.Contains(subnetIP, "-") {
+		netutils.SetBitsOutsideRange(&sp.IPAllocMap, subnetIP, subnetLen)
+	}
+
+	nwCfg.SubnetPools = append(nwCfg.SubnetPools, sp)
+
+	log.Infof("Adding subnet %s to network %s", subnetCIDR, networkID)
+
+	return nwCfg.Write()
+}
+
+// DeleteNetworkSubnet removes an additional subnet from a network
+func DeleteNetworkSubnet(stateDriver core.StateDriver, networkID, subnetCIDR string) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(networkID)
+	if err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return err
+	}
+
+	subnetIP, subnetLen, err := netutils.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return err
+	}
+	subnetAddr := netutils.GetSubnetAddr(subnetIP, subnetLen)
+
+	for idx, sp := range nwCfg.SubnetPools {
+		if sp.SubnetIP != subnetAddr || sp.SubnetLen != subnetLen {
+			continue
+		}
+
+		if sp.AllocCount > 0 {
+			return core.Errorf("subnet %s has %d allocated addresses", subnetCIDR, sp.AllocCount)
+		}
+		for _, resv := range nwCfg.IPReservations {
+			addrs, _ := reservedAddresses(resv)
+			for _, addr := range addrs {
+				if _, err := netutils.GetIPNumber(sp.SubnetIP, sp.SubnetLen, 32, addr); err == nil {
+					return core.Errorf("subnet %s has reservation %s", subnetCIDR, resv.Name)
+				}
+			}
+		}
+
+		log.Infof("Removing subnet %s from network %s", subnetCIDR, networkID)
+
+		nwCfg.SubnetPools = append(nwCfg.SubnetPools[:idx], nwCfg.SubnetPools[idx+1:]...)
+		return nwCfg.Write()
+	}
+
+	if subnetAddr == nwCfg.SubnetIP && subnetLen == nwCfg.SubnetLen {
+		return core.Errorf("can not remove the primary subnet of network %s", networkID)
+	}
+
+	return core.Errorf("subnet %s not found in network %s", subnetCIDR, networkID)
+}
+
 // Allocate an address from the network
 func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv6 bool) (string, error) {
 	var ipAddress string
@@ -549,12 +731,13 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv
 	var found bool
 	var err error
 	var hostID string
+	var pool ipv4Pool
 
 	// alloc address
 	if reqAddr == "" {
 		if isIPv6 {
 			// Get the next available IPv6 address
-			hostID, err = netutils.GetNextIPv6HostID(nwCfg.IPv6LastHost, nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, nwCfg.IPv6AllocMap)
+			hostID, err = nwCfg.IPv6AllocRanges.NextClear(nwCfg.IPv6LastHost, nwCfg.IPv6SubnetLen)
 			if err != nil {
 				log.Errorf("create eps: error allocating ip. Error: %s", err)
 				return "", err
@@ -566,15 +749,24 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv
 			}
 			nwCfg.IPv6LastHost = hostID
 		} else {
-			ipAddrValue, found = nwCfg.IPAllocMap.NextClear(0)
-			if !found {
-				log.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
-					nwCfg.SubnetIP, nwCfg.SubnetLen)
-				err = core.Errorf("auto allocation failed - address exhaustion in subnet %s/%d",
-					nwCfg.SubnetIP, nwCfg.SubnetLen)
+			// allocate from the pools in the order they were added
+			subnets := []string{}
+			for _, p := range networkIPv4Pools(nwCfg) {
+				ipAddrValue, found = p.allocMap.NextClear(0)
+				if found && ipAddrValue < p.size() {
+					pool = p
+					break
+				}
+				subnets = append(subnets, fmt.Sprintf("%s/%d", p.subnetIP, p.subnetLen))
+			}
+			if pool.allocMap == nil {
+				log.Errorf("auto allocation failed - address exhaustion in subnets %s",
+					strings.Join(subnets, ", "))
+				err = core.Errorf("auto allocation failed - address exhaustion in subnets %s",
+					strings.Join(subnets, ", "))
 				return "", err
 			}
-			ipAddress, err = netutils.GetSubnetIP(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddrValue)
+			ipAddress, err = netutils.GetSubnetIP(pool.subnetIP, pool.subnetLen, 32, ipAddrValue)
 			if err != nil {
 				log.Errorf("create eps: error acquiring subnet ip. Error: %s", err)
 				return "", err
@@ -598,22 +790,28 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv
 				return "", err
 			}
 		} else {
-			ipAddrValue, err = netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, reqAddr)
+			pool = findIPv4Pool(nwCfg, reqAddr)
+			ipAddrValue, err = netutils.GetIPNumber(pool.subnetIP, pool.subnetLen, 32, reqAddr)
 			if err != nil {
 				log.Errorf("create eps: error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-					reqAddr, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+					reqAddr, pool.subnetIP, pool.subnetLen, err)
 				return "", err
 			}
+			if isExcludedAddress(nwCfg, reqAddr) {
+				return "", core.Errorf("address %s is in a reserved range of network %s", reqAddr, nwCfg.ID)
+			}
 		}
 
 		ipAddress = reqAddr
 	}
 
 	if isIPv6 {
-		netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
+		if err = nwCfg.IPv6AllocRanges.Set(hostID); err != nil {
+			return "", err
+		}
 	} else {
 		// Set the bitmap
-		nwCfg.IPAllocMap.Set(ipAddrValue)
+		pool.set(ipAddrValue)
 	}
 
 	err = nwCfg.Write()
@@ -629,33 +827,37 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv
 func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
 	isIPv6 := netutils.IsIPv6(ipAddress)
 	if isIPv6 {
-		hostID, err := netutils.GetIPv6HostID(nwCfg.SubnetIP, nwCfg.SubnetLen, ipAddress)
+		hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, ipAddress)
 		if err != nil {
 			log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-				ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+				ipAddress, nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, err)
 			return err
 		}
 		// networkReleaseAddress is called from multiple places
 		// Make sure we decrement the EpCount only if the IPAddress
 		// was not already freed earlier
-		if _, found := nwCfg.IPv6AllocMap[hostID]; found {
+		if nwCfg.IPv6AllocRanges.Test(hostID) {
 			nwCfg.EpAddrCount--
 		}
-		delete(nwCfg.IPv6AllocMap, hostID)
+		nwCfg.IPv6AllocRanges.Clear(hostID)
+	} else if findReservation(nwCfg, ipAddress) != nil {
+		// reserved addresses stay allocated until the reservation is removed
+		log.Infof("Keeping reserved address %s in network %s", ipAddress, nwCfg.ID)
 	} else {
-		ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, ipAddress)
+		pool := findIPv4Pool(nwCfg, ipAddress)
+		ipAddrValue, err := netutils.GetIPNumber(pool.subnetIP, pool.subnetLen, 32, ipAddress)
 		if err != nil {
 			log.Errorf("error getting host id from hostIP %s Subnet %s/%d. Error: %s",
-				ipAddress, nwCfg.SubnetIP, nwCfg.SubnetLen, err)
+				ipAddress, pool.subnetIP, pool.subnetLen, err)
 			return err
 		}
 		// networkReleaseAddress is called from multiple places
 		// Make sure we decrement the EpCount only if the IPAddress
 		// was not already freed earlier
-		if nwCfg.IPAllocMap.Test(ipAddrValue) {
+		if pool.allocMap.Test(ipAddrValue) {
 			nwCfg.EpAddrCount--
 		}
-		nwCfg.IPAllocMap.Clear(ipAddrValue)
+		pool.clear(ipAddrValue)
 	}
 
 	err := nwCfg.Write()
@@ -668,7 +870,5 @@ func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) e
 }
 
 func hasActiveEndpoints(nwCfg *mastercfg.CfgNetworkState) bool {
-	// We spin a dns container if IsDNSEnabled() == true
-	// We need to exlude that from Active EPs check.
-	return (IsDNSEnabled() && nwCfg.EpCount > 1) || ((!IsDNSEnabled()) && nwCfg.EpCount > 0)
+	return nwCfg.EpCount > 0
 }
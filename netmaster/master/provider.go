@@ -17,6 +17,7 @@ package master
 
 import (
 	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/utils"
 )
@@ -42,8 +43,18 @@ func SvcProviderUpdate(serviceID string, isDelete bool) error {
 		return nil
 	}
 
-	for _, provider := range mastercfg.ServiceLBDb[serviceID].Providers {
-		providerList = append(providerList, provider.IPAddress)
+	//only healthy providers receive traffic
+	service := mastercfg.ServiceLBDb[serviceID]
+	for providerID, provider := range service.Providers {
+		if providerIsHealthy(service, providerID) {
+			providerList = append(providerList, provider.IPAddress)
+			if weight := providerWeight(service, provider); weight != 1 {
+				if svcProvider.Weights == nil {
+					svcProvider.Weights = make(map[string]int)
+				}
+				svcProvider.Weights[provider.IPAddress] = weight
+			}
+		}
 	}
 
 	//empty the current provider list
@@ -67,6 +78,111 @@ func getProviderID(provider *mastercfg.Provider) string {
 	return provider.IPAddress + ":" + provider.Tenant
 }
 
+// getProviderDbID returns the provider db key of a provider. A container
+// attached to several networks has a provider on each of them.
 func getProviderDbID(provider *mastercfg.Provider) string {
-	return provider.ContainerID
+	if provider.ContainerID == "" {
+		return ""
+	}
+	return provider.ContainerID + ":" + provider.Network + "." + provider.Tenant
+}
+
+// providerServesService checks if a provider in the tenant of a service can
+// serve it. A container attached to several networks of the tenant serves
+// the service from its endpoint in the service network, if it has one.
+func providerServesService(provider *mastercfg.Provider, service *mastercfg.ServiceLBInfo) bool {
+	if provider.Tenant != service.Tenant {
+		return false
+	}
+	if service.Network == "" || provider.Network == service.Network {
+		return true
+	}
+
+	svcNwProvider := &mastercfg.Provider{
+		ContainerID: provider.ContainerID,
+		Network:     service.Network,
+		Tenant:      service.Tenant,
+	}
+	return mastercfg.ProviderDb[getProviderDbID(svcNwProvider)] == nil
+}
+
+// removeServiceProvider removes a provider from a service
+func removeServiceProvider(txn *svcTxn, provider *mastercfg.Provider, serviceID string) {
+	for i, service := range provider.Services {
+		if service == serviceID {
+			provider.Services = append(provider.Services[:i], provider.Services[i+1:]...)
+			break
+		}
+	}
+	if providerDbID := getProviderDbID(provider); mastercfg.ProviderDb[providerDbID] != nil {
+		txn.providerChanged(providerDbID)
+	}
+
+	providerID := getProviderID(provider)
+	if service := mastercfg.ServiceLBDb[serviceID]; service != nil && service.Providers[providerID] != nil {
+		delete(service.Providers, providerID)
+		delete(service.ProviderHealth, providerID)
+		txn.serviceChanged(serviceID)
+	}
+}
+
+// addProviderToServices adds a provider to the services whose selectors
+// match its labels
+func addProviderToServices(txn *svcTxn, provider *mastercfg.Provider) {
+	providerID := getProviderID(provider)
+	for serviceID, service := range mastercfg.ServiceLBDb {
+		if !providerServesService(provider, service) ||
+			!serviceMatchesLabels(service, provider.Labels) {
+			continue
+		}
+
+		if provider.Network == service.Network {
+			//the container serves the service from the service
+			//network, not from its other networks
+			for _, otherProvider := range service.Providers {
+				if otherProvider.ContainerID == provider.ContainerID &&
+					otherProvider.Network != provider.Network {
+					removeServiceProvider(txn, otherProvider, serviceID)
+				}
+			}
+		}
+
+		//Container corresponds to the service since it
+		//matches all service Selectors
+		if !stringInSlice(serviceID, provider.Services) {
+			provider.Services = append(provider.Services, serviceID)
+		}
+		service.Providers[providerID] = provider
+		txn.serviceChanged(serviceID)
+	}
+	txn.providerChanged(getProviderDbID(provider))
+}
+
+// addServiceProvider records a provider started by a netplugin and adds it
+// to the services it matches
+func addServiceProvider(stateDriver core.StateDriver, provider *mastercfg.Provider) error {
+	return updateSvcDb(stateDriver, func(txn *svcTxn) error {
+		mastercfg.ProviderDb[getProviderDbID(provider)] = provider
+		addProviderToServices(txn, provider)
+		return nil
+	})
+}
+
+// removeContainerProviders removes the providers of a container from the
+// provider db and their services. A container is a provider on each of its
+// networks.
+func removeContainerProviders(stateDriver core.StateDriver, containerID string) error {
+	return updateSvcDb(stateDriver, func(txn *svcTxn) error {
+		for providerDbID, provider := range mastercfg.ProviderDb {
+			if provider.ContainerID != containerID {
+				continue
+			}
+			for _, serviceID := range append([]string{}, provider.Services...) {
+				removeServiceProvider(txn, provider, serviceID)
+			}
+			delete(mastercfg.ProviderDb, providerDbID)
+			txn.providerChanged(providerDbID)
+		}
+		return nil
+	})
 }
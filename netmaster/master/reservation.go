@@ -0,0 +1,327 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+)
+
+// maxReservedRange limits the number of addresses in an exclude range
+const maxReservedRange = 65536
+
+// ipv4ToUint converts an ipv4 address to a number
+func ipv4ToUint(ipAddr string) (uint32, error) {
+	ip := net.ParseIP(strings.TrimSpace(ipAddr)).To4()
+	if ip == nil {
+		return 0, core.Errorf("invalid ipv4 address %q", ipAddr)
+	}
+
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]), nil
+}
+
+// uintToIPv4 converts a number to an ipv4 address
+func uintToIPv4(ipNum uint32) string {
+	return net.IPv4(byte(ipNum>>24), byte(ipNum>>16), byte(ipNum>>8), byte(ipNum)).String()
+}
+
+// reservationRange returns the first and last address of a reservation
+func reservationRange(resv *mastercfg.IPReservation) (uint32, uint32, error) {
+	if resv.IPRange == "" {
+		ipNum, err := ipv4ToUint(resv.IPAddress)
+		return ipNum, ipNum, err
+	}
+
+	// range is either a single address or start-end
+	fields := strings.Split(resv.IPRange, "-")
+	if len(fields) > 2 {
+		return 0, 0, core.Errorf("invalid ip range %q", resv.IPRange)
+	}
+
+	start, err := ipv4ToUint(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start
+	if len(fields) == 2 {
+		end, err = ipv4ToUint(fields[1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if end < start || end-start >= maxReservedRange {
+		return 0, 0, core.Errorf("invalid ip range %q", resv.IPRange)
+	}
+
+	return start, end, nil
+}
+
+// reservedAddresses returns all addresses held by a reservation
+func reservedAddresses(resv *mastercfg.IPReservation) ([]string, error) {
+	start, end, err := reservationRange(resv)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{}
+	for ipNum := start; ipNum <= end; ipNum++ {
+		addrs = append(addrs, uintToIPv4(ipNum))
+		if ipNum == end {
+			break
+		}
+	}
+
+	return addrs, nil
+}
+
+// reservationBit returns the pool and bit of an address that can be reserved
+func reservationBit(nwCfg *mastercfg.CfgNetworkState, ipAddr string) (ipv4Pool, uint, error) {
+	pool := findIPv4Pool(nwCfg, ipAddr)
+	ipAddrValue, err := netutils.GetIPNumber(pool.subnetIP, pool.subnetLen, 32, ipAddr)
+	if err != nil {
+		return pool, 0, core.Errorf("address %s is not in any subnet of network %s", ipAddr, nwCfg.ID)
+	}
+
+	return pool, ipAddrValue, nil
+}
+
+// findReservation returns the reservation holding an address
+func findReservation(nwCfg *mastercfg.CfgNetworkState, ipAddr string) *mastercfg.IPReservation {
+	ipNum, err := ipv4ToUint(ipAddr)
+	if err != nil {
+		return nil
+	}
+
+	for _, resv := range nwCfg.IPReservations {
+		start, end, err := reservationRange(resv)
+		if err == nil && ipNum >= start && ipNum <= end {
+			return resv
+		}
+	}
+
+	return nil
+}
+
+// isExcludedAddress checks if an address is in an exclude range
+func isExcludedAddress(nwCfg *mastercfg.CfgNetworkState, ipAddr string) bool {
+	resv := findReservation(nwCfg, ipAddr)
+	return resv != nil && resv.IPRange != ""
+}
+
+// reservationEndpoint returns the endpoint using a sticky address
+func reservationEndpoint(nwCfg *mastercfg.CfgNetworkState, resv *mastercfg.IPReservation) string {
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = nwCfg.StateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if err != nil {
+		return ""
+	}
+
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if ep.NetID == nwCfg.ID && ep.IPAddress == resv.IPAddress {
+			return ep.ID
+		}
+	}
+
+	return ""
+}
+
+// checkReservedAddress checks that a requested address is not the sticky
+// address of another owner or of a running endpoint. Docker does not tell the
+// ipam driver which container an address is for, so an empty owner claims a
+// sticky address by requesting it.
+func checkReservedAddress(nwCfg *mastercfg.CfgNetworkState, ipAddr, owner string) error {
+	resv := findReservation(nwCfg, ipAddr)
+	if resv == nil || resv.IPRange != "" {
+		// exclude ranges are refused by networkAllocAddress
+		return nil
+	}
+
+	if owner != "" && owner != resv.Owner {
+		return core.Errorf("address %s is reserved for %s in network %s", ipAddr, resv.Owner, nwCfg.ID)
+	}
+	if epID := reservationEndpoint(nwCfg, resv); epID != "" {
+		return core.Errorf("reserved address %s is in use by endpoint %s", ipAddr, epID)
+	}
+
+	return nil
+}
+
+// stickyAddress returns the address reserved for a container or pod
+func stickyAddress(nwCfg *mastercfg.CfgNetworkState, owner string) string {
+	if owner == "" {
+		return ""
+	}
+
+	for _, resv := range nwCfg.IPReservations {
+		if resv.Owner == owner {
+			return resv.IPAddress
+		}
+	}
+
+	return ""
+}
+
+// AddIPReservation adds a named reservation to a network
+func AddIPReservation(stateDriver core.StateDriver, networkID string, resv *mastercfg.IPReservation) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(networkID)
+	if err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return err
+	}
+
+	if resv.Name == "" {
+		return core.Errorf("reservation name is required")
+	}
+	if _, ok := nwCfg.IPReservations[resv.Name]; ok {
+		return core.Errorf("reservation %s already exists in network %s", resv.Name, networkID)
+	}
+
+	if resv.IPRange != "" {
+		if resv.Owner != "" || resv.IPAddress != "" {
+			return core.Errorf("ip range can not be combined with a sticky address")
+		}
+	} else {
+		if resv.Owner == "" {
+			return core.Errorf("either an ip range or an owner is required")
+		}
+		if addr := stickyAddress(nwCfg, resv.Owner); addr != "" {
+			return core.Errorf("%s already has reserved address %s", resv.Owner, addr)
+		}
+
+		// pick the next free address if none was specified
+		if resv.IPAddress == "" {
+			for _, p := range networkIPv4Pools(nwCfg) {
+				ipAddrValue, found := p.allocMap.NextClear(0)
+				if found && ipAddrValue < p.size() {
+					resv.IPAddress, err = netutils.GetSubnetIP(p.subnetIP, p.subnetLen, 32, ipAddrValue)
+					if err != nil {
+						return err
+					}
+					break
+				}
+			}
+			if resv.IPAddress == "" {
+				return core.Errorf("no free address in network %s", networkID)
+			}
+		}
+	}
+
+	addrs, err := reservedAddresses(resv)
+	if err != nil {
+		return err
+	}
+
+	// verify all addresses before reserving any of them
+	for _, addr := range addrs {
+		pool, ipAddrValue, err := reservationBit(nwCfg, addr)
+		if err != nil {
+			return err
+		}
+		if pool.allocMap.Test(ipAddrValue) {
+			return core.Errorf("address %s in network %s is already in use", addr, networkID)
+		}
+	}
+
+	for _, addr := range addrs {
+		pool, ipAddrValue, _ := reservationBit(nwCfg, addr)
+		pool.allocMap.Set(ipAddrValue)
+	}
+
+	if nwCfg.IPReservations == nil {
+		nwCfg.IPReservations = make(map[string]*mastercfg.IPReservation)
+	}
+	nwCfg.IPReservations[resv.Name] = resv
+
+	log.Infof("Adding reservation %+v to network %s", resv, networkID)
+
+	return nwCfg.Write()
+}
+
+// DeleteIPReservation removes a named reservation from a network
+func DeleteIPReservation(stateDriver core.StateDriver, networkID, name string) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(networkID)
+	if err != nil {
+		log.Errorf("network %s is not operational", networkID)
+		return err
+	}
+
+	resv, ok := nwCfg.IPReservations[name]
+	if !ok {
+		return core.Errorf("reservation %s not found in network %s", name, networkID)
+	}
+
+	// sticky address can not be released while an endpoint is using it
+	if resv.IPRange == "" {
+		if epID := reservationEndpoint(nwCfg, resv); epID != "" {
+			return core.Errorf("reserved address %s is in use by endpoint %s", resv.IPAddress, epID)
+		}
+	}
+
+	addrs, err := reservedAddresses(resv)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		pool, ipAddrValue, err := reservationBit(nwCfg, addr)
+		if err != nil {
+			log.Errorf("Error releasing reserved address %s. Err: %v", addr, err)
+			continue
+		}
+		pool.allocMap.Clear(ipAddrValue)
+	}
+
+	delete(nwCfg.IPReservations, name)
+
+	log.Infof("Removing reservation %s from network %s", name, networkID)
+
+	return nwCfg.Write()
+}
+
+// ListIPReservations returns the reservations of a network
+func ListIPReservations(nwCfg *mastercfg.CfgNetworkState) []string {
+	names := []string{}
+	for name := range nwCfg.IPReservations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := []string{}
+	for _, name := range names {
+		resv := nwCfg.IPReservations[name]
+		if resv.IPRange != "" {
+			list = append(list, fmt.Sprintf("%s: %s excluded", name, resv.IPRange))
+		} else {
+			list = append(list, fmt.Sprintf("%s: %s owner %s", name, resv.IPAddress, resv.Owner))
+		}
+	}
+
+	return list
+}
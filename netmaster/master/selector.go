@@ -0,0 +1,150 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// SelectorOpEquals is the operator of equality selectors, key=value
+const SelectorOpEquals = "="
+
+var (
+	selectorKeyRegexp   = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9_./]*$`)
+	selectorValueRegexp = regexp.MustCompile(`^[-A-Za-z0-9_.]*$`)
+	selectorSetRegexp   = regexp.MustCompile(`^(\S+)\s+(in|notin)\s+\((.*)\)$`)
+)
+
+// ParseSelector parses a service selector. The selector is one of
+// key=value, key!=value, "key in (v1,v2)", "key notin (v1,v2)",
+// key (the label exists) or !key (the label does not exist).
+func ParseSelector(selector string) (*mastercfg.SelectorExpr, error) {
+	selector = strings.TrimSpace(selector)
+	expr := &mastercfg.SelectorExpr{}
+
+	switch {
+	case selectorSetRegexp.MatchString(selector):
+		match := selectorSetRegexp.FindStringSubmatch(selector)
+		expr.Key, expr.Operator = match[1], match[2]
+		for _, value := range strings.Split(match[3], ",") {
+			value = strings.TrimSpace(value)
+			if value == "" || !selectorValueRegexp.MatchString(value) {
+				return nil, core.Errorf("invalid value %q in selector %s", value, selector)
+			}
+			expr.Values = append(expr.Values, value)
+		}
+
+	case strings.Contains(selector, "!="):
+		kv := strings.SplitN(selector, "!=", 2)
+		expr.Key, expr.Operator = strings.TrimSpace(kv[0]), mastercfg.SelectorOpNotIn
+		expr.Values = []string{strings.TrimSpace(kv[1])}
+
+	case strings.Contains(selector, "="):
+		kv := strings.SplitN(strings.Replace(selector, "==", "=", 1), "=", 2)
+		expr.Key, expr.Operator = strings.TrimSpace(kv[0]), SelectorOpEquals
+		expr.Values = []string{strings.TrimSpace(kv[1])}
+
+	case strings.HasPrefix(selector, "!"):
+		expr.Key = strings.TrimSpace(selector[1:])
+		expr.Operator = mastercfg.SelectorOpDoesNotExist
+
+	default:
+		expr.Key, expr.Operator = selector, mastercfg.SelectorOpExists
+	}
+
+	if !selectorKeyRegexp.MatchString(expr.Key) {
+		return nil, core.Errorf("invalid key %q in selector %s", expr.Key, selector)
+	}
+	for _, value := range expr.Values {
+		if !selectorValueRegexp.MatchString(value) {
+			return nil, core.Errorf("invalid value %q in selector %s", value, selector)
+		}
+	}
+
+	return expr, nil
+}
+
+// parseSelectorExprs parses the set based selectors of a service
+func parseSelectorExprs(selectors []string) ([]mastercfg.SelectorExpr, error) {
+	exprs := []mastercfg.SelectorExpr{}
+	for _, selector := range selectors {
+		expr, err := ParseSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		if expr.Operator == SelectorOpEquals {
+			return nil, core.Errorf("equality selector %s is not a set based selector", selector)
+		}
+		exprs = append(exprs, *expr)
+	}
+
+	return exprs, nil
+}
+
+// selectorsMatch checks if labels match all the selectors of a service.
+// Labels that the selectors do not mention are ignored.
+func selectorsMatch(selectors map[string]string, exprs []mastercfg.SelectorExpr,
+	labels map[string]string) bool {
+	for key, value := range selectors {
+		if label, ok := labels[key]; !ok || label != value {
+			return false
+		}
+	}
+
+	for idx := range exprs {
+		if !exprMatches(&exprs[idx], labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// exprMatches checks if labels match a selector expression
+func exprMatches(expr *mastercfg.SelectorExpr, labels map[string]string) bool {
+	label, ok := labels[expr.Key]
+	switch expr.Operator {
+	case SelectorOpEquals, mastercfg.SelectorOpIn:
+		return ok && stringInSlice(label, expr.Values)
+	case mastercfg.SelectorOpNotIn:
+		return !ok || !stringInSlice(label, expr.Values)
+	case mastercfg.SelectorOpExists:
+		return ok
+	case mastercfg.SelectorOpDoesNotExist:
+		return !ok
+	default:
+		return false
+	}
+}
+
+// serviceMatchesLabels checks if labels match the selectors of a service
+func serviceMatchesLabels(service *mastercfg.ServiceLBInfo, labels map[string]string) bool {
+	return selectorsMatch(service.Selectors, service.SelectorExprs, labels)
+}
+
+func stringInSlice(str string, list []string) bool {
+	for _, item := range list {
+		if item == str {
+			return true
+		}
+	}
+
+	return false
+}
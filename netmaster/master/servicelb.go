@@ -28,116 +28,146 @@ import (
 //CreateServiceLB adds to the etcd state
 func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigServiceLB) error {
 
-	var providersPresent bool
 	serviceIP := serviceLbCfg.IPAddress
 
 	log.Infof("Recevied Create Service Load Balancer config {%v}", serviceLbCfg)
 
+	selectorExprs, err := parseSelectorExprs(serviceLbCfg.SelectorExprs)
+	if err != nil {
+		return err
+	}
+
+	healthCheck, err := getHealthCheck(serviceLbCfg.HealthCheck, serviceLbCfg.Ports)
+	if err != nil {
+		return err
+	}
+
+	lbPolicy, err := getLBPolicy(serviceLbCfg.LBPolicy)
+	if err != nil {
+		return err
+	}
+
 	//Check if service already exists.
 	svcID := getServiceID(serviceLbCfg.ServiceName, serviceLbCfg.Tenant)
 
 	mastercfg.SvcMutex.RLock()
 	oldServiceInfo := mastercfg.ServiceLBDb[svcID]
-	mastercfg.SvcMutex.RUnlock()
-
+	unchanged, keepIP := false, false
 	if oldServiceInfo != nil {
-		//ServiceInfo Exists
-		if reflect.DeepEqual(oldServiceInfo.Ports, serviceLbCfg.Ports) &&
+		unchanged = reflect.DeepEqual(oldServiceInfo.Ports, serviceLbCfg.Ports) &&
 			reflect.DeepEqual(oldServiceInfo.Selectors, serviceLbCfg.Selectors) &&
-			serviceLbCfg.Tenant == oldServiceInfo.Tenant {
-			return nil
-		}
-		serviceIP = oldServiceInfo.IPAddress
-		DeleteServiceLB(stateDriver, oldServiceInfo.ServiceName, oldServiceInfo.Tenant)
+			reflect.DeepEqual(oldServiceInfo.SelectorExprs, selectorExprs) &&
+			reflect.DeepEqual(oldServiceInfo.HealthCheck, healthCheck) &&
+			reflect.DeepEqual(oldServiceInfo.LBPolicy, lbPolicy) &&
+			oldServiceInfo.External == serviceLbCfg.External &&
+			oldServiceInfo.Network == serviceLbCfg.Network &&
+			serviceLbCfg.Tenant == oldServiceInfo.Tenant
+		// the service keeps its ip unless it moves in or out of the VIP
+		// pool or to another network
+		keepIP = oldServiceInfo.External == serviceLbCfg.External &&
+			(serviceLbCfg.External || oldServiceInfo.Network == serviceLbCfg.Network)
 	}
+	mastercfg.SvcMutex.RUnlock()
 
-	//New Service
-	serviceLbState := &mastercfg.CfgServiceLBState{}
-	serviceLbState.ServiceName = serviceLbCfg.ServiceName
-	serviceLbState.Tenant = serviceLbCfg.Tenant
-	serviceLbState.Network = serviceLbCfg.Network
-	serviceLbState.StateDriver = stateDriver
-	serviceLbState.ID = getServiceID(serviceLbCfg.ServiceName, serviceLbCfg.Tenant)
-	serviceLbState.Ports = append(serviceLbState.Ports, serviceLbCfg.Ports...)
-	serviceLbState.Selectors = make(map[string]string)
-	serviceLbState.Providers = make(map[string]*mastercfg.Provider)
-	for k, v := range serviceLbCfg.Selectors {
-		serviceLbState.Selectors[k] = v
+	if unchanged {
+		return nil
 	}
 
 	// find the network from network id
-	networkID := serviceLbState.Network + "." + serviceLbState.Tenant
+	networkID := serviceLbCfg.Network + "." + serviceLbCfg.Tenant
 	nwCfg := &mastercfg.CfgNetworkState{}
 	nwCfg.StateDriver = stateDriver
-	err := nwCfg.Read(networkID)
+	err = nwCfg.Read(networkID)
 	if err != nil {
-		log.Errorf("network %s on tenant %s is not created %s", serviceLbState.Network, serviceLbCfg.Tenant, networkID)
+		log.Errorf("network %s on tenant %s is not created %s", serviceLbCfg.Network, serviceLbCfg.Tenant, networkID)
 		return err
 	}
 
-	// Alloc addresses
-	addr, err := networkAllocAddress(nwCfg, serviceIP, false)
-	if err != nil {
-		log.Errorf("Failed to allocate address. Err: %v", err)
-		return err
+	// Alloc addresses, external services from the service VIP pool
+	var addr string
+	if keepIP {
+		addr = oldServiceInfo.IPAddress
+	} else if serviceLbCfg.External {
+		addr, err = allocServiceVIP(stateDriver, serviceIP)
+	} else {
+		addr, err = networkAllocAddress(nwCfg, serviceIP, false)
 	}
-	serviceLbState.IPAddress = addr
-	mastercfg.SvcMutex.Lock()
-	err = serviceLbState.Write()
-
 	if err != nil {
-		mastercfg.SvcMutex.Unlock()
+		log.Errorf("Failed to allocate address. Err: %v", err)
 		return err
 	}
 
-	serviceID := getServiceID(serviceLbState.ServiceName, serviceLbState.Tenant)
-
-	mastercfg.ServiceLBDb[serviceID] = &mastercfg.ServiceLBInfo{
-		IPAddress:   serviceLbState.IPAddress,
-		Tenant:      serviceLbState.Tenant,
-		ServiceName: serviceLbState.ServiceName,
-		Network:     serviceLbState.Network,
+	//New Service
+	service := &mastercfg.ServiceLBInfo{
+		IPAddress:      addr,
+		Tenant:         serviceLbCfg.Tenant,
+		ServiceName:    serviceLbCfg.ServiceName,
+		Network:        serviceLbCfg.Network,
+		Selectors:      make(map[string]string),
+		SelectorExprs:  selectorExprs,
+		Providers:      make(map[string]*mastercfg.Provider),
+		HealthCheck:    healthCheck,
+		ProviderHealth: make(map[string]string),
+		LBPolicy:       lbPolicy,
+		External:       serviceLbCfg.External,
 	}
-	mastercfg.ServiceLBDb[serviceID].Ports = append(mastercfg.ServiceLBDb[serviceID].Ports, serviceLbState.Ports...)
-	mastercfg.ServiceLBDb[serviceID].Selectors = make(map[string]string)
-	mastercfg.ServiceLBDb[serviceID].Providers = make(map[string]*mastercfg.Provider)
-
+	service.Ports = append(service.Ports, serviceLbCfg.Ports...)
 	for k, v := range serviceLbCfg.Selectors {
-		mastercfg.ServiceLBDb[serviceID].Selectors[k] = v
+		service.Selectors[k] = v
 	}
 
-	//Check for containers in the tenant matching service selectors
-	for _, providerInfo := range mastercfg.ProviderDb {
-		if providerInfo.Tenant == serviceLbState.Tenant {
-			if eq := reflect.DeepEqual(providerInfo.Labels, mastercfg.ServiceLBDb[serviceID].Selectors); eq {
+	// an update replaces the service in one transaction
+	err = updateSvcDb(stateDriver, func(txn *svcTxn) error {
+		if mastercfg.ServiceLBDb[svcID] != oldServiceInfo {
+			return core.Errorf("service %s was changed concurrently", svcID)
+		}
+
+		if oldServiceInfo != nil {
+			// the providers keep their health while the check is the same
+			if reflect.DeepEqual(oldServiceInfo.HealthCheck, healthCheck) {
+				for providerID, health := range oldServiceInfo.ProviderHealth {
+					service.ProviderHealth[providerID] = health
+				}
+			}
+			for _, providerInfo := range oldServiceInfo.Providers {
+				removeServiceProvider(txn, providerInfo, svcID)
+			}
+		}
+		mastercfg.ServiceLBDb[svcID] = service
+		txn.serviceChanged(svcID)
+
+		//Check for containers in the tenant matching service selectors
+		for providerDbID, providerInfo := range mastercfg.ProviderDb {
+			if providerServesService(providerInfo, service) &&
+				serviceMatchesLabels(service, providerInfo.Labels) {
 				//provider matches service selectors
-				providerID := getProviderID(providerInfo)
-				providerDbID := getProviderDbID(providerInfo)
-				providerInfo.Services = append(providerInfo.Services, serviceID)
-				mastercfg.ServiceLBDb[serviceID].Providers[providerID] = providerInfo
-				mastercfg.ProviderDb[providerDbID] = providerInfo
-				serviceLbState.Providers[providerID] = providerInfo
-				providersPresent = true
+				providerInfo.Services = append(providerInfo.Services, svcID)
+				service.Providers[getProviderID(providerInfo)] = providerInfo
+				txn.providerChanged(providerDbID)
 			}
 		}
-	}
 
-	//Write into cluster store
-	err = serviceLbState.Write()
+		// health of the providers no longer serving the service
+		for providerID := range service.ProviderHealth {
+			if service.Providers[providerID] == nil {
+				delete(service.ProviderHealth, providerID)
+			}
+		}
 
+		return nil
+	})
 	if err != nil {
-		mastercfg.SvcMutex.Unlock()
+		log.Errorf("Error creating service %s. Err: %v", svcID, err)
+		if !keepIP {
+			releaseServiceIP(stateDriver, nwCfg, addr, serviceLbCfg.External)
+		}
 		return err
 	}
 
-	if providersPresent {
-		err = SvcProviderUpdate(serviceID, false)
-		if err != nil {
-			log.Errorf("Error updating Provider for service %s : %s", serviceID, err)
-			return err
-		}
+	// the ip of the service before the update
+	if oldServiceInfo != nil && !keepIP {
+		releaseServiceNetworkIP(stateDriver, oldServiceInfo)
 	}
-	mastercfg.SvcMutex.Unlock()
 
 	return nil
 }
@@ -146,141 +176,158 @@ func CreateServiceLB(stateDriver core.StateDriver, serviceLbCfg *intent.ConfigSe
 func DeleteServiceLB(stateDriver core.StateDriver, serviceName string, tenantName string) error {
 
 	log.Infof("Received Delete Service Load Balancer %s on %s", serviceName, tenantName)
-	serviceLBState := &mastercfg.CfgServiceLBState{}
-	serviceLBState.StateDriver = stateDriver
-	serviceLBState.ID = getServiceID(serviceName, tenantName)
 
-	mastercfg.SvcMutex.RLock()
-	err := serviceLBState.Read(serviceLBState.ID)
+	serviceID := getServiceID(serviceName, tenantName)
+	var service *mastercfg.ServiceLBInfo
+	err := updateSvcDb(stateDriver, func(txn *svcTxn) error {
+		service = mastercfg.ServiceLBDb[serviceID]
+		if service == nil {
+			return core.Errorf("service %s in tenant %s not found", serviceName, tenantName)
+		}
+
+		//Remove the service ID from the provider cache
+		for _, providerInfo := range service.Providers {
+			if providerDbID := getProviderDbID(providerInfo); mastercfg.ProviderDb[providerDbID] != nil {
+				removeServiceProvider(txn, mastercfg.ProviderDb[providerDbID], serviceID)
+			}
+		}
+
+		//Remove the service from the service cache
+		delete(mastercfg.ServiceLBDb, serviceID)
+		txn.serviceChanged(serviceID)
+
+		return nil
+	})
 	if err != nil {
-		mastercfg.SvcMutex.RUnlock()
-		log.Errorf("Error reading service lb config for service %s in tenant %s", serviceName, tenantName)
+		log.Errorf("Error deleting service lb config for service %s in tenant %s. Err: %v",
+			serviceName, tenantName, err)
 		return err
 	}
-	mastercfg.SvcMutex.RUnlock()
 
+	return releaseServiceNetworkIP(stateDriver, service)
+}
+
+// releaseServiceNetworkIP releases the ip of a service to the pool of its
+// network or to the service VIP pool
+func releaseServiceNetworkIP(stateDriver core.StateDriver, service *mastercfg.ServiceLBInfo) error {
 	// find the network from network id
 	nwCfg := &mastercfg.CfgNetworkState{}
 	nwCfg.StateDriver = stateDriver
-	networkID := serviceLBState.Network + "." + serviceLBState.Tenant
-	err = nwCfg.Read(networkID)
+	networkID := service.Network + "." + service.Tenant
+	err := nwCfg.Read(networkID)
 	if err != nil {
-		log.Errorf("network %s is not operational. Service object deletion failed", networkID)
+		log.Errorf("network %s is not operational. Service address %s was not released", networkID, service.IPAddress)
 		return err
 	}
-	err = networkReleaseAddress(nwCfg, serviceLBState.IPAddress)
-	if err != nil {
-		log.Errorf("Network release address  failed %s", err)
-	}
+	releaseServiceIP(stateDriver, nwCfg, service.IPAddress, service.External)
 
-	serviceID := getServiceID(serviceLBState.ServiceName, serviceLBState.Tenant)
+	return nil
+}
 
-	mastercfg.SvcMutex.Lock()
-	//Remove the service ID from the provider cache
-	for _, providerInfo := range mastercfg.ServiceLBDb[serviceID].Providers {
-		containerID := providerInfo.ContainerID
-		for i, service := range mastercfg.ProviderDb[containerID].Services {
-			if service == serviceID {
-				mastercfg.ProviderDb[containerID].Services =
-					append(mastercfg.ProviderDb[containerID].Services[:i],
-						mastercfg.ProviderDb[containerID].Services[i+1:]...)
-			}
-		}
+// releaseServiceIP releases the ip of a service, to the service VIP pool if
+// the service is external
+func releaseServiceIP(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	ipAddress string, external bool) {
+	var err error
+	if external {
+		err = releaseServiceVIP(stateDriver, ipAddress)
+	} else {
+		err = networkReleaseAddress(nwCfg, ipAddress)
 	}
-	//Remove the service from the service cache
-	delete(mastercfg.ServiceLBDb, serviceID)
-
-	SvcProviderUpdate(serviceID, true)
-
-	err = serviceLBState.Clear()
 	if err != nil {
-		mastercfg.SvcMutex.Unlock()
-
-		log.Errorf("Error deleing service lb config for service %s in tenant %s", serviceName, tenantName)
-		return err
+		log.Errorf("Network release address  failed %s", err)
 	}
-	mastercfg.SvcMutex.Unlock()
-
-	return nil
-
 }
 
 func getServiceID(servicename string, tenantname string) string {
 	return servicename + ":" + tenantname
 }
 
-//RestoreServiceProviderLBDb restores provider and servicelb db
+//RestoreServiceProviderLBDb restores provider and servicelb db from the
+//state store, replacing the dbs of an earlier term as leader
 func RestoreServiceProviderLBDb() {
 
 	log.Infof("Restoring ProviderDb and ServiceDB cache")
 
-	svcLBState := &mastercfg.CfgServiceLBState{}
 	stateDriver, err := utils.GetStateDriver()
 	if err != nil {
 		log.Errorf("Error Restoring Service and ProviderDb Err:%s", err)
 		return
 	}
-	svcLBState.StateDriver = stateDriver
-	svcLBCfgs, err := svcLBState.ReadAll()
-
-	if err == nil {
-		mastercfg.SvcMutex.Lock()
-		for _, svcLBCfg := range svcLBCfgs {
-			svcLB := svcLBCfg.(*mastercfg.CfgServiceLBState)
-			//mastercfg.ServiceLBDb = make(map[string]*mastercfg.ServiceLBInfo)
-			serviceID := getServiceID(svcLB.ServiceName, svcLB.Tenant)
-			mastercfg.ServiceLBDb[serviceID] = &mastercfg.ServiceLBInfo{
-				IPAddress:   svcLB.IPAddress,
-				Tenant:      svcLB.Tenant,
-				ServiceName: svcLB.ServiceName,
-				Network:     svcLB.Network,
-			}
-			mastercfg.ServiceLBDb[serviceID].Ports = append(mastercfg.ServiceLBDb[serviceID].Ports, svcLB.Ports...)
 
-			mastercfg.ServiceLBDb[serviceID].Selectors = make(map[string]string)
-			mastercfg.ServiceLBDb[serviceID].Providers = make(map[string]*mastercfg.Provider)
-
-			for k, v := range svcLB.Selectors {
-				mastercfg.ServiceLBDb[serviceID].Selectors[k] = v
-			}
+	mastercfg.SvcMutex.Lock()
+	err = loadSvcDb(stateDriver)
+	legacy := false
+	if len(mastercfg.ProviderDb) == 0 {
+		for _, service := range mastercfg.ServiceLBDb {
+			legacy = legacy || len(service.Providers) > 0
+		}
+	}
+	mastercfg.SvcMutex.Unlock()
+	if err != nil {
+		log.Errorf("Error reading service load balancer state from cluster store. Err: %v", err)
+		return
+	}
 
-			for providerID, providerInfo := range svcLB.Providers {
-				mastercfg.ServiceLBDb[serviceID].Providers[providerID] = providerInfo
-				providerDBId := providerInfo.ContainerID
-				mastercfg.ProviderDb[providerDBId] = providerInfo
-			}
+	// stores of earlier releases only hold the providers of services
+	if legacy {
+		err = restoreLegacyProviders(stateDriver)
+		if err != nil {
+			log.Errorf("Error restoring the provider db. Err: %v", err)
 		}
-		mastercfg.SvcMutex.Unlock()
-	} else {
-		log.Errorf("Error reading service load balancer state from cluster store")
 	}
+}
+
+// restoreLegacyProviders recovers the provider db of a state store without
+// providers from the services and the endpoints
+func restoreLegacyProviders(stateDriver core.StateDriver) error {
+	log.Infof("Recovering ProviderDb from the services and endpoints")
 
-	//Recover from endpoint state as well .
 	epCfgState := mastercfg.CfgEndpointState{}
 	epCfgState.StateDriver = stateDriver
 	epCfgs, err := epCfgState.ReadAll()
-	if err == nil {
+	if core.ErrIfKeyExists(err) != nil {
+		log.Errorf("Error reading endpoint config during restore")
+		return err
+	}
+
+	return updateSvcDb(stateDriver, func(txn *svcTxn) error {
+		for _, service := range mastercfg.ServiceLBDb {
+			for _, providerInfo := range service.Providers {
+				providerDBId := getProviderDbID(providerInfo)
+				if providerDBId != "" && mastercfg.ProviderDb[providerDBId] == nil {
+					mastercfg.ProviderDb[providerDBId] = providerInfo
+					txn.providerChanged(providerDBId)
+				}
+			}
+		}
+		linkServiceProviders()
+
 		for _, epCfg := range epCfgs {
 			ep := epCfg.(*mastercfg.CfgEndpointState)
-			providerDBId := ep.ContainerID
-			if ep.Labels != nil && mastercfg.ProviderDb[providerDBId] == nil {
-				//Create provider info and store it in provider db
-				providerInfo := &mastercfg.Provider{}
-				providerInfo.ContainerID = ep.ContainerID
-				providerInfo.Network = strings.Split(ep.NetID, ".")[0]
-				providerInfo.Tenant = strings.Split(ep.NetID, ".")[1]
+			if len(ep.Labels) == 0 {
+				continue
+			}
+			//Create provider info and store it in provider db
+			providerInfo := &mastercfg.Provider{}
+			providerInfo.ContainerID = ep.ContainerID
+			providerInfo.Network = strings.Split(ep.NetID, ".")[0]
+			providerInfo.Tenant = strings.Split(ep.NetID, ".")[1]
+			providerDBId := getProviderDbID(providerInfo)
+			if providerDBId != "" && mastercfg.ProviderDb[providerDBId] == nil {
 				providerInfo.Labels = make(map[string]string)
 				providerInfo.IPAddress = ep.IPAddress
+				providerInfo.Host = ep.HomingHost
 
 				for k, v := range ep.Labels {
 					providerInfo.Labels[k] = v
 				}
-				mastercfg.SvcMutex.Lock()
 				mastercfg.ProviderDb[providerDBId] = providerInfo
-				mastercfg.SvcMutex.Unlock()
+				// the services of providers missing in the service state
+				addProviderToServices(txn, providerInfo)
 			}
 		}
-	} else {
-		log.Errorf("Error reading endpoint config during restore")
-	}
+
+		return nil
+	})
 }
@@ -0,0 +1,131 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils/netutils"
+)
+
+// serviceVIPPoolID is the id of the cluster wide service VIP pool
+const serviceVIPPoolID = "global"
+
+// setServiceVIPPool configures the pool of external service VIPs, removing
+// it when the pool is empty. The pool can not change while VIPs are in use.
+func setServiceVIPPool(stateDriver core.StateDriver, pool string) error {
+	if pool != "" {
+		poolNet, err := parsePoolCIDR(pool)
+		if err != nil {
+			return err
+		}
+		subnetLen, _ := poolNet.Mask.Size()
+		if poolNet.IP.To4() == nil || subnetLen < 8 || subnetLen > 30 {
+			return core.Errorf("invalid service VIP pool %s, expecting an ipv4 subnet of /8 to /30", pool)
+		}
+		pool = poolNet.String()
+	}
+
+	poolCfg := &mastercfg.CfgServiceVIPPool{}
+	poolCfg.StateDriver = stateDriver
+	err := poolCfg.Read(serviceVIPPoolID)
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	if err == nil {
+		if poolCfg.Pool == pool {
+			return nil
+		}
+		// the network and broadcast addresses are always set
+		if poolCfg.IPAllocMap.Count() > 2 {
+			return core.Errorf("service VIP pool %s is in use by external services", poolCfg.Pool)
+		}
+		if pool == "" {
+			log.Infof("Removing service VIP pool %s", poolCfg.Pool)
+			return poolCfg.Clear()
+		}
+	} else if pool == "" {
+		return nil
+	}
+
+	poolCfg = &mastercfg.CfgServiceVIPPool{Pool: pool}
+	poolCfg.ID = serviceVIPPoolID
+	poolCfg.StateDriver = stateDriver
+	_, subnetLen, _ := netutils.ParseCIDR(pool)
+	netutils.InitSubnetBitset(&poolCfg.IPAllocMap, subnetLen)
+
+	log.Infof("Setting service VIP pool %s", pool)
+
+	return poolCfg.Write()
+}
+
+// allocServiceVIP allocates a VIP of an external service, the requested one
+// if any
+func allocServiceVIP(stateDriver core.StateDriver, reqAddr string) (string, error) {
+	poolCfg := &mastercfg.CfgServiceVIPPool{}
+	poolCfg.StateDriver = stateDriver
+	if err := poolCfg.Read(serviceVIPPoolID); err != nil {
+		log.Errorf("Error reading service VIP pool. Err: %v", err)
+		return "", core.Errorf("no service VIP pool configured for external services")
+	}
+
+	subnetIP, subnetLen, _ := netutils.ParseCIDR(poolCfg.Pool)
+	var idx uint
+	if reqAddr != "" {
+		var err error
+		idx, err = netutils.GetIPNumber(subnetIP, subnetLen, 32, reqAddr)
+		if err != nil {
+			return "", core.Errorf("address %s is not within service VIP pool %s", reqAddr, poolCfg.Pool)
+		}
+		if poolCfg.IPAllocMap.Test(idx) {
+			return "", core.Errorf("address %s is already in use in service VIP pool %s", reqAddr, poolCfg.Pool)
+		}
+	} else {
+		var found bool
+		idx, found = poolCfg.IPAllocMap.NextClear(0)
+		if !found || idx >= 1<<(32-subnetLen) {
+			return "", core.Errorf("address exhaustion in service VIP pool %s", poolCfg.Pool)
+		}
+	}
+
+	poolCfg.IPAllocMap.Set(idx)
+	vip, err := netutils.GetSubnetIP(subnetIP, subnetLen, 32, idx)
+	if err != nil {
+		return "", err
+	}
+
+	return vip, poolCfg.Write()
+}
+
+// releaseServiceVIP releases a VIP of an external service
+func releaseServiceVIP(stateDriver core.StateDriver, vip string) error {
+	poolCfg := &mastercfg.CfgServiceVIPPool{}
+	poolCfg.StateDriver = stateDriver
+	if err := poolCfg.Read(serviceVIPPoolID); err != nil {
+		log.Errorf("Error reading service VIP pool. Err: %v", err)
+		return err
+	}
+
+	subnetIP, subnetLen, _ := netutils.ParseCIDR(poolCfg.Pool)
+	idx, err := netutils.GetIPNumber(subnetIP, subnetLen, 32, vip)
+	if err != nil {
+		return err
+	}
+	poolCfg.IPAllocMap.Clear(idx)
+
+	return poolCfg.Write()
+}
@@ -0,0 +1,370 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"bytes"
+	"encoding/json"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// svcTxn is a transaction on the service and provider dbs. The dbs are
+// changed in place and the changed entries are recorded. On commit the
+// entries that differ from the state store are written, and the providers
+// of the changed services are published once all writes succeeded. On
+// failure the writes are undone and the dbs are restored from the entries
+// stored before the transaction. The state store has no multi key
+// transactions, so this is best-effort: the watchers see the writes one
+// by one, and an undo that fails leaves its entry written.
+type svcTxn struct {
+	stateDriver core.StateDriver
+	services    map[string]bool // changed services
+	providers   map[string]bool // changed providers keyed by provider db id
+
+	// entries stored before the transaction, nil if not stored
+	oldServices  map[string]*mastercfg.CfgServiceLBState
+	oldProviders map[string]*mastercfg.CfgProviderState
+	published    []string // services published by the commit
+}
+
+// svcDbWrite is a write of a changed entry to the state store
+type svcDbWrite struct {
+	old core.State // the stored entry, nil if none
+	new core.State // the changed entry, nil if deleted
+}
+
+// apply writes the changed entry
+func (w *svcDbWrite) apply() error {
+	if w.new == nil {
+		return core.ErrIfKeyExists(w.old.Clear())
+	}
+	return w.new.Write()
+}
+
+// undo restores the stored entry
+func (w *svcDbWrite) undo() error {
+	if w.old == nil {
+		return core.ErrIfKeyExists(w.new.Clear())
+	}
+	return w.old.Write()
+}
+
+// updateSvcDb runs update as a transaction on the service and provider dbs,
+// holding the service mutex. The entries update changed are written to the
+// state store and the providers of the changed services are published to
+// the netplugins. A failed transaction is undone as far as the store allows.
+func updateSvcDb(stateDriver core.StateDriver, update func(txn *svcTxn) error) error {
+	mastercfg.SvcMutex.Lock()
+	defer mastercfg.SvcMutex.Unlock()
+
+	txn := &svcTxn{
+		stateDriver: stateDriver,
+		services:    make(map[string]bool),
+		providers:   make(map[string]bool),
+	}
+
+	err := update(txn)
+	if err == nil {
+		err = txn.commit()
+	}
+	if err != nil {
+		txn.rollback()
+		return err
+	}
+
+	return nil
+}
+
+// serviceChanged records a change of a service, a delete if the service is
+// no longer in the service db
+func (txn *svcTxn) serviceChanged(serviceID string) {
+	txn.services[serviceID] = true
+}
+
+// providerChanged records a change of a provider, a delete if the provider
+// is no longer in the provider db
+func (txn *svcTxn) providerChanged(providerDbID string) {
+	if providerDbID != "" {
+		txn.providers[providerDbID] = true
+	}
+}
+
+// readOld reads the stored entries of the changed services and providers.
+// Entries that can not be read are taken as not stored.
+func (txn *svcTxn) readOld() error {
+	var readErr error
+	txn.oldProviders = make(map[string]*mastercfg.CfgProviderState)
+	for providerDbID := range txn.providers {
+		providerState := &mastercfg.CfgProviderState{}
+		providerState.StateDriver = txn.stateDriver
+		if err := providerState.Read(providerDbID); err != nil {
+			if core.ErrIfKeyExists(err) != nil {
+				log.Errorf("Error reading provider %s. Err: %v", providerDbID, err)
+				readErr = err
+			}
+			continue
+		}
+		txn.oldProviders[providerDbID] = providerState
+	}
+
+	txn.oldServices = make(map[string]*mastercfg.CfgServiceLBState)
+	for serviceID := range txn.services {
+		serviceLbState := &mastercfg.CfgServiceLBState{}
+		serviceLbState.StateDriver = txn.stateDriver
+		if err := serviceLbState.Read(serviceID); err != nil {
+			if core.ErrIfKeyExists(err) != nil {
+				log.Errorf("Error reading service %s. Err: %v", serviceID, err)
+				readErr = err
+			}
+			continue
+		}
+		txn.oldServices[serviceID] = serviceLbState
+	}
+
+	return readErr
+}
+
+// diff returns the writes of the entries that differ from the store
+func (txn *svcTxn) diff() []*svcDbWrite {
+	writes := []*svcDbWrite{}
+	addWrite := func(w *svcDbWrite) {
+		if w.old == nil && w.new == nil {
+			return
+		}
+		if w.old != nil && w.new != nil {
+			oldJSON, oldErr := json.Marshal(w.old)
+			newJSON, newErr := json.Marshal(w.new)
+			if oldErr == nil && newErr == nil && bytes.Equal(oldJSON, newJSON) {
+				return
+			}
+		}
+		writes = append(writes, w)
+	}
+
+	for providerDbID := range txn.providers {
+		w := &svcDbWrite{}
+		if old := txn.oldProviders[providerDbID]; old != nil {
+			w.old = old
+		}
+		if provider := mastercfg.ProviderDb[providerDbID]; provider != nil {
+			providerState := &mastercfg.CfgProviderState{Provider: *provider}
+			providerState.StateDriver = txn.stateDriver
+			providerState.ID = providerDbID
+			w.new = providerState
+		}
+		addWrite(w)
+	}
+
+	for serviceID := range txn.services {
+		w := &svcDbWrite{}
+		if old := txn.oldServices[serviceID]; old != nil {
+			w.old = old
+		}
+		if service := mastercfg.ServiceLBDb[serviceID]; service != nil {
+			w.new = serviceLBState(txn.stateDriver, serviceID, service)
+		}
+		addWrite(w)
+	}
+
+	return writes
+}
+
+// commit writes the changed entries one by one and publishes the changed
+// services. The writes are not atomic, a failed write undoes the ones
+// before it as best it can.
+func (txn *svcTxn) commit() error {
+	if err := txn.readOld(); err != nil {
+		return err
+	}
+
+	writes := txn.diff()
+	for i, w := range writes {
+		if err := w.apply(); err != nil {
+			log.Errorf("Error writing the service db. Err: %v", err)
+			undoWrites(writes[:i])
+			return err
+		}
+	}
+
+	for serviceID := range txn.services {
+		err := SvcProviderUpdate(serviceID, mastercfg.ServiceLBDb[serviceID] == nil)
+		if err != nil {
+			log.Errorf("Error publishing the providers of service %s. Err: %v", serviceID, err)
+			undoWrites(writes)
+			return err
+		}
+		txn.published = append(txn.published, serviceID)
+	}
+
+	return nil
+}
+
+// undoWrites restores the stored entries in reverse order
+func undoWrites(writes []*svcDbWrite) {
+	for i := len(writes) - 1; i >= 0; i-- {
+		if err := writes[i].undo(); err != nil {
+			log.Errorf("Error restoring the service db. Err: %v", err)
+		}
+	}
+}
+
+// rollback restores the changed entries of the dbs to the stored entries
+// and publishes the services again whose changes were published
+func (txn *svcTxn) rollback() {
+	log.Warnf("Restoring services %v and providers %v from the state store",
+		setKeys(txn.services), setKeys(txn.providers))
+
+	if txn.oldServices == nil {
+		txn.readOld()
+	}
+
+	for providerDbID := range txn.providers {
+		if providerState := txn.oldProviders[providerDbID]; providerState != nil {
+			mastercfg.ProviderDb[providerDbID] = &providerState.Provider
+		} else {
+			delete(mastercfg.ProviderDb, providerDbID)
+		}
+	}
+
+	for serviceID := range txn.services {
+		if serviceLbState := txn.oldServices[serviceID]; serviceLbState != nil {
+			mastercfg.ServiceLBDb[serviceID] = serviceLBInfo(serviceLbState)
+		} else {
+			delete(mastercfg.ServiceLBDb, serviceID)
+		}
+	}
+
+	linkServiceProviders()
+
+	for _, serviceID := range txn.published {
+		err := SvcProviderUpdate(serviceID, mastercfg.ServiceLBDb[serviceID] == nil)
+		if err != nil {
+			log.Errorf("Error publishing the providers of service %s. Err: %v", serviceID, err)
+		}
+	}
+}
+
+// loadSvcDb replaces the service and provider dbs with the services and
+// providers in the state store. The caller holds the service mutex.
+func loadSvcDb(stateDriver core.StateDriver) error {
+	serviceLBDb := make(map[string]*mastercfg.ServiceLBInfo)
+	providerDb := make(map[string]*mastercfg.Provider)
+
+	svcLBState := &mastercfg.CfgServiceLBState{}
+	svcLBState.StateDriver = stateDriver
+	svcLBCfgs, err := svcLBState.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	for _, svcLBCfg := range svcLBCfgs {
+		svcLB := svcLBCfg.(*mastercfg.CfgServiceLBState)
+		serviceLBDb[getServiceID(svcLB.ServiceName, svcLB.Tenant)] = serviceLBInfo(svcLB)
+	}
+
+	providerState := &mastercfg.CfgProviderState{}
+	providerState.StateDriver = stateDriver
+	providerCfgs, err := providerState.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	for _, providerCfg := range providerCfgs {
+		provider := providerCfg.(*mastercfg.CfgProviderState)
+		providerDb[provider.ID] = &provider.Provider
+	}
+
+	mastercfg.ServiceLBDb = serviceLBDb
+	mastercfg.ProviderDb = providerDb
+	linkServiceProviders()
+
+	return nil
+}
+
+// linkServiceProviders points the providers of the services to their
+// provider db entries, the entries holding the services of a provider
+func linkServiceProviders() {
+	for _, service := range mastercfg.ServiceLBDb {
+		for providerID, provider := range service.Providers {
+			if dbProvider := mastercfg.ProviderDb[getProviderDbID(provider)]; dbProvider != nil {
+				service.Providers[providerID] = dbProvider
+			}
+		}
+	}
+}
+
+// serviceLBInfo returns the service db entry of a service state
+func serviceLBInfo(svcLB *mastercfg.CfgServiceLBState) *mastercfg.ServiceLBInfo {
+	service := &mastercfg.ServiceLBInfo{
+		IPAddress:      svcLB.IPAddress,
+		Tenant:         svcLB.Tenant,
+		ServiceName:    svcLB.ServiceName,
+		Network:        svcLB.Network,
+		Selectors:      make(map[string]string),
+		SelectorExprs:  svcLB.SelectorExprs,
+		Providers:      make(map[string]*mastercfg.Provider),
+		HealthCheck:    svcLB.HealthCheck,
+		ProviderHealth: make(map[string]string),
+		LBPolicy:       svcLB.LBPolicy,
+		External:       svcLB.External,
+	}
+	service.Ports = append(service.Ports, svcLB.Ports...)
+	for k, v := range svcLB.Selectors {
+		service.Selectors[k] = v
+	}
+	for providerID, provider := range svcLB.Providers {
+		service.Providers[providerID] = provider
+	}
+	for k, v := range svcLB.ProviderHealth {
+		service.ProviderHealth[k] = v
+	}
+
+	return service
+}
+
+// serviceLBState returns the state of a service db entry
+func serviceLBState(stateDriver core.StateDriver, serviceID string,
+	service *mastercfg.ServiceLBInfo) *mastercfg.CfgServiceLBState {
+	svcLB := &mastercfg.CfgServiceLBState{
+		ServiceName:    service.ServiceName,
+		Tenant:         service.Tenant,
+		Network:        service.Network,
+		Ports:          service.Ports,
+		Selectors:      service.Selectors,
+		SelectorExprs:  service.SelectorExprs,
+		IPAddress:      service.IPAddress,
+		Providers:      service.Providers,
+		HealthCheck:    service.HealthCheck,
+		ProviderHealth: service.ProviderHealth,
+		LBPolicy:       service.LBPolicy,
+		External:       service.External,
+	}
+	svcLB.StateDriver = stateDriver
+	svcLB.ID = serviceID
+
+	return svcLB
+}
+
+// setKeys returns the keys of a set
+func setKeys(set map[string]bool) []string {
+	list := []string{}
+	for key := range set {
+		list = append(list, key)
+	}
+
+	return list
+}
@@ -0,0 +1,164 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// defaults of service health checks
+const (
+	defaultHealthCheckInterval = 10
+	defaultHealthCheckTimeout  = 5
+	defaultHealthyThreshold    = 2
+	defaultUnhealthyThreshold  = 3
+)
+
+// getHealthCheck returns the health check of a service, nil if the
+// providers of the service are not health checked
+func getHealthCheck(cfg *intent.ConfigHealthCheck, ports []string) (*mastercfg.HealthCheck, error) {
+	if cfg == nil || cfg.Type == "" {
+		return nil, nil
+	}
+	if cfg.Type != "tcp" && cfg.Type != "http" {
+		return nil, core.Errorf("invalid health check %s, expecting tcp or http", cfg.Type)
+	}
+
+	check := &mastercfg.HealthCheck{
+		Type:               cfg.Type,
+		Path:               cfg.Path,
+		Interval:           cfg.Interval,
+		Timeout:            cfg.Timeout,
+		HealthyThreshold:   cfg.HealthyThreshold,
+		UnhealthyThreshold: cfg.UnhealthyThreshold,
+	}
+	if check.Interval == 0 {
+		check.Interval = defaultHealthCheckInterval
+	}
+	if check.Timeout == 0 {
+		check.Timeout = defaultHealthCheckTimeout
+		if check.Timeout > check.Interval {
+			check.Timeout = check.Interval
+		}
+	}
+	if check.HealthyThreshold == 0 {
+		check.HealthyThreshold = defaultHealthyThreshold
+	}
+	if check.UnhealthyThreshold == 0 {
+		check.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	if check.Type == "http" && check.Path == "" {
+		check.Path = "/"
+	}
+	if check.Interval < 0 || check.Timeout < 0 || check.Timeout > check.Interval ||
+		check.HealthyThreshold < 0 || check.UnhealthyThreshold < 0 {
+		return nil, core.Errorf("invalid health check interval %d, timeout %d or thresholds %d/%d",
+			check.Interval, check.Timeout, check.HealthyThreshold, check.UnhealthyThreshold)
+	}
+	if check.Type == "http" && !strings.HasPrefix(check.Path, "/") {
+		return nil, core.Errorf("invalid http health check path %s", check.Path)
+	}
+
+	// the provider port of the first service port is checked by default
+	port := cfg.Port
+	if port == 0 && len(ports) > 0 {
+		portInfo := strings.Split(ports[0], ":")
+		if len(portInfo) == 3 {
+			port, _ = strconv.Atoi(portInfo[1])
+		}
+	}
+	if port <= 0 || port > 65535 {
+		return nil, core.Errorf("invalid health check port %d", port)
+	}
+	check.Port = uint16(port)
+
+	return check, nil
+}
+
+// providerIsHealthy checks if a provider of a service can receive traffic.
+// Providers of health checked services do once their checks pass.
+func providerIsHealthy(service *mastercfg.ServiceLBInfo, providerID string) bool {
+	if service.HealthCheck == nil {
+		return true
+	}
+
+	return service.ProviderHealth[providerID] == mastercfg.ProviderHealthy
+}
+
+// UpdateProviderHealth records the health of a provider of a service, as
+// reported by the netplugin hosting the provider
+func UpdateProviderHealth(stateDriver core.StateDriver, req *SvcProviderHealthRequest) error {
+	health := mastercfg.ProviderUnhealthy
+	if req.Healthy {
+		health = mastercfg.ProviderHealthy
+	}
+
+	return updateSvcDb(stateDriver, func(txn *svcTxn) error {
+		service := mastercfg.ServiceLBDb[req.ServiceID]
+		if service == nil || service.Providers[req.ProviderID] == nil {
+			return core.Errorf("provider %s of service %s not found", req.ProviderID, req.ServiceID)
+		}
+		if service.ProviderHealth[req.ProviderID] == health {
+			return nil
+		}
+
+		log.Infof("Provider %s of service %s is %s", req.ProviderID, req.ServiceID, health)
+
+		if service.ProviderHealth == nil {
+			service.ProviderHealth = make(map[string]string)
+		}
+		service.ProviderHealth[req.ProviderID] = health
+		txn.serviceChanged(req.ServiceID)
+
+		return nil
+	})
+}
+
+// GetServiceProviders returns the providers of a service and their health
+// as "ip container health", sorted by ip
+func GetServiceProviders(serviceName, tenantName string) []string {
+	mastercfg.SvcMutex.RLock()
+	defer mastercfg.SvcMutex.RUnlock()
+
+	providers := []string{}
+	service := mastercfg.ServiceLBDb[getServiceID(serviceName, tenantName)]
+	if service == nil {
+		return providers
+	}
+
+	for providerID, provider := range service.Providers {
+		health := "-"
+		if service.HealthCheck != nil {
+			health = service.ProviderHealth[providerID]
+			if health == "" {
+				health = "unknown"
+			}
+		}
+		providers = append(providers, fmt.Sprintf("%s %s %s",
+			provider.IPAddress, provider.ContainerID, health))
+	}
+	sort.Strings(providers)
+
+	return providers
+}
@@ -0,0 +1,510 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+const (
+	// TracePathLocal means both endpoints are on the same host
+	TracePathLocal = "local"
+	// TracePathUplink means packets leave the host vlan tagged on the uplink
+	TracePathUplink = "uplink"
+	// TracePathVtep means packets are tunneled between vteps
+	TracePathVtep = "vtep"
+
+	// TraceVerdictAllow means policy permits the flow
+	TraceVerdictAllow = "allow"
+	// TraceVerdictDeny means policy drops the flow
+	TraceVerdictDeny = "deny"
+
+	vlanBridgeName  = "contivVlanBridge"
+	vxlanBridgeName = "contivVxlanBridge"
+)
+
+// policyDirName names the rule directions in the trace hops
+var policyDirName = map[string]string{"out": "egress", "in": "ingress"}
+
+// TraceRequest is the trace request from netctl
+type TraceRequest struct {
+	SrcEndpoint string // source endpoint id or container id
+	DstEndpoint string // destination endpoint id or container id
+	SrcIP       string // source ip, used when SrcEndpoint is not specified
+	DstIP       string // destination ip or service ip
+	Protocol    string // tcp, udp or icmp
+	DstPort     int    // destination port
+	Tenant      string // tenant of the endpoints, all tenants if empty
+}
+
+// TraceEndpoint describes an endpoint taking part in a trace
+type TraceEndpoint struct {
+	EndpointID    string
+	Container     string
+	IPAddress     string
+	MacAddress    string
+	Network       string
+	Tenant        string
+	EndpointGroup string
+	EndpointGrpID int
+	Host          string
+	VtepIP        string
+}
+
+// TraceService describes the load balancer translation applied to a trace
+type TraceService struct {
+	ServiceName string
+	IPAddress   string
+	ServicePort int
+	ProvPort    int
+	Providers   []string
+	Provider    string
+}
+
+// TraceRule describes a policy rule that matched the traced flow
+type TraceRule struct {
+	PolicyKey string
+	RuleID    string
+	Direction string
+	Priority  int
+	Action    string
+}
+
+// TraceHostCmd is an ovs trace command to run on a host of the path. The
+// commands are returned for the operator to run, netmaster does not run
+// them.
+type TraceHostCmd struct {
+	Host    string
+	Command string
+}
+
+// TraceResponse is the packet path netmaster computes from the state
+type TraceResponse struct {
+	Source        *TraceEndpoint
+	Destination   *TraceEndpoint
+	Service       *TraceService `json:",omitempty"`
+	Path          string
+	Encap         string
+	PktTag        int
+	Routed        bool
+	PolicyVerdict string
+	MatchedRules  []TraceRule `json:",omitempty"`
+	Hops          []string
+	TraceCommands []TraceHostCmd
+}
+
+// epInTenant checks if an endpoint is on a network of the tenant, the
+// network ids are network.tenant
+func epInTenant(ep *mastercfg.CfgEndpointState, tenant string) bool {
+	return tenant == "" || strings.HasSuffix(ep.NetID, "."+tenant)
+}
+
+// findTraceEndpoint looks up an endpoint of a tenant by id, container id or
+// ip address. All the tenants are searched when tenant is empty, the
+// endpoint must then be unique across the tenants.
+func findTraceEndpoint(stateDriver core.StateDriver, tenant, epID, ipAddr string) (*mastercfg.CfgEndpointState, error) {
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	if epID != "" && epCfg.Read(epID) == nil && epInTenant(epCfg, tenant) {
+		return epCfg, nil
+	}
+
+	epCfgs, err := epCfg.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *mastercfg.CfgEndpointState
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if !epInTenant(ep, tenant) {
+			continue
+		}
+		if epID != "" {
+			if ep.ContName != epID && (ep.ContainerID == "" || !strings.HasPrefix(ep.ContainerID, epID)) {
+				continue
+			}
+		} else if ipAddr == "" || (ep.IPAddress != ipAddr && ep.IPv6Address != ipAddr) {
+			continue
+		}
+
+		if found != nil && found.ID != ep.ID {
+			return nil, core.Errorf("endpoint %s%s is ambiguous, found on networks %s and %s. Specify the tenant",
+				epID, ipAddr, found.NetID, ep.NetID)
+		}
+		found = ep
+	}
+	if found != nil {
+		return found, nil
+	}
+
+	if epID != "" {
+		return nil, core.Errorf("endpoint %s not found", epID)
+	}
+
+	return nil, core.Errorf("endpoint with ip %s not found", ipAddr)
+}
+
+// newTraceEndpoint builds the trace view of an endpoint
+func newTraceEndpoint(epCfg *mastercfg.CfgEndpointState, nwCfg *mastercfg.CfgNetworkState) *TraceEndpoint {
+	return &TraceEndpoint{
+		EndpointID:    epCfg.ID,
+		Container:     epCfg.ContName,
+		IPAddress:     epCfg.IPAddress,
+		MacAddress:    epCfg.MacAddress,
+		Network:       nwCfg.NetworkName,
+		Tenant:        nwCfg.Tenant,
+		EndpointGroup: epCfg.ServiceName,
+		EndpointGrpID: epCfg.EndpointGroupID,
+		Host:          epCfg.HomingHost,
+		VtepIP:        epCfg.VtepIP,
+	}
+}
+
+// findTraceService returns the service whose virtual ip is ipAddr
+func findTraceService(ipAddr, tenant string, port int, protocol string) *TraceService {
+	mastercfg.SvcMutex.RLock()
+	defer mastercfg.SvcMutex.RUnlock()
+
+	for _, svc := range mastercfg.ServiceLBDb {
+		if svc.IPAddress != ipAddr || (tenant != "" && svc.Tenant != tenant) {
+			continue
+		}
+
+		tSvc := &TraceService{
+			ServiceName: svc.ServiceName,
+			IPAddress:   svc.IPAddress,
+			ServicePort: port,
+			ProvPort:    port,
+		}
+
+		// translate the service port; format is svcPort:provPort:protocol
+		for _, p := range svc.Ports {
+			fields := strings.Split(p, ":")
+			if len(fields) != 3 {
+				continue
+			}
+			svcPort, _ := strconv.Atoi(fields[0])
+			provPort, _ := strconv.Atoi(fields[1])
+			if (port == 0 || svcPort == port) &&
+				(protocol == "" || strings.EqualFold(fields[2], protocol)) {
+				tSvc.ServicePort = svcPort
+				tSvc.ProvPort = provPort
+				break
+			}
+		}
+
+		for provIP := range svc.Providers {
+			tSvc.Providers = append(tSvc.Providers, provIP)
+		}
+		sort.Strings(tSvc.Providers)
+
+		return tSvc
+	}
+
+	return nil
+}
+
+// ipMatches checks if ipAddr matches an ip address or a cidr
+func ipMatches(match, ipAddr string) bool {
+	if match == ipAddr {
+		return true
+	}
+
+	_, ipNet, err := net.ParseCIDR(match)
+	if err != nil {
+		return false
+	}
+
+	return ipNet.Contains(net.ParseIP(ipAddr))
+}
+
+// ruleMatchesPeer checks if the remote side of a rule matches the peer endpoint
+func ruleMatchesPeer(epg, network, ipAddr string, peer *TraceEndpoint) bool {
+	if epg != "" && epg != peer.EndpointGroup {
+		return false
+	}
+	if network != "" && network != peer.Network {
+		return false
+	}
+	if ipAddr != "" && !ipMatches(ipAddr, peer.IPAddress) {
+		return false
+	}
+
+	return true
+}
+
+// matchPolicyRules returns the rules of policies attached to the endpoint's group
+// that apply to traffic flowing in the given direction
+func matchPolicyRules(ep, peer *TraceEndpoint, dir, protocol string, port int) []TraceRule {
+	var matched []TraceRule
+	if ep.EndpointGrpID == 0 {
+		return matched
+	}
+
+	for _, gp := range mastercfg.FindEpgPoliciesByGroupID(ep.EndpointGrpID) {
+		for _, ruleMap := range gp.RuleMaps {
+			rule := ruleMap.Rule
+			if rule == nil || rule.Direction != dir || rule.TenantName != ep.Tenant {
+				continue
+			}
+			if rule.Protocol != "" && protocol != "" && !strings.EqualFold(rule.Protocol, protocol) {
+				continue
+			}
+			if rule.Port != 0 && rule.Port != port {
+				continue
+			}
+
+			var peerMatch bool
+			if dir == "in" {
+				peerMatch = ruleMatchesPeer(rule.FromEndpointGroup, rule.FromNetwork, rule.FromIpAddress, peer)
+			} else {
+				peerMatch = ruleMatchesPeer(rule.ToEndpointGroup, rule.ToNetwork, rule.ToIpAddress, peer)
+			}
+			if !peerMatch {
+				continue
+			}
+
+			matched = append(matched, TraceRule{
+				PolicyKey: gp.EpgPolicyKey,
+				RuleID:    rule.RuleID,
+				Direction: dir,
+				Priority:  rule.Priority,
+				Action:    rule.Action,
+			})
+		}
+	}
+
+	return matched
+}
+
+// policyVerdict picks the highest priority rule of a direction and returns
+// its verdict, nil if no rule matched. Deny wins a tie.
+func policyVerdict(rules []TraceRule) (string, *TraceRule) {
+	sort.Sort(traceRulesByPriority(rules))
+	if len(rules) == 0 {
+		return TraceVerdictAllow, nil
+	}
+	if rules[0].Action != TraceVerdictDeny {
+		return TraceVerdictAllow, &rules[0]
+	}
+
+	return TraceVerdictDeny, &rules[0]
+}
+
+type traceRulesByPriority []TraceRule
+
+func (r traceRulesByPriority) Len() int      { return len(r) }
+func (r traceRulesByPriority) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r traceRulesByPriority) Less(i, j int) bool {
+	if r[i].Priority != r[j].Priority {
+		return r[i].Priority > r[j].Priority
+	}
+	return r[i].Action == TraceVerdictDeny && r[j].Action != TraceVerdictDeny
+}
+
+// ofprotoTraceCmd builds the ovs-appctl command to trace the flow on a host
+func ofprotoTraceCmd(bridge string, src, dst *TraceEndpoint, dstIP, protocol string, port int) string {
+	flow := fmt.Sprintf("dl_src=%s,dl_dst=%s", src.MacAddress, dst.MacAddress)
+	switch strings.ToLower(protocol) {
+	case "tcp", "udp":
+		flow += fmt.Sprintf(",%s,nw_src=%s,nw_dst=%s", strings.ToLower(protocol), src.IPAddress, dstIP)
+		if port != 0 {
+			flow += fmt.Sprintf(",tp_dst=%d", port)
+		}
+	case "icmp":
+		flow += fmt.Sprintf(",icmp,nw_src=%s,nw_dst=%s", src.IPAddress, dstIP)
+	default:
+		flow += fmt.Sprintf(",ip,nw_src=%s,nw_dst=%s", src.IPAddress, dstIP)
+	}
+
+	return fmt.Sprintf("ovs-appctl ofproto/trace %s %s", bridge, flow)
+}
+
+// TraceEndpoints computes the expected packet path between two endpoints
+func TraceEndpoints(stateDriver core.StateDriver, req *TraceRequest) (*TraceResponse, error) {
+	srcCfg, err := findTraceEndpoint(stateDriver, req.Tenant, req.SrcEndpoint, req.SrcIP)
+	if err != nil {
+		return nil, err
+	}
+
+	srcNw := &mastercfg.CfgNetworkState{}
+	srcNw.StateDriver = stateDriver
+	err = srcNw.Read(srcCfg.NetID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &TraceResponse{Source: newTraceEndpoint(srcCfg, srcNw)}
+	resp.Hops = append(resp.Hops, fmt.Sprintf("source %s (%s) on host %s, network %s, group %q",
+		srcCfg.ContName, srcCfg.IPAddress, srcCfg.HomingHost, srcNw.ID, srcCfg.ServiceName))
+
+	// service ip is translated to one of the providers by the load balancer
+	dstIP := req.DstIP
+	dstPort := req.DstPort
+	if req.DstEndpoint == "" && dstIP != "" {
+		resp.Service = findTraceService(dstIP, srcNw.Tenant, dstPort, req.Protocol)
+	}
+	if resp.Service != nil {
+		if len(resp.Service.Providers) == 0 {
+			resp.Hops = append(resp.Hops, fmt.Sprintf("service %s (%s) has no providers, packet is dropped",
+				resp.Service.ServiceName, dstIP))
+			resp.PolicyVerdict = TraceVerdictDeny
+			return resp, nil
+		}
+
+		resp.Service.Provider = resp.Service.Providers[0]
+		resp.Hops = append(resp.Hops, fmt.Sprintf("service %s %s:%d load balanced to %s:%d (providers: %s)",
+			resp.Service.ServiceName, dstIP, resp.Service.ServicePort, resp.Service.Provider,
+			resp.Service.ProvPort, strings.Join(resp.Service.Providers, ",")))
+		dstIP = resp.Service.Provider
+		dstPort = resp.Service.ProvPort
+	}
+
+	// networks of different tenants are not connected
+	dstCfg, err := findTraceEndpoint(stateDriver, srcNw.Tenant, req.DstEndpoint, dstIP)
+	if err != nil {
+		return nil, err
+	}
+
+	dstNw := &mastercfg.CfgNetworkState{}
+	dstNw.StateDriver = stateDriver
+	err = dstNw.Read(dstCfg.NetID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Destination = newTraceEndpoint(dstCfg, dstNw)
+	if dstIP == "" {
+		dstIP = dstCfg.IPAddress
+	}
+	origDstIP := req.DstIP
+	if origDstIP == "" {
+		origDstIP = dstIP
+	}
+
+	// routing between networks happens at the source host
+	resp.Encap = dstNw.PktTagType
+	resp.PktTag = dstNw.PktTag
+	if srcNw.ID != dstNw.ID {
+		resp.Routed = true
+		resp.Hops = append(resp.Hops, fmt.Sprintf("routed from network %s to network %s via gateway %s",
+			srcNw.ID, dstNw.ID, srcNw.Gateway))
+	}
+
+	bridge := vlanBridgeName
+	if resp.Encap == "vxlan" {
+		bridge = vxlanBridgeName
+	}
+
+	switch {
+	case srcCfg.HomingHost == dstCfg.HomingHost:
+		resp.Path = TracePathLocal
+		resp.Hops = append(resp.Hops, fmt.Sprintf("switched locally on %s of host %s", bridge, srcCfg.HomingHost))
+	case resp.Encap == "vxlan":
+		resp.Path = TracePathVtep
+		resp.Hops = append(resp.Hops, fmt.Sprintf("tunneled with vxlan vni %d from host %s (vtep %s) to host %s (vtep %s)",
+			resp.PktTag, srcCfg.HomingHost, srcCfg.VtepIP, dstCfg.HomingHost, dstCfg.VtepIP))
+	default:
+		resp.Path = TracePathUplink
+		resp.Hops = append(resp.Hops, fmt.Sprintf("sent on uplink with vlan %d from host %s to host %s",
+			resp.PktTag, srcCfg.HomingHost, dstCfg.HomingHost))
+	}
+
+	// egress policy of the source group and ingress policy of the destination
+	// group are applied in turn, the flow is dropped if either denies it
+	resp.PolicyVerdict = TraceVerdictAllow
+	for _, dir := range []string{"out", "in"} {
+		var rules []TraceRule
+		if dir == "out" {
+			rules = matchPolicyRules(resp.Source, resp.Destination, dir, req.Protocol, dstPort)
+		} else {
+			rules = matchPolicyRules(resp.Destination, resp.Source, dir, req.Protocol, dstPort)
+		}
+		resp.MatchedRules = append(resp.MatchedRules, rules...)
+
+		verdict, rule := policyVerdict(rules)
+		if rule != nil {
+			resp.Hops = append(resp.Hops, fmt.Sprintf("%s policy %s rule %s (priority %d) verdict %s",
+				policyDirName[dir], rule.PolicyKey, rule.RuleID, rule.Priority, verdict))
+		} else {
+			resp.Hops = append(resp.Hops, fmt.Sprintf("no %s policy rule matched, verdict allow", policyDirName[dir]))
+		}
+		if verdict == TraceVerdictDeny {
+			resp.PolicyVerdict = TraceVerdictDeny
+			break
+		}
+	}
+
+	if resp.PolicyVerdict == TraceVerdictAllow {
+		resp.Hops = append(resp.Hops, fmt.Sprintf("delivered to %s (%s) on host %s",
+			dstCfg.ContName, dstIP, dstCfg.HomingHost))
+	}
+
+	// ovs trace commands to verify the computed path on the hosts involved,
+	// run by the operator on those hosts
+	resp.TraceCommands = append(resp.TraceCommands, TraceHostCmd{
+		Host:    srcCfg.HomingHost,
+		Command: ofprotoTraceCmd(bridge, resp.Source, resp.Destination, origDstIP, req.Protocol, req.DstPort),
+	})
+	if resp.Path != TracePathLocal {
+		resp.TraceCommands = append(resp.TraceCommands, TraceHostCmd{
+			Host:    dstCfg.HomingHost,
+			Command: ofprotoTraceCmd(bridge, resp.Source, resp.Destination, dstIP, req.Protocol, dstPort),
+		})
+	}
+
+	return resp, nil
+}
+
+// TraceHandler handles trace requests from netctl
+func TraceHandler(w http.ResponseWriter, r *http.Request, vars map[string]string) (interface{}, error) {
+	var traceReq TraceRequest
+
+	// Get object from the request
+	err := json.NewDecoder(r.Body).Decode(&traceReq)
+	if err != nil {
+		log.Errorf("Error decoding TraceHandler. Err %v", err)
+		return nil, err
+	}
+
+	log.Infof("Received TraceRequest: %+v", traceReq)
+
+	if traceReq.SrcEndpoint == "" && traceReq.SrcIP == "" {
+		return nil, core.Errorf("source endpoint or ip is required")
+	}
+	if traceReq.DstEndpoint == "" && traceReq.DstIP == "" {
+		return nil, core.Errorf("destination endpoint or ip is required")
+	}
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	return TraceEndpoints(stateDriver, &traceReq)
+}
@@ -18,22 +18,48 @@ package mastercfg
 import (
 	"encoding/json"
 	"fmt"
+	"time"
+
 	"github.com/contiv/netplugin/core"
 )
 
 const (
 	bgpConfigPathPrefix = StateConfigPath + "bgp/"
 	bgpConfigPath       = bgpConfigPathPrefix + "%s"
+	bgpOperPathPrefix   = StateOperPath + "bgp/"
+	bgpOperPath         = bgpOperPathPrefix + "%s"
 )
 
+// BgpNeighbor is a Bgp neighbor of the host
+type BgpNeighbor struct {
+	Address  string `json:"address"`
+	As       string `json:"as"`
+	Password string `json:"password,omitempty"` // not kept by the api objects
+}
+
 // CfgBgpState is the router Bgp configuration for the host
 type CfgBgpState struct {
 	core.CommonState
-	Hostname   string `json:"hostname"`
-	RouterIP   string `json:"router-ip"`
-	As         string `json:"as"`
-	NeighborAs string `json:"neighbor-as"`
-	Neighbor   string `json:"neighbor"`
+	Hostname       string        `json:"hostname"`
+	RouterIP       string        `json:"router-ip"`
+	As             string        `json:"as"`
+	NeighborAs     string        `json:"neighbor-as"`
+	Neighbor       string        `json:"neighbor"`
+	Neighbors      []BgpNeighbor `json:"neighbors,omitempty"`
+	HoldTime       int           `json:"hold-time,omitempty"`
+	Keepalive      int           `json:"keepalive,omitempty"`
+	ImportPrefixes []string      `json:"import-prefixes,omitempty"`
+	ExportPrefixes []string      `json:"export-prefixes,omitempty"`
+	ExportTenants  []string      `json:"export-tenants,omitempty"`
+}
+
+// BgpNeighbors returns the neighbors of the host. Configurations written
+// before multiple neighbors were supported only have Neighbor set.
+func (s *CfgBgpState) BgpNeighbors() []BgpNeighbor {
+	if len(s.Neighbors) == 0 && s.Neighbor != "" {
+		return []BgpNeighbor{{Address: s.Neighbor, As: s.NeighborAs}}
+	}
+	return s.Neighbors
 }
 
 // Write the state
@@ -64,3 +90,58 @@ func (s *CfgBgpState) WatchAll(rsps chan core.WatchState) error {
 	return s.StateDriver.WatchAllState(bgpConfigPathPrefix, s, json.Unmarshal,
 		rsps)
 }
+
+// BgpNeighborOper is the session state of a Bgp neighbor
+type BgpNeighborOper struct {
+	Address    string `json:"address"`
+	As         string `json:"as"`
+	State      string `json:"state"`
+	AdminState string `json:"admin-state"`
+	Uptime     uint64 `json:"uptime"` // seconds the session is established
+	Received   uint32 `json:"received"`
+	Accepted   uint32 `json:"accepted"`
+	Advertised uint32 `json:"advertised"`
+}
+
+// BgpRoute is a route of the Bgp RIB
+type BgpRoute struct {
+	Prefix  string `json:"prefix"`
+	NextHop string `json:"next-hop"`
+	AsPath  string `json:"as-path,omitempty"`
+	Best    bool   `json:"best"`
+}
+
+// OperBgpState is the Bgp state of the host, published by netplugin
+type OperBgpState struct {
+	core.CommonState
+	Hostname   string            `json:"hostname"`
+	RouterIP   string            `json:"router-ip"`
+	As         string            `json:"as"`
+	Neighbors  []BgpNeighborOper `json:"neighbors"`
+	Routes     []BgpRoute        `json:"routes"`   // host routes of the RIB
+	RibSize    int               `json:"rib-size"` // number of routes in the RIB
+	UpdateTime time.Time         `json:"update-time"`
+}
+
+// Write the state
+func (s *OperBgpState) Write() error {
+	key := fmt.Sprintf(bgpOperPath, s.Hostname)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *OperBgpState) Read(id string) error {
+	key := fmt.Sprintf(bgpOperPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads the Bgp state of all the hosts
+func (s *OperBgpState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(bgpOperPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state from the state store.
+func (s *OperBgpState) Clear() error {
+	key := fmt.Sprintf(bgpOperPath, s.Hostname)
+	return s.StateDriver.ClearState(key)
+}
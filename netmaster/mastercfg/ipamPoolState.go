@@ -0,0 +1,67 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/jainvipin/bitset"
+)
+
+const (
+	ipamPoolConfigPathPrefix = StateConfigPath + "ipamPools/"
+	ipamPoolConfigPath       = ipamPoolConfigPathPrefix + "%s"
+)
+
+// CfgIpamPoolState is an address pool handed out to docker's ipam. A pool
+// either belongs to a contiv network, which holds its addresses, or stands
+// alone and holds its addresses itself.
+type CfgIpamPoolState struct {
+	core.CommonState
+	NetworkID    string          `json:"networkID"`    // contiv network of the pool, empty if standalone
+	Pool         string          `json:"pool"`         // subnet of the pool
+	SubPool      string          `json:"subPool"`      // range addresses are allocated from, if any
+	IPv6         bool            `json:"ipv6"`         // ipv6 pool
+	RefCount     int             `json:"refCount"`     // docker networks using the pool
+	IPAllocMap   bitset.BitSet   `json:"ipAllocMap"`   // allocated addresses of a standalone pool
+	AuxAddresses map[string]bool `json:"auxAddresses"` // addresses requested explicitly
+	Legacy       bool            `json:"legacy"`       // pool of docker networks with the subnet as pool id
+}
+
+// Write the state
+func (s *CfgIpamPoolState) Write() error {
+	key := fmt.Sprintf(ipamPoolConfigPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *CfgIpamPoolState) Read(id string) error {
+	key := fmt.Sprintf(ipamPoolConfigPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the ipam pools and returns them.
+func (s *CfgIpamPoolState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(ipamPoolConfigPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the configuration from the state store.
+func (s *CfgIpamPoolState) Clear() error {
+	key := fmt.Sprintf(ipamPoolConfigPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
@@ -0,0 +1,119 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	testIpamPoolID = "orange.tenant-one:10.1.1.0-24"
+	ipamPoolCfgKey = ipamPoolConfigPathPrefix + testIpamPoolID
+)
+
+type testIpamPoolStateDriver struct{}
+
+var ipamPoolStateDriver = &testIpamPoolStateDriver{}
+
+func (d *testIpamPoolStateDriver) Init(instInfo *core.InstanceInfo) error {
+	return core.Errorf("Shouldn't be called!")
+}
+
+func (d *testIpamPoolStateDriver) Deinit() {
+}
+
+func (d *testIpamPoolStateDriver) Write(key string, value []byte) error {
+	return core.Errorf("Shouldn't be called!")
+}
+
+func (d *testIpamPoolStateDriver) Read(key string) ([]byte, error) {
+	return []byte{}, core.Errorf("Shouldn't be called!")
+}
+
+func (d *testIpamPoolStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	return [][]byte{}, core.Errorf("Shouldn't be called!")
+}
+
+func (d *testIpamPoolStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return core.Errorf("not supported")
+}
+
+func (d *testIpamPoolStateDriver) validateKey(key string) error {
+	if key != ipamPoolCfgKey {
+		return core.Errorf("Unexpected key. recvd: %s expected: %s ",
+			key, ipamPoolCfgKey)
+	}
+
+	return nil
+}
+
+func (d *testIpamPoolStateDriver) ClearState(key string) error {
+	return d.validateKey(key)
+}
+
+func (d *testIpamPoolStateDriver) ReadState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) error {
+	return d.validateKey(key)
+}
+
+func (d *testIpamPoolStateDriver) ReadAllState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	return nil, core.Errorf("Shouldn't be called!")
+}
+
+func (d *testIpamPoolStateDriver) WatchAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	return core.Errorf("not supported")
+}
+
+func (d *testIpamPoolStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	return d.validateKey(key)
+}
+
+func TestCfgIpamPoolStateRead(t *testing.T) {
+	ipamPoolCfg := &CfgIpamPoolState{}
+	ipamPoolCfg.StateDriver = ipamPoolStateDriver
+
+	err := ipamPoolCfg.Read(testIpamPoolID)
+	if err != nil {
+		t.Fatalf("read config state failed. Error: %s", err)
+	}
+}
+
+func TestCfgIpamPoolStateWrite(t *testing.T) {
+	ipamPoolCfg := &CfgIpamPoolState{}
+	ipamPoolCfg.StateDriver = ipamPoolStateDriver
+	ipamPoolCfg.ID = testIpamPoolID
+
+	err := ipamPoolCfg.Write()
+	if err != nil {
+		t.Fatalf("write config state failed. Error: %s", err)
+	}
+}
+
+func TestCfgIpamPoolStateClear(t *testing.T) {
+	ipamPoolCfg := &CfgIpamPoolState{}
+	ipamPoolCfg.StateDriver = ipamPoolStateDriver
+	ipamPoolCfg.ID = testIpamPoolID
+
+	err := ipamPoolCfg.Clear()
+	if err != nil {
+		t.Fatalf("clear config state failed. Error: %s", err)
+	}
+}
@@ -18,8 +18,11 @@ package mastercfg
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/jainvipin/bitset"
 )
 
@@ -39,28 +42,62 @@ const (
 	epGroupConfigPath        = epGroupConfigPathPrefix + "%s"
 )
 
+// SubnetPool is an additional ipv4 address pool of a network
+type SubnetPool struct {
+	SubnetIP   string        `json:"subnetIP"`
+	SubnetLen  uint          `json:"subnetLen"`
+	Gateway    string        `json:"gateway"`
+	AllocCount int           `json:"allocCount"`
+	IPAllocMap bitset.BitSet `json:"ipAllocMap"`
+}
+
+// IPReservation is a named address reservation in a network. It either
+// excludes a range of addresses from allocation or binds an address to a
+// container or pod name
+type IPReservation struct {
+	Name      string `json:"name"`
+	IPRange   string `json:"ipRange"`   // excluded addresses, e.g. 10.1.1.200-10.1.1.250
+	IPAddress string `json:"ipAddress"` // sticky address bound to Owner
+	Owner     string `json:"owner"`     // container or pod name
+}
+
+// StaticRoute is a route installed in the containers of a network
+type StaticRoute struct {
+	Destination string `json:"destination"`
+	NextHop     string `json:"nextHop"` // directly connected when empty
+}
+
 // CfgNetworkState implements the State interface for a network implemented using
 // vlans with ovs. The state is stored as Json objects.
 type CfgNetworkState struct {
 	core.CommonState
-	Tenant        string          `json:"tenant"`
-	NetworkName   string          `json:"networkName"`
-	NwType        string          `json:"nwType"`
-	PktTagType    string          `json:"pktTagType"`
-	PktTag        int             `json:"pktTag"`
-	ExtPktTag     int             `json:"extPktTag"`
-	SubnetIP      string          `json:"subnetIP"`
-	SubnetLen     uint            `json:"subnetLen"`
-	Gateway       string          `json:"gateway"`
-	EpAddrCount   int             `json:"epAddrCount"`
-	EpCount       int             `json:"epCount"`
-	IPAllocMap    bitset.BitSet   `json:"ipAllocMap"`
-	DNSServer     string          `json:"dnsServer"`
-	IPv6Subnet    string          `json:"ipv6SubnetIP"`
-	IPv6SubnetLen uint            `json:"ipv6SubnetLen"`
-	IPv6Gateway   string          `json:"ipv6Gateway"`
-	IPv6AllocMap  map[string]bool `json:"ipv6AllocMap"`
-	IPv6LastHost  string          `json:"ipv6LastHost"`
+	Tenant          string                `json:"tenant"`
+	NetworkName     string                `json:"networkName"`
+	NwType          string                `json:"nwType"`
+	PktTagType      string                `json:"pktTagType"`
+	PktTag          int                   `json:"pktTag"`
+	ExtPktTag       int                   `json:"extPktTag"`
+	SubnetIP        string                `json:"subnetIP"`
+	SubnetLen       uint                  `json:"subnetLen"`
+	Gateway         string                `json:"gateway"`
+	EpAddrCount     int                   `json:"epAddrCount"`
+	EpCount         int                   `json:"epCount"`
+	IPAllocMap      bitset.BitSet         `json:"ipAllocMap"`
+	DNSServer       string                `json:"dnsServer"`
+	IPv6Subnet      string                `json:"ipv6SubnetIP"`
+	IPv6SubnetLen   uint                  `json:"ipv6SubnetLen"`
+	IPv6Gateway     string                `json:"ipv6Gateway"`
+	IPv6AllocRanges netutils.IPv6RangeSet `json:"ipv6AllocRanges"`
+	IPv6LastHost    string                `json:"ipv6LastHost"`
+	SubnetPools     []*SubnetPool         `json:"subnetPools"`
+
+	IPReservations map[string]*IPReservation `json:"ipReservations"`
+
+	// routes and dns config of the containers
+	StaticRoutes []StaticRoute `json:"staticRoutes"`
+	DNSServers   []string      `json:"dnsServers"`
+	DNSSearch    []string      `json:"dnsSearch"`
+	DNSOptions   []string      `json:"dnsOptions"`
 }
 
 // Write the state.
@@ -69,6 +106,29 @@ func (s *CfgNetworkState) Write() error {
 	return s.StateDriver.WriteState(key, s, json.Marshal)
 }
 
+// UnmarshalJSON decodes the network state and converts the ipv6 host ids
+// allocated by older versions to ranges
+func (s *CfgNetworkState) UnmarshalJSON(data []byte) error {
+	type cfgNetworkState CfgNetworkState
+	if err := json.Unmarshal(data, (*cfgNetworkState)(s)); err != nil {
+		return err
+	}
+
+	legacy := struct {
+		IPv6AllocMap map[string]bool `json:"ipv6AllocMap"`
+	}{}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	for hostID := range legacy.IPv6AllocMap {
+		if err := s.IPv6AllocRanges.Set(hostID); err != nil {
+			log.Errorf("Error migrating ipv6 host id %s of network %s. Err: %v", hostID, s.ID, err)
+		}
+	}
+
+	return nil
+}
+
 // Read the state for a given identifier
 func (s *CfgNetworkState) Read(id string) error {
 	key := fmt.Sprintf(networkConfigPath, id)
@@ -103,3 +163,17 @@ func (s *CfgNetworkState) DecrEpCount() error {
 	s.EpCount--
 	return s.Write()
 }
+
+// GetSubnetOfAddress returns the subnet length and gateway of the address pool
+// an ipv4 address belongs to
+func (s *CfgNetworkState) GetSubnetOfAddress(ipAddress string) (uint, string) {
+	ip := net.ParseIP(ipAddress)
+	for _, sp := range s.SubnetPools {
+		_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", sp.SubnetIP, sp.SubnetLen))
+		if err == nil && ipNet.Contains(ip) {
+			return sp.SubnetLen, sp.Gateway
+		}
+	}
+
+	return s.SubnetLen, s.Gateway
+}
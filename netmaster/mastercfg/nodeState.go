@@ -0,0 +1,73 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+const (
+	nodeOperPathPrefix = StateOperPath + "nodes/"
+	nodeOperPath       = nodeOperPathPrefix + "%s"
+)
+
+// LLDPNeighbor is the neighbor learnt over lldp on an uplink of the host
+type LLDPNeighbor struct {
+	Uplink            string    `json:"uplink"`
+	ChassisID         string    `json:"chassis-id"`
+	PortID            string    `json:"port-id"`
+	PortDesc          string    `json:"port-desc,omitempty"`
+	SystemName        string    `json:"system-name,omitempty"`
+	SystemDescription string    `json:"system-desc,omitempty"`
+	CiscoACIPodID     string    `json:"aci-pod-id,omitempty"`
+	CiscoACINodeID    string    `json:"aci-node-id,omitempty"`
+	TTL               int       `json:"ttl"`
+	UpdateTime        time.Time `json:"update-time"` // when the neighbor was learnt or changed
+}
+
+// OperNodeState is the state of a host, published by netplugin
+type OperNodeState struct {
+	core.CommonState
+	Hostname      string         `json:"hostname"`
+	LLDPNeighbors []LLDPNeighbor `json:"lldp-neighbors"`
+}
+
+// Write the state
+func (s *OperNodeState) Write() error {
+	key := fmt.Sprintf(nodeOperPath, s.Hostname)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *OperNodeState) Read(id string) error {
+	key := fmt.Sprintf(nodeOperPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads the state of all the hosts
+func (s *OperNodeState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(nodeOperPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the state from the state store.
+func (s *OperNodeState) Clear() error {
+	key := fmt.Sprintf(nodeOperPath, s.Hostname)
+	return s.StateDriver.ClearState(key)
+}
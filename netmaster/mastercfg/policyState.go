@@ -155,6 +155,18 @@ func FindEpgPolicy(epgpKey string) *EpgPolicy {
 	return epgPolicyDb[epgpKey]
 }
 
+// FindEpgPoliciesByGroupID returns all epg policies attached to an endpoint group
+func FindEpgPoliciesByGroupID(epgID int) []*EpgPolicy {
+	var policies []*EpgPolicy
+	for _, gp := range epgPolicyDb {
+		if gp.EndpointGroupID == epgID {
+			policies = append(policies, gp)
+		}
+	}
+
+	return policies
+}
+
 // Delete deletes the epg policy
 func (gp *EpgPolicy) Delete() error {
 	// delete from the DB
@@ -24,6 +24,8 @@ import (
 const (
 	svcProviderPathPrefix = StateConfigPath + "provider/"
 	svcProviderPath       = svcProviderPathPrefix + "%s"
+	providerPathPrefix    = StateConfigPath + "serviceProvider/"
+	providerPath          = providerPathPrefix + "%s"
 )
 
 //SvcProvider holds service information
@@ -31,9 +33,10 @@ type SvcProvider struct {
 	core.CommonState
 	ServiceName string
 	Providers   []string
+	Weights     map[string]int // weights of the providers keyed by ip, 1 if missing
 }
 
-//ProviderDb is map of providers for a service keyed by provider ip
+//ProviderDb is map of providers keyed by container and network
 var ProviderDb = make(map[string]*Provider)
 
 //Provider has providers info
@@ -45,9 +48,17 @@ type Provider struct {
 	Network     string
 	Services    []string
 	Container   string //container endpoint id
+	Host        string // host of the container
 
 }
 
+// CfgProviderState is a ProviderDb entry kept in the state store, so the
+// provider db survives netmaster leader changes
+type CfgProviderState struct {
+	core.CommonState
+	Provider
+}
+
 // Write the state
 func (s *SvcProvider) Write() error {
 	key := fmt.Sprintf(svcProviderPath, s.ID)
@@ -79,3 +90,26 @@ func (s *SvcProvider) WatchAll(rsps chan core.WatchState) error {
 	return s.StateDriver.WatchAllState(svcProviderPathPrefix, s, json.Unmarshal,
 		rsps)
 }
+
+// Write the state
+func (s *CfgProviderState) Write() error {
+	key := fmt.Sprintf(providerPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *CfgProviderState) Read(id string) error {
+	key := fmt.Sprintf(providerPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the providers and returns them.
+func (s *CfgProviderState) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(providerPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the provider from the state store.
+func (s *CfgProviderState) Clear() error {
+	key := fmt.Sprintf(providerPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
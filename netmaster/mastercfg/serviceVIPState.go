@@ -0,0 +1,60 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mastercfg
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/jainvipin/bitset"
+)
+
+const (
+	serviceVIPPoolConfigPathPrefix = StateConfigPath + "serviceVIPPool/"
+	serviceVIPPoolConfigPath       = serviceVIPPoolConfigPathPrefix + "%s"
+)
+
+// CfgServiceVIPPool is the pool the VIPs of external services are allocated
+// from. External VIPs are advertised over bgp by the hosts of their providers.
+type CfgServiceVIPPool struct {
+	core.CommonState
+	Pool       string        `json:"pool"`       // subnet of the pool
+	IPAllocMap bitset.BitSet `json:"ipAllocMap"` // allocated VIPs
+}
+
+// Write the state
+func (s *CfgServiceVIPPool) Write() error {
+	key := fmt.Sprintf(serviceVIPPoolConfigPath, s.ID)
+	return s.StateDriver.WriteState(key, s, json.Marshal)
+}
+
+// Read the state in for a given ID.
+func (s *CfgServiceVIPPool) Read(id string) error {
+	key := fmt.Sprintf(serviceVIPPoolConfigPath, id)
+	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+}
+
+// ReadAll reads all the service VIP pools and returns them.
+func (s *CfgServiceVIPPool) ReadAll() ([]core.State, error) {
+	return s.StateDriver.ReadAllState(serviceVIPPoolConfigPathPrefix, s, json.Unmarshal)
+}
+
+// Clear removes the configuration from the state store.
+func (s *CfgServiceVIPPool) Clear() error {
+	key := fmt.Sprintf(serviceVIPPoolConfigPath, s.ID)
+	return s.StateDriver.ClearState(key)
+}
@@ -27,15 +27,72 @@ const (
 	serviceLBConfigPath       = serviceLBConfigPathPrefix + "%s"
 )
 
+// operators of set based selector expressions
+const (
+	SelectorOpIn           = "in"
+	SelectorOpNotIn        = "notin"
+	SelectorOpExists       = "exists"
+	SelectorOpDoesNotExist = "!"
+)
+
+// SelectorExpr is a set based selector expression of a service
+type SelectorExpr struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+// health of the providers of a service
+const (
+	ProviderHealthy   = "healthy"
+	ProviderUnhealthy = "unhealthy"
+)
+
+// HealthCheck is the health check of the providers of a service
+type HealthCheck struct {
+	Type               string `json:"type"`           // tcp or http
+	Port               uint16 `json:"port,omitempty"` // provider port, the first provider port if 0
+	Path               string `json:"path,omitempty"` // path of http checks
+	Interval           int    `json:"interval"`       // seconds between checks
+	Timeout            int    `json:"timeout"`        // seconds
+	HealthyThreshold   int    `json:"healthyThreshold"`
+	UnhealthyThreshold int    `json:"unhealthyThreshold"`
+}
+
+// load balancing modes of a service
+const (
+	LBModeRoundRobin = "roundrobin"
+	LBModeLeastConn  = "leastconn"
+)
+
+// ProviderWeight is the weight of the providers matching a selector
+type ProviderWeight struct {
+	Selector SelectorExpr `json:"selector"`
+	Weight   int          `json:"weight"`
+}
+
+// LBPolicy is how the clients of a service are spread over its providers
+type LBPolicy struct {
+	Mode             string           `json:"mode"`                       // roundrobin or leastconn
+	ClientIPAffinity bool             `json:"clientIPAffinity,omitempty"` // clients stick to their provider
+	AffinityTimeout  int              `json:"affinityTimeout,omitempty"`  // seconds
+	Weights          []ProviderWeight `json:"weights,omitempty"`          // first match applies, 1 if none
+}
+
 //ServiceLBInfo holds service information
 type ServiceLBInfo struct {
-	ServiceName string               //Service name
-	IPAddress   string               //Service IP
-	Tenant      string               //Tenant name of the service
-	Network     string               // service network
-	Ports       []string             //Service_port:Provider_port:protocol
-	Selectors   map[string]string    // selector labels associated with a service
-	Providers   map[string]*Provider //map of providers for a service keyed by provider ip
+	ServiceName    string               //Service name
+	IPAddress      string               //Service IP
+	Tenant         string               //Tenant name of the service
+	Network        string               // service network
+	Ports          []string             //Service_port:Provider_port:protocol
+	Selectors      map[string]string    // selector labels associated with a service
+	SelectorExprs  []SelectorExpr       // set based selectors associated with a service
+	Providers      map[string]*Provider //map of providers for a service keyed by provider ip
+	HealthCheck    *HealthCheck         // health check of the providers, nil if none
+	ProviderHealth map[string]string    // health of the providers keyed by provider id
+	LBPolicy       *LBPolicy            // load balancing of the providers, nil for the default
+	External       bool                 // VIP from the service VIP pool, advertised over bgp
 }
 
 //ServiceLBDb is map of all services
@@ -47,13 +104,18 @@ var SvcMutex sync.RWMutex
 // CfgServiceLBState is the service object configuration
 type CfgServiceLBState struct {
 	core.CommonState
-	ServiceName string               `json:"servicename"`
-	Tenant      string               `json:"tenantname"`
-	Network     string               `json:"subnet"`
-	Ports       []string             `json:"ports"`
-	Selectors   map[string]string    `json:"selectors"`
-	IPAddress   string               `json:"ipaddress"`
-	Providers   map[string]*Provider `json:"providers"`
+	ServiceName    string               `json:"servicename"`
+	Tenant         string               `json:"tenantname"`
+	Network        string               `json:"subnet"`
+	Ports          []string             `json:"ports"`
+	Selectors      map[string]string    `json:"selectors"`
+	SelectorExprs  []SelectorExpr       `json:"selectorExprs,omitempty"`
+	IPAddress      string               `json:"ipaddress"`
+	Providers      map[string]*Provider `json:"providers"`
+	HealthCheck    *HealthCheck         `json:"healthCheck,omitempty"`
+	ProviderHealth map[string]string    `json:"providerHealth,omitempty"`
+	LBPolicy       *LBPolicy            `json:"lbPolicy,omitempty"`
+	External       bool                 `json:"external,omitempty"`
 }
 
 // Write the state
@@ -26,8 +26,10 @@ import (
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/objdb/modeldb"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/gorilla/mux"
@@ -153,9 +155,10 @@ func (ac *APIController) GlobalCreate(global *contivModel.Global) error {
 
 	// Build global config
 	gCfg := intent.ConfigGlobal{
-		NwInfraType: global.NetworkInfraType,
-		VLANs:       global.Vlans,
-		VXLANs:      global.Vxlans,
+		NwInfraType:    global.NetworkInfraType,
+		VLANs:          global.Vlans,
+		VXLANs:         global.Vxlans,
+		ServiceVIPPool: global.ServiceVIPPool,
 	}
 
 	// Create the object
@@ -180,9 +183,10 @@ func (ac *APIController) GlobalUpdate(global, params *contivModel.Global) error
 
 	// Build global config
 	gCfg := intent.ConfigGlobal{
-		NwInfraType: params.NetworkInfraType,
-		VLANs:       params.Vlans,
-		VXLANs:      params.Vxlans,
+		NwInfraType:    params.NetworkInfraType,
+		VLANs:          params.Vlans,
+		VXLANs:         params.Vxlans,
+		ServiceVIPPool: params.ServiceVIPPool,
 	}
 
 	// Create the object
@@ -195,6 +199,7 @@ func (ac *APIController) GlobalUpdate(global, params *contivModel.Global) error
 	global.NetworkInfraType = params.NetworkInfraType
 	global.Vlans = params.Vlans
 	global.Vxlans = params.Vxlans
+	global.ServiceVIPPool = params.ServiceVIPPool
 
 	return nil
 }
@@ -361,33 +366,59 @@ func (ac *APIController) EndpointGetOper(endpoint *contivModel.EndpointInspect)
 	readEp.StateDriver = stateDriver
 	// TODO avoid linear read
 	epCfgs, err := readEp.ReadAll()
-	if err == nil {
-		for idx, epCfg := range epCfgs {
-			ep := epCfg.(*mastercfg.CfgEndpointState)
-			log.Infof("read ep key[%d] %s, populating state \n", idx, ep.ID)
-			if strings.Contains(ep.ContainerID, endpoint.Oper.Key) {
-				endpoint.Oper.Network = ep.NetID
-				endpoint.Oper.Name = ep.ContName
-				endpoint.Oper.ServiceName = ep.ServiceName
-				endpoint.Oper.EndpointGroupID = ep.EndpointGroupID
-				endpoint.Oper.EndpointGroupKey = ep.EndpointGroupKey
-				endpoint.Oper.AttachUUID = ep.AttachUUID
-				endpoint.Oper.IpAddress = []string{ep.IPAddress, ep.IPv6Address}
-				endpoint.Oper.MacAddress = ep.MacAddress
-				endpoint.Oper.HomingHost = ep.HomingHost
-				endpoint.Oper.IntfName = ep.IntfName
-				endpoint.Oper.VtepIP = ep.VtepIP
-				endpoint.Oper.Labels = fmt.Sprintf("%s", ep.Labels)
-				endpoint.Oper.ContainerID = ep.ContainerID
-
-				break
-			}
+	if err != nil {
+		return nil
+	}
+
+	// a container has an endpoint on each of its networks
+	contEps := []*mastercfg.CfgEndpointState{}
+	for idx, epCfg := range epCfgs {
+		ep := epCfg.(*mastercfg.CfgEndpointState)
+		log.Infof("read ep key[%d] %s, populating state \n", idx, ep.ID)
+		if ep.ContainerID != "" && strings.Contains(ep.ContainerID, endpoint.Oper.Key) {
+			contEps = append(contEps, ep)
 		}
 	}
+	if len(contEps) == 0 {
+		return nil
+	}
+	sort.Sort(endpointsByID(contEps))
+
+	ep := contEps[0]
+	endpoint.Oper.Network = ep.NetID
+	endpoint.Oper.Name = ep.ContName
+	endpoint.Oper.ServiceName = ep.ServiceName
+	endpoint.Oper.EndpointGroupID = ep.EndpointGroupID
+	endpoint.Oper.EndpointGroupKey = ep.EndpointGroupKey
+	endpoint.Oper.AttachUUID = ep.AttachUUID
+	endpoint.Oper.IpAddress = []string{ep.IPAddress, ep.IPv6Address}
+	endpoint.Oper.MacAddress = ep.MacAddress
+	endpoint.Oper.HomingHost = ep.HomingHost
+	endpoint.Oper.IntfName = ep.IntfName
+	endpoint.Oper.VtepIP = ep.VtepIP
+	endpoint.Oper.Labels = fmt.Sprintf("%s", ep.Labels)
+	endpoint.Oper.ContainerID = ep.ContainerID
+
+	// list all endpoints of the container as "network ip[,ipv6] mac host"
+	for _, ep := range contEps {
+		ipAddress := ep.IPAddress
+		if ep.IPv6Address != "" {
+			ipAddress += "," + ep.IPv6Address
+		}
+		endpoint.Oper.Endpoints = append(endpoint.Oper.Endpoints,
+			fmt.Sprintf("%s %s %s %s", ep.NetID, ipAddress, ep.MacAddress, ep.HomingHost))
+	}
 
 	return nil
 }
 
+// endpointsByID sorts endpoints by their id
+type endpointsByID []*mastercfg.CfgEndpointState
+
+func (e endpointsByID) Len() int           { return len(e) }
+func (e endpointsByID) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e endpointsByID) Less(i, j int) bool { return e[i].ID < e[j].ID }
+
 // Cleans up state off endpointGroup and related objects.
 func endpointGroupCleanup(endpointGroup *contivModel.EndpointGroup) {
 	// delete the endpoint group state
@@ -751,6 +782,10 @@ func (ac *APIController) NetworkCreate(network *contivModel.Network) error {
 		Gateway:        network.Gateway,
 		IPv6SubnetCIDR: network.Ipv6Subnet,
 		IPv6Gateway:    network.Ipv6Gateway,
+		StaticRoutes:   network.StaticRoutes,
+		DNSServers:     network.DnsServers,
+		DNSSearch:      network.DnsSearch,
+		DNSOptions:     network.DnsOptions,
 	}
 
 	// Create the network
@@ -794,10 +829,14 @@ func (ac *APIController) NetworkGetOper(network *contivModel.NetworkInspect) err
 
 	network.Oper.AllocatedAddressesCount = nwCfg.EpAddrCount
 	network.Oper.AllocatedIPAddresses = master.ListAllocatedIPs(nwCfg)
+	network.Oper.AllocatedIPv6Addresses = master.ListAllocatedIPv6s(nwCfg)
+	network.Oper.AllocatedIPv6AddressesCount = int(nwCfg.IPv6AllocRanges.Count().Int64())
 	network.Oper.DnsServerIP = nwCfg.DNSServer
 	network.Oper.ExternalPktTag = nwCfg.ExtPktTag
 	network.Oper.NumEndpoints = nwCfg.EpCount
 	network.Oper.PktTag = nwCfg.PktTag
+	network.Oper.Reservations = master.ListIPReservations(nwCfg)
+	network.Oper.SubnetPools = master.ListSubnetPools(nwCfg)
 
 	return nil
 }
@@ -1186,9 +1225,45 @@ func (ac *APIController) TenantDelete(tenant *contivModel.Tenant) error {
 	return nil
 }
 
+// bgpIntentConfig builds the intent of a bgp object
+func bgpIntentConfig(bgpCfg *contivModel.Bgp) intent.ConfigBgp {
+	bgpIntentCfg := intent.ConfigBgp{
+		Hostname:       bgpCfg.Hostname,
+		RouterIP:       bgpCfg.Routerip,
+		As:             bgpCfg.As,
+		NeighborAs:     bgpCfg.NeighborAs,
+		Neighbor:       bgpCfg.Neighbor,
+		HoldTime:       bgpCfg.HoldTime,
+		Keepalive:      bgpCfg.Keepalive,
+		ImportPrefixes: bgpCfg.ImportPrefixes,
+		ExportPrefixes: bgpCfg.ExportPrefixes,
+		ExportTenants:  bgpCfg.ExportTenants,
+	}
+	for _, neighbor := range bgpCfg.Neighbors {
+		bgpIntentCfg.Neighbors = append(bgpIntentCfg.Neighbors, intent.ConfigBgpNeighbor{
+			Address:       neighbor.Address,
+			As:            neighbor.As,
+			Password:      neighbor.Password,
+			ClearPassword: neighbor.ClearPassword,
+		})
+	}
+
+	return bgpIntentCfg
+}
+
+// clearBgpPasswords removes the neighbor passwords from a bgp object. The
+// passwords are write only, the object is saved and returned without them.
+func clearBgpPasswords(bgpCfg *contivModel.Bgp) {
+	for idx := range bgpCfg.Neighbors {
+		bgpCfg.Neighbors[idx].Password = ""
+		bgpCfg.Neighbors[idx].ClearPassword = false
+	}
+}
+
 //BgpCreate add bgp neighbor
 func (ac *APIController) BgpCreate(bgpCfg *contivModel.Bgp) error {
-	log.Infof("Received BgpCreate: %+v", bgpCfg)
+	// the config is not logged, it has the neighbor passwords
+	log.Infof("Received BgpCreate for host %s", bgpCfg.Hostname)
 
 	if bgpCfg.Hostname == "" {
 		return core.Errorf("Invalid host name")
@@ -1201,13 +1276,8 @@ func (ac *APIController) BgpCreate(bgpCfg *contivModel.Bgp) error {
 	}
 
 	// Build bgp config
-	bgpIntentCfg := intent.ConfigBgp{
-		Hostname:   bgpCfg.Hostname,
-		RouterIP:   bgpCfg.Routerip,
-		As:         bgpCfg.As,
-		NeighborAs: bgpCfg.NeighborAs,
-		Neighbor:   bgpCfg.Neighbor,
-	}
+	bgpIntentCfg := bgpIntentConfig(bgpCfg)
+	clearBgpPasswords(bgpCfg)
 
 	// Add the Bgp neighbor
 	err = master.AddBgp(stateDriver, &bgpIntentCfg)
@@ -1218,6 +1288,50 @@ func (ac *APIController) BgpCreate(bgpCfg *contivModel.Bgp) error {
 	return nil
 }
 
+// BgpGetOper returns the bgp neighbors and routes of a host
+func (ac *APIController) BgpGetOper(bgp *contivModel.BgpInspect) error {
+	log.Infof("Received BgpInspect for host %s", bgp.Config.Hostname)
+
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return err
+	}
+
+	bgpOper, err := master.GetBgpOper(stateDriver, bgp.Config.Hostname)
+	if err != nil {
+		return err
+	}
+
+	bgp.Oper.RouterIP = bgpOper.RouterIP
+	bgp.Oper.As = bgpOper.As
+	bgp.Oper.RibSize = bgpOper.RibSize
+	for _, neighbor := range bgpOper.Neighbors {
+		bgp.Oper.Neighbors = append(bgp.Oper.Neighbors, contivModel.BgpNeighborOper{
+			Address:    neighbor.Address,
+			As:         neighbor.As,
+			State:      neighbor.State,
+			AdminState: neighbor.AdminState,
+			Uptime:     int(neighbor.Uptime),
+			Received:   int(neighbor.Received),
+			Accepted:   int(neighbor.Accepted),
+			Advertised: int(neighbor.Advertised),
+		})
+	}
+	for _, route := range bgpOper.Routes {
+		bgp.Oper.Routes = append(bgp.Oper.Routes, contivModel.BgpRoute{
+			Prefix:  route.Prefix,
+			NextHop: route.NextHop,
+			AsPath:  route.AsPath,
+			Best:    route.Best,
+		})
+	}
+	if !bgpOper.LastUpdated.IsZero() {
+		bgp.Oper.LastUpdated = bgpOper.LastUpdated.Format(time.RFC3339)
+	}
+
+	return nil
+}
+
 //BgpDelete deletes bgp neighbor
 func (ac *APIController) BgpDelete(bgpCfg *contivModel.Bgp) error {
 
@@ -1238,7 +1352,7 @@ func (ac *APIController) BgpDelete(bgpCfg *contivModel.Bgp) error {
 
 //BgpUpdate updates bgp config
 func (ac *APIController) BgpUpdate(oldbgpCfg *contivModel.Bgp, NewbgpCfg *contivModel.Bgp) error {
-	log.Infof("Received BgpUpdate: %+v", NewbgpCfg)
+	log.Infof("Received BgpUpdate for host %s", NewbgpCfg.Hostname)
 
 	if NewbgpCfg.Hostname == "" {
 		return core.Errorf("Invalid host name")
@@ -1251,13 +1365,8 @@ func (ac *APIController) BgpUpdate(oldbgpCfg *contivModel.Bgp, NewbgpCfg *contiv
 	}
 
 	// Build bgp config
-	bgpIntentCfg := intent.ConfigBgp{
-		Hostname:   NewbgpCfg.Hostname,
-		RouterIP:   NewbgpCfg.Routerip,
-		As:         NewbgpCfg.As,
-		NeighborAs: NewbgpCfg.NeighborAs,
-		Neighbor:   NewbgpCfg.Neighbor,
-	}
+	bgpIntentCfg := bgpIntentConfig(NewbgpCfg)
+	clearBgpPasswords(NewbgpCfg)
 
 	// Add the Bgp neighbor
 	err = master.AddBgp(stateDriver, &bgpIntentCfg)
@@ -1266,6 +1375,18 @@ func (ac *APIController) BgpUpdate(oldbgpCfg *contivModel.Bgp, NewbgpCfg *contiv
 		return err
 	}
 
+	// update the bgp object, it is saved by the caller
+	oldbgpCfg.Routerip = NewbgpCfg.Routerip
+	oldbgpCfg.As = NewbgpCfg.As
+	oldbgpCfg.NeighborAs = NewbgpCfg.NeighborAs
+	oldbgpCfg.Neighbor = NewbgpCfg.Neighbor
+	oldbgpCfg.Neighbors = NewbgpCfg.Neighbors
+	oldbgpCfg.HoldTime = NewbgpCfg.HoldTime
+	oldbgpCfg.Keepalive = NewbgpCfg.Keepalive
+	oldbgpCfg.ImportPrefixes = NewbgpCfg.ImportPrefixes
+	oldbgpCfg.ExportPrefixes = NewbgpCfg.ExportPrefixes
+	oldbgpCfg.ExportTenants = NewbgpCfg.ExportTenants
+
 	return nil
 }
 
@@ -1301,18 +1422,42 @@ func (ac *APIController) ServiceLBCreate(serviceCfg *contivModel.ServiceLB) erro
 		Tenant:      serviceCfg.TenantName,
 		Network:     serviceCfg.NetworkName,
 		IPAddress:   serviceCfg.IpAddress,
+		External:    serviceCfg.External,
 	}
 	serviceIntentCfg.Ports = append(serviceIntentCfg.Ports, serviceCfg.Ports...)
+	if serviceCfg.HealthCheck != "" {
+		serviceIntentCfg.HealthCheck = &intent.ConfigHealthCheck{
+			Type:               serviceCfg.HealthCheck,
+			Port:               serviceCfg.HealthCheckPort,
+			Path:               serviceCfg.HealthCheckPath,
+			Interval:           serviceCfg.HealthCheckInterval,
+			Timeout:            serviceCfg.HealthCheckTimeout,
+			HealthyThreshold:   serviceCfg.HealthyThreshold,
+			UnhealthyThreshold: serviceCfg.UnhealthyThreshold,
+		}
+	}
+	if serviceCfg.LbMode != "" || serviceCfg.SessionAffinity != "" ||
+		serviceCfg.AffinityTimeout != 0 || len(serviceCfg.Weights) > 0 {
+		serviceIntentCfg.LBPolicy = &intent.ConfigLBPolicy{
+			Mode:            serviceCfg.LbMode,
+			SessionAffinity: serviceCfg.SessionAffinity,
+			AffinityTimeout: serviceCfg.AffinityTimeout,
+			Weights:         serviceCfg.Weights,
+		}
+	}
 
 	serviceIntentCfg.Selectors = make(map[string]string)
 
 	for _, selector := range serviceCfg.Selectors {
-		if validateSelectors(selector) {
-			key := strings.Split(selector, "=")[0]
-			value := strings.Split(selector, "=")[1]
-			serviceIntentCfg.Selectors[key] = value
+		if !validateSelectors(selector) {
+			return core.Errorf("Invalid selector %s. selector format is key1=value1, key1!=value1, "+
+				"key1 in (value1,value2), key1 notin (value1,value2), key1 or !key1", selector)
+		}
+		expr, _ := master.ParseSelector(selector)
+		if expr.Operator == master.SelectorOpEquals {
+			serviceIntentCfg.Selectors[expr.Key] = expr.Values[0]
 		} else {
-			return core.Errorf("Invalid selector %s. selector format is key1=value1", selector)
+			serviceIntentCfg.SelectorExprs = append(serviceIntentCfg.SelectorExprs, selector)
 		}
 	}
 	// Add the service object
@@ -1325,6 +1470,19 @@ func (ac *APIController) ServiceLBCreate(serviceCfg *contivModel.ServiceLB) erro
 
 }
 
+// ServiceLBGetOper returns the providers of a service and their health
+func (ac *APIController) ServiceLBGetOper(serviceLB *contivModel.ServiceLBInspect) error {
+	log.Infof("Received ServiceLBInspect: %+v", serviceLB)
+
+	tenantName := serviceLB.Config.TenantName
+	if tenantName == "" {
+		tenantName = "default"
+	}
+	serviceLB.Oper.Providers = master.GetServiceProviders(serviceLB.Config.ServiceName, tenantName)
+
+	return nil
+}
+
 //ServiceLBUpdate updates service object
 func (ac *APIController) ServiceLBUpdate(oldServiceCfg *contivModel.ServiceLB, serviceCfg *contivModel.ServiceLB) error {
 	return ac.ServiceLBCreate(serviceCfg)
@@ -1358,10 +1516,11 @@ func (ac *APIController) ServiceLBDelete(serviceCfg *contivModel.ServiceLB) erro
 }
 
 func validateSelectors(selector string) bool {
-	if strings.Count(selector, "=") == 1 {
-		return true
+	if _, err := master.ParseSelector(selector); err != nil {
+		log.Errorf("Invalid selector. Err: %v", err)
+		return false
 	}
-	return false
+	return true
 }
 
 func validatePorts(ports []string) bool {
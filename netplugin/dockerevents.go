@@ -0,0 +1,279 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/netplugin/cluster"
+	"github.com/docker/engine-api/client"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/events"
+	"github.com/docker/engine-api/types/filters"
+	"golang.org/x/net/context"
+)
+
+const (
+	dockerHost       = "unix:///var/run/docker.sock"
+	dockerAPIVersion = "v1.21"
+
+	// backoff between reconnects to the docker event stream
+	dockerMinBackoff = time.Second
+	dockerMaxBackoff = time.Minute
+)
+
+// container events that change the service providers
+var dockerEventFilter = []string{"start", "die", "kill", "destroy"}
+
+// errDockerStreamClosed is returned when docker closes the event stream
+var errDockerStreamClosed = errors.New("docker event stream closed")
+
+// dockerClient is the part of the docker api used by the event monitor
+type dockerClient interface {
+	Events(ctx context.Context, options types.EventsOptions) (io.ReadCloser, error)
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+}
+
+// newDockerClient creates a client of the local docker
+func newDockerClient() (dockerClient, error) {
+	defaultHeaders := map[string]string{"User-Agent": "engine-api-cli-1.0"}
+	return client.NewClient(dockerHost, dockerAPIVersion, nil, defaultHeaders)
+}
+
+// dockerEventMonitor watches docker container events and keeps the service
+// providers of the containers on this host registered with netmaster
+type dockerEventMonitor struct {
+	stateDriver core.StateDriver
+	hostLabel   string
+	cli         dockerClient
+
+	// sends a provider update to netmaster
+	updateProvider func(req *master.SvcProvUpdateRequest) error
+}
+
+// newDockerEventMonitor creates a docker event monitor
+func newDockerEventMonitor(stateDriver core.StateDriver, opts cliOpts) *dockerEventMonitor {
+	return &dockerEventMonitor{
+		stateDriver:    stateDriver,
+		hostLabel:      opts.hostLabel,
+		updateProvider: postProviderUpdate,
+	}
+}
+
+// postProviderUpdate posts a provider update to netmaster
+func postProviderUpdate(req *master.SvcProvUpdateRequest) error {
+	var svcProvResp master.SvcProvUpdateResponse
+	return cluster.MasterPostReq("/plugin/svcProviderUpdate", req, &svcProvResp)
+}
+
+// run monitors docker events, reconnecting with backoff whenever the
+// event stream fails. It never returns.
+func (m *dockerEventMonitor) run() {
+	backoff := dockerMinBackoff
+	for {
+		start := time.Now()
+		err := m.monitor()
+		log.Errorf("Docker event stream failed. Error: %v", err)
+
+		// a stream that was up for a while starts over with the minimum backoff
+		if time.Since(start) > dockerMaxBackoff {
+			backoff = dockerMinBackoff
+		}
+		log.Infof("Reconnecting to docker in %v", backoff)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > dockerMaxBackoff {
+			backoff = dockerMaxBackoff
+		}
+	}
+}
+
+// monitor connects to the docker event stream, resyncs the providers and
+// handles events until the stream fails
+func (m *dockerEventMonitor) monitor() error {
+	if m.cli == nil {
+		cli, err := newDockerClient()
+		if err != nil {
+			return err
+		}
+		m.cli = cli
+	}
+
+	eventFilter := filters.NewArgs()
+	for _, event := range dockerEventFilter {
+		eventFilter.Add("event", event)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := m.cli.Events(ctx, types.EventsOptions{Filters: eventFilter})
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// resync after subscribing, so no event is lost in between
+	if err := m.resync(); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var event events.Message
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF {
+				return errDockerStreamClosed
+			}
+			return err
+		}
+		m.handleEvent(&event)
+	}
+}
+
+// handleEvent updates the service providers on a container event
+func (m *dockerEventMonitor) handleEvent(event *events.Message) {
+	log.Infof("Received Docker event: {%+v}", *event)
+	if event.ID == "" || (event.Type != "" && event.Type != events.ContainerEventType) {
+		return
+	}
+
+	switch event.Status {
+	case "start":
+		containerInfo, err := m.cli.ContainerInspect(context.Background(), event.ID)
+		if err != nil {
+			log.Errorf("Container Inspect failed :%s", err)
+			return
+		}
+		m.providerStart(&containerInfo)
+
+	case "kill":
+		// a container may survive the signal it was killed with
+		containerInfo, err := m.cli.ContainerInspect(context.Background(), event.ID)
+		if err == nil && containerInfo.State != nil && containerInfo.State.Running {
+			return
+		}
+		m.providerDie(event.ID)
+
+	case "die", "destroy":
+		m.providerDie(event.ID)
+	}
+}
+
+// resync registers the providers of the running containers and removes the
+// providers of containers that stopped while the stream was down
+func (m *dockerEventMonitor) resync() error {
+	containers, err := m.cli.ContainerList(context.Background(), types.ContainerListOptions{})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Resyncing service providers of %d running containers", len(containers))
+
+	running := make(map[string]bool)
+	for _, container := range containers {
+		containerInfo, err := m.cli.ContainerInspect(context.Background(), container.ID)
+		if err != nil {
+			log.Errorf("Container Inspect failed :%s", err)
+			continue
+		}
+		running[container.ID] = true
+		m.providerStart(&containerInfo)
+	}
+
+	for _, containerID := range m.hostContainers() {
+		if !running[containerID] {
+			m.providerDie(containerID)
+		}
+	}
+
+	return nil
+}
+
+// hostContainers returns the containers netmaster knows of on this host
+func (m *dockerEventMonitor) hostContainers() []string {
+	containers := []string{}
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = m.stateDriver
+	epCfgs, err := epCfg.ReadAll()
+	if err != nil {
+		if core.ErrIfKeyExists(err) != nil {
+			log.Errorf("Error reading endpoints. Error: %v", err)
+		}
+		return containers
+	}
+
+	// a container has an endpoint on each of its networks
+	seen := make(map[string]bool)
+	for _, state := range epCfgs {
+		ep := state.(*mastercfg.CfgEndpointState)
+		if ep.ContainerID != "" && ep.HomingHost == m.hostLabel && !seen[ep.ContainerID] {
+			seen[ep.ContainerID] = true
+			containers = append(containers, ep.ContainerID)
+		}
+	}
+
+	return containers
+}
+
+// providerStart registers a container as a provider on each contiv network
+// it is attached to
+func (m *dockerEventMonitor) providerStart(containerInfo *types.ContainerJSON) {
+	labelMap := getLabelsFromContainerInspect(containerInfo)
+
+	// the master only records the container of endpoints without labels
+	for _, epNetwork := range getEpNetworkInfoFromContainerInspect(containerInfo) {
+		providerUpdReq := &master.SvcProvUpdateRequest{}
+		providerUpdReq.IPAddress = epNetwork.ipAddress
+		providerUpdReq.ContainerID = containerInfo.ID
+		providerUpdReq.Tenant = epNetwork.tenant
+		providerUpdReq.Network = epNetwork.network
+		providerUpdReq.Event = "start"
+		providerUpdReq.Container = epNetwork.endpointID
+		providerUpdReq.Labels = make(map[string]string)
+
+		for k, v := range labelMap {
+			providerUpdReq.Labels[k] = v
+		}
+
+		log.Infof("Sending Provider create request to master: {%+v}", providerUpdReq)
+
+		if err := m.updateProvider(providerUpdReq); err != nil {
+			log.Errorf("Event: 'start' , Http error posting service provider update, Error:%s", err)
+		}
+	}
+}
+
+// providerDie removes a container from the providers of all its networks
+func (m *dockerEventMonitor) providerDie(containerID string) {
+	providerUpdReq := &master.SvcProvUpdateRequest{}
+	providerUpdReq.ContainerID = containerID
+	providerUpdReq.Event = "die"
+	log.Infof("Sending Provider delete request to master: {%+v}", providerUpdReq)
+	if err := m.updateProvider(providerUpdReq); err != nil {
+		log.Errorf("Event:'die' Http error posting service provider update, Error:%s", err)
+	}
+}
@@ -0,0 +1,151 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/docker/engine-api/types"
+	"github.com/docker/engine-api/types/events"
+	"golang.org/x/net/context"
+)
+
+// fakeDockerClient serves the event streams queued by the tests and the
+// containers marked as running
+type fakeDockerClient struct {
+	streams []io.ReadCloser
+	running map[string]bool
+}
+
+func (c *fakeDockerClient) Events(ctx context.Context, options types.EventsOptions) (io.ReadCloser, error) {
+	if len(c.streams) == 0 {
+		return nil, errors.New("docker is down")
+	}
+	stream := c.streams[0]
+	c.streams = c.streams[1:]
+	return stream, nil
+}
+
+func (c *fakeDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	containers := []types.Container{}
+	for id, running := range c.running {
+		if running {
+			containers = append(containers, types.Container{ID: id})
+		}
+	}
+	return containers, nil
+}
+
+func (c *fakeDockerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	running, ok := c.running[containerID]
+	if !ok {
+		return types.ContainerJSON{}, errors.New("no such container")
+	}
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:    containerID,
+			State: &types.ContainerState{Running: running},
+		},
+	}, nil
+}
+
+// queueStream queues an event stream ending after the events
+func (c *fakeDockerClient) queueStream(t *testing.T, msgs ...events.Message) {
+	buf := &bytes.Buffer{}
+	for _, msg := range msgs {
+		if err := json.NewEncoder(buf).Encode(msg); err != nil {
+			t.Fatalf("Error encoding docker event. Err: %v", err)
+		}
+	}
+	c.streams = append(c.streams, ioutil.NopCloser(buf))
+}
+
+func addHostEndpoint(t *testing.T, stateDriver core.StateDriver, containerID, host string) {
+	epCfg := &mastercfg.CfgEndpointState{ContainerID: containerID, HomingHost: host}
+	epCfg.StateDriver = stateDriver
+	epCfg.ID = "net.default-" + containerID
+	if err := epCfg.Write(); err != nil {
+		t.Fatalf("Error writing endpoint of %s. Err: %v", containerID, err)
+	}
+}
+
+func TestDockerEventResync(t *testing.T) {
+	stateDriver, err := utils.NewStateDriver("fakedriver", &core.InstanceInfo{})
+	if err != nil {
+		t.Fatalf("failed to init statedriver. Error: %s", err)
+	}
+	defer utils.ReleaseStateDriver()
+
+	for _, containerID := range []string{"c1", "c2", "c3"} {
+		addHostEndpoint(t, stateDriver, containerID, "host1")
+	}
+	addHostEndpoint(t, stateDriver, "c4", "host2")
+
+	cli := &fakeDockerClient{running: map[string]bool{"c1": true, "c2": true, "c3": true}}
+	died := []string{}
+	m := newDockerEventMonitor(stateDriver, cliOpts{hostLabel: "host1"})
+	m.cli = cli
+	m.updateProvider = func(req *master.SvcProvUpdateRequest) error {
+		if req.Event != "die" {
+			t.Errorf("Unexpected provider update %+v", req)
+		}
+		died = append(died, req.ContainerID)
+		return nil
+	}
+
+	// c1 survives the signal it is killed with
+	cli.queueStream(t, events.Message{Status: "kill", ID: "c1", Type: events.ContainerEventType})
+	if err := m.monitor(); err != errDockerStreamClosed {
+		t.Fatalf("Unexpected error from a closed stream: %v", err)
+	}
+	if len(died) != 0 {
+		t.Fatalf("Providers removed while their containers run: %v", died)
+	}
+
+	// c3 stops while the stream is down, c2 is destroyed and c5, unknown to
+	// docker by now, is killed after the reconnect
+	cli.running["c3"] = false
+	cli.queueStream(t,
+		events.Message{Status: "kill", ID: "c1", Type: events.ContainerEventType},
+		events.Message{Status: "destroy", ID: "c2", Type: events.NetworkEventType},
+		events.Message{Status: "destroy", ID: "c2", Type: events.ContainerEventType},
+		events.Message{Status: "kill", ID: "c5", Type: events.ContainerEventType})
+	if err := m.monitor(); err != errDockerStreamClosed {
+		t.Fatalf("Unexpected error from a closed stream: %v", err)
+	}
+	if expDied := []string{"c3", "c2", "c5"}; !reflect.DeepEqual(died, expDied) {
+		t.Fatalf("Removed providers %v, expected %v", died, expDied)
+	}
+
+	// a failed reconnect leaves the providers alone
+	died = []string{}
+	if err := m.monitor(); err == nil {
+		t.Fatalf("Connecting to a stopped docker succeeded")
+	}
+	if len(died) != 0 {
+		t.Fatalf("Providers removed without docker: %v", died)
+	}
+}
@@ -0,0 +1,275 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+)
+
+const (
+	lldpEtherType     = 0x88cc
+	vlanEtherType     = 0x8100
+	lldpMaxFrameLen   = 9216
+	lldpReadTimeout   = time.Second
+	lldpRetryInterval = 10 * time.Second
+)
+
+// lldpMulticastMAC is the nearest bridge address the lldp frames are sent to
+var lldpMulticastMAC = []byte{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// lldpFilter is the socket filter passing the lldp frames, untagged or
+// vlan tagged, to the packet sockets
+var lldpFilter = []syscall.SockFilter{
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 12),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, lldpEtherType, 3, 0),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, vlanEtherType, 0, 3),
+	*syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 16),
+	*syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, lldpEtherType, 0, 1),
+	*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, lldpMaxFrameLen),
+	*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+}
+
+// packetMreq is the struct packet_mreq of the packet sockets
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+// lldpMonitor receives the lldp frames of the uplinks and publishes the
+// neighbors of the host
+type lldpMonitor struct {
+	sync.Mutex
+	stateDriver core.StateDriver
+	hostname    string
+	neighbors   map[string]*mastercfg.LLDPNeighbor // keyed by uplink
+	expiry      map[string]time.Time               // keyed by uplink
+}
+
+// startLLDPMonitor starts learning the lldp neighbors of the uplinks
+func startLLDPMonitor(stateDriver core.StateDriver, hostname string, uplinks []string) {
+	m := &lldpMonitor{
+		stateDriver: stateDriver,
+		hostname:    hostname,
+		neighbors:   make(map[string]*mastercfg.LLDPNeighbor),
+		expiry:      make(map[string]time.Time),
+	}
+
+	// the neighbors published by an earlier run may be gone
+	m.Lock()
+	m.publish()
+	m.Unlock()
+
+	for _, uplink := range uplinks {
+		go m.receive(uplink)
+	}
+}
+
+// receive reads the lldp frames of an uplink, the socket is reopened on
+// errors, e.g. when the uplink is recreated
+func (m *lldpMonitor) receive(uplink string) {
+	for {
+		fd, err := openLLDPSocket(uplink)
+		if err != nil {
+			log.Errorf("Error opening lldp socket on %s. Err: %v", uplink, err)
+		} else {
+			err = m.readFrames(fd, uplink)
+			syscall.Close(fd)
+			log.Errorf("Error receiving lldp frames on %s. Err: %v", uplink, err)
+		}
+
+		m.expire(uplink)
+		time.Sleep(lldpRetryInterval)
+	}
+}
+
+// readFrames reads the lldp frames of an uplink until the socket fails
+func (m *lldpMonitor) readFrames(fd int, uplink string) error {
+	buf := make([]byte, lldpMaxFrameLen)
+	for {
+		frameLen, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			m.expire(uplink)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// the socket sees the frames the host sends too
+		if sll, ok := from.(*syscall.SockaddrLinklayer); ok && sll.Pkttype == syscall.PACKET_OUTGOING {
+			continue
+		}
+
+		lldp, err := utils.ParseLLDPFrame(buf[:frameLen])
+		if err != nil {
+			log.Debugf("Dropping lldp frame received on %s. Err: %v", uplink, err)
+			continue
+		}
+		m.update(uplink, lldp)
+		m.expire(uplink)
+	}
+}
+
+// update records the neighbor heard on an uplink. The neighbors are
+// published when they change, a zero ttl removes the neighbor.
+func (m *lldpMonitor) update(uplink string, lldp *utils.LLDPNeighbor) {
+	m.Lock()
+	defer m.Unlock()
+
+	if lldp.TTL == 0 {
+		if m.neighbors[uplink] != nil {
+			log.Infof("LLDP neighbor %s on %s is shutting down", m.neighbors[uplink].ChassisID, uplink)
+			delete(m.neighbors, uplink)
+			delete(m.expiry, uplink)
+			m.publish()
+		}
+		return
+	}
+
+	now := time.Now()
+	m.expiry[uplink] = now.Add(time.Duration(lldp.TTL) * time.Second)
+
+	neighbor := &mastercfg.LLDPNeighbor{
+		Uplink:            uplink,
+		ChassisID:         lldp.ChassisID,
+		PortID:            lldp.PortID,
+		PortDesc:          lldp.PortDesc,
+		SystemName:        lldp.SystemName,
+		SystemDescription: lldp.SystemDescription,
+		CiscoACIPodID:     lldp.CiscoACIPodID,
+		CiscoACINodeID:    lldp.CiscoACINodeID,
+		TTL:               int(lldp.TTL),
+		UpdateTime:        now,
+	}
+	if old := m.neighbors[uplink]; old != nil {
+		neighbor.UpdateTime = old.UpdateTime
+		if *neighbor == *old {
+			return
+		}
+		neighbor.UpdateTime = now
+	}
+
+	log.Infof("LLDP neighbor on %s: %s port %s", uplink, lldp.SystemName, lldp.PortID)
+	m.neighbors[uplink] = neighbor
+	m.publish()
+}
+
+// expire removes the neighbor of an uplink not heard within its ttl
+func (m *lldpMonitor) expire(uplink string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.neighbors[uplink] == nil || time.Now().Before(m.expiry[uplink]) {
+		return
+	}
+
+	log.Infof("LLDP neighbor %s on %s expired", m.neighbors[uplink].ChassisID, uplink)
+	delete(m.neighbors, uplink)
+	delete(m.expiry, uplink)
+	m.publish()
+}
+
+// publish writes the neighbors of the host to the state store
+func (m *lldpMonitor) publish() {
+	uplinks := []string{}
+	for uplink := range m.neighbors {
+		uplinks = append(uplinks, uplink)
+	}
+	sort.Strings(uplinks)
+
+	nodeState := &mastercfg.OperNodeState{
+		Hostname:      m.hostname,
+		LLDPNeighbors: []mastercfg.LLDPNeighbor{},
+	}
+	nodeState.StateDriver = m.stateDriver
+	nodeState.ID = m.hostname
+	for _, uplink := range uplinks {
+		nodeState.LLDPNeighbors = append(nodeState.LLDPNeighbors, *m.neighbors[uplink])
+	}
+
+	if err := nodeState.Write(); err != nil {
+		// published again on the next change of the neighbors
+		log.Errorf("Error writing the lldp neighbors of host %s. Err: %v", m.hostname, err)
+	}
+}
+
+// openLLDPSocket opens a packet socket receiving the lldp frames of an
+// interface. The frames of an interface attached to an ovs bridge are
+// handed to ovs before the sockets of a protocol see them, so the socket
+// receives all the protocols and filters the lldp frames.
+func openLLDPSocket(ifname string) (int, error) {
+	intf, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return -1, err
+	}
+
+	// no frames are received until the socket is bound, after the filter
+	// is attached
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return -1, err
+	}
+
+	err = syscall.AttachLsf(fd, lldpFilter)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ALL), Ifindex: intf.Index})
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	// the frames to the lldp address are filtered unless the interface is
+	// promiscuous
+	mreq := packetMreq{ifindex: int32(intf.Index), mrType: syscall.PACKET_MR_MULTICAST, alen: 6}
+	copy(mreq.address[:], lldpMulticastMAC)
+	err = syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP,
+		string((*[unsafe.Sizeof(mreq)]byte)(unsafe.Pointer(&mreq))[:]))
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	// the reads time out for the neighbors to expire
+	tv := syscall.NsecToTimeval(int64(lldpReadTimeout))
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+// htons converts a short to the network byte order
+func htons(value uint16) uint16 {
+	return value<<8 | value>>8
+}
@@ -2,5 +2,7 @@ package main
 
 import (
 	_ "github.com/contiv/netplugin/svcplugin/consulextension"
+	_ "github.com/contiv/netplugin/svcplugin/corednsextension"
 	_ "github.com/contiv/netplugin/svcplugin/skydns2extension"
+	_ "github.com/contiv/netplugin/svcplugin/webhookextension"
 )
@@ -0,0 +1,195 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nameserver
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// dns record types and classes
+const (
+	typeA    = 1
+	typeAAAA = 28
+	typeSRV  = 33
+	typeANY  = 255
+	classIN  = 1
+)
+
+// dns response codes
+const (
+	rcodeSuccess        = 0
+	rcodeFormatError    = 1
+	rcodeServerFailure  = 2
+	rcodeNameError      = 3
+	rcodeNotImplemented = 4
+)
+
+const (
+	headerLen     = 12
+	maxUDPMsgLen  = 512
+	flagResponse  = 0x8000
+	flagAuthority = 0x0400
+	flagTruncated = 0x0200
+	flagRecursion = 0x0100 // recursion desired
+	flagRecAvail  = 0x0080 // recursion available
+	opcodeMask    = 0x7800
+)
+
+var errMalformed = errors.New("malformed dns message")
+
+// dnsQuestion is the question of a dns query
+type dnsQuestion struct {
+	name  string // lower case, without the trailing dot
+	qtype uint16
+	class uint16
+	raw   []byte // the question as sent
+}
+
+// dnsQuery is a dns query with a single question
+type dnsQuery struct {
+	id       uint16
+	flags    uint16
+	question dnsQuestion
+}
+
+// dnsRecord is an answer to a dns query
+type dnsRecord struct {
+	rtype  uint16
+	ttl    uint32
+	ip     net.IP // A and AAAA records
+	port   uint16 // SRV records
+	target string // SRV records
+}
+
+// parseQuery parses a dns query. Queries with other than one question are
+// malformed for the name server.
+func parseQuery(msg []byte) (*dnsQuery, error) {
+	if len(msg) < headerLen {
+		return nil, errMalformed
+	}
+
+	query := &dnsQuery{
+		id:    binary.BigEndian.Uint16(msg[0:2]),
+		flags: binary.BigEndian.Uint16(msg[2:4]),
+	}
+	if query.flags&flagResponse != 0 || binary.BigEndian.Uint16(msg[4:6]) != 1 {
+		return query, errMalformed
+	}
+
+	labels := []string{}
+	offset := headerLen
+	for {
+		if offset >= len(msg) {
+			return query, errMalformed
+		}
+		labelLen := int(msg[offset])
+		offset++
+		if labelLen == 0 {
+			break
+		}
+		// compression is not expected in the question
+		if labelLen > 63 || offset+labelLen > len(msg) {
+			return query, errMalformed
+		}
+		labels = append(labels, string(msg[offset:offset+labelLen]))
+		offset += labelLen
+	}
+	if offset+4 > len(msg) {
+		return query, errMalformed
+	}
+
+	query.question = dnsQuestion{
+		name:  strings.ToLower(strings.Join(labels, ".")),
+		qtype: binary.BigEndian.Uint16(msg[offset : offset+2]),
+		class: binary.BigEndian.Uint16(msg[offset+2 : offset+4]),
+		raw:   msg[headerLen : offset+4],
+	}
+
+	return query, nil
+}
+
+// reply builds the reply to a query. Replies not fitting in a udp message
+// are sent truncated, without answers.
+func (q *dnsQuery) reply(rcode int, answers []dnsRecord) []byte {
+	flags := flagResponse | flagRecAvail | (q.flags & (opcodeMask | flagRecursion)) | uint16(rcode)
+	if rcode == rcodeSuccess || rcode == rcodeNameError {
+		flags |= flagAuthority
+	}
+
+	msg := make([]byte, headerLen, maxUDPMsgLen)
+	binary.BigEndian.PutUint16(msg[0:2], q.id)
+	binary.BigEndian.PutUint16(msg[2:4], flags)
+	if q.question.raw != nil {
+		binary.BigEndian.PutUint16(msg[4:6], 1)
+		msg = append(msg, q.question.raw...)
+	}
+
+	ancount := 0
+	for _, answer := range answers {
+		if q.question.raw == nil {
+			break
+		}
+		msg = append(msg, 0xc0, headerLen) // the name of the question
+		msg = appendUint16(msg, answer.rtype)
+		msg = appendUint16(msg, classIN)
+		msg = append(msg, byte(answer.ttl>>24), byte(answer.ttl>>16), byte(answer.ttl>>8), byte(answer.ttl))
+
+		var rdata []byte
+		switch answer.rtype {
+		case typeA:
+			rdata = answer.ip.To4()
+		case typeAAAA:
+			rdata = answer.ip.To16()
+		case typeSRV:
+			rdata = appendUint16(rdata, 0) // priority
+			rdata = appendUint16(rdata, 0) // weight
+			rdata = appendUint16(rdata, answer.port)
+			rdata = appendName(rdata, answer.target)
+		}
+		msg = appendUint16(msg, uint16(len(rdata)))
+		msg = append(msg, rdata...)
+		ancount++
+	}
+	binary.BigEndian.PutUint16(msg[6:8], uint16(ancount))
+
+	if len(msg) > maxUDPMsgLen {
+		truncated := q.reply(rcode, nil)
+		binary.BigEndian.PutUint16(truncated[2:4], flags|flagTruncated)
+		return truncated
+	}
+
+	return msg
+}
+
+func appendUint16(msg []byte, value uint16) []byte {
+	return append(msg, byte(value>>8), byte(value))
+}
+
+// appendName appends a domain name in the uncompressed wire format
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.Trim(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+
+	return append(msg, 0)
+}
@@ -0,0 +1,358 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package nameserver implements the name server of the networks. The
+// containers of a tenant resolve the endpoints, endpoint groups and services
+// of the tenant by name, other names are resolved by the upstream name
+// servers.
+package nameserver
+
+import (
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// DefaultTTL is the ttl of the answers of the name server
+const DefaultTTL = 5
+
+// upstreamTimeout is how long an upstream name server may take to answer
+const upstreamTimeout = 2 * time.Second
+
+// nameIndex is a set of endpoint ids keyed by name
+type nameIndex map[string]map[string]bool
+
+func (idx nameIndex) add(name, epID string) {
+	if name == "" {
+		return
+	}
+	name = strings.ToLower(name)
+	if idx[name] == nil {
+		idx[name] = make(map[string]bool)
+	}
+	idx[name][epID] = true
+}
+
+func (idx nameIndex) remove(name, epID string) {
+	name = strings.ToLower(name)
+	delete(idx[name], epID)
+	if len(idx[name]) == 0 {
+		delete(idx, name)
+	}
+}
+
+// NameServer answers the dns queries of the networks. Its tables are kept
+// up to date with the networks, endpoints and services of the state store
+// by the Update calls of the state watches.
+type NameServer struct {
+	mutex     sync.RWMutex
+	upstreams []string                                // host:port of the upstream name servers
+	networks  map[string]string                       // tenant keyed by network id
+	endpoints map[string]*mastercfg.CfgEndpointState  // keyed by endpoint id
+	services  map[string]*mastercfg.CfgServiceLBState // keyed by name.tenant
+	groups    nameIndex                               // endpoint groups
+	names     nameIndex                               // container ids and names
+}
+
+// NewNameServer creates a name server forwarding the names it does not
+// know to the upstream name servers
+func NewNameServer(upstreams []string) *NameServer {
+	ns := &NameServer{
+		networks:  make(map[string]string),
+		endpoints: make(map[string]*mastercfg.CfgEndpointState),
+		services:  make(map[string]*mastercfg.CfgServiceLBState),
+		groups:    make(nameIndex),
+		names:     make(nameIndex),
+	}
+	for _, upstream := range upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		ns.upstreams = append(ns.upstreams, upstream)
+	}
+
+	return ns
+}
+
+// UpdateNetwork adds, updates or removes a network
+func (ns *NameServer) UpdateNetwork(nwCfg *mastercfg.CfgNetworkState, isDelete bool) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	if isDelete {
+		delete(ns.networks, nwCfg.ID)
+		return
+	}
+	ns.networks[nwCfg.ID] = nwCfg.Tenant
+}
+
+// UpdateEndpoint adds, updates or removes an endpoint
+func (ns *NameServer) UpdateEndpoint(epCfg *mastercfg.CfgEndpointState, isDelete bool) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	if old := ns.endpoints[epCfg.ID]; old != nil {
+		ns.groups.remove(old.ServiceName, old.ID)
+		ns.names.remove(old.ContainerID, old.ID)
+		ns.names.remove(old.ContName, old.ID)
+		delete(ns.endpoints, old.ID)
+	}
+	if isDelete {
+		return
+	}
+
+	ns.endpoints[epCfg.ID] = epCfg
+	ns.groups.add(epCfg.ServiceName, epCfg.ID)
+	ns.names.add(epCfg.ContainerID, epCfg.ID)
+	ns.names.add(epCfg.ContName, epCfg.ID)
+}
+
+// UpdateService adds, updates or removes a service, services are resolved
+// once they have an address
+func (ns *NameServer) UpdateService(svcCfg *mastercfg.CfgServiceLBState, isDelete bool) {
+	ns.mutex.Lock()
+	defer ns.mutex.Unlock()
+
+	key := strings.ToLower(svcCfg.ServiceName + "." + svcCfg.Tenant)
+	if isDelete || svcCfg.IPAddress == "" {
+		delete(ns.services, key)
+		return
+	}
+	ns.services[key] = svcCfg
+}
+
+// ServeDNS answers a dns query sent to the name server of a network
+func (ns *NameServer) ServeDNS(networkID string, msg []byte) []byte {
+	query, err := parseQuery(msg)
+	if err != nil {
+		if query == nil {
+			return nil
+		}
+		return query.reply(rcodeFormatError, nil)
+	}
+	if query.flags&opcodeMask != 0 {
+		return query.reply(rcodeNotImplemented, nil)
+	}
+
+	ns.mutex.RLock()
+	tenant, found := ns.networks[networkID]
+	if !found {
+		ns.mutex.RUnlock()
+		log.Debugf("Dropping dns query for unknown network %s", networkID)
+		return nil
+	}
+
+	answers, authoritative := ns.lookup(tenant, &query.question)
+	ns.mutex.RUnlock()
+
+	if authoritative {
+		if answers == nil {
+			return query.reply(rcodeNameError, nil)
+		}
+		return query.reply(rcodeSuccess, answers)
+	}
+
+	return ns.forward(query, msg)
+}
+
+// lookup answers a question from the names of a tenant. Names are
+// <name> or <name>.<tenant>, where a name is a service, an endpoint group
+// or a container. Returns false if the question is not for the tenant.
+func (ns *NameServer) lookup(tenant string, question *dnsQuestion) ([]dnsRecord, bool) {
+	if question.class != classIN {
+		return nil, false
+	}
+
+	name := question.name
+	tenant = strings.ToLower(tenant)
+	if strings.HasSuffix(name, "."+tenant) {
+		name = strings.TrimSuffix(name, "."+tenant)
+	} else if strings.Contains(name, ".") {
+		return nil, false
+	}
+	if name == "" || strings.Contains(name, ".") {
+		return nil, name != ""
+	}
+
+	answers := []dnsRecord{}
+	if svc := ns.services[name+"."+tenant]; svc != nil {
+		answers = appendIPRecords(answers, question.qtype, svc.IPAddress, "")
+		if question.qtype == typeSRV || question.qtype == typeANY {
+			answers = appendSRVRecords(answers, question.name, svc.Ports)
+		}
+	} else if group := ns.tenantEndpoints(tenant, ns.groups[name]); len(group) != 0 {
+		for _, ep := range group {
+			answers = appendIPRecords(answers, question.qtype, ep.IPAddress, ep.IPv6Address)
+		}
+	} else if ep := ns.findEndpoint(tenant, name); ep != nil {
+		answers = appendIPRecords(answers, question.qtype, ep.IPAddress, ep.IPv6Address)
+	} else {
+		return nil, true
+	}
+
+	return answers, true
+}
+
+// tenantEndpoints returns the endpoints of a tenant among a set of
+// endpoint ids, sorted by id
+func (ns *NameServer) tenantEndpoints(tenant string, epIDs map[string]bool) []*mastercfg.CfgEndpointState {
+	ids := []string{}
+	for epID := range epIDs {
+		ids = append(ids, epID)
+	}
+	sort.Strings(ids)
+
+	eps := []*mastercfg.CfgEndpointState{}
+	for _, epID := range ids {
+		ep := ns.endpoints[epID]
+		if nwTenant, found := ns.networks[ep.NetID]; found && strings.ToLower(nwTenant) == tenant {
+			eps = append(eps, ep)
+		}
+	}
+
+	return eps
+}
+
+// findEndpoint finds an endpoint of a tenant by container name, container
+// id or the short container id
+func (ns *NameServer) findEndpoint(tenant, name string) *mastercfg.CfgEndpointState {
+	if eps := ns.tenantEndpoints(tenant, ns.names[name]); len(eps) != 0 {
+		return eps[0]
+	}
+
+	if len(name) != 12 {
+		return nil
+	}
+	matches := make(map[string]bool)
+	for _, ep := range ns.endpoints {
+		if strings.HasPrefix(strings.ToLower(ep.ContainerID), name) {
+			matches[ep.ID] = true
+		}
+	}
+	if eps := ns.tenantEndpoints(tenant, matches); len(eps) != 0 {
+		return eps[0]
+	}
+
+	return nil
+}
+
+// appendIPRecords appends the A or AAAA records asked for
+func appendIPRecords(answers []dnsRecord, qtype uint16, ipv4, ipv6 string) []dnsRecord {
+	if qtype == typeA || qtype == typeANY {
+		if ip := net.ParseIP(ipv4); ip != nil && ip.To4() != nil {
+			answers = append(answers, dnsRecord{rtype: typeA, ttl: DefaultTTL, ip: ip})
+		}
+	}
+	if qtype == typeAAAA || qtype == typeANY {
+		if ip := net.ParseIP(ipv6); ip != nil && ip.To4() == nil {
+			answers = append(answers, dnsRecord{rtype: typeAAAA, ttl: DefaultTTL, ip: ip})
+		}
+	}
+
+	return answers
+}
+
+// appendSRVRecords appends a SRV record per service port. The ports are
+// service_port:provider_port:protocol.
+func appendSRVRecords(answers []dnsRecord, target string, ports []string) []dnsRecord {
+	for _, port := range ports {
+		svcPort, err := strconv.ParseUint(strings.Split(port, ":")[0], 10, 16)
+		if err != nil {
+			continue
+		}
+		answers = append(answers, dnsRecord{
+			rtype:  typeSRV,
+			ttl:    DefaultTTL,
+			port:   uint16(svcPort),
+			target: target,
+		})
+	}
+
+	return answers
+}
+
+// forward sends a query to the upstream name servers and returns the first
+// reply
+func (ns *NameServer) forward(query *dnsQuery, msg []byte) []byte {
+	for _, upstream := range ns.upstreams {
+		reply, err := exchange(upstream, msg)
+		if err != nil {
+			log.Debugf("Error forwarding dns query to %s. Err: %v", upstream, err)
+			continue
+		}
+		if len(reply) < headerLen || reply[0] != msg[0] || reply[1] != msg[1] {
+			log.Debugf("Invalid dns reply from %s", upstream)
+			continue
+		}
+
+		return reply
+	}
+
+	return query.reply(rcodeServerFailure, nil)
+}
+
+// exchange sends a query to a name server over udp and reads its reply
+func exchange(server string, msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 65535)
+	replyLen, err := conn.Read(reply)
+	if err != nil {
+		return nil, err
+	}
+
+	return reply[:replyLen], nil
+}
+
+// ResolvConfPath is the resolver configuration of the host
+const ResolvConfPath = "/etc/resolv.conf"
+
+// ResolvConfServers returns the name servers of a resolver configuration
+func ResolvConfServers(path string) []string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Warnf("Error reading %s. Err: %v", path, err)
+		return nil
+	}
+
+	servers := []string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if net.ParseIP(fields[1]) != nil {
+			servers = append(servers, fields[1])
+		}
+	}
+
+	return servers
+}
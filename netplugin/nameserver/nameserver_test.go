@@ -0,0 +1,294 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nameserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// addTestState feeds the networks, endpoints and services of the tests to a
+// name server, the endpoints arrive before their networks
+func addTestState(ns *NameServer) {
+	eps := []*mastercfg.CfgEndpointState{
+		{NetID: "net1.default", ContName: "web1", ServiceName: "web",
+			ContainerID: "0123456789abcdef0123", IPAddress: "10.1.1.2", IPv6Address: "2001::2"},
+		{NetID: "net1.default", ContName: "web2", ServiceName: "web",
+			ContainerID: "fedcba98765432100123", IPAddress: "10.1.1.3"},
+		{NetID: "net1.blue", ContName: "db1", ServiceName: "db",
+			ContainerID: "aaaabbbbccccdddd", IPAddress: "10.2.1.2"},
+	}
+	for idx, ep := range eps {
+		ep.ID = fmt.Sprintf("ep%d", idx+1)
+		ns.UpdateEndpoint(ep, false)
+	}
+
+	for _, tenant := range []string{"default", "blue"} {
+		nw := &mastercfg.CfgNetworkState{Tenant: tenant, NetworkName: "net1"}
+		nw.ID = "net1." + tenant
+		ns.UpdateNetwork(nw, false)
+	}
+
+	svcs := []*mastercfg.CfgServiceLBState{
+		{ServiceName: "app", Tenant: "default", IPAddress: "10.254.0.1",
+			Ports: []string{"80:8080:TCP", "443:8443:TCP"}},
+		{ServiceName: "web", Tenant: "blue", IPAddress: "10.254.0.2"},
+	}
+	for _, svc := range svcs {
+		svc.ID = svc.ServiceName + ":" + svc.Tenant
+		ns.UpdateService(svc, false)
+	}
+}
+
+// buildQuery builds a dns query for a name
+func buildQuery(id uint16, name string, qtype uint16) []byte {
+	msg := make([]byte, headerLen)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], flagRecursion)
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	msg = appendName(msg, name)
+	msg = appendUint16(msg, qtype)
+	return appendUint16(msg, classIN)
+}
+
+// testReply is a decoded reply of the name server
+type testReply struct {
+	id    uint16
+	rcode int
+	ips   []string
+	ports []int
+}
+
+// parseReply decodes a reply, answers must point to the question name
+func parseReply(t *testing.T, msg []byte) *testReply {
+	if len(msg) < headerLen {
+		t.Fatalf("Short dns reply %v", msg)
+	}
+	flags := binary.BigEndian.Uint16(msg[2:4])
+	if flags&flagResponse == 0 {
+		t.Fatalf("Reply is not a response: %v", msg)
+	}
+	reply := &testReply{
+		id:    binary.BigEndian.Uint16(msg[0:2]),
+		rcode: int(flags & 0xf),
+	}
+
+	if binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return reply
+	}
+	query, err := parseQuery(append([]byte{0, 0, 0, 0}, msg[4:]...))
+	if err != nil {
+		t.Fatalf("Error parsing the question of reply %v. Err: %v", msg, err)
+	}
+
+	offset := headerLen + len(query.question.raw)
+	for idx := 0; idx < int(binary.BigEndian.Uint16(msg[6:8])); idx++ {
+		rtype := binary.BigEndian.Uint16(msg[offset+2:])
+		rdLen := int(binary.BigEndian.Uint16(msg[offset+10:]))
+		rdata := msg[offset+12 : offset+12+rdLen]
+		switch rtype {
+		case typeA, typeAAAA:
+			reply.ips = append(reply.ips, net.IP(rdata).String())
+		case typeSRV:
+			reply.ports = append(reply.ports, int(binary.BigEndian.Uint16(rdata[4:6])))
+		}
+		offset += 12 + rdLen
+	}
+	sort.Strings(reply.ips)
+
+	return reply
+}
+
+func checkReply(t *testing.T, ns *NameServer, networkID, name string, qtype uint16,
+	expRcode int, expIPs []string) *testReply {
+	reply := parseReply(t, ns.ServeDNS(networkID, buildQuery(0x1234, name, qtype)))
+	if reply.id != 0x1234 || reply.rcode != expRcode {
+		t.Fatalf("Query of %s in %s: expecting rcode %d, got %+v", name, networkID, expRcode, reply)
+	}
+	if len(expIPs) != 0 || len(reply.ips) != 0 {
+		if !reflect.DeepEqual(reply.ips, expIPs) {
+			t.Fatalf("Query of %s in %s: expecting %v, got %+v", name, networkID, expIPs, reply)
+		}
+	}
+
+	return reply
+}
+
+func TestNameServerTenantNames(t *testing.T) {
+	ns := NewNameServer(nil)
+	addTestState(ns)
+
+	// services
+	checkReply(t, ns, "net1.default", "app", typeA, rcodeSuccess, []string{"10.254.0.1"})
+	checkReply(t, ns, "net1.default", "APP.default.", typeA, rcodeSuccess, []string{"10.254.0.1"})
+	reply := checkReply(t, ns, "net1.default", "app.default", typeSRV, rcodeSuccess, nil)
+	if !reflect.DeepEqual(reply.ports, []int{80, 443}) {
+		t.Fatalf("Expecting srv ports 80 and 443, got %+v", reply)
+	}
+
+	// endpoint groups, services of the same name take precedence
+	checkReply(t, ns, "net1.default", "web", typeA, rcodeSuccess, []string{"10.1.1.2", "10.1.1.3"})
+	checkReply(t, ns, "net1.blue", "web", typeA, rcodeSuccess, []string{"10.254.0.2"})
+	checkReply(t, ns, "net1.blue", "db.blue", typeA, rcodeSuccess, []string{"10.2.1.2"})
+
+	// endpoints by name, id and short id
+	checkReply(t, ns, "net1.default", "web1", typeA, rcodeSuccess, []string{"10.1.1.2"})
+	checkReply(t, ns, "net1.default", "web1", typeAAAA, rcodeSuccess, []string{"2001::2"})
+	checkReply(t, ns, "net1.default", "fedcba98765432100123", typeA, rcodeSuccess, []string{"10.1.1.3"})
+	checkReply(t, ns, "net1.default", "0123456789ab", typeA, rcodeSuccess, []string{"10.1.1.2"})
+
+	// names of other tenants are not visible
+	checkReply(t, ns, "net1.default", "db1", typeA, rcodeNameError, nil)
+	checkReply(t, ns, "net1.blue", "web1", typeA, rcodeNameError, nil)
+	checkReply(t, ns, "net1.default", "unknown.default", typeA, rcodeNameError, nil)
+
+	// names without addresses of the type are empty answers
+	checkReply(t, ns, "net1.default", "web2", typeAAAA, rcodeSuccess, nil)
+
+	// queries of unknown networks are dropped
+	if reply := ns.ServeDNS("net2.default", buildQuery(1, "app", typeA)); reply != nil {
+		t.Fatalf("Query of unknown network answered: %v", reply)
+	}
+}
+
+func TestNameServerUpdates(t *testing.T) {
+	ns := NewNameServer(nil)
+	addTestState(ns)
+
+	// an endpoint renamed and moved to another group
+	ep := &mastercfg.CfgEndpointState{NetID: "net1.default", ContName: "api1", ServiceName: "api",
+		ContainerID: "fedcba98765432100123", IPAddress: "10.1.1.3"}
+	ep.ID = "ep2"
+	ns.UpdateEndpoint(ep, false)
+	checkReply(t, ns, "net1.default", "web2", typeA, rcodeNameError, nil)
+	checkReply(t, ns, "net1.default", "api1", typeA, rcodeSuccess, []string{"10.1.1.3"})
+	checkReply(t, ns, "net1.default", "web", typeA, rcodeSuccess, []string{"10.1.1.2"})
+	checkReply(t, ns, "net1.default", "api", typeA, rcodeSuccess, []string{"10.1.1.3"})
+
+	// deleted endpoints and services are gone
+	ns.UpdateEndpoint(ep, true)
+	checkReply(t, ns, "net1.default", "api1", typeA, rcodeNameError, nil)
+	checkReply(t, ns, "net1.default", "fedcba987654", typeA, rcodeNameError, nil)
+	svc := &mastercfg.CfgServiceLBState{ServiceName: "app", Tenant: "default"}
+	svc.ID = "app:default"
+	ns.UpdateService(svc, false)
+	checkReply(t, ns, "net1.default", "app", typeA, rcodeNameError, nil)
+
+	// queries of deleted networks are dropped
+	nw := &mastercfg.CfgNetworkState{Tenant: "blue", NetworkName: "net1"}
+	nw.ID = "net1.blue"
+	ns.UpdateNetwork(nw, true)
+	if reply := ns.ServeDNS("net1.blue", buildQuery(1, "db1", typeA)); reply != nil {
+		t.Fatalf("Query of deleted network answered: %v", reply)
+	}
+}
+
+func TestNameServerForwarding(t *testing.T) {
+	// an upstream name server answering every query with its id
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening. Err: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			msgLen, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			query, err := parseQuery(buf[:msgLen])
+			if err != nil {
+				continue
+			}
+			reply := query.reply(rcodeSuccess, []dnsRecord{{rtype: typeA, ip: net.ParseIP("192.0.2.1")}})
+			conn.WriteTo(reply, addr)
+		}
+	}()
+
+	// the first upstream does not answer
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening. Err: %v", err)
+	}
+	defer silent.Close()
+
+	ns := NewNameServer([]string{silent.LocalAddr().String(), conn.LocalAddr().String()})
+	addTestState(ns)
+	checkReply(t, ns, "net1.default", "www.example.com", typeA, rcodeSuccess, []string{"192.0.2.1"})
+
+	// upstream failures are server failures
+	ns = NewNameServer([]string{silent.LocalAddr().String()})
+	addTestState(ns)
+	checkReply(t, ns, "net1.default", "www.example.com", typeA, rcodeServerFailure, nil)
+}
+
+func TestNameServerMalformedQueries(t *testing.T) {
+	ns := NewNameServer(nil)
+	if reply := ns.ServeDNS("net1.default", []byte{1, 2, 3}); reply != nil {
+		t.Fatalf("Short query answered: %v", reply)
+	}
+
+	query := buildQuery(7, "app", typeA)
+	reply := parseReply(t, ns.ServeDNS("net1.default", query[:len(query)-2]))
+	if reply.id != 7 || reply.rcode != rcodeFormatError {
+		t.Fatalf("Expecting format error, got %+v", reply)
+	}
+}
+
+func TestNameServerTruncation(t *testing.T) {
+	query, err := parseQuery(buildQuery(9, "web", typeA))
+	if err != nil {
+		t.Fatalf("Error parsing query. Err: %v", err)
+	}
+
+	answers := []dnsRecord{}
+	for idx := 0; idx < 40; idx++ {
+		answers = append(answers, dnsRecord{rtype: typeA, ip: net.IPv4(10, 1, 1, byte(idx))})
+	}
+	msg := query.reply(rcodeSuccess, answers)
+	if len(msg) > maxUDPMsgLen || binary.BigEndian.Uint16(msg[2:4])&flagTruncated == 0 ||
+		binary.BigEndian.Uint16(msg[6:8]) != 0 {
+		t.Fatalf("Expecting a truncated reply, got %v", msg)
+	}
+}
+
+func TestResolvConfServers(t *testing.T) {
+	file, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatalf("Error creating file. Err: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("# comment\nsearch example.com\nnameserver 10.0.0.2\nnameserver bad\nnameserver 2001:db8::1\n")
+	file.Close()
+
+	servers := ResolvConfServers(file.Name())
+	if !reflect.DeepEqual(servers, []string{"10.0.0.2", "2001:db8::1"}) {
+		t.Fatalf("Unexpected name servers %v", servers)
+	}
+	if ns := NewNameServer(servers); !reflect.DeepEqual(ns.upstreams, []string{"10.0.0.2:53", "[2001:db8::1]:53"}) {
+		t.Fatalf("Unexpected upstreams %v", ns.upstreams)
+	}
+}
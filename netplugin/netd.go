@@ -28,18 +28,19 @@ import (
 	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netplugin/cluster"
+	"github.com/contiv/netplugin/netplugin/nameserver"
 	"github.com/contiv/netplugin/netplugin/plugin"
 	"github.com/contiv/netplugin/svcplugin"
+	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/netplugin/version"
-	"github.com/docker/engine-api/client"
 	"github.com/docker/engine-api/types"
-	"github.com/samalba/dockerclient"
-	"golang.org/x/net/context"
 	"log/syslog"
 	"net/url"
 	"os"
 	"os/user"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -62,6 +63,25 @@ type cliOpts struct {
 	routerIP   string // myrouter ip to start a protocol like Bgp
 	fwdMode    string // default "bridge". Values: "routing" , "bridge"
 	dbURL      string // state store URL
+	svcRegURL  string // service registry adapter URL
+	dnsFwders  string // upstream name servers of the networks
+}
+
+// nameServer answers the dns queries of the networks, its tables are fed by
+// the state watches
+var nameServer *nameserver.NameServer
+
+// updateNameServer feeds the networks, endpoints and services to the name
+// server
+func updateNameServer(state core.State, isDelete bool) {
+	switch cfg := state.(type) {
+	case *mastercfg.CfgNetworkState:
+		nameServer.UpdateNetwork(cfg, isDelete)
+	case *mastercfg.CfgEndpointState:
+		nameServer.UpdateEndpoint(cfg, isDelete)
+	case *mastercfg.CfgServiceLBState:
+		nameServer.UpdateService(cfg, isDelete)
+	}
 }
 
 func skipHost(vtepIP, homingHost, myHostLabel string) bool {
@@ -77,6 +97,7 @@ func processCurrentState(netPlugin *plugin.NetPlugin, opts cliOpts) error {
 		for idx, netCfg := range netCfgs {
 			net := netCfg.(*mastercfg.CfgNetworkState)
 			log.Debugf("read net key[%d] %s, populating state \n", idx, net.ID)
+			updateNameServer(net, false)
 			processNetEvent(netPlugin, net, false)
 			if net.NwType == "infra" {
 				processInfraNwCreate(netPlugin, net, opts)
@@ -91,6 +112,7 @@ func processCurrentState(netPlugin *plugin.NetPlugin, opts cliOpts) error {
 		for idx, epCfg := range epCfgs {
 			ep := epCfg.(*mastercfg.CfgEndpointState)
 			log.Debugf("read ep key[%d] %s, populating state \n", idx, ep.ID)
+			updateNameServer(ep, false)
 			processEpState(netPlugin, opts, ep.ID)
 		}
 	}
@@ -114,6 +136,7 @@ func processCurrentState(netPlugin *plugin.NetPlugin, opts cliOpts) error {
 			serviceLb := serviceLbCfg.(*mastercfg.CfgServiceLBState)
 			log.Debugf("read svc key[%d] %s for tenant %s, populating state \n", idx,
 				serviceLb.ServiceName, serviceLb.Tenant)
+			updateNameServer(serviceLb, false)
 			processServiceLBEvent(netPlugin, opts, serviceLb, false)
 		}
 	}
@@ -234,6 +257,31 @@ func processNetEvent(netPlugin *plugin.NetPlugin, nwCfg *mastercfg.CfgNetworkSta
 	return
 }
 
+// subnetPoolsChanged checks if the additional subnets of a network changed
+func subnetPoolsChanged(prevCfg, nwCfg *mastercfg.CfgNetworkState) bool {
+	if len(prevCfg.SubnetPools) != len(nwCfg.SubnetPools) {
+		return true
+	}
+	for idx, sp := range nwCfg.SubnetPools {
+		prevSp := prevCfg.SubnetPools[idx]
+		if sp.SubnetIP != prevSp.SubnetIP || sp.SubnetLen != prevSp.SubnetLen || sp.Gateway != prevSp.Gateway {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceSpecChanged checks if the spec of a service load balancer changed,
+// as opposed to its providers
+func serviceSpecChanged(prevCfg, svcLBCfg *mastercfg.CfgServiceLBState) bool {
+	return prevCfg.IPAddress != svcLBCfg.IPAddress ||
+		!reflect.DeepEqual(prevCfg.Ports, svcLBCfg.Ports) ||
+		!reflect.DeepEqual(prevCfg.Selectors, svcLBCfg.Selectors) ||
+		!reflect.DeepEqual(prevCfg.SelectorExprs, svcLBCfg.SelectorExprs) ||
+		!reflect.DeepEqual(prevCfg.LBPolicy, svcLBCfg.LBPolicy)
+}
+
 // processEpState restores endpoint state
 func processEpState(netPlugin *plugin.NetPlugin, opts cliOpts, epID string) error {
 	// take a lock to ensure we are programming one event at a time.
@@ -312,18 +360,41 @@ func processStateEvent(netPlugin *plugin.NetPlugin, opts cliOpts, rsps chan core
 			currentState = rsp.Prev
 			isDelete = true
 			eventStr = "delete"
-		} else if rsp.Prev != nil {
-			log.Infof("Received a modify event, ignoring it")
+		}
+		updateNameServer(currentState, isDelete)
+
+		if !isDelete && rsp.Prev != nil {
+			eventStr = "modify"
 			if bgpCfg, ok := currentState.(*mastercfg.CfgBgpState); ok {
 				log.Infof("Received %q for Bgp: %q", eventStr, bgpCfg.Hostname)
 				processBgpEvent(netPlugin, opts, bgpCfg.Hostname, isDelete)
 				continue
-			} /*
-				if serviceLbCfg, ok := currentState.(*mastercfg.CfgServiceLBState); ok {
+			}
+
+			// the subnets and the dns server of a network change without
+			// recreating it
+			if nwCfg, ok := currentState.(*mastercfg.CfgNetworkState); ok {
+				prevCfg := rsp.Prev.(*mastercfg.CfgNetworkState)
+				if subnetPoolsChanged(prevCfg, nwCfg) || prevCfg.DNSServer != nwCfg.DNSServer {
+					log.Infof("Received subnets or dns server update for network: %q", nwCfg.ID)
+					processNetEvent(netPlugin, nwCfg, false)
+				}
+			}
+
+			// the switches replace the spec of a service when it changes,
+			// the providers of a service change without changing its spec
+			if serviceLbCfg, ok := currentState.(*mastercfg.CfgServiceLBState); ok {
+				if serviceSpecChanged(rsp.Prev.(*mastercfg.CfgServiceLBState), serviceLbCfg) {
 					log.Infof("Received %q for Service %s on tenant %s", eventStr,
 						serviceLbCfg.ServiceName, serviceLbCfg.Tenant)
-					processServiceLBEvent(netPlugin, opts, serviceLbCfg, isDelete)
-				}*/
+					processServiceLBEvent(netPlugin, opts, serviceLbCfg, false)
+				} else {
+					log.Infof("Received providers update for Service %s on tenant %s",
+						serviceLbCfg.ServiceName, serviceLbCfg.Tenant)
+					svcHealth.update(serviceLbCfg, opts.hostLabel, false)
+					svcVIPs.update(netPlugin, serviceLbCfg, opts.hostLabel, false)
+				}
+			}
 
 			if svcProvider, ok := currentState.(*mastercfg.SvcProvider); ok {
 				log.Infof("Received %q for Service %s , provider:%#v", eventStr,
@@ -331,7 +402,15 @@ func processStateEvent(netPlugin *plugin.NetPlugin, opts cliOpts, rsps chan core
 				processSvcProviderUpdEvent(netPlugin, opts, svcProvider, isDelete)
 			}
 
-			log.Infof("Received a modify event, ignoring it")
+			// the network policies of kubernetes move endpoints between epgs
+			if epCfg, ok := currentState.(*mastercfg.CfgEndpointState); ok &&
+				epCfg.EndpointGroupID != rsp.Prev.(*mastercfg.CfgEndpointState).EndpointGroupID {
+				log.Infof("Received epg update for endpoint: %q", epCfg.ID)
+				processEpState(netPlugin, opts, epCfg.ID)
+			}
+
+			// other changes of the state need no update of the datapath
+			log.Debugf("Processed %q event", eventStr)
 			continue
 
 		}
@@ -406,6 +485,15 @@ func handleSvcProviderUpdEvents(netPlugin *plugin.NetPlugin, opts cliOpts, recvE
 	return
 }
 
+func handleEndpointEvents(netPlugin *plugin.NetPlugin, opts cliOpts, recvErr chan error) {
+	rsps := make(chan core.WatchState)
+	go processStateEvent(netPlugin, opts, rsps)
+	cfg := mastercfg.CfgEndpointState{}
+	cfg.StateDriver = netPlugin.StateDriver
+	recvErr <- cfg.WatchAll(rsps)
+	return
+}
+
 func handleEvents(netPlugin *plugin.NetPlugin, opts cliOpts) error {
 
 	recvErr := make(chan error, 1)
@@ -418,8 +506,9 @@ func handleEvents(netPlugin *plugin.NetPlugin, opts cliOpts) error {
 
 	go handleSvcProviderUpdEvents(netPlugin, opts, recvErr)
 
-	docker, _ := dockerclient.NewDockerClient("unix:///var/run/docker.sock", nil)
-	go docker.StartMonitorEvents(handleDockerEvents, recvErr, netPlugin, recvErr)
+	go handleEndpointEvents(netPlugin, opts, recvErr)
+
+	go newDockerEventMonitor(netPlugin.StateDriver, opts).run()
 
 	err := <-recvErr
 	if err != nil {
@@ -519,6 +608,14 @@ func main() {
 		"cluster-store",
 		"etcd://127.0.0.1:2379",
 		"state store url")
+	flagSet.StringVar(&opts.svcRegURL,
+		"svc-registry",
+		"",
+		"service registry url, e.g. coredns://127.0.0.1:2379 or webhook://host:port/path, default is the state store url")
+	flagSet.StringVar(&opts.dnsFwders,
+		"dns-forwarders",
+		"",
+		"comma separated upstream name servers of the networks, default is the name servers in /etc/resolv.conf")
 
 	err = flagSet.Parse(os.Args[1:])
 	if err != nil {
@@ -580,6 +677,13 @@ func main() {
 
 	netPlugin := &plugin.NetPlugin{}
 
+	// the name server answering the dns queries of the networks
+	dnsFwders := nameserver.ResolvConfServers(nameserver.ResolvConfPath)
+	if opts.dnsFwders != "" {
+		dnsFwders = strings.Split(opts.dnsFwders, ",")
+	}
+	nameServer = nameserver.NewNameServer(dnsFwders)
+
 	// initialize the config
 	pluginConfig := plugin.Config{
 		Drivers: plugin.Drivers{
@@ -587,17 +691,21 @@ func main() {
 			State:   stateStore,
 		},
 		Instance: core.InstanceInfo{
-			HostLabel: opts.hostLabel,
-			VtepIP:    opts.vtepIP,
-			VlanIntf:  opts.vlanIntf,
-			RouterIP:  opts.routerIP,
-			FwdMode:   opts.fwdMode,
-			DbURL:     opts.dbURL,
+			HostLabel:  opts.hostLabel,
+			VtepIP:     opts.vtepIP,
+			VlanIntf:   opts.vlanIntf,
+			RouterIP:   opts.routerIP,
+			FwdMode:    opts.fwdMode,
+			DbURL:      opts.dbURL,
+			NameServer: nameServer,
 		},
 	}
 
 	// Initialize service registry plugin
-	svcPlugin, quitCh, err := svcplugin.NewSvcregPlugin(opts.dbURL, nil)
+	if opts.svcRegURL == "" {
+		opts.svcRegURL = opts.dbURL
+	}
+	svcPlugin, quitCh, err := svcplugin.NewSvcregPlugin(opts.svcRegURL, nil)
 	if err != nil {
 		log.Fatalf("Error initializing service registry plugin")
 	}
@@ -624,6 +732,11 @@ func main() {
 	// Process all current state
 	processCurrentState(netPlugin, opts)
 
+	// learn the lldp neighbors of the uplink
+	if opts.vlanIntf != "" {
+		startLLDPMonitor(netPlugin.StateDriver, opts.hostLabel, []string{opts.vlanIntf})
+	}
+
 	// Initialize clustering
 	cluster.Init(netPlugin, opts.ctrlIP, opts.vtepIP, opts.dbURL)
 
@@ -675,6 +788,15 @@ func processServiceLBEvent(netPlugin *plugin.NetPlugin, opts cliOpts, svcLBCfg *
 		IPAddress: svcLBCfg.IPAddress,
 		Ports:     portSpecList,
 	}
+	if svcLBCfg.LBPolicy != nil {
+		spec.LBMode = svcLBCfg.LBPolicy.Mode
+		spec.ClientIPAffinity = svcLBCfg.LBPolicy.ClientIPAffinity
+		spec.AffinityTimeout = uint32(svcLBCfg.LBPolicy.AffinityTimeout)
+	}
+
+	// health check the providers of the service hosted here
+	svcHealth.update(svcLBCfg, opts.hostLabel, isDelete)
+
 	operStr := ""
 	if isDelete {
 		err = netPlugin.DeleteServiceLB(serviceID, spec)
@@ -689,6 +811,9 @@ func processServiceLBEvent(netPlugin *plugin.NetPlugin, opts cliOpts, svcLBCfg *
 	}
 	log.Infof("Service Load Balancer %s succeeded", operStr)
 
+	// advertise the VIP of external services while their providers are here
+	svcVIPs.update(netPlugin, svcLBCfg, opts.hostLabel, isDelete)
+
 	return nil
 }
 
@@ -699,74 +824,14 @@ func processSvcProviderUpdEvent(netPlugin *plugin.NetPlugin, opts cliOpts,
 		//ignore delete event since servicelb delete will take care of this.
 		return nil
 	}
-	netPlugin.SvcProviderUpdate(svcProvider.ServiceName, svcProvider.Providers)
-	return nil
-}
-
-/*Handles docker events monitored by dockerclient. Currently we only handle
-  container start and die event*/
-func handleDockerEvents(event *dockerclient.Event, ec chan error, args ...interface{}) {
-
-	log.Printf("Received Docker event: {%#v}\n", *event)
-	providerUpdReq := &master.SvcProvUpdateRequest{}
-	switch event.Status {
-	case "start":
-		defaultHeaders := map[string]string{"User-Agent": "engine-api-cli-1.0"}
-		cli, err := client.NewClient("unix:///var/run/docker.sock", "v1.21", nil, defaultHeaders)
-		if err != nil {
-			panic(err)
-		}
-		containerInfo, err := cli.ContainerInspect(context.Background(), event.ID)
-		if err != nil {
-			log.Errorf("Container Inspect failed :%s", err)
-			return
-		}
-		if event.ID != "" {
-			labelMap := getLabelsFromContainerInspect(&containerInfo)
-
-			containerTenant := getTenantFromContainerInspect(&containerInfo)
-			network, ipAddress := getEpNetworkInfoFromContainerInspect(&containerInfo)
-			container := getContainerFromContainerInspect(&containerInfo)
-			if ipAddress != "" {
-				//Create provider info
-				networkname := strings.Split(network, "/")[0]
-				providerUpdReq.IPAddress = ipAddress
-				providerUpdReq.ContainerID = event.ID
-				providerUpdReq.Tenant = containerTenant
-				providerUpdReq.Network = networkname
-				providerUpdReq.Event = "start"
-				providerUpdReq.Container = container
-				providerUpdReq.Labels = make(map[string]string)
-
-				for k, v := range labelMap {
-					providerUpdReq.Labels[k] = v
-				}
-			}
-			if len(labelMap) == 0 {
-				//Ignore container without labels
-				return
-			}
-			var svcProvResp master.SvcProvUpdateResponse
-
-			log.Infof("Sending Provider create request to master: {%+v}", providerUpdReq)
-
-			err := cluster.MasterPostReq("/plugin/svcProviderUpdate", providerUpdReq, &svcProvResp)
-			if err != nil {
-				log.Errorf("Event: 'start' , Http error posting service provider update, Error:%s", err)
-			}
-		} else {
-			log.Errorf("Unable to fetch container labels for container %s ", event.ID)
-		}
-	case "die":
-		providerUpdReq.ContainerID = event.ID
-		providerUpdReq.Event = "die"
-		var svcProvResp master.SvcProvUpdateResponse
-		log.Infof("Sending Provider delete request to master: {%+v}", providerUpdReq)
-		err := cluster.MasterPostReq("/plugin/svcProviderUpdate", providerUpdReq, &svcProvResp)
-		if err != nil {
-			log.Errorf("Event:'die' Http error posting service provider update, Error:%s", err)
-		}
+	// providers of a service load balancer serve on the ports of the service
+	providers := make([]core.ProviderSpec, len(svcProvider.Providers))
+	for idx, provIP := range svcProvider.Providers {
+		providers[idx].IPAddress = provIP
+		providers[idx].Weight = svcProvider.Weights[provIP]
 	}
+	netPlugin.SvcProviderUpdate(svcProvider.ServiceName, providers)
+	return nil
 }
 
 //getLabelsFromContainerInspect returns the labels associated with the container
@@ -777,46 +842,62 @@ func getLabelsFromContainerInspect(containerInfo *types.ContainerJSON) map[strin
 	return nil
 }
 
-//getTenantFromContainerInspect returns the tenant the container belongs to.
-func getTenantFromContainerInspect(containerInfo *types.ContainerJSON) string {
-	tenant := "default"
-	if containerInfo != nil && containerInfo.NetworkSettings != nil {
-		for network := range containerInfo.NetworkSettings.Networks {
-			if strings.Contains(network, "/") {
-				//network name is of the form networkname/tenantname for non default tenant
-				tenant = strings.Split(network, "/")[1]
-			}
-		}
-	}
-	return tenant
+// epNetworkInfo is a contiv network a container is attached to
+type epNetworkInfo struct {
+	tenant     string
+	network    string
+	ipAddress  string
+	endpointID string
 }
 
-/*getEpNetworkInfoFromContainerInspect inspects the network info from containerinfo returned by dockerclient*/
-func getEpNetworkInfoFromContainerInspect(containerInfo *types.ContainerJSON) (string, string) {
-	var networkName string
-	var IPAddress string
-
-	if containerInfo != nil && containerInfo.NetworkSettings != nil {
-		for network, endpoint := range containerInfo.NetworkSettings.Networks {
-			networkName = network
-			if strings.Contains(network, "/") {
-				//network name is of the form networkname/tenantname for non default tenant
-				networkName = strings.Split(network, "/")[0]
-			}
-			IPAddress = endpoint.IPAddress
+// getEpNetworkInfoFromContainerInspect returns the contiv networks of the container
+// from the containerinfo returned by docker, sorted by tenant and network
+func getEpNetworkInfoFromContainerInspect(containerInfo *types.ContainerJSON) []epNetworkInfo {
+	epNetworks := []epNetworkInfo{}
+	if containerInfo == nil || containerInfo.NetworkSettings == nil {
+		return epNetworks
+	}
+
+	for dnetName, endpoint := range containerInfo.NetworkSettings.Networks {
+		if endpoint == nil || endpoint.IPAddress == "" {
+			continue
 		}
+		tenant, network, _, err := dockplugin.GetDockerNetworkName(endpoint.NetworkID)
+		if err != nil || !isContivNetwork(network+"."+tenant) {
+			log.Debugf("Skipping non contiv network %s of the container", dnetName)
+			continue
+		}
+		epNetworks = append(epNetworks, epNetworkInfo{
+			tenant:     tenant,
+			network:    network,
+			ipAddress:  endpoint.IPAddress,
+			endpointID: endpoint.EndpointID,
+		})
 	}
-	return networkName, IPAddress
+
+	sort.Sort(byTenantNetwork(epNetworks))
+	return epNetworks
 }
 
-func getContainerFromContainerInspect(containerInfo *types.ContainerJSON) string {
+// byTenantNetwork sorts the networks of a container by tenant and network
+type byTenantNetwork []epNetworkInfo
 
-	container := ""
-	if containerInfo != nil && containerInfo.NetworkSettings != nil {
-		for _, endpoint := range containerInfo.NetworkSettings.Networks {
-			container = endpoint.EndpointID
-		}
+func (n byTenantNetwork) Len() int      { return len(n) }
+func (n byTenantNetwork) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n byTenantNetwork) Less(i, j int) bool {
+	if n[i].tenant != n[j].tenant {
+		return n[i].tenant < n[j].tenant
 	}
-	return container
+	return n[i].network < n[j].network
+}
 
+// isContivNetwork checks if the network is configured in contiv
+func isContivNetwork(networkID string) bool {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		return false
+	}
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	return nwCfg.Read(networkID) == nil
 }
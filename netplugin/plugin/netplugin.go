@@ -197,6 +197,16 @@ func (p *NetPlugin) DeleteServiceLB(servicename string, spec *core.ServiceSpec)
 }
 
 //SvcProviderUpdate hhhh
-func (p *NetPlugin) SvcProviderUpdate(servicename string, providers []string) {
+func (p *NetPlugin) SvcProviderUpdate(servicename string, providers []core.ProviderSpec) {
 	p.NetworkDriver.SvcProviderUpdate(servicename, providers)
 }
+
+//AddServiceVIP advertises a service VIP
+func (p *NetPlugin) AddServiceVIP(vip string) error {
+	return p.NetworkDriver.AddServiceVIP(vip)
+}
+
+//DelServiceVIP withdraws a service VIP
+func (p *NetPlugin) DelServiceVIP(vip string) error {
+	return p.NetworkDriver.DelServiceVIP(vip)
+}
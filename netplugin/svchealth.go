@@ -0,0 +1,300 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/netplugin/cluster"
+	"golang.org/x/net/context"
+	"golang.org/x/sys/unix"
+)
+
+// providerCheck health checks a provider of a service
+type providerCheck struct {
+	sync.Mutex
+	serviceID   string
+	providerID  string
+	containerID string
+	addr        string // ip:port of the provider
+	check       mastercfg.HealthCheck
+	health      string // health last known to netmaster
+	pid         int    // pid of the container, looked up on the first probe
+	stopChan    chan struct{}
+}
+
+// svcHealthChecker runs the health checks of the service providers hosted
+// on this host and reports their health to netmaster
+type svcHealthChecker struct {
+	sync.Mutex
+	checks map[string]*providerCheck // keyed by service and provider id
+}
+
+var svcHealth = &svcHealthChecker{checks: make(map[string]*providerCheck)}
+
+// update starts and stops the checks of the local providers of a service
+func (c *svcHealthChecker) update(svcLBCfg *mastercfg.CfgServiceLBState, hostLabel string, isDelete bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	serviceID := svcLBCfg.ID
+	wanted := make(map[string]*providerCheck)
+	if !isDelete && svcLBCfg.HealthCheck != nil {
+		for providerID, provider := range svcLBCfg.Providers {
+			if provider.Host != hostLabel {
+				continue
+			}
+			wanted[serviceID+"/"+providerID] = &providerCheck{
+				serviceID:   serviceID,
+				providerID:  providerID,
+				containerID: provider.ContainerID,
+				addr: net.JoinHostPort(provider.IPAddress,
+					strconv.Itoa(int(svcLBCfg.HealthCheck.Port))),
+				check:  *svcLBCfg.HealthCheck,
+				health: svcLBCfg.ProviderHealth[providerID],
+			}
+		}
+	}
+
+	for key, pc := range c.checks {
+		if pc.serviceID != serviceID {
+			continue
+		}
+		newPc := wanted[key]
+		if newPc != nil && newPc.addr == pc.addr && newPc.containerID == pc.containerID &&
+			newPc.check == pc.check {
+			// netmaster may have lost the health, e.g. on a provider restart
+			pc.Lock()
+			pc.health = newPc.health
+			pc.Unlock()
+			delete(wanted, key)
+			continue
+		}
+		close(pc.stopChan)
+		delete(c.checks, key)
+	}
+
+	for key, pc := range wanted {
+		log.Infof("Starting %s health check of provider %s of service %s",
+			pc.check.Type, pc.addr, serviceID)
+		pc.stopChan = make(chan struct{})
+		c.checks[key] = pc
+		go pc.run()
+	}
+}
+
+// run checks the provider until the check is stopped
+func (pc *providerCheck) run() {
+	ticker := time.NewTicker(time.Duration(pc.check.Interval) * time.Second)
+	defer ticker.Stop()
+
+	passed, failed := 0, 0
+	for {
+		if pc.probe() {
+			passed, failed = passed+1, 0
+		} else {
+			passed, failed = 0, failed+1
+		}
+
+		pc.Lock()
+		health := pc.health
+		pc.Unlock()
+
+		switch {
+		case health != mastercfg.ProviderHealthy && passed >= pc.check.HealthyThreshold:
+			pc.report(true)
+		case health != mastercfg.ProviderUnhealthy && failed >= pc.check.UnhealthyThreshold:
+			pc.report(false)
+		}
+
+		select {
+		case <-pc.stopChan:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe checks if the provider serves. The provider is probed from its own
+// network namespace, the host has no route to the contiv networks.
+func (pc *providerCheck) probe() bool {
+	timeout := time.Duration(pc.check.Timeout) * time.Second
+
+	conn, err := pc.dial(timeout)
+	if err != nil {
+		log.Debugf("Health check of %s failed. Error: %v", pc.addr, err)
+		return false
+	}
+	defer conn.Close()
+
+	if pc.check.Type != "http" {
+		return true
+	}
+
+	req, err := http.NewRequest("GET", "http://"+pc.addr+pc.check.Path, nil)
+	if err != nil {
+		log.Errorf("Invalid health check path %q. Error: %v", pc.check.Path, err)
+		return false
+	}
+	req.Close = true
+	conn.SetDeadline(time.Now().Add(timeout))
+	if err := req.Write(conn); err != nil {
+		log.Debugf("Health check of %s failed. Error: %v", pc.addr, err)
+		return false
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		log.Debugf("Health check of %s failed. Error: %v", pc.addr, err)
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// dial connects to the provider from the network namespace of its container
+func (pc *providerCheck) dial(timeout time.Duration) (net.Conn, error) {
+	if pc.pid == 0 {
+		pid, err := containerPid(pc.containerID)
+		if err != nil {
+			return nil, err
+		}
+		pc.pid = pid
+	}
+
+	conn, err := dialInNetns(pc.pid, pc.addr, timeout)
+	if os.IsNotExist(err) {
+		// the container is gone, it may have been restarted
+		pc.pid = 0
+	}
+	return conn, err
+}
+
+// containerPid returns the pid of a running container
+func containerPid(containerID string) (int, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return 0, err
+	}
+
+	containerInfo, err := cli.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		return 0, err
+	}
+	if containerInfo.State == nil || containerInfo.State.Pid == 0 {
+		return 0, core.Errorf("container %s is not running", containerID)
+	}
+
+	return containerInfo.State.Pid, nil
+}
+
+// dialInNetns connects to addr from the network namespace of a process.
+// The socket is created on a thread moved to the namespace. A thread that
+// can not be moved back stays locked to its goroutine, which never returns,
+// so that no other goroutine runs in the namespace.
+func dialInNetns(pid int, addr string, timeout time.Duration) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	result := make(chan dialResult, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		hostNs, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- dialResult{err: err}
+			return
+		}
+		defer hostNs.Close()
+
+		ns, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+		if err != nil {
+			runtime.UnlockOSThread()
+			result <- dialResult{err: err}
+			return
+		}
+		defer ns.Close()
+
+		if err := setns(ns); err != nil {
+			runtime.UnlockOSThread()
+			result <- dialResult{err: err}
+			return
+		}
+
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if nsErr := setns(hostNs); nsErr != nil {
+			// the go runtime reuses the thread of a goroutine that exits,
+			// even locked, so the goroutine parks the thread for good
+			log.Errorf("Error restoring the network namespace, parking the thread. Error: %v", nsErr)
+			result <- dialResult{conn: conn, err: err}
+			select {}
+		}
+		runtime.UnlockOSThread()
+		result <- dialResult{conn: conn, err: err}
+	}()
+
+	res := <-result
+	return res.conn, res.err
+}
+
+// setns moves the calling thread to a network namespace
+func setns(ns *os.File) error {
+	_, _, errno := unix.Syscall(unix.SYS_SETNS, ns.Fd(), unix.CLONE_NEWNET, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// report sends the health of the provider to netmaster
+func (pc *providerCheck) report(healthy bool) {
+	healthReq := &master.SvcProviderHealthRequest{
+		ServiceID:  pc.serviceID,
+		ProviderID: pc.providerID,
+		Healthy:    healthy,
+	}
+	var healthResp master.SvcProviderHealthResponse
+
+	log.Infof("Sending Provider health to master: {%+v}", healthReq)
+
+	err := cluster.MasterPostReq("/plugin/svcProviderHealth", healthReq, &healthResp)
+	if err != nil {
+		// retried on the next check
+		log.Errorf("Http error posting service provider health, Error:%s", err)
+		return
+	}
+
+	pc.Lock()
+	pc.health = mastercfg.ProviderUnhealthy
+	if healthy {
+		pc.health = mastercfg.ProviderHealthy
+	}
+	pc.Unlock()
+}
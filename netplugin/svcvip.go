@@ -0,0 +1,88 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/netplugin/plugin"
+)
+
+// svcVIPAdvertiser advertises the VIPs of external services over bgp while
+// this host has healthy providers of the service
+type svcVIPAdvertiser struct {
+	sync.Mutex
+	vips map[string]string // advertised VIPs keyed by service id
+}
+
+var svcVIPs = &svcVIPAdvertiser{vips: make(map[string]string)}
+
+// update advertises or withdraws the VIP of a service. The clients on the
+// uplink are only balanced over the providers on this host.
+func (a *svcVIPAdvertiser) update(netPlugin *plugin.NetPlugin, svcLBCfg *mastercfg.CfgServiceLBState,
+	hostLabel string, isDelete bool) {
+	a.Lock()
+	defer a.Unlock()
+
+	serviceID := svcLBCfg.ID
+	vip := ""
+	if !isDelete && svcLBCfg.External && hasLocalBackends(svcLBCfg, hostLabel) {
+		vip = svcLBCfg.IPAddress
+	}
+
+	oldVIP := a.vips[serviceID]
+	if vip == oldVIP {
+		return
+	}
+
+	if oldVIP != "" {
+		log.Infof("Withdrawing VIP %s of service %s", oldVIP, serviceID)
+		err := netPlugin.DelServiceVIP(oldVIP)
+		if err != nil {
+			log.Errorf("Error withdrawing VIP %s of service %s. Err: %v", oldVIP, serviceID, err)
+		}
+		delete(a.vips, serviceID)
+	}
+
+	if vip != "" {
+		log.Infof("Advertising VIP %s of service %s", vip, serviceID)
+		err := netPlugin.AddServiceVIP(vip)
+		if err != nil {
+			// retried on the next update of the service
+			log.Errorf("Error advertising VIP %s of service %s. Err: %v", vip, serviceID, err)
+			return
+		}
+		a.vips[serviceID] = vip
+	}
+}
+
+// hasLocalBackends checks if a provider of a service on this host can
+// receive traffic
+func hasLocalBackends(svcLBCfg *mastercfg.CfgServiceLBState, hostLabel string) bool {
+	for providerID, provider := range svcLBCfg.Providers {
+		if provider.Host != hostLabel {
+			continue
+		}
+		if svcLBCfg.HealthCheck == nil ||
+			svcLBCfg.ProviderHealth[providerID] == mastercfg.ProviderHealthy {
+			return true
+		}
+	}
+
+	return false
+}
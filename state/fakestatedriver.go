@@ -2,6 +2,7 @@ package state
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/contiv/netplugin/core"
 
@@ -17,8 +18,9 @@ type valueData struct {
 type FakeStateDriverConfig struct{}
 
 // FakeStateDriver implements core.StateDriver interface for use with
-// unit-tests
+// unit-tests. It may be used from concurrent goroutines.
 type FakeStateDriver struct {
+	sync.Mutex
 	TestState map[string]valueData
 }
 
@@ -36,6 +38,9 @@ func (d *FakeStateDriver) Deinit() {
 
 // Write value to key
 func (d *FakeStateDriver) Write(key string, value []byte) error {
+	d.Lock()
+	defer d.Unlock()
+
 	val := valueData{value: value}
 	d.TestState[key] = val
 
@@ -44,6 +49,9 @@ func (d *FakeStateDriver) Write(key string, value []byte) error {
 
 // Read value from key
 func (d *FakeStateDriver) Read(key string) ([]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
 	if val, ok := d.TestState[key]; ok {
 		return val.value, nil
 	}
@@ -53,6 +61,9 @@ func (d *FakeStateDriver) Read(key string) ([]byte, error) {
 
 // ReadAll values from baseKey
 func (d *FakeStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	d.Lock()
+	defer d.Unlock()
+
 	values := [][]byte{}
 
 	for key, val := range d.TestState {
@@ -70,6 +81,9 @@ func (d *FakeStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
 
 // ClearState clears key
 func (d *FakeStateDriver) ClearState(key string) error {
+	d.Lock()
+	defer d.Unlock()
+
 	if _, ok := d.TestState[key]; ok {
 		delete(d.TestState, key)
 	}
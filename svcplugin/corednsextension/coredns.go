@@ -0,0 +1,184 @@
+package corednsextension
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/svcplugin/bridge"
+)
+
+// DefaultPrefix is the etcd prefix CoreDNS reads records from by default
+const DefaultPrefix = "/skydns"
+
+// DefaultZone is the dns zone of the services
+const DefaultZone = "contiv.local"
+
+// gatewayPath is the path of the etcd v3 json gateway (etcd 3.4 and later)
+const gatewayPath = "/v3"
+
+const requestTimeout = 5 * time.Second
+
+func init() {
+	log.Debugf("Calling coredns init")
+	bridge.Register(new(Factory), "coredns")
+}
+
+// Factory implementation to implement RegistryAdapter interface functions
+type Factory struct{}
+
+// New function to register CorednsAdapter. The uri is
+// coredns://<etcd host:port>[/<prefix>][?zone=<zone>]
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	if uri.Host == "" {
+		uri.Host = "localhost:2379"
+	}
+
+	prefix := strings.TrimRight(uri.Path, "/")
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	zone := strings.Trim(uri.Query().Get("zone"), ".")
+	if zone == "" {
+		zone = DefaultZone
+	}
+
+	return &CorednsAdapter{
+		endpoint: "http://" + uri.Host + gatewayPath,
+		prefix:   prefix,
+		zone:     zone,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// CorednsAdapter writes the services as CoreDNS records into etcd. A
+// service is served as A and SRV records of
+// <service>.<network>.<tenant>.<zone>, with one record per service
+// instance.
+type CorednsAdapter struct {
+	endpoint string // url of the etcd v3 gateway
+	prefix   string
+	zone     string
+	client   *http.Client
+}
+
+// corednsRecord is the record CoreDNS reads from etcd
+type corednsRecord struct {
+	Host string `json:"host"`
+	Port int    `json:"port,omitempty"`
+	TTL  int    `json:"ttl,omitempty"`
+}
+
+// Ping will try to connect to etcd by retrieving its status
+func (r *CorednsAdapter) Ping() error {
+	return r.post("/maintenance/status", struct{}{}, nil)
+}
+
+// Register will register CorednsAdapter's interface with RegistryAdapter
+func (r *CorednsAdapter) Register(service *bridge.Service) error {
+	record, err := json.Marshal(&corednsRecord{
+		Host: service.IP,
+		Port: service.Port,
+		TTL:  service.TTL,
+	})
+	if err != nil {
+		return err
+	}
+
+	putReq := map[string]string{
+		"key":   encode(r.servicePath(service)),
+		"value": base64.StdEncoding.EncodeToString(record),
+	}
+
+	// the record expires with a lease unless it is refreshed
+	if service.TTL > 0 {
+		leaseResp := struct {
+			ID string `json:"ID"`
+		}{}
+		err = r.post("/lease/grant", map[string]int{"TTL": service.TTL}, &leaseResp)
+		if err != nil {
+			log.Errorf("coredns: failed to grant lease: %s", err)
+			return err
+		}
+		putReq["lease"] = leaseResp.ID
+	}
+
+	err = r.post("/kv/put", putReq, nil)
+	if err != nil {
+		log.Errorf("coredns: failed to register service: %s", err)
+	}
+	return err
+}
+
+// Deregister will deregister CorednsAdapter's interface from RegistryAdapter
+func (r *CorednsAdapter) Deregister(service *bridge.Service) error {
+	err := r.post("/kv/deleterange", map[string]string{
+		"key": encode(r.servicePath(service)),
+	}, nil)
+	if err != nil {
+		log.Warningf("coredns: failed to deregister service: %s", err)
+	}
+	return err
+}
+
+// Refresh writes the record again with a new lease
+func (r *CorednsAdapter) Refresh(service *bridge.Service) error {
+	return r.Register(service)
+}
+
+// servicePath returns the key of a service record, the labels of the
+// service name in reverse order under the prefix
+func (r *CorednsAdapter) servicePath(service *bridge.Service) string {
+	labels := []string{service.Name, service.Network, service.Tenant}
+	labels = append(labels, strings.Split(r.zone, ".")...)
+
+	path := r.prefix
+	for idx := len(labels) - 1; idx >= 0; idx-- {
+		path += "/" + strings.ToLower(labels[idx])
+	}
+
+	return path + "/" + service.ID
+}
+
+// post sends a request to the etcd v3 gateway and decodes its response
+func (r *CorednsAdapter) post(path string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpResp, err := r.client.Post(r.endpoint+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		errResp := struct {
+			Error string `json:"error"`
+		}{}
+		json.NewDecoder(httpResp.Body).Decode(&errResp)
+		if errResp.Error != "" {
+			return errors.New(errResp.Error)
+		}
+		return fmt.Errorf("etcd returned %s for %s", httpResp.Status, path)
+	}
+
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+// encode encodes a key as the gateway expects it
+func encode(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
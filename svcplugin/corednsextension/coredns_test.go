@@ -0,0 +1,146 @@
+package corednsextension
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/contiv/netplugin/svcplugin/bridge"
+)
+
+// fakeEtcd is a stand-in of the etcd v3 json gateway
+type fakeEtcd struct {
+	sync.Mutex
+	keys   map[string]string // key to value
+	leases map[string]string // key to lease
+	ttls   []int
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{keys: make(map[string]string), leases: make(map[string]string)}
+}
+
+func (e *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.Lock()
+	defer e.Unlock()
+
+	req := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"bad request"}`, http.StatusBadRequest)
+		return
+	}
+	decode := func(field string) string {
+		value, _ := base64.StdEncoding.DecodeString(req[field].(string))
+		return string(value)
+	}
+
+	switch r.URL.Path {
+	case "/v3/maintenance/status":
+		w.Write([]byte(`{"version":"3.4.0"}`))
+	case "/v3/lease/grant":
+		e.ttls = append(e.ttls, int(req["TTL"].(float64)))
+		w.Write([]byte(`{"ID":"7587822","TTL":"30"}`))
+	case "/v3/kv/put":
+		key := decode("key")
+		e.keys[key] = decode("value")
+		if lease, ok := req["lease"]; ok {
+			e.leases[key] = lease.(string)
+		}
+		w.Write([]byte(`{}`))
+	case "/v3/kv/deleterange":
+		delete(e.keys, decode("key"))
+		w.Write([]byte(`{}`))
+	default:
+		http.Error(w, `{"error":"Not Found"}`, http.StatusNotFound)
+	}
+}
+
+func newTestAdapter(t *testing.T, etcd *fakeEtcd, adapterURI string) (*bridge.Bridge, func()) {
+	server := httptest.NewServer(etcd)
+	serverURL, _ := url.Parse(server.URL)
+
+	config := bridge.DefaultBridgeConfig()
+	config.RefreshTTL = 30
+	b, err := bridge.New(strings.Replace(adapterURI, "HOST", serverURL.Host, 1), config)
+	if err != nil {
+		t.Fatalf("Error creating bridge. Err: %v", err)
+	}
+
+	return b, server.Close
+}
+
+func TestCorednsRegister(t *testing.T) {
+	etcd := newFakeEtcd()
+	b, stop := newTestAdapter(t, etcd, "coredns://HOST")
+	defer stop()
+
+	if err := b.Ping(); err != nil {
+		t.Fatalf("Error pinging etcd. Err: %v", err)
+	}
+
+	b.AddService("c1", "web", "net1", "Tenant1", "10.1.1.2")
+	b.AddService("c2", "web", "net1", "Tenant1", "10.1.1.3")
+
+	key := "/skydns/local/contiv/tenant1/net1/web/c1"
+	record := corednsRecord{}
+	if err := json.Unmarshal([]byte(etcd.keys[key]), &record); err != nil {
+		t.Fatalf("Error decoding record %q of %s. Err: %v", etcd.keys[key], key, err)
+	}
+	if record.Host != "10.1.1.2" || record.TTL != 30 {
+		t.Fatalf("Unexpected record %+v of %s", record, key)
+	}
+	if etcd.leases[key] != "7587822" || len(etcd.ttls) != 2 || etcd.ttls[0] != 30 {
+		t.Fatalf("Record %s not leased. leases: %v, ttls: %v", key, etcd.leases, etcd.ttls)
+	}
+	if len(etcd.keys) != 2 {
+		t.Fatalf("Expecting 2 records, found %v", etcd.keys)
+	}
+
+	b.Refresh()
+	if len(etcd.ttls) != 4 {
+		t.Fatalf("Records not refreshed. ttls: %v", etcd.ttls)
+	}
+
+	b.RemoveService("c1", "web", "net1", "Tenant1", "10.1.1.2")
+	if _, ok := etcd.keys[key]; ok || len(etcd.keys) != 1 {
+		t.Fatalf("Record %s not removed: %v", key, etcd.keys)
+	}
+}
+
+func TestCorednsPrefixAndZone(t *testing.T) {
+	etcd := newFakeEtcd()
+	b, stop := newTestAdapter(t, etcd, "coredns://HOST/dns/?zone=svc.example.com.")
+	defer stop()
+
+	b.AddService("c1", "db", "net2", "default", "10.1.2.2")
+
+	key := "/dns/com/example/svc/default/net2/db/c1"
+	if _, ok := etcd.keys[key]; !ok {
+		t.Fatalf("Record %s not found in %v", key, etcd.keys)
+	}
+}
+
+func TestCorednsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"etcdserver: no leader","code":14}`))
+	}))
+	serverURL, _ := url.Parse(server.URL)
+
+	adapter := new(Factory).New(&url.URL{Scheme: "coredns", Host: serverURL.Host})
+	err := adapter.Ping()
+	if err == nil || err.Error() != "etcdserver: no leader" {
+		t.Fatalf("Expecting etcd error, got %v", err)
+	}
+
+	server.Close()
+	service := &bridge.Service{ID: "c1", Name: "web", Network: "net1", Tenant: "default", IP: "10.1.1.2"}
+	if err := adapter.Register(service); err == nil {
+		t.Fatalf("Registered service with etcd down")
+	}
+}
@@ -0,0 +1,205 @@
+package webhookextension
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/svcplugin/bridge"
+)
+
+// DefaultRetries is the number of times a failed event is resent
+const DefaultRetries = 3
+
+// DefaultRetryInterval is the time between resending a failed event
+const DefaultRetryInterval = time.Second
+
+const requestTimeout = 5 * time.Second
+
+// events sent to the webhook
+const (
+	EventPing       = "ping"
+	EventRegister   = "register"
+	EventDeregister = "deregister"
+	EventRefresh    = "refresh"
+)
+
+func init() {
+	log.Debugf("Calling webhook init")
+	bridge.Register(&Factory{scheme: "http"}, "webhook")
+	bridge.Register(&Factory{scheme: "https"}, "webhooks")
+}
+
+// Factory implementation to implement RegistryAdapter interface functions
+type Factory struct {
+	scheme string
+}
+
+// New function to register WebhookAdapter. The uri is
+// webhook[s]://<host:port>/<path>[?retries=<n>&interval=<duration>],
+// the events are posted to http[s]://<host:port>/<path>
+func (f *Factory) New(uri *url.URL) bridge.RegistryAdapter {
+	query := uri.Query()
+
+	retries := DefaultRetries
+	if value := query.Get("retries"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			log.Fatalf("webhook: invalid retries %s", value)
+		}
+		retries = count
+	}
+
+	interval := DefaultRetryInterval
+	if value := query.Get("interval"); value != "" {
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			log.Fatalf("webhook: invalid retry interval %s", value)
+		}
+		interval = duration
+	}
+
+	query.Del("retries")
+	query.Del("interval")
+	hookURL := url.URL{
+		Scheme:   f.scheme,
+		Host:     uri.Host,
+		Path:     uri.Path,
+		RawQuery: query.Encode(),
+	}
+
+	return &WebhookAdapter{
+		url:      hookURL.String(),
+		retries:  retries,
+		interval: interval,
+		client:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// WebhookAdapter posts the service registry events to a webhook
+type WebhookAdapter struct {
+	url      string
+	retries  int
+	interval time.Duration
+	client   *http.Client
+}
+
+// Event is the body of the requests posted to the webhook
+type Event struct {
+	Event   string         `json:"event"`
+	Service *ServiceRecord `json:"service,omitempty"`
+}
+
+// ServiceRecord is the service of an event
+type ServiceRecord struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Network string            `json:"network"`
+	Tenant  string            `json:"tenant"`
+	IP      string            `json:"ip"`
+	Port    int               `json:"port,omitempty"`
+	TTL     int               `json:"ttl,omitempty"`
+	Tags    []string          `json:"tags,omitempty"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+// Ping will try to connect to the webhook by posting a ping event
+func (r *WebhookAdapter) Ping() error {
+	return r.post(&Event{Event: EventPing})
+}
+
+// Register will register WebhookAdapter's interface with RegistryAdapter
+func (r *WebhookAdapter) Register(service *bridge.Service) error {
+	err := r.send(EventRegister, service)
+	if err != nil {
+		log.Errorf("webhook: failed to register service: %s", err)
+	}
+	return err
+}
+
+// Deregister will deregister WebhookAdapter's interface from RegistryAdapter
+func (r *WebhookAdapter) Deregister(service *bridge.Service) error {
+	err := r.send(EventDeregister, service)
+	if err != nil {
+		log.Warningf("webhook: failed to deregister service: %s", err)
+	}
+	return err
+}
+
+// Refresh posts a refresh event of a registered service
+func (r *WebhookAdapter) Refresh(service *bridge.Service) error {
+	return r.send(EventRefresh, service)
+}
+
+// send posts an event, resending it while the webhook fails
+func (r *WebhookAdapter) send(event string, service *bridge.Service) error {
+	req := &Event{
+		Event: event,
+		Service: &ServiceRecord{
+			ID:      service.ID,
+			Name:    service.Name,
+			Network: service.Network,
+			Tenant:  service.Tenant,
+			IP:      service.IP,
+			Port:    service.Port,
+			TTL:     service.TTL,
+			Tags:    service.Tags,
+			Attrs:   service.Attrs,
+		},
+	}
+
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		if attempt > 0 {
+			log.Debugf("webhook: resending %s event of %s (%d/%d)",
+				event, service.ID, attempt, r.retries)
+			time.Sleep(r.interval)
+		}
+
+		err = r.post(req)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(permanentError); ok {
+			return err
+		}
+	}
+
+	return err
+}
+
+// permanentError is a rejection of an event by the webhook, which is not
+// resent
+type permanentError string
+
+func (e permanentError) Error() string {
+	return string(e)
+}
+
+// post posts an event once
+func (r *WebhookAdapter) post(req *Event) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Post(r.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return permanentError(fmt.Sprintf("webhook rejected %s event: %s", req.Event, resp.Status))
+	default:
+		return fmt.Errorf("webhook returned %s for %s event", resp.Status, req.Event)
+	}
+}
@@ -0,0 +1,116 @@
+package webhookextension
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/contiv/netplugin/svcplugin/bridge"
+)
+
+// fakeWebhook is a stand-in of a webhook, answering the given number of
+// failures with status before accepting events
+type fakeWebhook struct {
+	sync.Mutex
+	failures int
+	status   int
+	requests int
+	events   []Event
+}
+
+func (h *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.requests++
+	if h.failures > 0 {
+		h.failures--
+		w.WriteHeader(h.status)
+		return
+	}
+
+	event := Event{}
+	if r.URL.Path != "/hooks/svc" || r.URL.Query().Get("token") != "abc" ||
+		json.NewDecoder(r.Body).Decode(&event) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.events = append(h.events, event)
+}
+
+func newTestBridge(t *testing.T, hook *fakeWebhook) (*bridge.Bridge, func()) {
+	server := httptest.NewServer(hook)
+	serverURL, _ := url.Parse(server.URL)
+
+	b, err := bridge.New("webhook://"+serverURL.Host+
+		"/hooks/svc?token=abc&retries=2&interval=1ms", bridge.DefaultBridgeConfig())
+	if err != nil {
+		t.Fatalf("Error creating bridge. Err: %v", err)
+	}
+
+	return b, server.Close
+}
+
+func TestWebhookEvents(t *testing.T) {
+	hook := &fakeWebhook{}
+	b, stop := newTestBridge(t, hook)
+	defer stop()
+
+	if err := b.Ping(); err != nil {
+		t.Fatalf("Error pinging webhook. Err: %v", err)
+	}
+
+	b.AddService("c1", "web", "net1", "default", "10.1.1.2")
+	b.Refresh()
+	b.RemoveService("c1", "web", "net1", "default", "10.1.1.2")
+
+	expEvents := []string{EventPing, EventRegister, EventRefresh, EventDeregister}
+	if len(hook.events) != len(expEvents) {
+		t.Fatalf("Expecting events %v, got %+v", expEvents, hook.events)
+	}
+	for idx, event := range hook.events {
+		if event.Event != expEvents[idx] {
+			t.Fatalf("Expecting event %s, got %+v", expEvents[idx], event)
+		}
+		if idx == 0 {
+			continue
+		}
+		if event.Service == nil || event.Service.ID != "c1" || event.Service.Name != "web" ||
+			event.Service.Network != "net1" || event.Service.Tenant != "default" ||
+			event.Service.IP != "10.1.1.2" {
+			t.Fatalf("Unexpected service in event %+v", event)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	hook := &fakeWebhook{failures: 2, status: http.StatusServiceUnavailable}
+	b, stop := newTestBridge(t, hook)
+	defer stop()
+
+	// succeeds on the last retry
+	b.AddService("c1", "web", "net1", "default", "10.1.1.2")
+	if hook.requests != 3 || len(hook.events) != 1 {
+		t.Fatalf("Expecting 3 requests and 1 event, got %d and %+v", hook.requests, hook.events)
+	}
+
+	// fails all retries
+	hook.failures = 5
+	hook.requests = 0
+	b.RemoveService("c1", "web", "net1", "default", "10.1.1.2")
+	if hook.requests != 3 || len(hook.events) != 1 {
+		t.Fatalf("Expecting 3 requests and no event, got %d and %+v", hook.requests, hook.events)
+	}
+
+	// rejected events are not resent
+	hook.failures = 1
+	hook.status = http.StatusForbidden
+	hook.requests = 0
+	b.AddService("c2", "web", "net1", "default", "10.1.1.3")
+	if hook.requests != 1 {
+		t.Fatalf("Rejected event resent, got %d requests", hook.requests)
+	}
+}
@@ -16,6 +16,8 @@ limitations under the License.
 package utils
 
 import (
+	"encoding/binary"
+	"net"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
@@ -33,12 +35,14 @@ const (
 
 // LLDPNeighbor lists the neighbor attributes as learned by the host
 type LLDPNeighbor struct {
+	ChassisID         string
 	SystemName        string
 	SystemDescription string
 	PortID            string
 	PortDesc          string
 	CiscoACIPodID     string
 	CiscoACINodeID    string
+	TTL               uint16 // seconds the neighbor information is valid
 }
 
 /* FIXME: Migrate this to samalba/dockerclient
@@ -168,8 +172,8 @@ func lldpReadACIPodID(systemDesc string) (string, error) {
 	}
 	words := strings.Split(systemDesc, "/")
 	podID := ""
-	if len(words) >= 2 {
-		podID = strings.Split(words[1], "-")[1]
+	if len(words) >= 2 && strings.HasPrefix(words[1], "pod-") {
+		podID = strings.TrimPrefix(words[1], "pod-")
 	}
 	return podID, nil
 }
@@ -180,8 +184,123 @@ func lldpReadACINodeID(systemDesc string) (string, error) {
 	}
 	words := strings.Split(systemDesc, "/")
 	nodeID := ""
-	if len(words) >= 2 {
-		nodeID = strings.Split(words[2], "-")[1]
+	if len(words) >= 3 && strings.HasPrefix(words[2], "node-") {
+		nodeID = strings.TrimPrefix(words[2], "node-")
 	}
 	return nodeID, nil
 }
+
+// lldp frame constants
+const (
+	lldpEtherType = 0x88cc
+	vlanEtherType = 0x8100
+	ethHeaderLen  = 14
+	tlvTypeEnd    = 0
+	tlvChassisID  = 1
+	tlvPortID     = 2
+	tlvTTL        = 3
+	tlvPortDesc   = 4
+	tlvSystemName = 5
+	tlvSystemDesc = 6
+
+	chassisSubtypeMAC     = 4
+	chassisSubtypeNetAddr = 5
+	portSubtypeMAC        = 3
+	portSubtypeNetAddr    = 4
+)
+
+// ParseLLDPFrame parses the neighbor attributes of an ethernet frame
+// carrying an lldp data unit
+func ParseLLDPFrame(frame []byte) (*LLDPNeighbor, error) {
+	if len(frame) < ethHeaderLen {
+		return nil, core.Errorf("short frame of %d bytes", len(frame))
+	}
+
+	offset := 12
+	etherType := binary.BigEndian.Uint16(frame[offset:])
+	if etherType == vlanEtherType && len(frame) >= ethHeaderLen+4 {
+		offset += 4
+		etherType = binary.BigEndian.Uint16(frame[offset:])
+	}
+	if etherType != lldpEtherType {
+		return nil, core.Errorf("not an lldp frame, ethertype 0x%x", etherType)
+	}
+	offset += 2
+
+	lldp := &LLDPNeighbor{}
+	// the chassis id, port id and ttl are mandatory and come first
+	tlvCount := 0
+	for {
+		if offset+2 > len(frame) {
+			return nil, core.Errorf("lldp data unit has no end")
+		}
+		tlvType := frame[offset] >> 1
+		tlvLen := int(binary.BigEndian.Uint16(frame[offset:]) & 0x1ff)
+		offset += 2
+		if offset+tlvLen > len(frame) {
+			return nil, core.Errorf("tlv %d of %d bytes exceeds the frame", tlvType, tlvLen)
+		}
+		value := frame[offset : offset+tlvLen]
+		offset += tlvLen
+
+		if tlvType == tlvTypeEnd {
+			break
+		}
+		if tlvCount < 3 && int(tlvType) != tlvCount+1 {
+			return nil, core.Errorf("missing mandatory tlv %d", tlvCount+1)
+		}
+		tlvCount++
+
+		switch tlvType {
+		case tlvChassisID:
+			if len(value) < 2 {
+				return nil, core.Errorf("invalid chassis id tlv")
+			}
+			lldp.ChassisID = lldpIDString(value[0], value[1:], chassisSubtypeMAC, chassisSubtypeNetAddr)
+		case tlvPortID:
+			if len(value) < 2 {
+				return nil, core.Errorf("invalid port id tlv")
+			}
+			lldp.PortID = lldpIDString(value[0], value[1:], portSubtypeMAC, portSubtypeNetAddr)
+		case tlvTTL:
+			if len(value) < 2 {
+				return nil, core.Errorf("invalid ttl tlv")
+			}
+			lldp.TTL = binary.BigEndian.Uint16(value)
+		case tlvPortDesc:
+			lldp.PortDesc = lldpString(value)
+		case tlvSystemName:
+			lldp.SystemName = lldpString(value)
+		case tlvSystemDesc:
+			lldp.SystemDescription = lldpString(value)
+		}
+	}
+	if tlvCount < 3 {
+		return nil, core.Errorf("missing mandatory tlv %d", tlvCount+1)
+	}
+
+	// only the Cisco ACI leafs describe their pod and node
+	if strings.Contains(lldp.SystemDescription, "pod-") && strings.Contains(lldp.SystemDescription, "node-") {
+		deriveLLDPArgs(lldp)
+	}
+
+	return lldp, nil
+}
+
+// lldpIDString formats a chassis or port id by its subtype
+func lldpIDString(subtype byte, id []byte, macSubtype, netAddrSubtype byte) string {
+	switch {
+	case subtype == macSubtype && len(id) == 6:
+		return net.HardwareAddr(id).String()
+	case subtype == netAddrSubtype && len(id) == net.IPv4len+1 && id[0] == 1:
+		return net.IP(id[1:]).String()
+	case subtype == netAddrSubtype && len(id) == net.IPv6len+1 && id[0] == 2:
+		return net.IP(id[1:]).String()
+	}
+
+	return lldpString(id)
+}
+
+func lldpString(value []byte) string {
+	return strings.Trim(string(value), " \x00")
+}
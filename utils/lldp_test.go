@@ -16,6 +16,7 @@ limitations under the License.
 package utils
 
 import (
+	"encoding/hex"
 	"testing"
 )
 
@@ -122,3 +123,104 @@ End of LLDPDU TLV`
 		t.Fatalf("parsed params invalid %v \n", parsedInfo)
 	}
 }
+
+// lldp frames of the wireshark sample captures lldp.detailed.pcap, sent by
+// an Extreme Summit300-48, and lldpmed_civicloc.pcap, sent by a ProCurve
+// Switch 2600-8-PWR
+const (
+	summitFrame = "0180c200000e000130f9ada088cc020704000130f9ada0040405312f31060200" +
+		"78081753756d6d69743330302d34382d506f72742031303031000a0d53756d6d" +
+		"69743330302d3438000c4c53756d6d69743330302d3438202d2056657273696f" +
+		"6e20372e34652e3120284275696c642035292062792052656c656173655f4d61" +
+		"737465722030352f32372f30352030343a35333a3131000e0400140014100e07" +
+		"06000130f9ada002000003e900fe0700120f02070100fe0900120f01036c0000" +
+		"10fe0900120f030100000000fe0600120f0405f2fe060080c20101e8fe070080" +
+		"c202010000fe170080c20301e81076322d303438382d30332d3035303500fe05" +
+		"0080c204000000"
+	procurveFrame = "0180c200000e00132157ca7f88cc02070400132157ca40040207310602007808" +
+		"01310a1a50726f43757276652053776974636820323630302d382d5057520c5f" +
+		"50726f4375727665204a38373632412053776974636820323630302d382d5057" +
+		"522c207265766973696f6e20482e30382e38392c20524f4d20482e30382e3558" +
+		"20282f73772f636f64652f6275696c642f666973682874735f30385f3529290e" +
+		"0400140004100c05010fff7a94020000000000fe0900120f01036c000010fe07" +
+		"0012bb01000f04fe080012bb02014065aefe2e0012bb03022802555301024341" +
+		"0309526f736576696c6c650609466f6f7468696c6c731304383030301a035233" +
+		"4cfe070012bb040300410000"
+)
+
+func decodeFrame(t *testing.T, frameHex string) []byte {
+	frame, err := hex.DecodeString(frameHex)
+	if err != nil {
+		t.Fatalf("failed to decode frame %s. Error: %s", frameHex, err)
+	}
+	return frame
+}
+
+func TestParseLLDPFrame(t *testing.T) {
+	summitLLDP := LLDPNeighbor{
+		ChassisID:         "00:01:30:f9:ad:a0",
+		SystemName:        "Summit300-48",
+		SystemDescription: "Summit300-48 - Version 7.4e.1 (Build 5) by Release_Master 05/27/05 04:53:11",
+		PortID:            "1/1",
+		PortDesc:          "Summit300-48-Port 1001",
+		TTL:               120,
+	}
+	lldp, err := ParseLLDPFrame(decodeFrame(t, summitFrame))
+	if err != nil {
+		t.Fatalf("failed to parse lldp frame. Error: %s", err)
+	}
+	if *lldp != summitLLDP {
+		t.Fatalf("parsed params invalid %+v", lldp)
+	}
+
+	// the same frame on a vlan
+	frame := decodeFrame(t, summitFrame)
+	taggedFrame := append(append(append([]byte{}, frame[:12]...), 0x81, 0x00, 0x00, 0x64), frame[12:]...)
+	lldp, err = ParseLLDPFrame(taggedFrame)
+	if err != nil {
+		t.Fatalf("failed to parse vlan tagged lldp frame. Error: %s", err)
+	}
+	if *lldp != summitLLDP {
+		t.Fatalf("parsed params invalid %+v", lldp)
+	}
+
+	lldp, err = ParseLLDPFrame(decodeFrame(t, procurveFrame))
+	if err != nil {
+		t.Fatalf("failed to parse lldp frame. Error: %s", err)
+	}
+	expLLDP := LLDPNeighbor{
+		ChassisID:         "00:13:21:57:ca:40",
+		SystemName:        "ProCurve Switch 2600-8-PWR",
+		SystemDescription: "ProCurve J8762A Switch 2600-8-PWR, revision H.08.89, ROM H.08.5X (/sw/code/build/fish(ts_08_5))",
+		PortID:            "1",
+		PortDesc:          "1",
+		TTL:               120,
+	}
+	if *lldp != expLLDP {
+		t.Fatalf("parsed params invalid %+v", lldp)
+	}
+}
+
+func TestParseLLDPFrameErrors(t *testing.T) {
+	frame := decodeFrame(t, summitFrame)
+
+	// truncated frames
+	for _, frameLen := range []int{10, 16, 40, len(frame) - 2} {
+		if _, err := ParseLLDPFrame(frame[:frameLen]); err == nil {
+			t.Fatalf("truncated frame of %d bytes parsed", frameLen)
+		}
+	}
+
+	// not lldp
+	ipFrame := append([]byte{}, frame...)
+	ipFrame[12], ipFrame[13] = 0x08, 0x00
+	if _, err := ParseLLDPFrame(ipFrame); err == nil {
+		t.Fatalf("ip frame parsed")
+	}
+
+	// the ttl is missing, the port description follows the port id
+	noTTLFrame := append(append([]byte{}, frame[:29]...), frame[33:]...)
+	if _, err := ParseLLDPFrame(noTTLFrame); err == nil {
+		t.Fatalf("frame without ttl parsed")
+	}
+}
@@ -0,0 +1,238 @@
+/***
+Copyright 2016 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutils
+
+import (
+	"math/big"
+	"net"
+	"sort"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// IPv6Range is an inclusive range of IPv6 host ids, e.g. ::1 - ::10
+type IPv6Range struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// IPv6RangeSet keeps allocated IPv6 host ids as a sorted list of
+// disjoint ranges, so its size depends on fragmentation and not
+// on the number of allocated addresses
+type IPv6RangeSet []IPv6Range
+
+// hostIDRange is the numeric form of an IPv6Range
+type hostIDRange struct {
+	start *big.Int
+	end   *big.Int
+}
+
+var bigOne = big.NewInt(1)
+
+// hostIDToInt converts a host id to a number
+func hostIDToInt(hostID string) (*big.Int, error) {
+	ip := net.ParseIP(hostID)
+	if ip == nil {
+		return nil, core.Errorf("invalid ipv6 host id %q", hostID)
+	}
+
+	return new(big.Int).SetBytes(ip.To16()), nil
+}
+
+// intToHostID converts a number to a host id
+func intToHostID(val *big.Int) string {
+	buf := make([]byte, net.IPv6len)
+	b := val.Bytes()
+	copy(buf[net.IPv6len-len(b):], b)
+	return net.IP(buf).String()
+}
+
+// ranges returns the numeric form of the set
+func (s IPv6RangeSet) ranges() ([]hostIDRange, error) {
+	list := []hostIDRange{}
+	for _, r := range s {
+		start, err := hostIDToInt(r.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := hostIDToInt(r.End)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, hostIDRange{start: start, end: end})
+	}
+
+	return list, nil
+}
+
+// setRanges sorts and merges the ranges and stores them in the set
+func (s *IPv6RangeSet) setRanges(list []hostIDRange) {
+	sort.Sort(byStart(list))
+
+	merged := []hostIDRange{}
+	for _, r := range list {
+		last := len(merged) - 1
+		if last >= 0 && r.start.Cmp(new(big.Int).Add(merged[last].end, bigOne)) <= 0 {
+			if r.end.Cmp(merged[last].end) > 0 {
+				merged[last].end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	*s = IPv6RangeSet{}
+	for _, r := range merged {
+		*s = append(*s, IPv6Range{Start: intToHostID(r.start), End: intToHostID(r.end)})
+	}
+}
+
+type byStart []hostIDRange
+
+func (l byStart) Len() int           { return len(l) }
+func (l byStart) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l byStart) Less(i, j int) bool { return l[i].start.Cmp(l[j].start) < 0 }
+
+// Test checks if a host id is allocated
+func (s IPv6RangeSet) Test(hostID string) bool {
+	val, err := hostIDToInt(hostID)
+	if err != nil {
+		return false
+	}
+	list, err := s.ranges()
+	if err != nil {
+		return false
+	}
+
+	for _, r := range list {
+		if val.Cmp(r.start) >= 0 && val.Cmp(r.end) <= 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Set marks a host id as allocated
+func (s *IPv6RangeSet) Set(hostID string) error {
+	val, err := hostIDToInt(hostID)
+	if err != nil {
+		return err
+	}
+	list, err := s.ranges()
+	if err != nil {
+		return err
+	}
+
+	s.setRanges(append(list, hostIDRange{start: val, end: val}))
+	return nil
+}
+
+// Clear marks a host id as free
+func (s *IPv6RangeSet) Clear(hostID string) error {
+	val, err := hostIDToInt(hostID)
+	if err != nil {
+		return err
+	}
+	list, err := s.ranges()
+	if err != nil {
+		return err
+	}
+
+	newList := []hostIDRange{}
+	for _, r := range list {
+		if val.Cmp(r.start) < 0 || val.Cmp(r.end) > 0 {
+			newList = append(newList, r)
+			continue
+		}
+		if val.Cmp(r.start) > 0 {
+			newList = append(newList, hostIDRange{start: r.start, end: new(big.Int).Sub(val, bigOne)})
+		}
+		if val.Cmp(r.end) < 0 {
+			newList = append(newList, hostIDRange{start: new(big.Int).Add(val, bigOne), end: r.end})
+		}
+	}
+
+	s.setRanges(newList)
+	return nil
+}
+
+// Count returns the number of allocated host ids
+func (s IPv6RangeSet) Count() *big.Int {
+	count := big.NewInt(0)
+	list, err := s.ranges()
+	if err != nil {
+		return count
+	}
+
+	for _, r := range list {
+		count.Add(count, new(big.Int).Sub(r.end, r.start))
+		count.Add(count, bigOne)
+	}
+
+	return count
+}
+
+// NextClear returns the first free host id after the given one, wrapping
+// around at the end of the subnet. Host id 0 is the subnet address and is
+// never returned.
+func (s IPv6RangeSet) NextClear(hostID string, subnetLen uint) (string, error) {
+	if subnetLen == 0 || subnetLen > 128 {
+		return "", core.Errorf("subnet length %d is invalid", subnetLen)
+	}
+	if hostID == "" {
+		hostID = "::"
+	}
+
+	last, err := hostIDToInt(hostID)
+	if err != nil {
+		return "", err
+	}
+	list, err := s.ranges()
+	if err != nil {
+		return "", err
+	}
+
+	maxHosts := new(big.Int).Lsh(bigOne, 128-subnetLen)
+	maxHosts.Sub(maxHosts, bigOne)
+	if s.Count().Cmp(maxHosts) >= 0 {
+		return "", core.Errorf("Reached MaxHosts (%v). Cannot allocate more hosts", maxHosts)
+	}
+
+	next := new(big.Int).Add(last, bigOne)
+	wrapped := false
+	for {
+		if next.Cmp(maxHosts) > 0 {
+			if wrapped {
+				return "", core.Errorf("Reached MaxHosts (%v). Cannot allocate more hosts", maxHosts)
+			}
+			next = big.NewInt(1)
+			wrapped = true
+		}
+
+		allocd := false
+		for _, r := range list {
+			if next.Cmp(r.start) >= 0 && next.Cmp(r.end) <= 0 {
+				next = new(big.Int).Add(r.end, bigOne)
+				allocd = true
+				break
+			}
+		}
+		if !allocd {
+			return intToHostID(next), nil
+		}
+	}
+}
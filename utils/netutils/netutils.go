@@ -18,7 +18,6 @@ package netutils
 import (
 	"errors"
 	"fmt"
-	"math"
 	"net"
 	"os"
 	"strconv"
@@ -192,73 +191,6 @@ func GetIPNumber(subnetIP string, subnetLen uint, allocSubnetLen uint, hostIP st
 	return uint(hostID), nil
 }
 
-// ReserveIPv6HostID sets the hostId in the AllocMap
-func ReserveIPv6HostID(hostID string, IPv6AllocMap *map[string]bool) {
-	if hostID == "" {
-		return
-	}
-	if *IPv6AllocMap == nil {
-		*IPv6AllocMap = make(map[string]bool)
-	}
-	(*IPv6AllocMap)[hostID] = true
-}
-
-// GetNextIPv6HostID returns the next available hostId in the AllocMap
-func GetNextIPv6HostID(hostID, subnetAddr string, subnetLen uint, IPv6AllocMap map[string]bool) (string, error) {
-	if hostID == "" {
-		hostID = "::"
-	}
-	if subnetLen == 0 {
-		return "", core.Errorf("subnet length %d is invalid", subnetLen)
-	}
-
-	hostidIP := net.ParseIP(hostID)
-
-	// start with the carryOver 1 to get the next hostID
-	var carryOver = 1
-	var allocd = true
-
-	for allocd == true {
-		// Add 1 to hostID
-		for i := len(hostidIP) - 1; i >= 0; i-- {
-			var temp int
-			temp = int(hostidIP[i]) + carryOver
-			if temp > int(0xFF) {
-				hostidIP[i] = 0
-				carryOver = 1
-			} else {
-				hostidIP[i] = uint8(temp)
-				carryOver = 0
-				break
-			}
-		}
-		// Check if this hostID is already allocated
-		if _, allocd = IPv6AllocMap[hostidIP.String()]; allocd == true {
-			// Already allocated find the next hostID
-			carryOver = 1
-		} else {
-			// allocd == false. check if we reached MaxHosts
-			offset := (subnetLen - 1) / 8
-			masklen := subnetLen % 8
-			mask := ((1 << masklen) - 1) << (8 - masklen)
-			if (hostidIP[offset] & byte(mask)) != 0 {
-				// if hostID is outside subnet range,
-				//	check if we have reached MaxHosts
-				maxHosts := math.Pow(2, float64(128-subnetLen)) - 1
-				if float64(len(IPv6AllocMap)) < maxHosts {
-					hostID = "::"
-					hostidIP = net.ParseIP(hostID)
-					carryOver = 1
-					allocd = true // continue the loop
-				} else {
-					return "", core.Errorf("Reached MaxHosts (%v). Cannot allocate more hosts", maxHosts)
-				}
-			}
-		}
-	}
-	return hostidIP.String(), nil
-}
-
 // GetSubnetIPv6 given a subnet IP and host identifier, calculates an IPv6 address
 // within the subnet for use.
 func GetSubnetIPv6(subnetAddr string, subnetLen uint, hostID string) (string, error) {
@@ -272,7 +204,7 @@ func GetSubnetIPv6(subnetAddr string, subnetLen uint, hostID string) (string, er
 
 	subnetIP := net.ParseIP(subnetAddr)
 	hostidIP := net.ParseIP(hostID)
-	hostIP := net.IPv6zero
+	hostIP := make(net.IP, net.IPv6len)
 
 	var offset int
 	for offset = 0; offset < int(subnetLen/8); offset++ {
@@ -300,7 +232,7 @@ func GetIPv6HostID(subnetAddr string, subnetLen uint, hostAddr string) (string,
 		return "", core.Errorf("subnet length %d not supported", subnetLen)
 	}
 	// Initialize hostID
-	hostID := net.IPv6zero
+	hostID := make(net.IP, net.IPv6len)
 
 	var offset uint
 
@@ -17,6 +17,7 @@ package netutils
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -188,10 +189,10 @@ var testHostIDs = []testHostID{
 	{hostID: "::", nextHostID: "::2", subnetAddr: "1234::", subnetLen: 100},
 
 	// find next of 4
-	{hostID: "::4:FFFF:FFFF", nextHostID: "::5:0:0", subnetAddr: "1234::", subnetLen: 100},
+	{hostID: "::4:FFFF", nextHostID: "::5:0", subnetAddr: "1234::", subnetLen: 100},
 
 	// this time next of 4 should skip 5, as it was alloc'd above
-	{hostID: "::4:ffff:ffff", nextHostID: "::5:0:1", subnetAddr: "1234::", subnetLen: 100},
+	{hostID: "::4:ffff", nextHostID: "::5:1", subnetAddr: "1234::", subnetLen: 100},
 
 	// verify the corner case
 	{hostID: "::FFFE", nextHostID: "::ffff", subnetAddr: "1234::", subnetLen: 100},
@@ -200,15 +201,55 @@ var testHostIDs = []testHostID{
 	{hostID: "::F", nextHostID: "::3", subnetAddr: "1234::", subnetLen: 124},
 }
 
-func TestGetNextIPv6HostID(t *testing.T) {
-	var allocMap map[string]bool
+func TestIPv6RangeSetNextClear(t *testing.T) {
+	var allocRanges IPv6RangeSet
 	for _, te := range testHostIDs {
-		nextHostID, err := GetNextIPv6HostID(te.hostID, te.subnetAddr, te.subnetLen, allocMap)
+		nextHostID, err := allocRanges.NextClear(te.hostID, te.subnetLen)
 		if nextHostID != te.nextHostID || err != nil {
 			t.Fatalf("obtained nextHostID %s doesn't match expected ID %s for %s\n",
 				nextHostID, te.nextHostID, te.hostID)
 		}
-		ReserveIPv6HostID(nextHostID, &allocMap)
+		if err := allocRanges.Set(nextHostID); err != nil {
+			t.Fatalf("error setting host id %s. Err: %v", nextHostID, err)
+		}
+	}
+}
+
+func TestIPv6RangeSet(t *testing.T) {
+	var allocRanges IPv6RangeSet
+	for _, hostID := range []string{"::3", "::1", "::2", "::5", "::4", "::a"} {
+		if err := allocRanges.Set(hostID); err != nil {
+			t.Fatalf("error setting host id %s. Err: %v", hostID, err)
+		}
+	}
+
+	expRanges := IPv6RangeSet{{Start: "::1", End: "::5"}, {Start: "::a", End: "::a"}}
+	if !reflect.DeepEqual(allocRanges, expRanges) {
+		t.Fatalf("got ranges %v expected %v", allocRanges, expRanges)
+	}
+	if count := allocRanges.Count().Int64(); count != 6 {
+		t.Fatalf("got count %d expected 6", count)
+	}
+
+	if err := allocRanges.Clear("::3"); err != nil {
+		t.Fatalf("error clearing host id. Err: %v", err)
+	}
+	if allocRanges.Test("::3") || !allocRanges.Test("::4") {
+		t.Fatalf("unexpected ranges %v after clearing ::3", allocRanges)
+	}
+
+	// lowest free host id is found after wrapping around
+	nextHostID, err := allocRanges.NextClear("::f", 124)
+	if err != nil || nextHostID != "::3" {
+		t.Fatalf("got next host id %s expected ::3. Err: %v", nextHostID, err)
+	}
+
+	var fullRanges IPv6RangeSet
+	fullRanges.Set("::1")
+	fullRanges.Set("::2")
+	fullRanges.Set("::3")
+	if _, err := fullRanges.NextClear("", 126); err == nil {
+		t.Fatalf("allocated host id from a full subnet")
 	}
 }
 